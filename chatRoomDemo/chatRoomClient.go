@@ -16,10 +16,15 @@ import (
 
 // 消息结构体
 type Message struct {
-	DataSource string	`json:"data_source"`	// 消息（数据）来源
+	DataSource string	`json:"data_source"`	// 消息（数据）来源，用户的昵称或者"服务端"
 	Data string	`json:"data"`					// 消息（数据）内容
+	ConnId uint64	`json:"conn_id,omitempty"`	// 发送消息的连接 id，服务端发出的消息为 0
+	Type string	`json:"type,omitempty"`			// 消息的类型，private 表示 IRC 用户发来的私聊消息
 }
 
+// 服务端发来的一行最多的字节数，和服务端一致
+const MaxLineSize = 64 * 1024
+
 // 与服务端建立连接，成功后返回连接句柄 conn
 func GetConnect() (conn net.Conn, err error) {
 	// 链接到服务器，这里用本地(localhost)举例，端口号为：8080，tcp 协议
@@ -32,13 +37,17 @@ func GetConnect() (conn net.Conn, err error) {
 }
 
 // 发送消息到服务端，入参为 连接句柄conn 和 发送消息内容content
+// 每条消息一行，以换行符结尾，服务端按行读取
 func SendMsgToServer(conn net.Conn, content string) (err error) {
 	// 去掉多余到换行符，并打印需要发送的消息内容
-	readContent := strings.Trim(content, "\n")
+	readContent := strings.TrimRight(content, "\r\n")
+	if readContent == "" {
+		return
+	}
 	fmt.Println("发送的消息:", readContent)
 
-	// 发送消息的内容转换成byte的格式，并发送给服务端
-	data := []byte(readContent)
+	// 发送消息的内容加上换行符，转换成byte的格式，并发送给服务端
+	data := []byte(readContent + "\n")
 	_, err = conn.Write(data)
 	if err != nil {
 		fmt.Println("SendGroupMes err=", err.Error())
@@ -47,19 +56,36 @@ func SendMsgToServer(conn net.Conn, content string) (err error) {
 	return
 }
 
+// 设置昵称：发送 /nick <昵称>，服务端确认后才加入聊天室
+// 昵称不可用时服务端会发来提示，可以再输入 /nick <昵称> 重试
+func SetNick(conn net.Conn, inputReader *bufio.Reader) (err error) {
+	fmt.Printf("请输入你的昵称：")
+	nick, err := inputReader.ReadString('\n')
+	if err != nil {
+		return
+	}
+	return SendMsgToServer(conn, "/nick "+strings.TrimSpace(nick))
+}
+
 // 客户端发送消息函数
 func ClientSendMsg(conn net.Conn) {
-	fmt.Println("请输入你想要发送的消息：")
+	// bufio 缓冲的方式获取终端输入的消息，只创建一次，否则缓冲中的输入会丢失
+	inputReader := bufio.NewReader(os.Stdin)
+	err := SetNick(conn, inputReader)
+	if err != nil {
+		fmt.Println("SetNick err=", err)
+		return
+	}
+	fmt.Println("请输入你想要发送的消息（/nick <昵称> 修改昵称）：")
 
 	// 这里循环获取终端输入的消息
 	for {
-		// bufio 缓冲的方式获取终端输入的消息
 		//fmt.Printf("发送消息: ")
-		inputReader := bufio.NewReader(os.Stdin)
 		// 读取到换行符就结束此次读取消息，并将消息存到 content 变量
 		content, err := inputReader.ReadString('\n')
 		if err != nil {
 			fmt.Println("ReadString err=", err)
+			return
 		}
 
 		// 发送终端读取的消息到服务端
@@ -70,22 +96,30 @@ func ClientSendMsg(conn net.Conn) {
 	}
 }
 
+// 接收服务端发送的消息，每行一个 JSON 格式的 Message
 func ClientReceiveMsg(conn net.Conn) {
-	for {
-		buf := make([]byte, 4096)
-		n, err := conn.Read(buf[:])
-		if err != nil {
-			fmt.Println("conn.Read err: ", err)
-			return
-		}
+	scanner := bufio.NewScanner(conn)
+	scanner.Buffer(make([]byte, 4096), MaxLineSize)
+	for scanner.Scan() {
 		var msg Message
-		err = json.Unmarshal(buf[:n], &msg)
+		err := json.Unmarshal(scanner.Bytes(), &msg)
 		if err != nil {
 			fmt.Println("json.Unmarshal err: ", err)
+			continue
 		}
 
+		if msg.Type == "private" {
+			fmt.Printf("收到[%s]发来的私聊消息: %s\n",  msg.DataSource, msg.Data)
+			continue
+		}
 		fmt.Printf("收到[%s]发来的消息: %s\n",  msg.DataSource, msg.Data)
 	}
+	if err := scanner.Err(); err != nil {
+		fmt.Println("conn.Read err: ", err)
+		return
+	}
+	fmt.Println("与服务端的连接已断开")
+	os.Exit(0)
 }
 
 // 客户端处理函数，入参为：与服务端建立连接的句柄 conn
@@ -102,8 +136,9 @@ func main() {
 	conn, err := GetConnect()
 	if err != nil {
 		fmt.Println("GetConnect err=", err)
+		return
 	}
-	fmt.Println("与服务端建立连接成功，欢迎来到【ChatRoom】，现在可以进行通讯了.")
+	fmt.Println("与服务端建立连接成功，欢迎来到【ChatRoom】.")
 	// 客户端处理进程
 	ClientProcess(conn)
 }
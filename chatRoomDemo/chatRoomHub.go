@@ -0,0 +1,323 @@
+/*
+	file：chatRoomHub.go
+	广播中心，和 chatRoomServer.go 一起运行：go run chatRoomServer.go chatRoomHub.go chatRoomIRC.go
+	在线用户、昵称和频道只在 hub 协程中读写，其他协程通过通道请求 hub 处理，不需要加锁
+	每个客户端有一个带缓冲的发送队列，由单独的写协程发送，队列满了说明客户端太慢，直接断开
+*/
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+const (
+	SendQueueSize = 256				// 每个客户端的发送队列长度
+	FlushTimeout = 3 * time.Second	// 连接结束时，发送队列中剩下的消息最多发送多久
+)
+
+var (
+	ErrSlowClient = errors.New("客户端的发送队列已满")
+	ErrClosed = errors.New("连接已关闭")
+)
+
+// 广播中心
+type Hub struct {
+	register chan *registration
+	unregister chan *UserProcess
+	broadcast chan *Broadcast
+	// 其他的请求，在 hub 协程中执行
+	calls chan func()
+
+	// 以下字段只在 hub 协程中访问
+	// 在线用户（客户端）变量，map 类型
+	// key为连接 id，用于标识别哪个用户，
+	onlineUsers map[uint64]*UserProcess
+}
+
+// 用户设置昵称后加入在线列表
+type registration struct {
+	up *UserProcess
+	nick string
+	reply chan error
+}
+
+// 需要广播的消息
+type Broadcast struct {
+	From *UserProcess	// 发送消息的用户，不会发给他自己
+	Channel string		// 发给频道中的用户，为空时发给和 From 在同一个频道中的用户
+	Msg *Message
+}
+
+func NewHub() *Hub {
+	return &Hub{
+		register : make(chan *registration),
+		unregister : make(chan *UserProcess),
+		broadcast : make(chan *Broadcast),
+		calls : make(chan func()),
+		onlineUsers : make(map[uint64]*UserProcess, 1024),
+	}
+}
+
+// hub 协程，处理所有对在线用户的修改和广播
+func (this *Hub) Run() {
+	for {
+		select {
+		case r := <-this.register:
+			r.reply <- this.setNick(r.up, r.nick)
+		case up := <-this.unregister:
+			delete(this.onlineUsers, up.Id)
+		case b := <-this.broadcast:
+			this.fanout(b)
+		case fn := <-this.calls:
+			fn()
+		}
+	}
+}
+
+// 在 hub 协程中执行 fn，等待执行完成
+func (this *Hub) Do(fn func()) {
+	done := make(chan struct{})
+	this.calls <- func() {
+		fn()
+		close(done)
+	}
+	<-done
+}
+
+// 设置昵称，加入在线列表
+func (this *Hub) Register(up *UserProcess, nick string) error {
+	err := CheckNick(nick)
+	if err != nil {
+		return err
+	}
+	reply := make(chan error, 1)
+	this.register <- &registration{up : up, nick : nick, reply : reply}
+	return <-reply
+}
+
+// 从在线列表删除
+func (this *Hub) Unregister(up *UserProcess) {
+	this.unregister <- up
+}
+
+// 广播消息，channel 为空时发给和 from 在同一个频道中的用户
+func (this *Hub) Broadcast(from *UserProcess, channel string, msg *Message) {
+	this.broadcast <- &Broadcast{From : from, Channel : channel, Msg : msg}
+}
+
+// 修改已经在线的用户的昵称，昵称不区分大小写，不能和其他在线用户重复
+func (this *Hub) SetNick(up *UserProcess, nick string) (oldNick string, err error) {
+	err = CheckNick(nick)
+	if err != nil {
+		return
+	}
+	this.Do(func() {
+		oldNick = up.Nick
+		err = this.setNick(up, nick)
+	})
+	return
+}
+
+// 私聊，对方不在线时返回 false
+func (this *Hub) SendPrivate(nick string, msg *Message) (ok bool) {
+	this.Do(func() {
+		other := this.findByNick(nick)
+		if other != nil {
+			this.send(other, msg)
+			ok = true
+		}
+	})
+	return
+}
+
+// 加入频道，已经在频道中时返回 false
+func (this *Hub) Join(up *UserProcess, channel string) (ok bool) {
+	channel = strings.ToLower(channel)
+	this.Do(func() {
+		if !up.channels[channel] {
+			up.channels[channel] = true
+			ok = true
+		}
+	})
+	return
+}
+
+// 离开频道，不在频道中时返回 false
+func (this *Hub) Part(up *UserProcess, channel string) (ok bool) {
+	channel = strings.ToLower(channel)
+	this.Do(func() {
+		ok = up.channels[channel]
+		delete(up.channels, channel)
+	})
+	return
+}
+
+// 用户是否在频道中
+func (this *Hub) InChannel(up *UserProcess, channel string) (ok bool) {
+	channel = strings.ToLower(channel)
+	this.Do(func() {
+		ok = up.channels[channel]
+	})
+	return
+}
+
+// 用户加入的频道
+func (this *Hub) Channels(up *UserProcess) (channels []string) {
+	this.Do(func() {
+		for channel := range up.channels {
+			channels = append(channels, channel)
+		}
+	})
+	return
+}
+
+// 频道中的在线用户的昵称
+func (this *Hub) Names(channel string) (nicks []string) {
+	channel = strings.ToLower(channel)
+	this.Do(func() {
+		for _, up := range this.members(channel) {
+			nicks = append(nicks, up.Nick)
+		}
+	})
+	return
+}
+
+// 以下方法只在 hub 协程中调用
+
+func (this *Hub) setNick(up *UserProcess, nick string) error {
+	other := this.findByNick(nick)
+	if other != nil && other.Id != up.Id {
+		return ErrNickTaken
+	}
+	up.Nick = nick
+	this.onlineUsers[up.Id] = up
+	return nil
+}
+
+func (this *Hub) findByNick(nick string) *UserProcess {
+	for _, up := range this.onlineUsers {
+		if strings.EqualFold(up.Nick, nick) {
+			return up
+		}
+	}
+	return nil
+}
+
+// 频道中的在线用户
+func (this *Hub) members(channel string) (users []*UserProcess) {
+	for _, up := range this.onlineUsers {
+		if up.channels[channel] {
+			users = append(users, up)
+		}
+	}
+	return
+}
+
+// 和 up 至少在一个相同频道中的其他在线用户，用户改名和断开连接时通知他们
+func (this *Hub) peers(up *UserProcess) (users []*UserProcess) {
+	for _, other := range this.onlineUsers {
+		if other.Id == up.Id {
+			continue
+		}
+		for channel := range up.channels {
+			if other.channels[channel] {
+				users = append(users, other)
+				break
+			}
+		}
+	}
+	return
+}
+
+func (this *Hub) fanout(b *Broadcast) {
+	var users []*UserProcess
+	if b.Channel != "" {
+		users = this.members(strings.ToLower(b.Channel))
+	} else {
+		users = this.peers(b.From)
+	}
+	for _, up := range users {
+		// 排除掉本身（当前客户端）
+		if b.From != nil && up.Id == b.From.Id {
+			continue
+		}
+		this.send(up, b.Msg)
+	}
+}
+
+// 把消息放到用户的发送队列，不会阻塞 hub 协程
+func (this *Hub) send(up *UserProcess, msg *Message) {
+	err := up.Send(msg)
+	// 发送出错时，打错误信息
+	if err != nil && err != ErrClosed {
+		fmt.Printf("转发消息给[%s]客户端失败，失败信息为：%v\n", up.UserAddr, err)
+	}
+}
+
+// 把数据放到发送队列，队列满了时断开客户端
+func (this *UserProcess) enqueue(data []byte) error {
+	select {
+	case <-this.done:
+		return ErrClosed
+	default:
+	}
+	select {
+	case this.send <- data:
+		return nil
+	default:
+		fmt.Printf("客户端[%s]的发送队列已满，断开连接.\n", this.UserAddr)
+		this.Drop()
+		return ErrSlowClient
+	}
+}
+
+// 写协程，把发送队列中的数据写到连接中，结束时关闭连接
+func (this *UserProcess) WritePump() {
+	defer this.Conn.Close()
+	for {
+		select {
+		case data := <-this.send:
+			_, err := this.Conn.Write(data)
+			if err != nil {
+				// 被 Drop 断开时不用再打印错误
+				select {
+				case <-this.done:
+				default:
+					fmt.Println("conn.Write fail", err)
+				}
+				this.Drop()
+				return
+			}
+		case <-this.done:
+			// 把队列中剩下的消息发出去，比如 IRC 的 ERROR
+			this.Conn.SetWriteDeadline(time.Now().Add(FlushTimeout))
+			for {
+				select {
+				case data := <-this.send:
+					if _, err := this.Conn.Write(data); err != nil {
+						return
+					}
+				default:
+					return
+				}
+			}
+		}
+	}
+}
+
+// 通讯结束，写协程发送完队列中的消息后关闭连接
+func (this *UserProcess) Close() {
+	this.once.Do(func() {
+		close(this.done)
+	})
+}
+
+// 立即断开连接，读协程会因为读取失败而结束
+func (this *UserProcess) Drop() {
+	this.Close()
+	this.Conn.Close()
+}
@@ -0,0 +1,166 @@
+/*
+	file：chatRoomHub_test.go
+	runCmd：go test chatRoomServer.go chatRoomHub.go chatRoomIRC.go chatRoomHub_test.go chatRoomServer_test.go chatRoomIRC_test.go
+	chatRoomClient.go 是单独的程序，不能和服务端一起编译，所以需要列出文件
+*/
+
+package main
+
+import (
+	"bufio"
+	"net"
+	"testing"
+	"time"
+)
+
+// 用 net.Pipe 创建一个连接，返回服务端的 UserProcess 和客户端的一端
+func newPipeUser(t *testing.T) (up *UserProcess, client net.Conn) {
+	t.Helper()
+	server, client := net.Pipe()
+	t.Cleanup(func() {
+		client.Close()
+		server.Close()
+	})
+	return NewUserProcess(server), client
+}
+
+func startHub() *Hub {
+	h := NewHub()
+	go h.Run()
+	return h
+}
+
+// 等待连接结束
+func expectDone(t *testing.T, up *UserProcess) {
+	t.Helper()
+	select {
+	case <-up.done:
+	case <-time.After(time.Second):
+		t.Fatalf("连接 %d 没有结束", up.Id)
+	}
+}
+
+// 昵称不区分大小写，离开后其他人可以使用
+func TestHubRegister(t *testing.T) {
+	h := startHub()
+	a, _ := newPipeUser(t)
+	b, _ := newPipeUser(t)
+
+	if err := h.Register(a, "Alice"); err != nil {
+		t.Fatal(err)
+	}
+	if err := h.Register(b, "alice"); err != ErrNickTaken {
+		t.Fatalf("重复的昵称返回 %v，期望 ErrNickTaken", err)
+	}
+	if err := h.Register(b, "bad nick"); err != ErrNickInvalid {
+		t.Fatalf("不合法的昵称返回 %v，期望 ErrNickInvalid", err)
+	}
+	// 自己重新设置同一个昵称不算冲突
+	if err := h.Register(a, "ALICE"); err != nil {
+		t.Fatal(err)
+	}
+
+	h.Unregister(a)
+	if err := h.Register(b, "alice"); err != nil {
+		t.Fatalf("离开后的昵称不能使用：%v", err)
+	}
+	h.Do(func() {
+		if len(h.onlineUsers) != 1 || h.onlineUsers[b.Id] != b {
+			t.Errorf("在线用户是 %v，期望只有 %d", h.onlineUsers, b.Id)
+		}
+	})
+	if b.Nick != "alice" {
+		t.Fatalf("昵称是 %q", b.Nick)
+	}
+}
+
+// 广播只发给频道中的其他用户
+func TestHubBroadcast(t *testing.T) {
+	h := startHub()
+	a, _ := newPipeUser(t)
+	b, _ := newPipeUser(t)
+	c, _ := newPipeUser(t)
+	for nick, up := range map[string]*UserProcess{"a": a, "b": b, "c": c} {
+		if err := h.Register(up, nick); err != nil {
+			t.Fatal(err)
+		}
+	}
+	h.Join(a, DefaultChannel)
+	h.Join(b, "#ChatRoom")
+	h.Join(c, "#other")
+
+	h.Broadcast(a, DefaultChannel, &Message{DataSource: "a", Data: "hi"})
+	// 等 hub 处理完广播
+	h.Do(func() {})
+	for _, want := range []struct {
+		up *UserProcess
+		n  int
+	}{{a, 0}, {b, 1}, {c, 0}} {
+		if len(want.up.send) != want.n {
+			t.Fatalf("用户 %s 的发送队列中有 %d 条消息，期望 %d", want.up.Nick, len(want.up.send), want.n)
+		}
+	}
+}
+
+// 发送队列满了时断开慢的客户端，不影响 hub 和其他客户端
+func TestHubDropSlowClient(t *testing.T) {
+	h := startHub()
+	slow, slowClient := newPipeUser(t)
+	fast, fastClient := newPipeUser(t)
+	sender, _ := newPipeUser(t)
+	for nick, up := range map[string]*UserProcess{"slow": slow, "fast": fast, "sender": sender} {
+		if err := h.Register(up, nick); err != nil {
+			t.Fatal(err)
+		}
+		h.Join(up, DefaultChannel)
+	}
+	// 慢的客户端没有写协程，发送队列不会被取走
+	go fast.WritePump()
+	received := make(chan int)
+	go func() {
+		n := 0
+		scanner := bufio.NewScanner(fastClient)
+		for scanner.Scan() {
+			n++
+		}
+		received <- n
+	}()
+
+	for i := 0; i < SendQueueSize; i++ {
+		h.Broadcast(sender, DefaultChannel, &Message{DataSource: "sender", Data: "msg"})
+	}
+	h.Do(func() {})
+	select {
+	case <-slow.done:
+		t.Fatalf("发送队列还没有满时断开了连接")
+	default:
+	}
+
+	h.Broadcast(sender, DefaultChannel, &Message{DataSource: "sender", Data: "msg"})
+	h.Do(func() {})
+	expectDone(t, slow)
+	// 连接被关闭，客户端读取失败
+	slowClient.SetReadDeadline(time.Now().Add(time.Second))
+	if _, err := slowClient.Read(make([]byte, 1)); err == nil || isTimeout(err) {
+		t.Fatalf("慢的客户端的连接没有关闭：%v", err)
+	}
+	if err := slow.enqueue([]byte("late\n")); err != ErrClosed {
+		t.Fatalf("断开后放到发送队列返回 %v，期望 ErrClosed", err)
+	}
+
+	// 快的客户端收到全部的消息
+	fast.Close()
+	select {
+	case n := <-received:
+		if n != SendQueueSize+1 {
+			t.Fatalf("快的客户端收到 %d 条消息，期望 %d", n, SendQueueSize+1)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("快的客户端的连接没有关闭")
+	}
+}
+
+func isTimeout(err error) bool {
+	ne, ok := err.(net.Error)
+	return ok && ne.Timeout()
+}
@@ -0,0 +1,454 @@
+/*
+	file：chatRoomIRC.go
+	IRC 协议的前端，和 chatRoomServer.go 一起运行：go run chatRoomServer.go chatRoomHub.go chatRoomIRC.go
+	用任意的 IRC 客户端连接 6667 端口，JOIN #chatroom 后就可以和 chatRoomClient.go 的用户聊天
+	支持的命令：NICK USER JOIN PART PRIVMSG QUIT PING PONG NAMES，其他的命令回复 421
+*/
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sort"
+	"strings"
+	"unicode"
+)
+
+const (
+	IRCAddr = "0.0.0.0:6667"	// IRC 客户端连接的地址
+	IRCServerName = "chatroom"	// 服务器的名字，用作服务端消息的来源
+	MaxChannelLen = 50			// 频道名最多的字节数
+	MaxChannels = 20			// 一个用户最多加入的频道数
+)
+
+// IRC 的数字回复
+const (
+	RplWelcome = "001"
+	RplYourHost = "002"
+	RplCreated = "003"
+	RplMyInfo = "004"
+	RplNoTopic = "331"
+	RplNamReply = "353"
+	RplEndOfNames = "366"
+	ErrNoSuchNick = "401"
+	ErrNoSuchChannel = "403"
+	ErrCannotSendToChan = "404"
+	ErrTooManyChannels = "405"
+	ErrNoOrigin = "409"
+	ErrNoRecipient = "411"
+	ErrNoTextToSend = "412"
+	ErrUnknownCommand = "421"
+	ErrNoMotd = "422"
+	ErrNoNicknameGiven = "431"
+	ErrErroneusNickname = "432"
+	ErrNicknameInUse = "433"
+	ErrNotOnChannel = "442"
+	ErrNotRegistered = "451"
+	ErrNeedMoreParams = "461"
+	ErrAlreadyRegistred = "462"
+)
+
+// 客户端发送了 QUIT
+var errIRCQuit = errors.New("客户端发送了 QUIT")
+
+// 监听 IRC 客户端的连接
+func ListenIRC(addr string) {
+	listen, err := net.Listen("tcp", addr)
+	if err != nil {
+		fmt.Println("IRC net.Listen err=", err)
+		return
+	}
+	defer listen.Close()
+	fmt.Printf("IRC 服务在 %s 监听....\n", addr)
+	for {
+		conn, err := listen.Accept()
+		if err != nil {
+			fmt.Println("IRC listen.Accept err=", err)
+			continue
+		}
+		up := NewUserProcess(conn)
+		up.IRC = true
+		fmt.Printf("IRC 客户端[%s]与服务端已建立连接.\n", up.UserAddr)
+		go IRCProcess(up)
+	}
+}
+
+// 解析一行 IRC 消息：[:prefix] COMMAND param1 param2 :trailing param
+// 客户端发来的 prefix 没有用处，直接忽略
+func ParseIRCLine(line string) (cmd string, params []string) {
+	line = strings.TrimLeft(line, " ")
+	if strings.HasPrefix(line, ":") {
+		_, line, _ = strings.Cut(line, " ")
+	}
+	for line != "" {
+		line = strings.TrimLeft(line, " ")
+		if strings.HasPrefix(line, ":") {
+			params = append(params, line[1:])
+			break
+		}
+		var param string
+		param, line, _ = strings.Cut(line, " ")
+		if param != "" {
+			params = append(params, param)
+		}
+	}
+	if len(params) == 0 {
+		return "", nil
+	}
+	return strings.ToUpper(params[0]), params[1:]
+}
+
+// 组成一行 IRC 消息，最后一个参数包含空格，或者以 : 开头，或者为空时加上 :
+func IRCLine(prefix string, cmd string, params ...string) string {
+	var b strings.Builder
+	if prefix != "" {
+		b.WriteString(":" + prefix + " ")
+	}
+	b.WriteString(cmd)
+	for i, param := range params {
+		b.WriteString(" ")
+		if i == len(params)-1 && (param == "" || strings.HasPrefix(param, ":") || strings.Contains(param, " ")) {
+			b.WriteString(":")
+		}
+		b.WriteString(param)
+	}
+	return b.String()
+}
+
+// 用户在 IRC 中的来源 nick!user@host，聊天室中没有用户名和主机，都用昵称和服务器名代替
+func IRCUserPrefix(nick string) string {
+	return nick + "!" + nick + "@" + IRCServerName
+}
+
+// 发送一行 IRC 消息，IRC 用 \r\n 结尾，放到发送队列
+func WriteIRC(up *UserProcess, line string) error {
+	return up.enqueue([]byte(line + "\r\n"))
+}
+
+// 发送服务端的数字回复，第一个参数是用户的昵称，还没有昵称时为 *
+func IRCReply(up *UserProcess, code string, params ...string) error {
+	nick := up.Nick
+	if nick == "" {
+		nick = "*"
+	}
+	return WriteIRC(up, IRCLine(IRCServerName, code, append([]string{nick}, params...)...))
+}
+
+// 把聊天室的消息转换成 IRC 的命令发给 IRC 客户端
+func WriteIRCMsg(up *UserProcess, msg *Message) error {
+	var line string
+	switch msg.Type {
+	case MsgChat:
+		line = IRCLine(IRCUserPrefix(msg.DataSource), "PRIVMSG", msg.Channel, msg.Data)
+	case MsgPrivate:
+		line = IRCLine(IRCUserPrefix(msg.DataSource), "PRIVMSG", up.Nick, msg.Data)
+	case MsgJoin:
+		line = IRCLine(IRCUserPrefix(msg.Nick), "JOIN", msg.Channel)
+	case MsgPart:
+		line = IRCLine(IRCUserPrefix(msg.Nick), "PART", msg.Channel)
+	case MsgQuit:
+		line = IRCLine(IRCUserPrefix(msg.Nick), "QUIT", "Quit")
+	case MsgNick:
+		line = IRCLine(IRCUserPrefix(msg.Nick), "NICK", msg.NewNick)
+	default:
+		line = IRCLine(IRCServerName, "NOTICE", up.Nick, msg.Data)
+	}
+	return WriteIRC(up, line)
+}
+
+// 频道名必须以 # 开头，不能包含空白字符和逗号
+func CheckChannel(channel string) bool {
+	if len(channel) < 2 || len(channel) > MaxChannelLen || channel[0] != '#' {
+		return false
+	}
+	for _, r := range channel {
+		if unicode.IsSpace(r) || !unicode.IsPrint(r) || r == ',' {
+			return false
+		}
+	}
+	return true
+}
+
+// 处理和 IRC 客户端的通讯
+func IRCProcess(up *UserProcess) {
+	// 写协程负责发送消息，结束时关闭conn
+	go up.WritePump()
+	defer up.Close()
+
+	// 先注册，NICK 和 USER 都收到以后才算登录
+	err := IRCRegister(up)
+	if err == nil {
+		fmt.Printf("IRC 客户端[%s]的昵称为[%s]，连接 id 为 %d.\n", up.UserAddr, up.Nick, up.Id)
+		// 处理通讯
+		err = IRCSubProcess(up)
+
+		// 将客户端离开的消息广播出去，并将该用户（客户端）在全局队列里剔除掉
+		JoinOrLeaveMsg(up, ClientQuit, "")
+		hub.Unregister(up)
+	}
+	if err == errIRCQuit {
+		// 写协程关闭连接之前会把这条消息发出去
+		WriteIRC(up, IRCLine("", "ERROR", "Closing Link"))
+		return
+	}
+	// 通讯出错时，打印错误信息
+	if err != io.EOF {
+		fmt.Printf("IRC 客户端[%v]（连接 id 为 %d）通讯错误，错误信息为：%s\n",
+			up.UserAddr, up.Id, err)
+	}
+}
+
+// 注册：等待客户端发送 NICK 和 USER，昵称可用后发送欢迎消息
+func IRCRegister(up *UserProcess) (err error) {
+	var nick string
+	var user bool
+	for {
+		msg, err := ReadData(up)
+		if err != nil {
+			return err
+		}
+		cmd, params := ParseIRCLine(msg.Data)
+		switch cmd {
+		case "":
+		case "NICK":
+			if len(params) == 0 {
+				err = IRCReply(up, ErrNoNicknameGiven, "No nickname given")
+			} else if CheckNick(params[0]) != nil {
+				err = IRCReply(up, ErrErroneusNickname, params[0], "Erroneous nickname")
+			} else {
+				nick = params[0]
+			}
+		case "USER":
+			if len(params) < 4 {
+				err = IRCReply(up, ErrNeedMoreParams, cmd, "Not enough parameters")
+			} else {
+				user = true
+			}
+		case "CAP":
+			// 不支持任何扩展
+			if len(params) > 0 && strings.ToUpper(params[0]) == "LS" {
+				err = WriteIRC(up, IRCLine(IRCServerName, "CAP", "*", "LS", ""))
+			}
+		case "PASS", "PONG":
+		case "PING":
+			err = IRCPing(up, params)
+		case "QUIT":
+			return errIRCQuit
+		default:
+			err = IRCReply(up, ErrNotRegistered, "You have not registered")
+		}
+		if err != nil {
+			return err
+		}
+		if nick == "" || !user {
+			continue
+		}
+		if err = hub.Register(up, nick); err != nil {
+			err = IRCReply(up, ErrNicknameInUse, nick, "Nickname is already in use")
+			nick = ""
+			if err != nil {
+				return err
+			}
+			continue
+		}
+		return IRCWelcome(up)
+	}
+}
+
+// 注册成功后的欢迎消息，没有 MOTD
+func IRCWelcome(up *UserProcess) (err error) {
+	replies := [][]string{
+		{RplWelcome, fmt.Sprintf("欢迎来到【ChatRoom】 %s，JOIN %s 和其他用户聊天", IRCUserPrefix(up.Nick), DefaultChannel)},
+		{RplYourHost, "Your host is " + IRCServerName},
+		{RplCreated, "This server was created for chatRoomDemo"},
+		{RplMyInfo, IRCServerName, "chatRoomDemo", "o", "o"},
+		{ErrNoMotd, "MOTD File is missing"},
+	}
+	for _, reply := range replies {
+		err = IRCReply(up, reply[0], reply[1:]...)
+		if err != nil {
+			return
+		}
+	}
+	return
+}
+
+// 封装正式处理 IRC 通讯的函数
+func IRCSubProcess(up *UserProcess) (err error) {
+	for {
+		msg, err := ReadData(up)
+		if err != nil {
+			if err == io.EOF {
+				fmt.Printf("IRC 客户端[%s]退出，与服务器端的连接断开.\n", up.Nick)
+			}
+			return err
+		}
+		err = IRCProcessMsg(up, msg.Data)
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// 处理注册以后的一条 IRC 命令
+func IRCProcessMsg(up *UserProcess, line string) (err error) {
+	cmd, params := ParseIRCLine(line)
+	switch cmd {
+	case "":
+		return
+	case "NICK":
+		return IRCNick(up, params)
+	case "USER":
+		return IRCReply(up, ErrAlreadyRegistred, "You may not reregister")
+	case "JOIN":
+		return IRCJoin(up, params)
+	case "PART":
+		return IRCPart(up, params)
+	case "PRIVMSG":
+		return IRCPrivmsg(up, params)
+	case "NAMES":
+		return IRCNames(up, params)
+	case "PING":
+		return IRCPing(up, params)
+	case "PONG":
+		return
+	case "QUIT":
+		return errIRCQuit
+	default:
+		return IRCReply(up, ErrUnknownCommand, cmd, "Unknown command")
+	}
+}
+
+func IRCPing(up *UserProcess, params []string) error {
+	if len(params) == 0 {
+		return IRCReply(up, ErrNoOrigin, "No origin specified")
+	}
+	return WriteIRC(up, IRCLine(IRCServerName, "PONG", IRCServerName, params[0]))
+}
+
+// 修改昵称，和 /nick 一样通知同一个频道中的用户
+func IRCNick(up *UserProcess, params []string) (err error) {
+	if len(params) == 0 {
+		return IRCReply(up, ErrNoNicknameGiven, "No nickname given")
+	}
+	nick := params[0]
+	oldNick, err := hub.SetNick(up, nick)
+	if err == ErrNickTaken {
+		return IRCReply(up, ErrNicknameInUse, nick, "Nickname is already in use")
+	} else if err != nil {
+		return IRCReply(up, ErrErroneusNickname, nick, "Erroneous nickname")
+	}
+	if oldNick == nick {
+		return
+	}
+	err = WriteIRC(up, IRCLine(IRCUserPrefix(oldNick), "NICK", nick))
+	NickMsg(up, oldNick, nick)
+	return
+}
+
+// 加入一个或多个频道，用逗号分隔，加入后回复频道中的用户列表
+func IRCJoin(up *UserProcess, params []string) (err error) {
+	if len(params) == 0 {
+		return IRCReply(up, ErrNeedMoreParams, "JOIN", "Not enough parameters")
+	}
+	for _, channel := range strings.Split(params[0], ",") {
+		channel = strings.ToLower(channel)
+		if !CheckChannel(channel) {
+			err = IRCReply(up, ErrNoSuchChannel, channel, "No such channel")
+		} else if len(hub.Channels(up)) >= MaxChannels && !hub.InChannel(up, channel) {
+			err = IRCReply(up, ErrTooManyChannels, channel, "You have joined too many channels")
+		} else if hub.Join(up, channel) {
+			err = WriteIRC(up, IRCLine(IRCUserPrefix(up.Nick), "JOIN", channel))
+			JoinOrLeaveMsg(up, ClientJoin, channel)
+			if err == nil {
+				err = IRCReply(up, RplNoTopic, channel, "No topic is set")
+			}
+			if err == nil {
+				err = IRCNames(up, []string{channel})
+			}
+		}
+		if err != nil {
+			return
+		}
+	}
+	return
+}
+
+// 离开一个或多个频道
+func IRCPart(up *UserProcess, params []string) (err error) {
+	if len(params) == 0 {
+		return IRCReply(up, ErrNeedMoreParams, "PART", "Not enough parameters")
+	}
+	for _, channel := range strings.Split(params[0], ",") {
+		channel = strings.ToLower(channel)
+		if !hub.InChannel(up, channel) {
+			err = IRCReply(up, ErrNotOnChannel, channel, "You're not on that channel")
+		} else {
+			// 先通知频道中的其他用户，再离开频道
+			JoinOrLeaveMsg(up, ClientLeave, channel)
+			hub.Part(up, channel)
+			err = WriteIRC(up, IRCLine(IRCUserPrefix(up.Nick), "PART", channel))
+		}
+		if err != nil {
+			return
+		}
+	}
+	return
+}
+
+// 发消息到频道，或者私聊某个用户
+func IRCPrivmsg(up *UserProcess, params []string) (err error) {
+	if len(params) == 0 {
+		return IRCReply(up, ErrNoRecipient, "No recipient given (PRIVMSG)")
+	}
+	if len(params) < 2 || params[1] == "" {
+		return IRCReply(up, ErrNoTextToSend, "No text to send")
+	}
+	target, text := params[0], params[1]
+	msg := &Message{
+		DataSource : up.Nick,
+		Data : text,
+		ConnId : up.Id,
+	}
+	if strings.HasPrefix(target, "#") {
+		if !hub.InChannel(up, target) {
+			return IRCReply(up, ErrCannotSendToChan, target, "Cannot send to channel")
+		}
+		fmt.Printf("IRC 客户端[%s]发送到%s的消息: %s\n", up.Nick, target, text)
+		msg.Channel = strings.ToLower(target)
+		SendMesToEachOnlineUser(up, msg)
+		return
+	}
+	msg.Type = MsgPrivate
+	if !hub.SendPrivate(target, msg) {
+		return IRCReply(up, ErrNoSuchNick, target, "No such nick/channel")
+	}
+	return nil
+}
+
+// 频道中的用户列表，没有参数时列出自己加入的所有频道
+func IRCNames(up *UserProcess, params []string) (err error) {
+	channels := hub.Channels(up)
+	if len(params) > 0 {
+		channels = strings.Split(params[0], ",")
+	}
+	for _, channel := range channels {
+		channel = strings.ToLower(channel)
+		nicks := hub.Names(channel)
+		sort.Strings(nicks)
+		if len(nicks) > 0 {
+			err = IRCReply(up, RplNamReply, "=", channel, strings.Join(nicks, " "))
+			if err != nil {
+				return
+			}
+		}
+		err = IRCReply(up, RplEndOfNames, channel, "End of /NAMES list")
+		if err != nil {
+			return
+		}
+	}
+	return
+}
@@ -0,0 +1,159 @@
+/*
+	file：chatRoomIRC_test.go
+	runCmd：go test chatRoomServer.go chatRoomHub.go chatRoomIRC.go chatRoomHub_test.go chatRoomServer_test.go chatRoomIRC_test.go
+*/
+
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseIRCLine(t *testing.T) {
+	tests := []struct {
+		line   string
+		cmd    string
+		params []string
+	}{
+		{"NICK alice", "NICK", []string{"alice"}},
+		{"nick alice", "NICK", []string{"alice"}},
+		{"QUIT", "QUIT", nil},
+		// prefix 被忽略
+		{":alice!alice@host PRIVMSG #chatroom :hello world", "PRIVMSG", []string{"#chatroom", "hello world"}},
+		{":irc.example.com PONG", "PONG", nil},
+		// 最后一个参数以 : 开头，可以包含空格和 :，可以为空
+		{"PRIVMSG #chatroom :hi :there", "PRIVMSG", []string{"#chatroom", "hi :there"}},
+		{"PRIVMSG #chatroom :", "PRIVMSG", []string{"#chatroom", ""}},
+		{"PRIVMSG #chatroom ::)", "PRIVMSG", []string{"#chatroom", ":)"}},
+		{"USER guest 0 * :Real Name", "USER", []string{"guest", "0", "*", "Real Name"}},
+		// 中间的参数中的 : 不是最后一个参数的开始
+		{"NICK a:b", "NICK", []string{"a:b"}},
+		// 多余的空格
+		{"  JOIN   #a,#b  ", "JOIN", []string{"#a,#b"}},
+		{"PING :irc.example.com", "PING", []string{"irc.example.com"}},
+		{"PING token", "PING", []string{"token"}},
+		{"PONG chatroom token", "PONG", []string{"chatroom", "token"}},
+		// 没有命令的行
+		{"", "", nil},
+		{"   ", "", nil},
+		{":prefix", "", nil},
+		{":prefix   ", "", nil},
+	}
+	for _, test := range tests {
+		cmd, params := ParseIRCLine(test.line)
+		// 没有参数时返回 nil 或者空的切片都可以
+		if cmd != test.cmd || fmt.Sprintf("%q", params) != fmt.Sprintf("%q", test.params) {
+			t.Errorf("ParseIRCLine(%q) = %q %q，期望 %q %q", test.line, cmd, params, test.cmd, test.params)
+		}
+	}
+}
+
+// 测试用的 IRC 客户端
+type ircClient struct {
+	t      *testing.T
+	up     *UserProcess
+	conn   net.Conn
+	reader *bufio.Reader
+}
+
+func connectIRC(t *testing.T) *ircClient {
+	t.Helper()
+	up, conn := newPipeUser(t)
+	up.IRC = true
+	go IRCProcess(up)
+	return &ircClient{t: t, up: up, conn: conn, reader: bufio.NewReader(conn)}
+}
+
+func (this *ircClient) write(line string) {
+	this.t.Helper()
+	this.conn.SetWriteDeadline(time.Now().Add(time.Second))
+	if _, err := this.conn.Write([]byte(line + "\r\n")); err != nil {
+		this.t.Fatal(err)
+	}
+}
+
+// 读取一行，检查是 \r\n 结尾，返回去掉 \r\n 的内容
+func (this *ircClient) read() string {
+	this.t.Helper()
+	this.conn.SetReadDeadline(time.Now().Add(time.Second))
+	line, err := this.reader.ReadString('\n')
+	if err != nil {
+		this.t.Fatalf("读取消息失败：%v", err)
+	}
+	if !strings.HasSuffix(line, "\r\n") {
+		this.t.Fatalf("%q 不是以 \\r\\n 结尾", line)
+	}
+	return strings.TrimSuffix(line, "\r\n")
+}
+
+func (this *ircClient) expect(want string) {
+	this.t.Helper()
+	if line := this.read(); line != want {
+		this.t.Fatalf("收到 %q，期望 %q", line, want)
+	}
+}
+
+// 读取欢迎消息，最后一条是没有 MOTD
+func (this *ircClient) expectWelcome(nick string) {
+	this.t.Helper()
+	line := this.read()
+	if !strings.HasPrefix(line, ":"+IRCServerName+" "+RplWelcome+" "+nick+" ") {
+		this.t.Fatalf("收到 %q，期望欢迎消息", line)
+	}
+	for !strings.HasPrefix(line, ":"+IRCServerName+" "+ErrNoMotd+" ") {
+		line = this.read()
+	}
+}
+
+// IRC 用户和 /nick 的用户共用昵称，不区分大小写，不能重复
+func TestIRCNickCollision(t *testing.T) {
+	lineUser := login(t, "ncLine")
+
+	irc := connectIRC(t)
+	irc.write("NICK NCLINE")
+	irc.write("USER guest 0 * :Guest")
+	irc.expect(":chatroom 433 * NCLINE :Nickname is already in use")
+	irc.write("NICK ncIRC")
+	irc.expectWelcome("ncIRC")
+
+	// 注册以后改名
+	irc.write("NICK ncline")
+	irc.expect(":chatroom 433 ncIRC ncline :Nickname is already in use")
+
+	// /nick 的用户也不能使用 IRC 用户的昵称
+	lineUser.write("/nick NCirc\n")
+	lineUser.expectServerMsg(ErrNickTaken.Error())
+	c := connectClient(t)
+	c.expectServerMsg("/nick")
+	c.write("/nick ncirc\n")
+	c.expectServerMsg(ErrNickTaken.Error())
+
+	// IRC 用户改名以后，原来的昵称可以使用
+	irc.write("NICK ncIRC2")
+	irc.expect(":ncIRC!ncIRC@chatroom NICK ncIRC2")
+	c.write("/nick ncIRC\n")
+	c.expectServerMsg("你的昵称是[ncIRC]")
+}
+
+// PING 回复 PONG，注册前后都可以
+func TestIRCPing(t *testing.T) {
+	irc := connectIRC(t)
+	irc.write("PING :token")
+	irc.expect(":chatroom PONG chatroom token")
+	irc.write("PING")
+	irc.expect(":chatroom 409 * :No origin specified")
+	irc.write("NICK pingUser")
+	irc.write("USER guest 0 * :Guest")
+	irc.expectWelcome("pingUser")
+	// 客户端回复的 PONG 忽略
+	irc.write("PONG chatroom")
+	irc.write("PING chatroom")
+	irc.expect(":chatroom PONG chatroom chatroom")
+	irc.write("QUIT :bye")
+	irc.expect("ERROR :Closing Link")
+}
@@ -1,197 +1,352 @@
 /*
 	file：chatRoomServer.go
-	runCmd：go run chatRoomServer.go
+	runCmd：go run chatRoomServer.go chatRoomHub.go chatRoomIRC.go
 */
 
 package main
 
 import (
-	"bytes"
+	"bufio"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"net"
-	"runtime"
-	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
+	"unicode"
+	"unicode/utf8"
 )
 
-// 保存连接用户（客户端）的全局变量
+// 广播中心，保存连接用户（客户端）的全局变量
 var (
-	userMgr *UserMgr
+	hub *Hub
 )
 
 const (
 	ClientJoin = 1	// 客户端加入
 	ClientLeave = 2	// 客户端离开
+	ClientQuit = 3	// 客户端断开连接，离开所有的频道
 )
 
-// 用户（客户端）结构体
-type UserMgr struct {
-	// 在线用户（客户端）变量，map 类型
-	// key为客户端端口号，用于标识别哪个用户，
-	onlineUsers map[string]*UserProcess
-}
+// 消息的类型，客户端只需要显示 Data，IRC 客户端按类型转换成对应的命令
+const (
+	MsgChat = ""			// 频道中的聊天消息
+	MsgPrivate = "private"	// 私聊消息，只有 IRC 客户端可以发送
+	MsgJoin = "join"
+	MsgPart = "part"
+	MsgQuit = "quit"
+	MsgNick = "nick"
+)
+
+// 默认的频道，就是【ChatRoom】，客户端连接后自动加入，IRC 客户端需要 JOIN #chatroom
+const DefaultChannel = "#chatroom"
+
+// 通讯协议：
+// 客户端 -> 服务端：每行一条文本消息，以 \n 结尾
+// 服务端 -> 客户端：每行一个 JSON 格式的 Message，以 \n 结尾（JSON 中的换行会被转义，不会把一条消息拆开）
+// 连接后第一行必须是 /nick <昵称>，昵称可用后才加入聊天室，之后也可以用 /nick 改名
+const (
+	MaxLineSize = 64 * 1024	// 一行最多的字节数，超过时断开连接
+	MaxNickLen = 20			// 昵称最多的字符数
+	ServerSource = "服务端"	// 服务端发出的消息的来源
+)
+
+var (
+	ErrNickInvalid = errors.New("昵称不能为空，不能包含空白字符和 #,!@:*? 等字符，并且不能超过 20 个字符")
+	ErrNickTaken = errors.New("昵称已被使用")
+)
+
+// 连接 id，每个连接一个，不会重复
+var nextConnId uint64
 
 // 用户（客户端）连接进程信息
 type UserProcess struct {
+	// 连接 id
+	Id uint64
 	// 连接句柄
 	Conn net.Conn
 	// RemoteAddr 字段，表示该Conn是哪个用户
 	UserAddr string
+	// 昵称，握手成功后设置，只在 hub 协程中修改
+	Nick string
+	// 是否是 IRC 客户端，发给 IRC 客户端的消息需要转换成 IRC 的命令
+	IRC bool
+	// 加入的频道，key 为小写的频道名，只在 hub 协程中读写
+	channels map[string]bool
+	// 按行读取客户端发送的消息
+	scanner *bufio.Scanner
+	// 发送队列，由 WritePump 协程写到连接中
+	send chan []byte
+	// 连接结束时关闭
+	done chan struct{}
+	once sync.Once
 }
 
 // 消息结构体
 type Message struct {
-	DataSource string	`json:"data_source"`	// 消息（数据）来源
+	DataSource string	`json:"data_source"`	// 消息（数据）来源，用户的昵称或者"服务端"
 	Data string	`json:"data"`					// 消息（数据）内容
+	ConnId uint64	`json:"conn_id,omitempty"`	// 发送消息的连接 id，服务端发出的消息为 0
+	Type string	`json:"type,omitempty"`			// 消息的类型，MsgChat MsgJoin 等
+	Channel string	`json:"channel,omitempty"`	// 频道
+	Nick string	`json:"nick,omitempty"`			// 加入，离开，改名的用户，改名时为原来的昵称
+	NewNick string	`json:"new_nick,omitempty"`	// 改名后的昵称
 }
 
-// 读取消息（数据）函数，入参为 连接句柄，返回消息体 Message
-func ReadData(conn net.Conn) (msg Message, err error) {
-	// 定义一个缓存变量 buf，可以存放 4096 个字节
-	buf := make([]byte, 4096)
-	// 读取客户端发送的消息
-	n, err := conn.Read(buf[:])
+// 新的连接
+func NewUserProcess(conn net.Conn) *UserProcess {
+	up := &UserProcess{
+		Id : atomic.AddUint64(&nextConnId, 1),	// 当前连接的 id，用于标识是哪个客户端
+		Conn : conn,							// 当前连接
+		UserAddr : conn.RemoteAddr().String(),	// 当前连接的客户端地址
+		channels : make(map[string]bool),
+		scanner : bufio.NewScanner(conn),
+		send : make(chan []byte, SendQueueSize),
+		done : make(chan struct{}),
+	}
+	up.scanner.Buffer(make([]byte, 4096), MaxLineSize)
+	return up
+}
+
+// 发送消息给这个用户（客户端），按客户端的协议编码后放到发送队列，不会阻塞
+// 只有用户自己的协程和 hub 协程可以调用
+func (this *UserProcess) Send(msg *Message) (err error) {
+	if this.IRC {
+		return WriteIRCMsg(this, msg)
+	}
+	data, err := json.Marshal(msg)
 	if err != nil {
 		return
 	}
+	return WriteData(this, data)
+}
+
+// 检查昵称是否合法，IRC 中有特殊含义的字符不能出现在昵称中
+func CheckNick(nick string) error {
+	if nick == "" || utf8.RuneCountInString(nick) > MaxNickLen || nick == ServerSource {
+		return ErrNickInvalid
+	}
+	if strings.ContainsAny(nick, "#,!@:*?") {
+		return ErrNickInvalid
+	}
+	for _, r := range nick {
+		if unicode.IsSpace(r) || !unicode.IsPrint(r) {
+			return ErrNickInvalid
+		}
+	}
+	return nil
+}
+
+// 解析 /nick <昵称> 命令，不是这个命令时 ok 为 false
+func ParseNick(data string) (nick string, ok bool) {
+	fields := strings.Fields(data)
+	if len(fields) == 0 || fields[0] != "/nick" {
+		return "", false
+	}
+	return strings.TrimSpace(strings.TrimPrefix(strings.TrimSpace(data), "/nick")), true
+}
+
+// 读取消息（数据）函数，入参为 用户连接信息，返回消息体 Message
+// 每次读取一整行，一行就是一条完整的消息
+func ReadData(up *UserProcess) (msg Message, err error) {
+	// 读取客户端发送的一行消息
+	if !up.scanner.Scan() {
+		err = up.scanner.Err()
+		if err == nil {
+			err = io.EOF
+		}
+		return
+	}
 
-	// 将客户端地址和读取到的消息赋值到消息体结构
-	msg.DataSource = conn.RemoteAddr().String()
-	msg.Data = string(buf[:n])
+	// 将客户端昵称和读取到的消息赋值到消息体结构
+	msg.DataSource = up.Nick
+	msg.ConnId = up.Id
+	msg.Data = strings.TrimRight(up.scanner.Text(), "\r")
 
 	return
 }
 
-// 写消息（数据）函数，入参为 连接句柄 和 需要发送到消息（数据）data
-func WriteData(conn net.Conn, data []byte) (err error) {
+// 写消息（数据）函数，入参为 用户连接信息 和 需要发送到消息（数据）data
+// data 后面加上换行符放到发送队列，由 WritePump 协程在一次 Write 中发送，消息不会交错
+func WriteData(up *UserProcess, data []byte) (err error) {
 	// 写（发送）数据
-	_, err = conn.Write(data)
+	return up.enqueue(append(data, '\n'))
+}
+
+// 给一个用户（客户端）发送服务端的提示消息
+func WriteServerMsg(up *UserProcess, text string) (err error) {
+	data, err := json.Marshal(Message{DataSource: ServerSource, Data: text})
 	if err != nil {
-		fmt.Println("conn.Write fail", err)
 		return
 	}
-	return
+	return WriteData(up, data)
 }
 
-// 广播消息给每一个在线用户（客户端）
-func SendMesToEachOnlineUser(userKey string, data []byte) {
-	// 遍历在线用户（客户端）全局队列，给每一个在线用户（客户端）发消息
-	for id, up := range userMgr.onlineUsers {
-		// 排除掉本身（当前客户端）
-		if id == userKey {
-			continue
-		}
-		// 发送消息（数据）
-		err := WriteData(up.Conn, data)
-		// 发送出错时，打错误信息
-		if err != nil {
-			fmt.Printf("转发消息给[%s]客户端失败，失败信息为：%v\n", up.UserAddr, err)
-		}
-	}
+// 广播消息给频道 msg.Channel 中的每一个在线用户（客户端），from 除外
+// msg.Channel 为空时发给和 from 在同一个频道中的用户，由 hub 协程放到每个用户的发送队列
+func SendMesToEachOnlineUser(from *UserProcess, msg *Message) {
+	hub.Broadcast(from, msg.Channel, msg)
 }
 
 // 服务端处理通讯消息函数
-func ServerProcessMsg(userKey string, msg *Message) (err error) {
+func ServerProcessMsg(up *UserProcess, msg *Message) (err error) {
+	// 改名
+	if nick, ok := ParseNick(msg.Data); ok {
+		return ChangeNick(up, nick)
+	}
+	if strings.TrimSpace(msg.Data) == "" {
+		return
+	}
 	// 打印提示信息
 	fmt.Printf("客户端[%s]发送的消息: %s\n", msg.DataSource, msg.Data)
-	// 序列化消息
-	data, err := json.Marshal(msg)
+	// 广播消息给聊天室中每一个在线的用户（客户端），当前客户端除外
+	msg.Channel = DefaultChannel
+	SendMesToEachOnlineUser(up, msg)
+	return
+}
+
+// 修改昵称，昵称不可用时只告诉当前客户端
+func ChangeNick(up *UserProcess, nick string) (err error) {
+	oldNick, err := hub.SetNick(up, nick)
 	if err != nil {
-		fmt.Println("json.Marshal err=", err)
+		return WriteServerMsg(up, err.Error())
+	}
+	if oldNick == nick {
 		return
 	}
-	// 广播消息给每一个在线的用户（客户端），当前客户端除外
-	SendMesToEachOnlineUser(userKey, data)
-	return
+	NickMsg(up, oldNick, nick)
+	return WriteServerMsg(up, fmt.Sprintf("你的昵称已改为[%s]", nick))
+}
+
+// 用户改名后，通知和他在同一个频道中的用户
+func NickMsg(up *UserProcess, oldNick string, nick string) {
+	msg := &Message{
+		DataSource : ServerSource,
+		Data : fmt.Sprintf("[%s]改名为[%s]", oldNick, nick),
+		Type : MsgNick,
+		Nick : oldNick,
+		NewNick : nick,
+	}
+	hub.Broadcast(up, "", msg)
+}
+
+// 握手：读取客户端发送的 /nick <昵称>，昵称可用后加入聊天室
+func Handshake(up *UserProcess) (err error) {
+	err = WriteServerMsg(up, "欢迎来到【ChatRoom】，请先发送 /nick <昵称> 设置你的昵称")
+	if err != nil {
+		return
+	}
+	for {
+		msg, err := ReadData(up)
+		if err != nil {
+			return err
+		}
+		nick, ok := ParseNick(msg.Data)
+		if !ok {
+			err = WriteServerMsg(up, "请先发送 /nick <昵称> 设置你的昵称")
+		} else if err = hub.Register(up, nick); err != nil {
+			err = WriteServerMsg(up, err.Error()+"，请换一个昵称")
+		} else {
+			return WriteServerMsg(up, fmt.Sprintf("你的昵称是[%s]，现在可以进行通讯了", up.Nick))
+		}
+		if err != nil {
+			return err
+		}
+	}
 }
 
 // 封装正式处理通讯的函数
-func SubProcess(conn net.Conn, userKey string) (err error) {
+func SubProcess(up *UserProcess) (err error) {
 	// 循环读取客户端发送过来的消息（数据）
 	for {
 		// 读取消息
-		msg, err := ReadData(conn)
-		// 报错或者客户端连接断开时，打印提示信息，并将该用户（客户端）在全局队列里剔除掉
+		msg, err := ReadData(up)
+		// 报错或者客户端连接断开时，返回错误，由 Process 把该用户（客户端）在全局队列里剔除掉
 		if err != nil {
-			delete(userMgr.onlineUsers, userKey)
 			if err == io.EOF {
-				fmt.Printf("客户端[%s]退出，与服务器端的连接断开.\n", conn.RemoteAddr())
-
-				// 将客户端离开通讯室的消息广播出去
-				clientAddr := conn.RemoteAddr().String()
-				JoinOrLeaveMsg(userKey, clientAddr, ClientLeave)
-
-				return err
+				fmt.Printf("客户端[%s]退出，与服务器端的连接断开.\n", up.Nick)
 			} else {
 				fmt.Println("ReadData err=", err)
-				return err
 			}
+			return err
 		}
 
 		// 服务端处理消息
-		err = ServerProcessMsg(userKey, &msg)
+		err = ServerProcessMsg(up, &msg)
 		if err != nil {
 			return err
 		}
 	}
 }
 
-// 客户端加入或离开 ChatRoom，需要发送消息通知每个在线的客户端
-func JoinOrLeaveMsg(userKey string, clientAddr string, msgType int) {
+// 客户端加入或离开频道，需要发送消息通知频道中每个在线的客户端
+// 断开连接（ClientQuit）时通知所有和他在同一个频道中的客户端，需要在离开频道之前调用
+func JoinOrLeaveMsg(up *UserProcess, msgType int, channel string) {
+	msg := &Message{
+		DataSource : ServerSource,
+		Channel : channel,
+		Nick : up.Nick,
+	}
 	var msgStr string
 	switch msgType {
-	case 1:
+	case ClientJoin:
 		msgStr = "加入"
-	case 2:
+		msg.Type = MsgJoin
+	case ClientLeave:
+		msgStr = "离开"
+		msg.Type = MsgPart
+	case ClientQuit:
 		msgStr = "离开"
+		msg.Type = MsgQuit
+		msg.Channel = ""
 	default:
 		fmt.Println("JoinOrLeaveMsg type wrong!")
+		return
 	}
 
-	// 将客户端加入聊天室的消息广播出去
-	var msg Message
-	joinStr := fmt.Sprintf("客户端[%s]已%s【ChatRoom】", clientAddr, msgStr)
-	msg.DataSource = "服务端"
-	msg.Data = joinStr
-	joinMsg, err := json.Marshal(msg)
-	if err != nil {
-		fmt.Println("json.Marshal err=", err)
+	// 将客户端加入或离开的消息广播出去
+	room := "【ChatRoom】"
+	if channel != "" && !strings.EqualFold(channel, DefaultChannel) {
+		room = "频道" + channel
 	}
-	SendMesToEachOnlineUser(userKey, joinMsg)
+	msg.Data = fmt.Sprintf("[%s]已%s%s", msg.Nick, msgStr, room)
+	SendMesToEachOnlineUser(up, msg)
 }
 
-// 处理和客户端的通讯，入参 连接句柄和 userKey
-// userKey 其实为当前连接客户端的端口号，用于标识是哪个客户端
-func Process(conn net.Conn, userKey string) {
-	//这里需要延时关闭conn
-	defer conn.Close()
+// 处理和客户端的通讯，入参 用户连接信息
+func Process(up *UserProcess) {
+	// 写协程负责发送消息，结束时关闭conn
+	go up.WritePump()
+	defer up.Close()
+
+	// 先握手，设置昵称后才加入聊天室
+	err := Handshake(up)
+	if err == nil {
+		fmt.Printf("客户端[%s]的昵称为[%s]，连接 id 为 %d.\n", up.UserAddr, up.Nick, up.Id)
+		// 客户端加入通讯室时，广播该客户端加入的消息
+		hub.Join(up, DefaultChannel)
+		JoinOrLeaveMsg(up, ClientJoin, DefaultChannel)
+
+		// 处理通讯
+		err = SubProcess(up)
 
-	// 处理通讯
-	err := SubProcess(conn, userKey)
+		// 将客户端离开通讯室的消息广播出去，并将该用户（客户端）在全局队列里剔除掉
+		JoinOrLeaveMsg(up, ClientQuit, "")
+		hub.Unregister(up)
+	}
 	// 通讯出错时，打印错误信息
 	if err != io.EOF {
-		fmt.Printf("客户端[%v]和服务器协程[%v]通讯错误，错误信息为：%s\n",
-			conn.RemoteAddr().String(), GetGID(), err)
+		fmt.Printf("客户端[%v]（连接 id 为 %d）通讯错误，错误信息为：%s\n",
+			up.UserAddr, up.Id, err)
 	}
 }
 
-// 获取协程 id，用于标识哪个协程
-func GetGID() uint64 {
-	b := make([]byte, 64)
-	b = b[:runtime.Stack(b, false)]
-	b = bytes.TrimPrefix(b, []byte("goroutine "))
-	b = b[:bytes.IndexByte(b, ' ')]
-	n, _ := strconv.ParseUint(string(b), 10, 64)
-	return n
-}
-
-//完成对userMgr初始化工作
+//完成对hub初始化工作，启动hub协程
 func init() {
-	userMgr = &UserMgr{
-		onlineUsers : make(map[string]*UserProcess, 1024),
-	}
+	hub = NewHub()
+	go hub.Run()
 }
 
 // 服务端主函数（入口函数）
@@ -200,11 +355,14 @@ func main() {
 	fmt.Println("服务器在 8080 端口监听....")
 	// 服务端监听端口 8080
 	listen, err := net.Listen("tcp", "0.0.0.0:8080")
-	defer listen.Close()
 	if err != nil {
 		fmt.Println("net.Listen err=", err)
 		return
 	}
+	defer listen.Close()
+
+	// IRC 客户端连接另外一个端口
+	go ListenIRC(IRCAddr)
 
 	// 一旦监听成功，就等待客户端连接的到来
 	fmt.Println("服务器等待客户端的连接.....")
@@ -212,24 +370,17 @@ func main() {
 		conn, err := listen.Accept()
 		if err != nil {
 			fmt.Println("listen.Accept err=" ,err)
+			continue
 		}
 
-		// 连接进来的客户端加入  用户(客户端)全局队列
+		// 连接进来的客户端先握手设置昵称，然后才加入 用户(客户端)全局队列
 		// 后面用于广播消息到该队列所有的客户端
-		clientAddr := conn.RemoteAddr().String()
-		var userProcess UserProcess
-		userProcess.Conn = conn		// 当前连接
-		userProcess.UserAddr = clientAddr	// 当前连接的客户端地址
-		userKey := strings.Split(clientAddr, ":")[1]	// 当前连接的客户端端口号，用与标识是哪个客户端
-		userMgr.onlineUsers[userKey] = &userProcess
+		userProcess := NewUserProcess(conn)
 
 		// 打印建立连接的信息
-		fmt.Printf("客户端[%s]与服务端已建立连接.\n", clientAddr)
-
-		// 客户端加入通讯室时，广播该客户端加入的消息
-		JoinOrLeaveMsg(userKey, clientAddr, ClientJoin)
+		fmt.Printf("客户端[%s]与服务端已建立连接.\n", userProcess.UserAddr)
 
 		// 一旦连接建立成功，则单独启动一个协程和客户端保持通讯
-		go Process(conn, userKey)
+		go Process(userProcess)
 	}
 }
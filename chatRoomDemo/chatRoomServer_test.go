@@ -0,0 +1,164 @@
+/*
+	file：chatRoomServer_test.go
+	runCmd：go test chatRoomServer.go chatRoomHub.go chatRoomIRC.go chatRoomHub_test.go chatRoomServer_test.go chatRoomIRC_test.go
+*/
+
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"io"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+// 测试用的客户端，连接到全局的 hub
+type testClient struct {
+	t      *testing.T
+	up     *UserProcess
+	conn   net.Conn
+	reader *bufio.Reader
+}
+
+// 建立连接，服务端由 Process 处理
+func connectClient(t *testing.T) *testClient {
+	t.Helper()
+	up, conn := newPipeUser(t)
+	go Process(up)
+	return &testClient{t: t, up: up, conn: conn, reader: bufio.NewReader(conn)}
+}
+
+func (this *testClient) write(data string) {
+	this.t.Helper()
+	this.conn.SetWriteDeadline(time.Now().Add(time.Second))
+	if _, err := io.WriteString(this.conn, data); err != nil {
+		this.t.Fatal(err)
+	}
+}
+
+// 读取一行，每行是一个 JSON 格式的 Message
+func (this *testClient) read() (msg Message) {
+	this.t.Helper()
+	this.conn.SetReadDeadline(time.Now().Add(time.Second))
+	line, err := this.reader.ReadString('\n')
+	if err != nil {
+		this.t.Fatalf("读取消息失败：%v", err)
+	}
+	if err = json.Unmarshal([]byte(line), &msg); err != nil {
+		this.t.Fatalf("消息 %q 不是 JSON：%v", line, err)
+	}
+	return
+}
+
+// 读取一条服务端的提示消息，检查内容
+func (this *testClient) expectServerMsg(contains string) {
+	this.t.Helper()
+	msg := this.read()
+	if msg.DataSource != ServerSource || !strings.Contains(msg.Data, contains) {
+		this.t.Fatalf("收到的消息是 %+v，期望服务端的 %q", msg, contains)
+	}
+}
+
+// 连接并设置昵称，等到加入聊天室以后返回
+func login(t *testing.T, nick string) *testClient {
+	t.Helper()
+	c := connectClient(t)
+	c.expectServerMsg("/nick")
+	c.write("/nick " + nick + "\n")
+	c.expectServerMsg("你的昵称是[" + nick + "]")
+	deadline := time.Now().Add(time.Second)
+	for !hub.InChannel(c.up, DefaultChannel) {
+		if time.Now().After(deadline) {
+			t.Fatalf("[%s]没有加入聊天室", nick)
+		}
+		time.Sleep(time.Millisecond)
+	}
+	return c
+}
+
+// 第一行必须是 /nick，昵称不合法或者被使用时可以重试
+func TestHandshake(t *testing.T) {
+	alice := login(t, "hsAlice")
+
+	c := connectClient(t)
+	c.expectServerMsg("/nick")
+	c.write("hello\n")
+	c.expectServerMsg("请先发送 /nick")
+	c.write("/nick\n")
+	c.expectServerMsg(ErrNickInvalid.Error())
+	c.write("/nick bad nick\n")
+	c.expectServerMsg(ErrNickInvalid.Error())
+	c.write("/nick HSALICE\n")
+	c.expectServerMsg(ErrNickTaken.Error())
+	c.write("/nick hsBob\r\n")
+	c.expectServerMsg("你的昵称是[hsBob]")
+
+	msg := alice.read()
+	if msg.Type != MsgJoin || msg.Nick != "hsBob" || msg.Channel != DefaultChannel {
+		t.Fatalf("收到的加入消息是 %+v", msg)
+	}
+
+	// 握手以后也可以用 /nick 改名
+	c.write("/nick hsCarol\n")
+	c.expectServerMsg("你的昵称已改为[hsCarol]")
+	msg = alice.read()
+	if msg.Type != MsgNick || msg.Nick != "hsBob" || msg.NewNick != "hsCarol" {
+		t.Fatalf("收到的改名消息是 %+v", msg)
+	}
+}
+
+// 握手时断开连接，不会加入在线列表
+func TestHandshakeEOF(t *testing.T) {
+	c := connectClient(t)
+	c.expectServerMsg("/nick")
+	c.conn.Close()
+	expectDone(t, c.up)
+	hub.Do(func() {
+		if _, ok := hub.onlineUsers[c.up.Id]; ok {
+			t.Errorf("没有设置昵称的连接在在线列表中")
+		}
+	})
+}
+
+// 客户端每行一条消息，一次写入多行或者一行分多次写入都按行拆分
+func TestLineFraming(t *testing.T) {
+	a := login(t, "lfA")
+	b := login(t, "lfB")
+	if msg := a.read(); msg.Type != MsgJoin || msg.Nick != "lfB" {
+		t.Fatalf("收到的加入消息是 %+v", msg)
+	}
+
+	a.write("第一行\r\n第二行\n\n")
+	a.write("分两次")
+	a.write("写入\n")
+	a.write(`带 "引号" 和 \ 的消息` + "\n")
+	for _, want := range []string{"第一行", "第二行", "分两次写入", `带 "引号" 和 \ 的消息`} {
+		msg := b.read()
+		if msg.DataSource != "lfA" || msg.Data != want || msg.ConnId != a.up.Id || msg.Channel != DefaultChannel {
+			t.Fatalf("收到的消息是 %+v，期望 %q", msg, want)
+		}
+	}
+}
+
+// 一行超过 MaxLineSize 时断开连接，通知其他人离开
+func TestLineTooLong(t *testing.T) {
+	a := login(t, "longA")
+	b := login(t, "longB")
+	if msg := a.read(); msg.Type != MsgJoin || msg.Nick != "longB" {
+		t.Fatalf("收到的加入消息是 %+v", msg)
+	}
+
+	// 服务端断开后写入会失败，不用检查错误
+	go io.WriteString(b.conn, strings.Repeat("x", MaxLineSize+1)+"\n")
+	expectDone(t, b.up)
+	if msg := a.read(); msg.Type != MsgQuit || msg.Nick != "longB" {
+		t.Fatalf("收到的离开消息是 %+v", msg)
+	}
+	b.conn.SetReadDeadline(time.Now().Add(time.Second))
+	if _, err := b.reader.ReadString('\n'); err != io.EOF {
+		t.Fatalf("连接断开后读取返回 %v，期望 io.EOF", err)
+	}
+}
@@ -0,0 +1,368 @@
+package command
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+//解析客户端输入的一行内容
+//以 / 开头的是命令, 比如 /msg 100 "你 好"，其它的是发到当前房间的聊天内容
+//这个包不依赖网络, 只负责解析和补全, 命令的执行由 process 包完成
+
+//一条命令的说明
+type Spec struct {
+	Name    string
+	Args    string //参数的用法说明
+	Help    string
+	MinArgs int
+	MaxArgs int
+	//最后一个参数可以不加引号直接包含空格，多出来的参数合并到最后一个参数中
+	Rest bool
+}
+
+//客户端支持的命令
+var Specs = []Spec{
+	{Name: "msg", Args: "<用户id> <内容>", Help: "给某个用户发送私聊消息", MinArgs: 2, MaxArgs: 2, Rest: true},
+	{Name: "emsg", Args: "<用户id> <内容>", Help: "发送端到端加密的私聊消息", MinArgs: 2, MaxArgs: 2, Rest: true},
+	{Name: "key", Args: "[用户id]", Help: "显示自己或者某个用户的公钥指纹", MinArgs: 0, MaxArgs: 1},
+	{Name: "trust", Args: "<用户id>", Help: "确认某个用户变化后的公钥", MinArgs: 1, MaxArgs: 1},
+	{Name: "edit", Args: "<消息id> <内容>", Help: "修改自己发的消息", MinArgs: 2, MaxArgs: 2, Rest: true},
+	{Name: "delete", Args: "<消息id>", Help: "删除自己发的消息", MinArgs: 1, MaxArgs: 1},
+	{Name: "react", Args: "<消息id> <表情>", Help: "用表情回应一条消息, 再次回应同一个表情会取消", MinArgs: 2, MaxArgs: 2},
+	{Name: "join", Args: "<房间>", Help: "进入房间, /join lobby 回到大厅", MinArgs: 1, MaxArgs: 1},
+	{Name: "keyword", Args: "<add|del> <关键词>", Help: "群聊消息中包含关键词时提醒我", MinArgs: 2, MaxArgs: 2, Rest: true},
+	{Name: "muteroom", Args: "<房间>", Help: "不再提醒某个房间中的@和关键词", MinArgs: 1, MaxArgs: 1},
+	{Name: "unmuteroom", Args: "<房间>", Help: "恢复某个房间的提醒", MinArgs: 1, MaxArgs: 1},
+	{Name: "notify", Help: "显示关键词和屏蔽提醒的房间", MinArgs: 0, MaxArgs: 0},
+	{Name: "who", Help: "显示在线用户", MinArgs: 0, MaxArgs: 0},
+	{Name: "history", Args: "[条数] [用户id]", Help: "查看当前房间或者和某个用户的聊天记录", MinArgs: 0, MaxArgs: 2},
+	{Name: "search", Args: "[from:id] [in:房间] [with:id] [since:日期] [until:日期] [内容]", Help: "搜索聊天记录, 不带参数时显示下一页", MinArgs: 0, MaxArgs: 1, Rest: true},
+	{Name: "schedule", Args: "<时间> <房间|用户id> <内容>", Help: "定时发送消息, 时间可以是 +10m, 17:00 或者 \"2024-01-02 17:00\"", MinArgs: 3, MaxArgs: 3, Rest: true},
+	{Name: "remind", Args: "<时间> <内容>", Help: "到时间提醒我, 时间的格式和 /schedule 一样", MinArgs: 2, MaxArgs: 2, Rest: true},
+	{Name: "unschedule", Args: "<任务id>", Help: "取消定时消息或者提醒", MinArgs: 1, MaxArgs: 1},
+	{Name: "status", Args: "<online|away|busy|invisible> [说明]", Help: "设置自己的状态", MinArgs: 1, MaxArgs: 2, Rest: true},
+	{Name: "menu", Help: "显示原来的数字菜单", MinArgs: 0, MaxArgs: 0},
+	{Name: "help", Args: "[命令]", Help: "显示帮助", MinArgs: 0, MaxArgs: 1},
+	{Name: "quit", Help: "退出系统", MinArgs: 0, MaxArgs: 0},
+}
+
+//可以设置的状态
+var Statuses = []string{"online", "away", "busy", "invisible"}
+
+//关键词的操作
+var KeywordActions = []string{"add", "del"}
+
+//解析后的一行输入, Name 为空表示普通的聊天内容, 内容在Text中
+type Command struct {
+	Name string
+	Args []string
+	Text string
+}
+
+var (
+	ErrUnclosedQuote = errors.New("引号没有闭合")
+	ErrEmptyCommand  = errors.New("请在 / 后面输入命令, 输入 /help 查看帮助")
+)
+
+//未知的命令
+type UnknownError struct {
+	Name string
+}
+
+func (this *UnknownError) Error() string {
+	return fmt.Sprintf("未知的命令 /%s, 输入 /help 查看帮助", this.Name)
+}
+
+//命令的参数不正确
+type UsageError struct {
+	Spec *Spec
+}
+
+func (this *UsageError) Error() string {
+	return "用法: " + this.Spec.Usage()
+}
+
+//命令的用法, 比如 /msg <用户id> <内容>
+func (this *Spec) Usage() string {
+	if this.Args == "" {
+		return "/" + this.Name
+	}
+	return "/" + this.Name + " " + this.Args
+}
+
+//根据名字找到命令
+func Lookup(name string) *Spec {
+	for i := range Specs {
+		if Specs[i].Name == name {
+			return &Specs[i]
+		}
+	}
+	return nil
+}
+
+//按空白切分参数, 支持双引号, 单引号和反斜杠转义
+//双引号中可以用反斜杠转义, 单引号中的内容原样保留
+func Split(line string) (args []string, err error) {
+
+	var cur strings.Builder
+	inArg := false //当前是否有一个参数, 用来支持 "" 这样的空参数
+	var quote rune
+	escaped := false
+	for _, r := range line {
+		switch {
+			case escaped:
+				cur.WriteRune(r)
+				escaped = false
+			case r == '\\' && quote != '\'':
+				escaped = true
+				inArg = true
+			case quote != 0:
+				if r == quote {
+					quote = 0
+				} else {
+					cur.WriteRune(r)
+				}
+			case r == '"' || r == '\'':
+				quote = r
+				inArg = true
+			case r == ' ' || r == '\t':
+				if inArg {
+					args = append(args, cur.String())
+					cur.Reset()
+					inArg = false
+				}
+			default:
+				cur.WriteRune(r)
+				inArg = true
+		}
+	}
+	if quote != 0 {
+		return nil, ErrUnclosedQuote
+	}
+	if escaped {
+		//行尾的反斜杠当作普通字符
+		cur.WriteRune('\\')
+	}
+	if inArg {
+		args = append(args, cur.String())
+	}
+	return
+}
+
+//解析一行输入
+func Parse(line string) (cmd *Command, err error) {
+
+	line = strings.TrimRight(line, "\r\n")
+	if !strings.HasPrefix(line, "/") {
+		return &Command{Text: line}, nil
+	}
+	// 用 // 开头可以发送以 / 开头的聊天内容
+	if strings.HasPrefix(line, "//") {
+		return &Command{Text: line[1:]}, nil
+	}
+
+	args, err := Split(line[1:])
+	if err != nil {
+		return
+	}
+	if len(args) == 0 || args[0] == "" {
+		return nil, ErrEmptyCommand
+	}
+	name := strings.ToLower(args[0])
+	args = args[1:]
+	spec := Lookup(name)
+	if spec == nil {
+		return nil, &UnknownError{Name: name}
+	}
+	if spec.Rest && len(args) > spec.MaxArgs {
+		rest := strings.Join(args[spec.MaxArgs-1:], " ")
+		args = append(args[:spec.MaxArgs-1], rest)
+	}
+	if len(args) < spec.MinArgs || len(args) > spec.MaxArgs {
+		return nil, &UsageError{Spec: spec}
+	}
+	cmd = &Command{
+		Name: name,
+		Args: args,
+	}
+	err = cmd.check(spec)
+	if err != nil {
+		return nil, err
+	}
+	return
+}
+
+//检查参数的类型
+func (this *Command) check(spec *Spec) (err error) {
+	usage := &UsageError{Spec: spec}
+	switch this.Name {
+		case "msg", "emsg", "trust", "key", "edit", "delete", "react", "unschedule":
+			if len(this.Args) == 0 {
+				break
+			}
+			if id, err := strconv.Atoi(this.Args[0]); err != nil || id <= 0 {
+				return usage
+			}
+		case "history":
+			for _, arg := range this.Args {
+				if n, err := strconv.Atoi(arg); err != nil || n <= 0 {
+					return usage
+				}
+			}
+		case "status":
+			if !contains(Statuses, strings.ToLower(this.Args[0])) {
+				return usage
+			}
+			this.Args[0] = strings.ToLower(this.Args[0])
+		case "join", "muteroom", "unmuteroom":
+			if this.Args[0] == "" {
+				return usage
+			}
+		case "keyword":
+			this.Args[0] = strings.ToLower(this.Args[0])
+			if !contains(KeywordActions, this.Args[0]) || strings.TrimSpace(this.Args[1]) == "" {
+				return usage
+			}
+		case "help":
+			if len(this.Args) == 1 && Lookup(strings.TrimPrefix(this.Args[0], "/")) == nil {
+				return &UnknownError{Name: strings.TrimPrefix(this.Args[0], "/")}
+			}
+	}
+	return nil
+}
+
+//第i个参数转成整数, 参数不存在时返回def
+//Parse 已经检查过参数的类型, 这里不再返回错误
+func (this *Command) Int(i int, def int) int {
+	if i >= len(this.Args) {
+		return def
+	}
+	n, err := strconv.Atoi(this.Args[i])
+	if err != nil {
+		return def
+	}
+	return n
+}
+
+//第i个参数, 不存在时返回空字符串
+func (this *Command) Arg(i int) string {
+	if i >= len(this.Args) {
+		return ""
+	}
+	return this.Args[i]
+}
+
+func contains(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+//帮助信息, name 为空时返回所有命令的帮助
+func Help(name string) string {
+	var sb strings.Builder
+	for i := range Specs {
+		spec := &Specs[i]
+		if name != "" && spec.Name != strings.TrimPrefix(name, "/") {
+			continue
+		}
+		fmt.Fprintf(&sb, "%-45s %s\n", spec.Usage(), spec.Help)
+	}
+	if name == "" {
+		sb.WriteString("不以 / 开头的内容会发送到当前房间, 以 / 开头的内容请输入 //\n")
+		sb.WriteString("不支持直接按 Tab 补全, 可以在输入的末尾按 Tab 再回车, 显示补全的结果后继续输入剩余的部分\n")
+	}
+	return sb.String()
+}
+
+//补全一行输入, 返回补全后的整行, 按字母顺序排列
+//只补全最后一个词: 命令名, /msg 等命令的用户id, /status 的状态
+//客户端在行缓冲模式下读取输入, 收不到单独的Tab, 需要用户输入 Tab 再回车后调用
+func Complete(line string, onlineIds []int) (candidates []string) {
+
+	if !strings.HasPrefix(line, "/") {
+		return nil
+	}
+	//已经输入的部分和正在输入的最后一个词
+	idx := strings.LastIndexAny(line, " \t")
+	head, word := line[:idx+1], line[idx+1:]
+	fields := strings.Fields(line[1:])
+	if idx >= 0 && len(fields) == 0 {
+		return nil
+	}
+	if idx < 0 {
+		//还在输入命令名
+		for _, spec := range Specs {
+			if strings.HasPrefix(spec.Name, strings.ToLower(word[1:])) {
+				candidates = append(candidates, "/"+spec.Name+" ")
+			}
+		}
+		sort.Strings(candidates)
+		return
+	}
+
+	//当前是第几个参数, 从0开始
+	argIdx := len(fields) - 1
+	if word != "" {
+		argIdx--
+	}
+	var words []string
+	switch strings.ToLower(fields[0]) {
+		case "msg", "emsg", "trust", "key":
+			if argIdx == 0 {
+				words = idStrings(onlineIds)
+			}
+		case "history":
+			if argIdx == 1 {
+				words = idStrings(onlineIds)
+			}
+		case "status":
+			if argIdx == 0 {
+				words = Statuses
+			}
+		case "keyword":
+			if argIdx == 0 {
+				words = KeywordActions
+			}
+		case "help":
+			if argIdx == 0 {
+				for _, spec := range Specs {
+					words = append(words, spec.Name)
+				}
+			}
+	}
+	for _, w := range words {
+		if strings.HasPrefix(w, word) {
+			candidates = append(candidates, head+w+" ")
+		}
+	}
+	sort.Strings(candidates)
+	return
+}
+
+func idStrings(ids []int) (words []string) {
+	for _, id := range ids {
+		words = append(words, strconv.Itoa(id))
+	}
+	return
+}
+
+//多个候选项的公共前缀, 用来在有多个候选项时尽量补全
+func CommonPrefix(candidates []string) string {
+	if len(candidates) == 0 {
+		return ""
+	}
+	prefix := candidates[0]
+	for _, c := range candidates[1:] {
+		for !strings.HasPrefix(c, prefix) {
+			prefix = prefix[:len(prefix)-1]
+		}
+	}
+	return prefix
+}
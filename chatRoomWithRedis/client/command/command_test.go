@@ -0,0 +1,194 @@
+package command
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestSplit(t *testing.T) {
+	tests := []struct {
+		line string
+		args []string
+		err  error
+	}{
+		{line: "", args: nil},
+		{line: "  \t ", args: nil},
+		{line: "a b  c", args: []string{"a", "b", "c"}},
+		{line: "a\tb", args: []string{"a", "b"}},
+		{line: `"你 好" x`, args: []string{"你 好", "x"}},
+		{line: `a"b c"d`, args: []string{"ab cd"}},
+		{line: `"" x`, args: []string{"", "x"}},
+		{line: `x ''`, args: []string{"x", ""}},
+		//双引号中可以转义, 单引号中原样保留
+		{line: `"a\"b"`, args: []string{`a"b`}},
+		{line: `'a\ b'`, args: []string{`a\ b`}},
+		{line: `'a"b'`, args: []string{`a"b`}},
+		{line: `a\ b`, args: []string{"a b"}},
+		{line: `\"a`, args: []string{`"a`}},
+		{line: `abc\`, args: []string{`abc\`}},
+		{line: `"abc`, err: ErrUnclosedQuote},
+		{line: `'abc" x`, err: ErrUnclosedQuote},
+	}
+	for _, tt := range tests {
+		args, err := Split(tt.line)
+		if err != tt.err {
+			t.Errorf("Split(%q) err=%v, 期望%v", tt.line, err, tt.err)
+			continue
+		}
+		if !reflect.DeepEqual(args, tt.args) {
+			t.Errorf("Split(%q) = %q, 期望%q", tt.line, args, tt.args)
+		}
+	}
+}
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		line string
+		cmd  *Command
+	}{
+		{line: "你好", cmd: &Command{Text: "你好"}},
+		{line: "你好\r\n", cmd: &Command{Text: "你好"}},
+		{line: "//不是命令", cmd: &Command{Text: "/不是命令"}},
+		{line: "/who", cmd: &Command{Name: "who"}},
+		{line: "/MSG 100 hi  there", cmd: &Command{Name: "msg", Args: []string{"100", "hi there"}}},
+		{line: `/msg 100 "hi  there"`, cmd: &Command{Name: "msg", Args: []string{"100", "hi  there"}}},
+		{line: "/status Away 吃饭 去了", cmd: &Command{Name: "status", Args: []string{"away", "吃饭 去了"}}},
+		{line: "/keyword ADD 部署", cmd: &Command{Name: "keyword", Args: []string{"add", "部署"}}},
+		{line: "/history 20 100", cmd: &Command{Name: "history", Args: []string{"20", "100"}}},
+		{line: "/search", cmd: &Command{Name: "search", Args: nil}},
+		{line: "/help /msg", cmd: &Command{Name: "help", Args: []string{"/msg"}}},
+	}
+	for _, tt := range tests {
+		cmd, err := Parse(tt.line)
+		if err != nil {
+			t.Errorf("Parse(%q) err=%v", tt.line, err)
+			continue
+		}
+		if cmd.Name != tt.cmd.Name || cmd.Text != tt.cmd.Text || len(cmd.Args) != len(tt.cmd.Args) ||
+			(len(cmd.Args) > 0 && !reflect.DeepEqual(cmd.Args, tt.cmd.Args)) {
+			t.Errorf("Parse(%q) = %+v, 期望%+v", tt.line, cmd, tt.cmd)
+		}
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	tests := []struct {
+		line    string
+		err     error
+		unknown string //未知的命令名
+		usage   string //用法错误的命令名
+	}{
+		{line: "/", err: ErrEmptyCommand},
+		{line: "/ ", err: ErrEmptyCommand},
+		{line: `/""`, err: ErrEmptyCommand},
+		{line: `/msg 100 "你好`, err: ErrUnclosedQuote},
+		{line: "/nope", unknown: "nope"},
+		{line: "/Nope x", unknown: "nope"},
+		{line: "/help nope", unknown: "nope"},
+		{line: "/msg abc 你好", usage: "msg"},
+		{line: "/msg 0 你好", usage: "msg"},
+		{line: "/delete -1", usage: "delete"},
+		{line: "/history 0", usage: "history"},
+		{line: "/status sleeping", usage: "status"},
+		{line: "/keyword rename x", usage: "keyword"},
+		{line: "/keyword add \"  \"", usage: "keyword"},
+		{line: `/join ""`, usage: "join"},
+	}
+	for _, tt := range tests {
+		_, err := Parse(tt.line)
+		var unknownErr *UnknownError
+		var usageErr *UsageError
+		switch {
+			case tt.err != nil:
+				if err != tt.err {
+					t.Errorf("Parse(%q) err=%v, 期望%v", tt.line, err, tt.err)
+				}
+			case tt.unknown != "":
+				if !errors.As(err, &unknownErr) || unknownErr.Name != tt.unknown {
+					t.Errorf("Parse(%q) err=%v, 期望未知的命令%s", tt.line, err, tt.unknown)
+				}
+			default:
+				if !errors.As(err, &usageErr) || usageErr.Spec.Name != tt.usage {
+					t.Errorf("Parse(%q) err=%v, 期望%s 的用法错误", tt.line, err, tt.usage)
+				}
+		}
+	}
+}
+
+//每个命令的参数少了或者多了都返回用法错误
+func TestParseArgCount(t *testing.T) {
+	for _, spec := range Specs {
+		//参数都用 1, 能通过类型检查的命令只会因为个数出错
+		if spec.MinArgs > 0 {
+			line := "/" + spec.Name + strings.Repeat(" 1", spec.MinArgs-1)
+			if _, err := Parse(line); !isUsage(err, spec.Name) {
+				t.Errorf("Parse(%q) err=%v, 期望参数太少的用法错误", line, err)
+			}
+		}
+		if spec.Rest {
+			//多出来的参数合并到最后一个参数中, 在 TestParse 中检查
+			continue
+		}
+		line := "/" + spec.Name + strings.Repeat(" 1", spec.MaxArgs+1)
+		if _, err := Parse(line); !isUsage(err, spec.Name) {
+			t.Errorf("Parse(%q) err=%v, 期望参数太多的用法错误", line, err)
+		}
+	}
+}
+
+func isUsage(err error, name string) bool {
+	var usageErr *UsageError
+	return errors.As(err, &usageErr) && usageErr.Spec.Name == name
+}
+
+func TestComplete(t *testing.T) {
+	onlineIds := []int{100, 101, 200}
+	tests := []struct {
+		line       string
+		candidates []string
+	}{
+		{line: "你好", candidates: nil},
+		{line: "/ ", candidates: nil},
+		{line: "/m", candidates: []string{"/menu ", "/msg ", "/muteroom "}},
+		{line: "/MS", candidates: []string{"/msg "}},
+		{line: "/un", candidates: []string{"/unmuteroom ", "/unschedule "}},
+		{line: "/xyz", candidates: nil},
+		{line: "/msg ", candidates: []string{"/msg 100 ", "/msg 101 ", "/msg 200 "}},
+		{line: "/msg 1", candidates: []string{"/msg 100 ", "/msg 101 "}},
+		{line: "/msg 100 ", candidates: nil},
+		{line: "/msg 100 h", candidates: nil},
+		{line: "/history 20 2", candidates: []string{"/history 20 200 "}},
+		{line: "/status a", candidates: []string{"/status away "}},
+		{line: "/keyword d", candidates: []string{"/keyword del "}},
+		{line: "/help un", candidates: []string{"/help unmuteroom ", "/help unschedule "}},
+	}
+	for _, tt := range tests {
+		candidates := Complete(tt.line, onlineIds)
+		if !reflect.DeepEqual(candidates, tt.candidates) {
+			t.Errorf("Complete(%q) = %q, 期望%q", tt.line, candidates, tt.candidates)
+		}
+	}
+	if n := len(Complete("/", onlineIds)); n != len(Specs) {
+		t.Errorf("Complete(\"/\") 返回%d 个候选项, 期望所有的%d 个命令", n, len(Specs))
+	}
+}
+
+func TestCommonPrefix(t *testing.T) {
+	tests := []struct {
+		candidates []string
+		prefix     string
+	}{
+		{candidates: nil, prefix: ""},
+		{candidates: []string{"/msg "}, prefix: "/msg "},
+		{candidates: []string{"/msg 100 ", "/msg 101 "}, prefix: "/msg 10"},
+		{candidates: []string{"/menu ", "/msg ", "/muteroom "}, prefix: "/m"},
+		{candidates: []string{"abc", "xyz"}, prefix: ""},
+	}
+	for _, tt := range tests {
+		if prefix := CommonPrefix(tt.candidates); prefix != tt.prefix {
+			t.Errorf("CommonPrefix(%q) = %q, 期望%q", tt.candidates, prefix, tt.prefix)
+		}
+	}
+}
@@ -0,0 +1,134 @@
+package e2e
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ecdh"
+	"crypto/hkdf"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+//端到端加密
+//每个用户有一对X25519密钥, 双方用自己的私钥和对方的公钥做ECDH得到相同的共享密钥,
+//再用HKDF-SHA256导出AES-256-GCM的密钥. 发送方和接收方的用户id作为附加数据参与认证,
+//服务器改不了消息的发送方或接收方
+
+var ErrDecrypt = errors.New("消息解密失败, 可能被篡改或者密钥不匹配")
+
+//用来区分不同用途的密钥
+const hkdfInfo = "go_code/chatroom e2e v1"
+
+//读取保存在path中的私钥, 文件不存在时生成一个新的并保存
+func LoadOrCreateKey(path string) (key *ecdh.PrivateKey, err error) {
+
+	data, err := os.ReadFile(path)
+	if err == nil {
+		raw, err := hex.DecodeString(strings.TrimSpace(string(data)))
+		if err != nil {
+			return nil, fmt.Errorf("私钥文件%s 格式不正确", path)
+		}
+		return ecdh.X25519().NewPrivateKey(raw)
+	}
+	if !os.IsNotExist(err) {
+		return
+	}
+
+	key, err = ecdh.X25519().GenerateKey(rand.Reader)
+	if err != nil {
+		return
+	}
+	err = os.MkdirAll(filepath.Dir(path), 0700)
+	if err != nil {
+		return
+	}
+	//私钥只有自己能读
+	err = os.WriteFile(path, []byte(hex.EncodeToString(key.Bytes())), 0600)
+	return
+}
+
+//公钥的指纹, 双方可以通过别的途径核对, 确认服务器没有替换公钥
+func Fingerprint(publicKey []byte) string {
+	sum := sha256.Sum256(publicKey)
+	s := hex.EncodeToString(sum[:16])
+	var groups []string
+	for i := 0; i < len(s); i += 4 {
+		groups = append(groups, s[i:i+4])
+	}
+	return strings.Join(groups, " ")
+}
+
+//参与认证的附加数据
+func additionalData(fromUserId int, toUserId int) []byte {
+	return []byte(fmt.Sprintf("%s:%d:%d", hkdfInfo, fromUserId, toUserId))
+}
+
+//用ECDH和HKDF算出双方共用的AES-256密钥
+func deriveKey(key *ecdh.PrivateKey, peerKey []byte) (aesKey []byte, err error) {
+
+	peer, err := ecdh.X25519().NewPublicKey(peerKey)
+	if err != nil {
+		return
+	}
+	shared, err := key.ECDH(peer)
+	if err != nil {
+		return
+	}
+	//salt 为空时按RFC 5869使用全0的salt
+	return hkdf.Key(sha256.New, shared, nil, hkdfInfo, 32)
+}
+
+//双方共用的AEAD
+func newAEAD(key *ecdh.PrivateKey, peerKey []byte) (aead cipher.AEAD, err error) {
+
+	aesKey, err := deriveKey(key, peerKey)
+	if err != nil {
+		return
+	}
+	block, err := aes.NewCipher(aesKey)
+	if err != nil {
+		return
+	}
+	return cipher.NewGCM(block)
+}
+
+//加密发给toUserId的消息
+func Encrypt(key *ecdh.PrivateKey, peerKey []byte, fromUserId int, toUserId int,
+	plaintext []byte) (nonce []byte, ciphertext []byte, err error) {
+
+	aead, err := newAEAD(key, peerKey)
+	if err != nil {
+		return
+	}
+	nonce = make([]byte, aead.NonceSize())
+	_, err = rand.Read(nonce)
+	if err != nil {
+		return
+	}
+	ciphertext = aead.Seal(nil, nonce, plaintext, additionalData(fromUserId, toUserId))
+	return
+}
+
+//解密, peerKey 是对方的公钥, 发送方解密自己发出的消息时就是接收方的公钥
+func Decrypt(key *ecdh.PrivateKey, peerKey []byte, fromUserId int, toUserId int,
+	nonce []byte, ciphertext []byte) (plaintext []byte, err error) {
+
+	aead, err := newAEAD(key, peerKey)
+	if err != nil {
+		return
+	}
+	if len(nonce) != aead.NonceSize() {
+		return nil, ErrDecrypt
+	}
+	plaintext, err = aead.Open(nil, nonce, ciphertext, additionalData(fromUserId, toUserId))
+	if err != nil {
+		err = ErrDecrypt
+	}
+	return
+}
@@ -0,0 +1,168 @@
+package e2e
+
+import (
+	"bytes"
+	"crypto/ecdh"
+	"crypto/rand"
+	"encoding/hex"
+	"testing"
+)
+
+//RFC 7748 6.1 中的X25519测试数据, 双方的共享密钥是
+//4a5d9d5ba4ce2de1728e3bf480350f25e07e21c947d19e3376f09b3c1e161742
+const (
+	alicePrivate = "77076d0a7318a57d3c16c17251b26645df4c2f87ebc0992ab177fba51db92c2a"
+	alicePublic  = "8520f0098930a754748b7ddcb43ef75a0dbf3a0d26381af4eba4a98eaa9b4e6a"
+	bobPrivate   = "5dab087e624a8a4b79e17f8b83800ee66f3bb1292618b6fd1c2f8b27ff88e0eb"
+	bobPublic    = "de9edb7d7b7dc1b4d35b61c2ece435373f8343c85b78674dadfc7e146f882b4f"
+
+	//HKDF-SHA256(共享密钥, 全0的salt, hkdfInfo), 用另外的实现算出来的
+	sharedAESKey = "ec7313132077480ea02fbd52d6095ef03bbc2c57f7ee2032c8874d420515e5ee"
+	//用上面的密钥, nonce 000102..0b, 附加数据是用户1发给用户2, 加密"你好, e2e"的结果
+	knownNonce      = "000102030405060708090a0b"
+	knownPlaintext  = "你好, e2e"
+	knownCiphertext = "eb6ae11eb5a775c5db9680b77545f9131e07bbb6ad2a02bb91c6c1"
+)
+
+func mustHex(t *testing.T, s string) []byte {
+	t.Helper()
+	b, err := hex.DecodeString(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
+
+func mustKey(t *testing.T, s string) *ecdh.PrivateKey {
+	t.Helper()
+	key, err := ecdh.X25519().NewPrivateKey(mustHex(t, s))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return key
+}
+
+//双方算出同一个密钥, 并且和已知的结果一样
+func TestDeriveKeyKnownAnswer(t *testing.T) {
+	alice := mustKey(t, alicePrivate)
+	bob := mustKey(t, bobPrivate)
+	if hex.EncodeToString(alice.PublicKey().Bytes()) != alicePublic ||
+		hex.EncodeToString(bob.PublicKey().Bytes()) != bobPublic {
+		t.Fatal("测试数据中的公钥不正确")
+	}
+	for _, tt := range []struct {
+		name    string
+		key     *ecdh.PrivateKey
+		peerKey string
+	}{
+		{"alice", alice, bobPublic},
+		{"bob", bob, alicePublic},
+	} {
+		aesKey, err := deriveKey(tt.key, mustHex(t, tt.peerKey))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := hex.EncodeToString(aesKey); got != sharedAESKey {
+			t.Errorf("%s 算出的密钥是%s, 期望%s", tt.name, got, sharedAESKey)
+		}
+	}
+}
+
+//固定nonce加密的结果和已知的一样, 接收方和发送方都能解密
+func TestSealOpenKnownAnswer(t *testing.T) {
+	alice := mustKey(t, alicePrivate)
+	bob := mustKey(t, bobPrivate)
+	nonce := mustHex(t, knownNonce)
+
+	aead, err := newAEAD(alice, mustHex(t, bobPublic))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ciphertext := aead.Seal(nil, nonce, []byte(knownPlaintext), additionalData(1, 2))
+	if got := hex.EncodeToString(ciphertext); got != knownCiphertext {
+		t.Fatalf("密文是%s, 期望%s", got, knownCiphertext)
+	}
+
+	plaintext, err := Decrypt(bob, mustHex(t, alicePublic), 1, 2, nonce, ciphertext)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(plaintext) != knownPlaintext {
+		t.Fatalf("接收方解密的结果是%q", plaintext)
+	}
+	plaintext, err = Decrypt(alice, mustHex(t, bobPublic), 1, 2, nonce, ciphertext)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(plaintext) != knownPlaintext {
+		t.Fatalf("发送方解密的结果是%q", plaintext)
+	}
+}
+
+func TestEncryptDecrypt(t *testing.T) {
+	alice, err := ecdh.X25519().GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	bob, err := ecdh.X25519().GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	plaintext := []byte("端到端加密的私聊")
+	nonce, ciphertext, err := Encrypt(alice, bob.PublicKey().Bytes(), 1, 2, plaintext)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := Decrypt(bob, alice.PublicKey().Bytes(), 1, 2, nonce, ciphertext)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, plaintext) {
+		t.Fatalf("解密的结果是%q, 期望%q", got, plaintext)
+	}
+	//每次加密使用不同的nonce
+	nonce2, _, err := Encrypt(alice, bob.PublicKey().Bytes(), 1, 2, plaintext)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Equal(nonce, nonce2) {
+		t.Fatal("两次加密使用了同一个nonce")
+	}
+}
+
+//服务器改了发送方, 接收方, 密文或者nonce, 解密都会失败
+func TestDecryptTampered(t *testing.T) {
+	bob := mustKey(t, bobPrivate)
+	nonce := mustHex(t, knownNonce)
+	ciphertext := mustHex(t, knownCiphertext)
+	alicePub := mustHex(t, alicePublic)
+
+	tampered := append([]byte(nil), ciphertext...)
+	tampered[0] ^= 1
+	shortNonce := nonce[:len(nonce)-1]
+	other, err := ecdh.X25519().GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name       string
+		peerKey    []byte
+		from, to   int
+		nonce      []byte
+		ciphertext []byte
+	}{
+		{"交换发送方和接收方", alicePub, 2, 1, nonce, ciphertext},
+		{"修改发送方", alicePub, 3, 2, nonce, ciphertext},
+		{"修改接收方", alicePub, 1, 3, nonce, ciphertext},
+		{"修改密文", alicePub, 1, 2, nonce, tampered},
+		{"nonce长度不对", alicePub, 1, 2, shortNonce, ciphertext},
+		{"替换公钥", other.PublicKey().Bytes(), 1, 2, nonce, ciphertext},
+	}
+	for _, tt := range tests {
+		_, err := Decrypt(bob, tt.peerKey, tt.from, tt.to, tt.nonce, tt.ciphertext)
+		if err != ErrDecrypt {
+			t.Errorf("%s: err=%v, 期望ErrDecrypt", tt.name, err)
+		}
+	}
+}
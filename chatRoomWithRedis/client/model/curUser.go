@@ -8,4 +8,6 @@ import (
 type CurUser struct {
 	Conn net.Conn
 	message.User
+	Room string //当前所在的房间, 空表示大厅
+	Protocol *message.Protocol //和服务器握手协商的协议
 } 
\ No newline at end of file
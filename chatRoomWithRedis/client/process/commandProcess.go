@@ -0,0 +1,269 @@
+package process
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"go_code/chatroom/client/command"
+	"go_code/chatroom/client/utils"
+	"go_code/chatroom/common/message"
+)
+
+//大厅在命令中的名字, 发给服务器时是空字符串
+const LobbyName = "lobby"
+
+//状态名和状态的对应关系
+var statusNames = map[string]int{
+	"online":    message.UserOnline,
+	"away":      message.UserAway,
+	"busy":      message.UserBusyStatus,
+	"invisible": message.UserInvisible,
+}
+
+//显示用的房间名
+func roomName(roomId string) string {
+	if roomId == "" {
+		return LobbyName
+	}
+	return roomId
+}
+
+//命令中的房间名, lobby 表示大厅
+func roomArg(name string) string {
+	if name == LobbyName {
+		return ""
+	}
+	return name
+}
+
+//从标准输入读取一行
+//一次只读一个字节, 不会多读, 这样 /menu 里的 fmt.Scanf 还能正常使用
+func readLine() (line string, err error) {
+	var buf []byte
+	b := make([]byte, 1)
+	for {
+		n, err := os.Stdin.Read(b)
+		if n == 1 {
+			if b[0] == '\n' {
+				break
+			}
+			buf = append(buf, b[0])
+			continue
+		}
+		if err != nil {
+			if err == io.EOF && len(buf) > 0 {
+				break
+			}
+			return "", err
+		}
+	}
+	return strings.TrimRight(string(buf), "\r"), nil
+}
+
+//当前在线用户的id, 用来补全
+func onlineUserIds() (ids []int) {
+	for id := range onlineUsers {
+		ids = append(ids, id)
+	}
+	sort.Ints(ids)
+	return
+}
+
+//登录成功后，读取用户输入的命令和聊天内容
+func RunCommandLoop() {
+
+	fmt.Printf("已进入 %s, 直接输入内容即可聊天, 输入 /help 查看命令\n", roomName(CurUser.Room))
+	//上一次补全的结果, 下一行输入会接在它后面
+	pending := ""
+	for {
+		line, err := readLine()
+		if err != nil {
+			fmt.Println("读取输入错误 err=", err)
+			os.Exit(0)
+		}
+		line = pending + line
+		pending = ""
+
+		//这不是真正的Tab补全: 终端在行缓冲模式下收不到单独的Tab, 也不能修改已经显示的输入
+		//所以约定在行尾输入Tab再回车, 打印补全的结果, 用户接着输入的内容会拼在补全结果的后面
+		if strings.HasSuffix(line, "\t") {
+			pending = completeLine(strings.TrimRight(line, "\t"))
+			continue
+		}
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
+		handleLine(line)
+	}
+}
+
+//补全并显示结果, 返回补全后的内容
+func completeLine(line string) string {
+	candidates := command.Complete(line, onlineUserIds())
+	switch len(candidates) {
+		case 0:
+			fmt.Println("没有可以补全的内容")
+			return line
+		case 1:
+			fmt.Printf("%s(继续输入剩余部分)\n", candidates[0])
+			return candidates[0]
+		default:
+			fmt.Println(strings.Join(candidates, "  "))
+			prefix := command.CommonPrefix(candidates)
+			fmt.Printf("%s(继续输入剩余部分)\n", prefix)
+			return prefix
+	}
+}
+
+//执行一行输入
+func handleLine(line string) {
+
+	cmd, err := command.Parse(line)
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	smsProcess := &SmsProcess{}
+	switch cmd.Name {
+		case "":
+			sendTyping(0)
+			smsProcess.SendGroupMes(cmd.Text)
+		case "msg":
+			toUserId := cmd.Int(0, 0)
+			sendTyping(toUserId)
+			smsProcess.SendPrivateMes(toUserId, cmd.Arg(1))
+		case "emsg":
+			SendE2EMes(cmd.Int(0, 0), cmd.Arg(1))
+		case "key":
+			outputFingerprint(cmd.Int(0, 0))
+		case "trust":
+			TrustKey(cmd.Int(0, 0))
+		case "edit":
+			EditMes(cmd.Int(0, 0), cmd.Arg(1))
+		case "delete":
+			DeleteMes(cmd.Int(0, 0))
+		case "react":
+			ReactMes(cmd.Int(0, 0), cmd.Arg(1))
+		case "join":
+			JoinRoom(roomArg(cmd.Arg(0)))
+		case "keyword":
+			if cmd.Arg(0) == "add" {
+				AddKeyword(cmd.Arg(1))
+			} else {
+				DelKeyword(cmd.Arg(1))
+			}
+		case "muteroom":
+			MuteRoom(roomArg(cmd.Arg(0)))
+		case "unmuteroom":
+			UnmuteRoom(roomArg(cmd.Arg(0)))
+		case "notify":
+			outputNotifySettings()
+		case "who":
+			fmt.Println("你在:", roomName(CurUser.Room))
+			outputOnlineUser()
+		case "history":
+			RequestHistory(cmd.Int(0, message.HistoryDefaultCount), cmd.Int(1, 0))
+		case "search":
+			SearchMes(cmd.Arg(0))
+		case "schedule":
+			ScheduleMes(cmd.Arg(0), cmd.Arg(1), cmd.Arg(2))
+		case "remind":
+			RemindMe(cmd.Arg(0), cmd.Arg(1))
+		case "unschedule":
+			CancelSchedule(cmd.Int(0, 0))
+		case "status":
+			SetStatus(statusNames[cmd.Arg(0)], cmd.Arg(1))
+		case "menu":
+			ShowMenu()
+		case "help":
+			fmt.Print(command.Help(cmd.Arg(0)))
+		case "quit":
+			fmt.Println("你选择退出了系统...")
+			os.Exit(0)
+	}
+	return
+}
+
+//进入房间, 服务器返回JoinRoomResMes后才真正切换
+func JoinRoom(roomId string) (err error) {
+	tf := &utils.Transfer{
+		Conn: CurUser.Conn,
+	}
+	err = tf.WriteMes(message.JoinRoomMesType, message.JoinRoomMes{RoomId: roomId})
+	if err != nil {
+		fmt.Println("JoinRoom err=", err)
+	}
+	return
+}
+
+//查询聊天记录, toUserId 为 0 时查询当前房间
+func RequestHistory(count int, toUserId int) (err error) {
+	historyMes := message.HistoryMes{
+		ToUserId: toUserId,
+		Count:    count,
+	}
+	if toUserId == 0 {
+		historyMes.RoomId = CurUser.Room
+	}
+	tf := &utils.Transfer{
+		Conn: CurUser.Conn,
+	}
+	err = tf.WriteMes(message.HistoryMesType, historyMes)
+	if err != nil {
+		fmt.Println("RequestHistory err=", err)
+	}
+	return
+}
+
+//服务器返回的进入房间的结果
+func outputJoinRoomRes(mes *message.Message) {
+	var joinRoomResMes message.JoinRoomResMes
+	err := json.Unmarshal([]byte(mes.Data), &joinRoomResMes)
+	if err != nil {
+		fmt.Println("json.Unmarshal err=", err)
+		return
+	}
+	if joinRoomResMes.Code != 200 {
+		fmt.Println("进入房间失败:", joinRoomResMes.Error)
+		return
+	}
+	CurUser.Room = joinRoomResMes.RoomId
+	fmt.Printf("已进入 %s, 房间中的用户: %v\n", roomName(joinRoomResMes.RoomId), joinRoomResMes.UsersId)
+}
+
+//显示服务器返回的聊天记录
+func outputHistory(mes *message.Message) {
+	var historyResMes message.HistoryResMes
+	err := json.Unmarshal([]byte(mes.Data), &historyResMes)
+	if err != nil {
+		fmt.Println("json.Unmarshal err=", err)
+		return
+	}
+	if historyResMes.Code != 200 {
+		fmt.Println("查询聊天记录失败:", historyResMes.Error)
+		return
+	}
+	for _, smsMes := range historyResMes.Messages {
+		sendTime := time.Unix(smsMes.SendTime, 0).Format("01-02 15:04:05")
+		switch {
+			case smsMes.UserId == CurUser.UserId && smsMes.ToUserId != 0:
+				fmt.Printf("%s [消息%d] 我对用户%d 说: %s\n", sendTime, smsMes.MesId, smsMes.ToUserId, smsText(&smsMes))
+			case smsMes.ToUserId != 0:
+				fmt.Printf("%s [消息%d] 用户%d 对我说: %s\n", sendTime, smsMes.MesId, smsMes.UserId, smsText(&smsMes))
+			default:
+				fmt.Printf("%s [消息%d] 用户%d: %s\n", sendTime, smsMes.MesId, smsMes.UserId, smsText(&smsMes))
+		}
+	}
+	if historyResMes.Last {
+		if historyResMes.ToUserId != 0 {
+			fmt.Printf("---- 以上是和用户%d 的聊天记录 ----\n", historyResMes.ToUserId)
+		} else {
+			fmt.Printf("---- 以上是 %s 的聊天记录 ----\n", roomName(historyResMes.RoomId))
+		}
+	}
+}
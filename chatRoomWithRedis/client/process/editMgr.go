@@ -0,0 +1,157 @@
+package process
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+
+	"go_code/chatroom/client/utils"
+	"go_code/chatroom/common/message"
+)
+
+//修改, 删除和回应消息
+//服务器转发的修改会更新本地保存的聊天记录, 之后显示的都是修改后的内容
+
+//修改自己发的消息
+func EditMes(mesId int, content string) (err error) {
+	return writeMes(message.EditMesType, message.EditMes{MesId: mesId, Content: content})
+}
+
+//删除自己发的消息
+func DeleteMes(mesId int) (err error) {
+	return writeMes(message.DeleteMesType, message.DeleteMes{MesId: mesId})
+}
+
+//回应一条消息, 已经用这个表情回应过时取消回应
+func ReactMes(mesId int, emoji string) (err error) {
+	remove := false
+	smsLock.Lock()
+	if smsMes := findSms(mesId); smsMes != nil {
+		for _, id := range smsMes.Reactions[emoji] {
+			if id == CurUser.UserId {
+				remove = true
+			}
+		}
+	}
+	smsLock.Unlock()
+	return writeMes(message.ReactMesType, message.ReactMes{MesId: mesId, Emoji: emoji, Remove: remove})
+}
+
+func writeMes(mesType string, v interface{}) (err error) {
+	tf := &utils.Transfer{
+		Conn: CurUser.Conn,
+	}
+	err = tf.WriteMes(mesType, v)
+	if err != nil {
+		fmt.Printf("发送%s 失败 err=%v\n", mesType, err)
+	}
+	return
+}
+
+//在本地的聊天记录中找到消息, 调用前需要持有smsLock
+func findSms(mesId int) *message.SmsMes {
+	for _, smsMes := range smsList {
+		if smsMes.MesId == mesId {
+			return smsMes
+		}
+	}
+	return nil
+}
+
+//显示用的消息内容, 包括修改和删除的标记, 以及表情回应
+func smsText(smsMes *message.SmsMes) string {
+	if smsMes.Deleted {
+		return "[消息已删除]"
+	}
+	text := smsContent(smsMes)
+	if smsMes.EditTime != 0 {
+		text += " (已修改)"
+	}
+	if len(smsMes.Reactions) > 0 {
+		text += " " + reactionsText(smsMes.Reactions)
+	}
+	return text
+}
+
+//比如 [👍2 ❤1]
+func reactionsText(reactions map[string][]int) string {
+	emojis := make([]string, 0, len(reactions))
+	for emoji := range reactions {
+		emojis = append(emojis, emoji)
+	}
+	sort.Strings(emojis)
+	var parts []string
+	for _, emoji := range emojis {
+		parts = append(parts, fmt.Sprintf("%s%d", emoji, len(reactions[emoji])))
+	}
+	return "[" + strings.Join(parts, " ") + "]"
+}
+
+//服务器转发的消息修改
+func onEditMes(mes *message.Message) {
+	var editMes message.EditMes
+	err := json.Unmarshal([]byte(mes.Data), &editMes)
+	if err != nil {
+		fmt.Println("json.Unmarshal err=", err)
+		return
+	}
+	smsLock.Lock()
+	if smsMes := findSms(editMes.MesId); smsMes != nil {
+		smsMes.Content = editMes.Content
+		smsMes.EditTime = editMes.EditTime
+	}
+	smsLock.Unlock()
+	fmt.Printf("[消息%d] 被用户%d 修改为: %s\n", editMes.MesId, editMes.UserId, editMes.Content)
+}
+
+func onDeleteMes(mes *message.Message) {
+	var deleteMes message.DeleteMes
+	err := json.Unmarshal([]byte(mes.Data), &deleteMes)
+	if err != nil {
+		fmt.Println("json.Unmarshal err=", err)
+		return
+	}
+	smsLock.Lock()
+	if smsMes := findSms(deleteMes.MesId); smsMes != nil {
+		smsMes.Deleted = true
+		smsMes.Content = ""
+		smsMes.E2E = nil
+		smsMes.Reactions = nil
+	}
+	smsLock.Unlock()
+	fmt.Printf("[消息%d] 被用户%d 删除\n", deleteMes.MesId, deleteMes.UserId)
+}
+
+func onReactMes(mes *message.Message) {
+	var reactMes message.ReactMes
+	err := json.Unmarshal([]byte(mes.Data), &reactMes)
+	if err != nil {
+		fmt.Println("json.Unmarshal err=", err)
+		return
+	}
+	smsLock.Lock()
+	if smsMes := findSms(reactMes.MesId); smsMes != nil {
+		smsMes.Reactions = reactMes.Reactions
+	}
+	smsLock.Unlock()
+	action := "回应了"
+	if reactMes.Remove {
+		action = "取消了回应"
+	}
+	fmt.Printf("[消息%d] 用户%d %s %s %s\n", reactMes.MesId, reactMes.UserId, action,
+		reactMes.Emoji, reactionsText(reactMes.Reactions))
+}
+
+//修改, 删除, 回应失败时显示原因, 成功时服务器会转发操作, 这里不再显示
+func outputMesActionRes(mes *message.Message) {
+	var resMes message.MesActionResMes
+	err := json.Unmarshal([]byte(mes.Data), &resMes)
+	if err != nil {
+		fmt.Println("json.Unmarshal err=", err)
+		return
+	}
+	if resMes.Code != 200 {
+		fmt.Printf("[消息%d] 操作失败: %s\n", resMes.MesId, resMes.Error)
+	}
+}
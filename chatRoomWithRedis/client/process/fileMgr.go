@@ -0,0 +1,382 @@
+package process
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"go_code/chatroom/client/utils"
+	"go_code/chatroom/common/message"
+)
+
+//收到的文件保存在这个目录下
+var DownloadDir = "downloads"
+
+//正在上传的文件
+type fileUpload struct {
+	Info message.FileInfo
+	Path string
+	Acks chan message.FileAckMes
+}
+
+//正在下载的文件
+type fileDownload struct {
+	Info message.FileInfo
+	File *os.File
+}
+
+//客户端维护的文件传输状态
+//读取服务器消息的协程和菜单协程都会访问，需要加锁
+type FileMgr struct {
+	lock sync.Mutex
+	//别人发给我的，还没有处理的文件
+	offers map[int]message.FileInfo
+	//已经发起，还没收到服务器分配的fileId, key为 toUserId:hash
+	waiting map[string]*fileUpload
+	//等待对方同意或者正在上传的文件
+	uploads map[int]*fileUpload
+	//正在下载的文件
+	downloads map[int]*fileDownload
+}
+
+var fileMgr = &FileMgr{
+	offers:    make(map[int]message.FileInfo),
+	waiting:   make(map[string]*fileUpload),
+	uploads:   make(map[int]*fileUpload),
+	downloads: make(map[int]*fileDownload),
+}
+
+func waitingKey(info *message.FileInfo) string {
+	return fmt.Sprintf("%d:%s", info.ToUserId, info.FileHash)
+}
+
+//计算文件的大小和sha256
+func hashFile(path string) (size int64, hash string, err error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return
+	}
+	defer f.Close()
+	h := sha256.New()
+	size, err = io.Copy(h, f)
+	if err != nil {
+		return
+	}
+	hash = hex.EncodeToString(h.Sum(nil))
+	return
+}
+
+//在客户端显示还没有处理的文件
+func outputFileOffers() {
+	fileMgr.lock.Lock()
+	defer fileMgr.lock.Unlock()
+	if len(fileMgr.offers) == 0 {
+		fmt.Println("没有待接收的文件")
+		return
+	}
+	fmt.Println("待接收的文件列表:")
+	for _, info := range fileMgr.offers {
+		status := "等待确认"
+		if info.Status == message.FileStored {
+			status = "离线文件"
+		}
+		fmt.Printf("文件id:\t%d 来自用户:\t%d 文件名:\t%s 大小:\t%d 状态:\t%s\n",
+			info.FileId, info.FromUserId, info.FileName, info.FileSize, status)
+	}
+}
+
+//处理服务器返回的FileOfferResMes
+func onFileOfferRes(mes *message.Message) {
+	var fileOfferResMes message.FileOfferResMes
+	err := json.Unmarshal([]byte(mes.Data), &fileOfferResMes)
+	if err != nil {
+		fmt.Println("json.Unmarshal err=", err)
+		return
+	}
+	info := fileOfferResMes.FileInfo
+
+	fileMgr.lock.Lock()
+	key := waitingKey(&info)
+	upload, ok := fileMgr.waiting[key]
+	delete(fileMgr.waiting, key)
+	if ok && fileOfferResMes.Code == 200 {
+		upload.Info = info
+		fileMgr.uploads[info.FileId] = upload
+	}
+	fileMgr.lock.Unlock()
+
+	if !ok {
+		return
+	}
+	if fileOfferResMes.Code != 200 {
+		fmt.Println("发送文件失败:", fileOfferResMes.Error)
+		return
+	}
+	if fileOfferResMes.Offline {
+		fmt.Printf("用户%d 不在线，文件[%s]将先上传到服务器\n", info.ToUserId, info.FileName)
+		go uploadFile(upload)
+		return
+	}
+	fmt.Printf("等待用户%d 确认接收文件[%s]\n", info.ToUserId, info.FileName)
+}
+
+//有人要给我发文件
+func onFileOffer(mes *message.Message) {
+	var fileOfferMes message.FileOfferMes
+	err := json.Unmarshal([]byte(mes.Data), &fileOfferMes)
+	if err != nil {
+		fmt.Println("json.Unmarshal err=", err)
+		return
+	}
+	info := fileOfferMes.FileInfo
+	fileMgr.lock.Lock()
+	fileMgr.offers[info.FileId] = info
+	fileMgr.lock.Unlock()
+	fmt.Printf("用户%d 想发送文件[%s](%d字节)给你，文件id:%d，请在菜单 5 中选择接收或拒绝\n",
+		info.FromUserId, info.FileName, info.FileSize, info.FileId)
+}
+
+//服务器推送的离线文件列表
+func onFileListRes(mes *message.Message) {
+	var fileListResMes message.FileListResMes
+	err := json.Unmarshal([]byte(mes.Data), &fileListResMes)
+	if err != nil {
+		fmt.Println("json.Unmarshal err=", err)
+		return
+	}
+	fileMgr.lock.Lock()
+	for _, info := range fileListResMes.Files {
+		fileMgr.offers[info.FileId] = info
+	}
+	fileMgr.lock.Unlock()
+	fmt.Printf("你有%d 个离线文件\n", len(fileListResMes.Files))
+	outputFileOffers()
+}
+
+//对方同意或拒绝了我发的文件
+func onFileAnswer(mes *message.Message) {
+	var fileAnswerMes message.FileAnswerMes
+	err := json.Unmarshal([]byte(mes.Data), &fileAnswerMes)
+	if err != nil {
+		fmt.Println("json.Unmarshal err=", err)
+		return
+	}
+	fileMgr.lock.Lock()
+	upload, ok := fileMgr.uploads[fileAnswerMes.FileId]
+	if ok && !fileAnswerMes.Accept {
+		delete(fileMgr.uploads, fileAnswerMes.FileId)
+	}
+	fileMgr.lock.Unlock()
+	if !ok {
+		return
+	}
+	if !fileAnswerMes.Accept {
+		fmt.Printf("用户%d 拒绝接收文件[%s]\n", upload.Info.ToUserId, upload.Info.FileName)
+		return
+	}
+	fmt.Printf("用户%d 同意接收文件[%s]，开始发送\n", upload.Info.ToUserId, upload.Info.FileName)
+	go uploadFile(upload)
+}
+
+//服务器对分片的确认
+func onFileAck(mes *message.Message) {
+	var fileAckMes message.FileAckMes
+	err := json.Unmarshal([]byte(mes.Data), &fileAckMes)
+	if err != nil {
+		fmt.Println("json.Unmarshal err=", err)
+		return
+	}
+	fileMgr.lock.Lock()
+	upload, ok := fileMgr.uploads[fileAckMes.FileId]
+	download, downloading := fileMgr.downloads[fileAckMes.FileId]
+	fileMgr.lock.Unlock()
+	if !ok {
+		//获取离线文件失败或者同意时文件还在上传，服务器也会返回FileAckMes
+		if fileAckMes.Code != 200 {
+			fmt.Printf("获取文件%d 失败: %s\n", fileAckMes.FileId, fileAckMes.Error)
+			if downloading {
+				abortDownload(download)
+			}
+		}
+		return
+	}
+	select {
+	case upload.Acks <- fileAckMes:
+	default:
+	}
+}
+
+//上传文件，最多允许FileWindowSize个分片没有被确认
+func uploadFile(upload *fileUpload) {
+
+	defer func() {
+		fileMgr.lock.Lock()
+		delete(fileMgr.uploads, upload.Info.FileId)
+		fileMgr.lock.Unlock()
+	}()
+
+	f, err := os.Open(upload.Path)
+	if err != nil {
+		fmt.Println("os.Open err=", err)
+		return
+	}
+	defer f.Close()
+
+	tf := &utils.Transfer{
+		Conn: CurUser.Conn,
+	}
+	inflight := 0
+	waitAck := func() bool {
+		select {
+		case ack := <-upload.Acks:
+			inflight--
+			if ack.Code != 200 {
+				fmt.Printf("发送文件[%s]失败: %s\n", upload.Info.FileName, ack.Error)
+				return false
+			}
+			return true
+		case <-time.After(30 * time.Second):
+			fmt.Printf("发送文件[%s]超时\n", upload.Info.FileName)
+			return false
+		}
+	}
+
+	buf := make([]byte, message.FileChunkSize)
+	for seq := 0; ; seq++ {
+		n, err := io.ReadFull(f, buf)
+		last := false
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			last = true
+		} else if err != nil {
+			fmt.Println("读取文件错误 err=", err)
+			return
+		}
+		for inflight >= message.FileWindowSize {
+			if !waitAck() {
+				return
+			}
+		}
+		chunk := message.FileChunkMes{
+			FileId: upload.Info.FileId,
+			Seq:    seq,
+			Data:   buf[:n],
+			Last:   last,
+		}
+		err = tf.WriteMes(message.FileChunkMesType, chunk)
+		if err != nil {
+			return
+		}
+		inflight++
+		if last {
+			break
+		}
+	}
+	for inflight > 0 {
+		if !waitAck() {
+			return
+		}
+	}
+	fmt.Printf("文件[%s]发送完成\n", upload.Info.FileName)
+}
+
+//准备接收一个文件，先写到.part临时文件中，校验通过后再改名
+func startDownload(info message.FileInfo) (err error) {
+
+	err = os.MkdirAll(DownloadDir, 0755)
+	if err != nil {
+		return
+	}
+	//只取文件名本身，防止对方传来 ../ 之类的路径
+	name := fmt.Sprintf("%d_%s.part", info.FileId, filepath.Base(info.FileName))
+	f, err := os.Create(filepath.Join(DownloadDir, name))
+	if err != nil {
+		return
+	}
+	fileMgr.lock.Lock()
+	fileMgr.downloads[info.FileId] = &fileDownload{
+		Info: info,
+		File: f,
+	}
+	fileMgr.lock.Unlock()
+	return
+}
+
+//收到文件分片
+func onFileChunk(mes *message.Message) {
+	var chunk message.FileChunkMes
+	err := json.Unmarshal([]byte(mes.Data), &chunk)
+	if err != nil {
+		fmt.Println("json.Unmarshal err=", err)
+		return
+	}
+	fileMgr.lock.Lock()
+	download, ok := fileMgr.downloads[chunk.FileId]
+	fileMgr.lock.Unlock()
+	if !ok {
+		return
+	}
+
+	fileAckMes := message.FileAckMes{
+		FileId: chunk.FileId,
+		Seq:    chunk.Seq,
+		Code:   200,
+	}
+	_, err = download.File.WriteAt(chunk.Data, int64(chunk.Seq)*message.FileChunkSize)
+	if err == nil && chunk.Last {
+		err = finishDownload(download)
+	}
+	if err != nil {
+		fmt.Printf("接收文件[%s]失败: %v\n", download.Info.FileName, err)
+		fileAckMes.Code = 400
+		fileAckMes.Error = err.Error()
+		abortDownload(download)
+	}
+
+	tf := &utils.Transfer{
+		Conn: CurUser.Conn,
+	}
+	tf.WriteMes(message.FileAckMesType, fileAckMes)
+}
+
+//所有分片都收到了，校验hash后改成正式的文件名
+func finishDownload(download *fileDownload) (err error) {
+
+	fileMgr.lock.Lock()
+	delete(fileMgr.downloads, download.Info.FileId)
+	fileMgr.lock.Unlock()
+
+	tmpPath := download.File.Name()
+	download.File.Close()
+	size, hash, err := hashFile(tmpPath)
+	if err != nil {
+		return
+	}
+	if size != download.Info.FileSize || hash != download.Info.FileHash {
+		return fmt.Errorf("文件校验失败")
+	}
+	path := filepath.Join(DownloadDir, filepath.Base(download.Info.FileName))
+	if _, err := os.Stat(path); err == nil {
+		//已经有同名文件了，加上文件id
+		path = filepath.Join(DownloadDir, fmt.Sprintf("%d_%s", download.Info.FileId, filepath.Base(download.Info.FileName)))
+	}
+	err = os.Rename(tmpPath, path)
+	if err != nil {
+		return
+	}
+	fmt.Printf("文件[%s]接收完成，已保存到 %s\n", download.Info.FileName, path)
+	return
+}
+
+func abortDownload(download *fileDownload) {
+	fileMgr.lock.Lock()
+	delete(fileMgr.downloads, download.Info.FileId)
+	fileMgr.lock.Unlock()
+	download.File.Close()
+	os.Remove(download.File.Name())
+}
@@ -0,0 +1,142 @@
+package process
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"go_code/chatroom/client/utils"
+	"go_code/chatroom/common/message"
+)
+
+type FileProcess struct {
+}
+
+//发送文件给某个用户
+func (this *FileProcess) SendFile(toUserId int, path string) (err error) {
+
+	size, hash, err := hashFile(path)
+	if err != nil {
+		fmt.Println("读取文件错误 err=", err)
+		return
+	}
+	if size > message.FileMaxSize {
+		err = fmt.Errorf("文件不能超过%d字节", message.FileMaxSize)
+		fmt.Println(err)
+		return
+	}
+
+	var fileOfferMes message.FileOfferMes
+	fileOfferMes.FromUserId = CurUser.UserId
+	fileOfferMes.ToUserId = toUserId
+	fileOfferMes.FileName = filepath.Base(path)
+	fileOfferMes.FileSize = size
+	fileOfferMes.FileHash = hash
+
+	//收到服务器分配的fileId前，先记录下来
+	fileMgr.lock.Lock()
+	fileMgr.waiting[waitingKey(&fileOfferMes.FileInfo)] = &fileUpload{
+		Info: fileOfferMes.FileInfo,
+		Path: path,
+		Acks: make(chan message.FileAckMes, message.FileWindowSize),
+	}
+	fileMgr.lock.Unlock()
+
+	tf := &utils.Transfer{
+		Conn: CurUser.Conn,
+	}
+	err = tf.WriteMes(message.FileOfferMesType, fileOfferMes)
+	if err != nil {
+		fmt.Println("SendFile err=", err)
+	}
+	return
+}
+
+//接收或拒绝别人发来的文件
+func (this *FileProcess) AnswerFile(fileId int, accept bool) (err error) {
+
+	fileMgr.lock.Lock()
+	info, ok := fileMgr.offers[fileId]
+	delete(fileMgr.offers, fileId)
+	fileMgr.lock.Unlock()
+	if !ok {
+		fmt.Println("文件不存在")
+		return
+	}
+
+	tf := &utils.Transfer{
+		Conn: CurUser.Conn,
+	}
+	//离线文件已经在服务器上了，直接获取即可
+	if info.Status == message.FileStored {
+		if !accept {
+			return
+		}
+		err = startDownload(info)
+		if err != nil {
+			fmt.Println("startDownload err=", err)
+			return
+		}
+		return tf.WriteMes(message.FileFetchMesType, message.FileFetchMes{FileId: fileId})
+	}
+
+	if accept {
+		err = startDownload(info)
+		if err != nil {
+			fmt.Println("startDownload err=", err)
+			accept = false
+		}
+	}
+	return tf.WriteMes(message.FileAnswerMesType, message.FileAnswerMes{
+		FileId: fileId,
+		Accept: accept,
+	})
+}
+
+//向服务器查询还没取走的离线文件
+func (this *FileProcess) ListFiles() (err error) {
+	tf := &utils.Transfer{
+		Conn: CurUser.Conn,
+	}
+	return tf.WriteMes(message.FileListMesType, message.FileListMes{})
+}
+
+//文件传输菜单
+func showFileMenu() {
+
+	fmt.Println("-------1. 发送文件---------")
+	fmt.Println("-------2. 处理待接收的文件---------")
+	fmt.Println("-------3. 查询离线文件---------")
+	fmt.Println("请选择(1-3):")
+	var key int
+	var toUserId int
+	var fileId int
+	var path string
+	var answer string
+
+	fileProcess := &FileProcess{}
+	fmt.Scanf("%d\n", &key)
+	switch key {
+		case 1:
+			fmt.Println("请输入接收方的用户id:")
+			fmt.Scanf("%d\n", &toUserId)
+			fmt.Println("请输入文件路径:")
+			fmt.Scanf("%s\n", &path)
+			if _, err := os.Stat(path); err != nil {
+				fmt.Println("文件不存在")
+				return
+			}
+			fileProcess.SendFile(toUserId, path)
+		case 2:
+			outputFileOffers()
+			fmt.Println("请输入文件id:")
+			fmt.Scanf("%d\n", &fileId)
+			fmt.Println("是否接收(y/n):")
+			fmt.Scanf("%s\n", &answer)
+			fileProcess.AnswerFile(fileId, answer == "y" || answer == "Y")
+		case 3:
+			fileProcess.ListFiles()
+		default :
+			fmt.Println("你输入的选项不正确..")
+	}
+}
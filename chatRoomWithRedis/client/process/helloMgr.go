@@ -0,0 +1,61 @@
+package process
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+
+	"go_code/chatroom/client/utils"
+	"go_code/chatroom/common/message"
+)
+
+//和服务器握手, 协商协议版本
+//HelloMes 和登录或注册的请求一起发出, 不等待回复:
+//新的服务器先回复HelloResMes, 再回复请求; 老的服务器不认识HelloMes, 忽略后直接回复请求
+
+//客户端的名字, 服务器只用来记录日志
+const clientName = "chatroom-cli/2"
+
+//登录或注册之前发送HelloMes
+func sendHello(conn net.Conn) error {
+	tf := &utils.Transfer{
+		Conn : conn,
+	}
+	return tf.WriteMes(message.HelloMesType, message.HelloMes{
+		Version:      message.ProtocolVersion,
+		MinVersion:   message.ProtocolLegacy,
+		Codecs:       []string{message.CodecJSON},
+		Compressions: []string{message.CompressionDeflate, message.CompressionNone},
+		Features:     []string{message.FeatureE2E, message.FeatureRooms},
+		Client:       clientName,
+	})
+}
+
+//读取登录或注册的回复, 先处理握手的结果
+//版本不兼容时服务器会断开连接, 这里返回服务器说明的原因
+func readReply(tf *utils.Transfer) (mes message.Message, protocol *message.Protocol, err error) {
+	mes, err = tf.ReadPkg()
+	if err != nil {
+		return
+	}
+	if mes.Type != message.HelloResMesType {
+		//老的服务器
+		return mes, message.LegacyProtocol(), nil
+	}
+	var helloResMes message.HelloResMes
+	err = json.Unmarshal([]byte(mes.Data), &helloResMes)
+	if err != nil {
+		return
+	}
+	if helloResMes.Code != 200 {
+		err = fmt.Errorf("和服务器握手失败: %s", helloResMes.Error)
+		return
+	}
+	protocol = helloResMes.Protocol()
+	//服务器之后发来的大包会被压缩, 我们发出的大包也压缩
+	if protocol.Compression != message.CompressionNone {
+		utils.EnableCompression(tf.Conn)
+	}
+	mes, err = tf.ReadPkg()
+	return
+}
@@ -0,0 +1,287 @@
+package process
+
+import (
+	"bytes"
+	"crypto/ecdh"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"go_code/chatroom/client/e2e"
+	"go_code/chatroom/client/utils"
+	"go_code/chatroom/common/message"
+)
+
+//私钥和已知的公钥保存在这个目录下
+var KeyDir = "keys"
+
+//客户端维护的端到端加密的状态
+//第一次拿到对方的公钥时记下来, 以后公钥变了要用户用 /trust 确认后才会继续加密
+type KeyMgr struct {
+	lock sync.Mutex
+	//自己的私钥
+	key *ecdh.PrivateKey
+	//已经信任的公钥
+	trusted map[int][]byte
+	//和已信任的公钥不一致, 还没有确认的公钥
+	changed map[int][]byte
+	//等待对方公钥的消息
+	pending map[int][]string
+}
+
+var keyMgr = &KeyMgr{
+	trusted: make(map[int][]byte),
+	changed: make(map[int][]byte),
+	pending: make(map[int][]string),
+}
+
+func knownKeysPath() string {
+	return filepath.Join(KeyDir, fmt.Sprintf("%d.known", CurUser.UserId))
+}
+
+//登录成功后，加载或生成自己的密钥并发布公钥
+func initKey() (err error) {
+
+	key, err := e2e.LoadOrCreateKey(filepath.Join(KeyDir, fmt.Sprintf("%d.key", CurUser.UserId)))
+	if err != nil {
+		fmt.Println("加载私钥失败 err=", err)
+		return
+	}
+	keyMgr.lock.Lock()
+	keyMgr.key = key
+	data, err := os.ReadFile(knownKeysPath())
+	if err == nil {
+		json.Unmarshal(data, &keyMgr.trusted)
+	}
+	keyMgr.lock.Unlock()
+
+	fmt.Println("你的公钥指纹:", e2e.Fingerprint(key.PublicKey().Bytes()))
+	tf := &utils.Transfer{
+		Conn: CurUser.Conn,
+	}
+	return tf.WriteMes(message.PublishKeyMesType, message.PublishKeyMes{
+		PublicKey: key.PublicKey().Bytes(),
+	})
+}
+
+//保存已经信任的公钥, 调用时要持有锁
+func (this *KeyMgr) saveTrusted() {
+	data, err := json.Marshal(this.trusted)
+	if err != nil {
+		return
+	}
+	err = os.WriteFile(knownKeysPath(), data, 0600)
+	if err != nil {
+		fmt.Println("保存公钥失败 err=", err)
+	}
+}
+
+//记录对方的公钥, 返回是否可以使用
+//第一次见到的公钥直接信任, 和原来不一样的公钥要等用户确认
+func (this *KeyMgr) checkPeerKey(userId int, publicKey []byte) bool {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	old, ok := this.trusted[userId]
+	if !ok {
+		this.trusted[userId] = publicKey
+		this.saveTrusted()
+		return true
+	}
+	if bytes.Equal(old, publicKey) {
+		return true
+	}
+	if !bytes.Equal(this.changed[userId], publicKey) {
+		this.changed[userId] = publicKey
+		fmt.Printf("警告: 用户%d 的公钥变了! 新的指纹: %s\n", userId, e2e.Fingerprint(publicKey))
+		fmt.Printf("请和对方核对指纹, 确认无误后输入 /trust %d\n", userId)
+	}
+	return false
+}
+
+//发送加密的私聊消息, 还没有对方的公钥时先向服务器查询
+func SendE2EMes(toUserId int, content string) {
+
+	keyMgr.lock.Lock()
+	peerKey, ok := keyMgr.trusted[toUserId]
+	_, changed := keyMgr.changed[toUserId]
+	if !ok || changed {
+		keyMgr.pending[toUserId] = append(keyMgr.pending[toUserId], content)
+	}
+	keyMgr.lock.Unlock()
+
+	if changed {
+		fmt.Printf("用户%d 的公钥还没有确认, 消息会在 /trust %d 后发出\n", toUserId, toUserId)
+		return
+	}
+	if !ok {
+		tf := &utils.Transfer{
+			Conn: CurUser.Conn,
+		}
+		err := tf.WriteMes(message.GetKeyMesType, message.GetKeyMes{UserId: toUserId})
+		if err != nil {
+			fmt.Println("查询公钥失败 err=", err)
+		}
+		return
+	}
+	sendEncrypted(toUserId, content, peerKey)
+}
+
+func sendEncrypted(toUserId int, content string, peerKey []byte) {
+
+	keyMgr.lock.Lock()
+	key := keyMgr.key
+	keyMgr.lock.Unlock()
+	if key == nil {
+		fmt.Println("没有自己的私钥, 无法发送加密消息")
+		return
+	}
+	nonce, ciphertext, err := e2e.Encrypt(key, peerKey, CurUser.UserId, toUserId, []byte(content))
+	if err != nil {
+		fmt.Println("加密失败 err=", err)
+		return
+	}
+
+	smsMes := &message.SmsMes{}
+	smsMes.Content = base64.StdEncoding.EncodeToString(ciphertext)
+	smsMes.UserId = CurUser.UserId
+	smsMes.UserStatus = CurUser.UserStatus
+	smsMes.ToUserId = toUserId
+	smsMes.E2E = &message.E2EInfo{
+		SenderKey:   key.PublicKey().Bytes(),
+		ReceiverKey: peerKey,
+		Nonce:       nonce,
+	}
+	addLocalSms(smsMes)
+
+	tf := &utils.Transfer{
+		Conn: CurUser.Conn,
+	}
+	err = tf.WriteMes(message.SmsMesType, smsMes)
+	if err != nil {
+		fmt.Println("SendE2EMes err=", err)
+	}
+}
+
+//确认对方新的公钥, 并发出等待中的消息
+func TrustKey(userId int) {
+	keyMgr.lock.Lock()
+	publicKey, ok := keyMgr.changed[userId]
+	if ok {
+		keyMgr.trusted[userId] = publicKey
+		delete(keyMgr.changed, userId)
+		keyMgr.saveTrusted()
+	}
+	keyMgr.lock.Unlock()
+	if !ok {
+		fmt.Printf("用户%d 的公钥没有变化, 不需要确认\n", userId)
+		return
+	}
+	fmt.Printf("已信任用户%d 的新公钥\n", userId)
+	flushPending(userId, publicKey)
+}
+
+func flushPending(userId int, publicKey []byte) {
+	keyMgr.lock.Lock()
+	contents := keyMgr.pending[userId]
+	delete(keyMgr.pending, userId)
+	keyMgr.lock.Unlock()
+	for _, content := range contents {
+		sendEncrypted(userId, content, publicKey)
+	}
+}
+
+//显示指纹, userId 为 0 时显示自己的
+func outputFingerprint(userId int) {
+	keyMgr.lock.Lock()
+	defer keyMgr.lock.Unlock()
+	if userId == 0 || userId == CurUser.UserId {
+		if keyMgr.key != nil {
+			fmt.Println("你的公钥指纹:", e2e.Fingerprint(keyMgr.key.PublicKey().Bytes()))
+		}
+		return
+	}
+	if publicKey, ok := keyMgr.trusted[userId]; ok {
+		fmt.Printf("用户%d 的公钥指纹: %s\n", userId, e2e.Fingerprint(publicKey))
+	} else {
+		fmt.Printf("还没有用户%d 的公钥, 给他发一条 /emsg 后再查看\n", userId)
+	}
+	if publicKey, ok := keyMgr.changed[userId]; ok {
+		fmt.Printf("用户%d 未确认的新公钥指纹: %s\n", userId, e2e.Fingerprint(publicKey))
+	}
+}
+
+//服务器返回的对方公钥
+func onGetKeyRes(mes *message.Message) {
+	var getKeyResMes message.GetKeyResMes
+	err := json.Unmarshal([]byte(mes.Data), &getKeyResMes)
+	if err != nil {
+		fmt.Println("json.Unmarshal err=", err)
+		return
+	}
+	userId := getKeyResMes.UserId
+	if getKeyResMes.Code != 200 {
+		keyMgr.lock.Lock()
+		n := len(keyMgr.pending[userId])
+		delete(keyMgr.pending, userId)
+		keyMgr.lock.Unlock()
+		fmt.Printf("获取用户%d 的公钥失败: %s, %d 条加密消息没有发出\n", userId, getKeyResMes.Error, n)
+		return
+	}
+	if !keyMgr.checkPeerKey(userId, getKeyResMes.PublicKey) {
+		return
+	}
+	flushPending(userId, getKeyResMes.PublicKey)
+}
+
+func onPublishKeyRes(mes *message.Message) {
+	var publishKeyResMes message.PublishKeyResMes
+	err := json.Unmarshal([]byte(mes.Data), &publishKeyResMes)
+	if err != nil {
+		fmt.Println("json.Unmarshal err=", err)
+		return
+	}
+	if publishKeyResMes.Code != 200 {
+		fmt.Println("发布公钥失败:", publishKeyResMes.Error)
+	}
+}
+
+//要显示的消息内容, 加密的消息先解密
+func smsContent(smsMes *message.SmsMes) string {
+	if smsMes.E2E == nil {
+		return smsMes.Content
+	}
+	keyMgr.lock.Lock()
+	key := keyMgr.key
+	keyMgr.lock.Unlock()
+	if key == nil {
+		return "[加密消息, 没有私钥无法解密]"
+	}
+	//发送方用接收方的公钥解密, 接收方用发送方的公钥解密
+	myKey, peerKey := smsMes.E2E.ReceiverKey, smsMes.E2E.SenderKey
+	if smsMes.UserId == CurUser.UserId {
+		myKey, peerKey = smsMes.E2E.SenderKey, smsMes.E2E.ReceiverKey
+	}
+	if !bytes.Equal(myKey, key.PublicKey().Bytes()) {
+		return "[加密消息, 使用的不是当前的密钥, 无法解密]"
+	}
+	ciphertext, err := base64.StdEncoding.DecodeString(smsMes.Content)
+	if err != nil {
+		return "[" + e2e.ErrDecrypt.Error() + "]"
+	}
+	plaintext, err := e2e.Decrypt(key, peerKey, smsMes.UserId, smsMes.ToUserId, smsMes.E2E.Nonce, ciphertext)
+	if err != nil {
+		return "[" + err.Error() + "]"
+	}
+	return "[加密] " + string(plaintext)
+}
+
+//收到加密消息时检查发送方的公钥, 和已信任的不一致时提醒用户
+func checkSenderKey(smsMes *message.SmsMes) {
+	if smsMes.E2E == nil || smsMes.UserId == CurUser.UserId {
+		return
+	}
+	keyMgr.checkPeerKey(smsMes.UserId, smsMes.E2E.SenderKey)
+}
@@ -0,0 +1,117 @@
+package process
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+
+	"go_code/chatroom/client/utils"
+	"go_code/chatroom/common/message"
+)
+
+type ModerateProcess struct {
+}
+
+//发起一个管理操作
+func (this *ModerateProcess) Moderate(moderateMes message.ModerateMes) (err error) {
+	tf := &utils.Transfer{
+		Conn: CurUser.Conn,
+	}
+	err = tf.WriteMes(message.ModerateMesType, moderateMes)
+	if err != nil {
+		fmt.Println("Moderate err=", err)
+	}
+	return
+}
+
+//管理菜单, 只有版主和管理员可以看到
+func showModerateMenu() {
+
+	fmt.Println("-------1. 禁言---------")
+	fmt.Println("-------2. 解除禁言---------")
+	fmt.Println("-------3. 踢下线---------")
+	fmt.Println("-------4. 封禁---------")
+	fmt.Println("-------5. 解除封禁---------")
+	fmt.Println("-------6. 设置角色(管理员)---------")
+	fmt.Println("请选择(1-6):")
+	var key int
+	fmt.Scanf("%d\n", &key)
+
+	actions := map[int]string{
+		1: message.ModerateMute,
+		2: message.ModerateUnmute,
+		3: message.ModerateKick,
+		4: message.ModerateBan,
+		5: message.ModerateUnban,
+		6: message.ModerateRole,
+	}
+	action, ok := actions[key]
+	if !ok {
+		fmt.Println("你输入的选项不正确..")
+		return
+	}
+	moderateMes := message.ModerateMes{
+		Action: action,
+	}
+	fmt.Println("请输入用户id(按ip封禁时输入0):")
+	fmt.Scanf("%d\n", &moderateMes.UserId)
+	switch action {
+		case message.ModerateMute:
+			fmt.Println("请输入禁言的秒数:")
+			fmt.Scanf("%d\n", &moderateMes.Duration)
+		case message.ModerateBan, message.ModerateUnban:
+			fmt.Println("请输入ip(不按ip封禁直接回车):")
+			fmt.Scanf("%s\n", &moderateMes.Ip)
+			if action == message.ModerateBan {
+				fmt.Println("请输入封禁的秒数(0表示永久):")
+				fmt.Scanf("%d\n", &moderateMes.Duration)
+			}
+		case message.ModerateRole:
+			fmt.Println("请输入角色(0 普通用户 1 版主 2 管理员):")
+			fmt.Scanf("%d\n", &moderateMes.Role)
+	}
+	if action != message.ModerateRole && action != message.ModerateUnmute && action != message.ModerateUnban {
+		fmt.Println("请输入原因:")
+		fmt.Scanf("%s\n", &moderateMes.Reason)
+	}
+	moderateProcess := &ModerateProcess{}
+	moderateProcess.Moderate(moderateMes)
+}
+
+//显示管理操作的结果
+func outputModerateRes(mes *message.Message) {
+	var moderateResMes message.ModerateResMes
+	err := json.Unmarshal([]byte(mes.Data), &moderateResMes)
+	if err != nil {
+		fmt.Println("json.Unmarshal err=", err)
+		return
+	}
+	if moderateResMes.Code == 200 {
+		fmt.Println("管理操作成功")
+	} else {
+		fmt.Println("管理操作失败:", moderateResMes.Error)
+	}
+}
+
+//我被管理员操作了
+func outputModerate(mes *message.Message) {
+	var moderateMes message.ModerateMes
+	err := json.Unmarshal([]byte(mes.Data), &moderateMes)
+	if err != nil {
+		fmt.Println("json.Unmarshal err=", err)
+		return
+	}
+	switch moderateMes.Action {
+		case message.ModerateMute:
+			fmt.Printf("你已被用户%d 禁言%d秒, 原因: %s\n", moderateMes.OperatorId, moderateMes.Duration, moderateMes.Reason)
+		case message.ModerateUnmute:
+			fmt.Println("你的禁言已被解除")
+		case message.ModerateKick, message.ModerateBan:
+			fmt.Printf("你已被用户%d 踢下线, 原因: %s\n", moderateMes.OperatorId, moderateMes.Reason)
+			os.Exit(0)
+		case message.ModerateRole:
+			CurUser.Role = moderateMes.Role
+			roles := []string{"普通用户", "版主", "管理员"}
+			fmt.Println("你的角色已变更为", roles[moderateMes.Role])
+	}
+}
@@ -0,0 +1,139 @@
+package process
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"sync"
+
+	"go_code/chatroom/common/message"
+)
+
+//@提醒和关键词提醒
+//提醒设置保存在服务器, 这里只保存一份最近收到的副本, 修改时在副本上修改后整体发给服务器
+
+var (
+	notifySettings message.NotifySettings
+	notifyLock     sync.Mutex
+)
+
+//登录后查询自己的提醒设置
+func RequestNotifySettings() (err error) {
+	return writeMes(message.GetNotifySettingsMesType, message.GetNotifySettingsMes{})
+}
+
+//在当前设置的副本上修改, 然后发给服务器, 服务器返回后才生效
+func updateNotifySettings(update func(settings *message.NotifySettings)) (err error) {
+	notifyLock.Lock()
+	settings := message.NotifySettings{
+		Keywords:   append([]string(nil), notifySettings.Keywords...),
+		MutedRooms: append([]string(nil), notifySettings.MutedRooms...),
+	}
+	notifyLock.Unlock()
+	update(&settings)
+	return writeMes(message.SetNotifySettingsMesType, message.SetNotifySettingsMes{Settings: settings})
+}
+
+func removeString(list []string, s string) (res []string) {
+	for _, v := range list {
+		if v != s {
+			res = append(res, v)
+		}
+	}
+	return
+}
+
+//增加一个关键词, 服务器会转成小写
+func AddKeyword(keyword string) (err error) {
+	return updateNotifySettings(func(settings *message.NotifySettings) {
+		settings.Keywords = append(settings.Keywords, keyword)
+	})
+}
+
+func DelKeyword(keyword string) (err error) {
+	keyword = strings.ToLower(strings.TrimSpace(keyword))
+	return updateNotifySettings(func(settings *message.NotifySettings) {
+		settings.Keywords = removeString(settings.Keywords, keyword)
+	})
+}
+
+//不再提醒某个房间中的@和关键词
+func MuteRoom(roomId string) (err error) {
+	return updateNotifySettings(func(settings *message.NotifySettings) {
+		settings.MutedRooms = append(removeString(settings.MutedRooms, roomId), roomId)
+	})
+}
+
+func UnmuteRoom(roomId string) (err error) {
+	return updateNotifySettings(func(settings *message.NotifySettings) {
+		settings.MutedRooms = removeString(settings.MutedRooms, roomId)
+	})
+}
+
+//显示当前的提醒设置
+func outputNotifySettings() {
+	notifyLock.Lock()
+	defer notifyLock.Unlock()
+	keywords := "无"
+	if len(notifySettings.Keywords) > 0 {
+		keywords = strings.Join(notifySettings.Keywords, ", ")
+	}
+	var rooms []string
+	for _, roomId := range notifySettings.MutedRooms {
+		rooms = append(rooms, roomName(roomId))
+	}
+	mutedRooms := "无"
+	if len(rooms) > 0 {
+		mutedRooms = strings.Join(rooms, ", ")
+	}
+	fmt.Println("关键词:", keywords)
+	fmt.Println("屏蔽提醒的房间:", mutedRooms)
+}
+
+//服务器返回的提醒设置
+func onNotifySettingsRes(mes *message.Message) {
+	var resMes message.NotifySettingsResMes
+	err := json.Unmarshal([]byte(mes.Data), &resMes)
+	if err != nil {
+		fmt.Println("json.Unmarshal err=", err)
+		return
+	}
+	if resMes.Code != 200 {
+		fmt.Println("修改提醒设置失败:", resMes.Error)
+		return
+	}
+	notifyLock.Lock()
+	notifySettings = resMes.Settings
+	notifyLock.Unlock()
+}
+
+//有人@了我, 或者消息中包含我的关键词
+//同一个房间的消息已经显示过, 只显示一行提醒, 其它房间或者离线时的消息显示完整的内容
+func onMention(mes *message.Message) {
+	var mentionMes message.MentionMes
+	err := json.Unmarshal([]byte(mes.Data), &mentionMes)
+	if err != nil {
+		fmt.Println("json.Unmarshal err=", err)
+		return
+	}
+	smsMes := &mentionMes.SmsMes
+	reason := "@了你"
+	if mentionMes.Reason == message.MentionKeyword {
+		reason = fmt.Sprintf("提到了关键词 \"%s\"", mentionMes.Keyword)
+	}
+
+	smsLock.Lock()
+	shown := findSms(smsMes.MesId) != nil
+	if !shown {
+		smsList = append(smsList, smsMes)
+	}
+	smsLock.Unlock()
+
+	if shown {
+		fmt.Printf("*** [提醒] 用户%d 在 %s %s [消息%d]\n", smsMes.UserId, roomName(smsMes.RoomId), reason, smsMes.MesId)
+		return
+	}
+	fmt.Printf("*** [提醒] 用户%d 在 %s %s [消息%d]: %s\n",
+		smsMes.UserId, roomName(smsMes.RoomId), reason, smsMes.MesId, smsText(smsMes))
+	fmt.Println()
+}
@@ -0,0 +1,120 @@
+package process
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"go_code/chatroom/common/message"
+)
+
+//定时消息和提醒, 由服务器保存, 客户端退出后也会按时发送
+//时间可以是:
+//	+10m, +1h30m        从现在开始多久以后
+//	17:00               今天的这个时间, 已经过了就是明天
+//	"2024-01-02 17:00"  日期和时间, 中间有空格, 需要加引号
+//定时消息发到 lobby(大厅), 某个房间, 或者某个用户id的私聊
+
+//支持的日期和时间的格式
+var sendAtLayouts = []string{
+	"2006-01-02 15:04:05",
+	"2006-01-02 15:04",
+}
+
+//把输入的时间转换成unix秒
+func parseSendAt(value string, now time.Time) (sendAt int64, err error) {
+	value = strings.TrimSpace(value)
+	if strings.HasPrefix(value, "+") {
+		d, err := time.ParseDuration(value[1:])
+		if err != nil || d <= 0 {
+			return 0, fmt.Errorf("时间格式不正确: %s, 例如 +10m, +1h30m", value)
+		}
+		return now.Add(d).Unix(), nil
+	}
+	if t, err := time.ParseInLocation("15:04", value, time.Local); err == nil {
+		at := time.Date(now.Year(), now.Month(), now.Day(), t.Hour(), t.Minute(), 0, 0, time.Local)
+		if !at.After(now) {
+			at = at.AddDate(0, 0, 1)
+		}
+		return at.Unix(), nil
+	}
+	for _, layout := range sendAtLayouts {
+		t, err := time.ParseInLocation(layout, value, time.Local)
+		if err == nil {
+			return t.Unix(), nil
+		}
+	}
+	return 0, fmt.Errorf("时间格式不正确: %s, 例如 +10m, 17:00 或者 \"%s\"", value, now.Format("2006-01-02 15:04"))
+}
+
+//定时发送消息, target 是房间名或者用户id
+func ScheduleMes(when string, target string, content string) (err error) {
+	sendAt, err := parseSendAt(when, time.Now())
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	scheduleMes := message.ScheduleMes{
+		Kind:    message.ScheduleSend,
+		Content: content,
+		SendAt:  sendAt,
+	}
+	if id, err := strconv.Atoi(target); err == nil && id > 0 {
+		scheduleMes.ToUserId = id
+	} else {
+		scheduleMes.RoomId = roomArg(target)
+	}
+	return writeMes(message.ScheduleMesType, scheduleMes)
+}
+
+//到时间提醒自己
+func RemindMe(when string, content string) (err error) {
+	sendAt, err := parseSendAt(when, time.Now())
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	return writeMes(message.ScheduleMesType, message.ScheduleMes{
+		Kind:    message.ScheduleRemind,
+		Content: content,
+		SendAt:  sendAt,
+	})
+}
+
+func CancelSchedule(id int) (err error) {
+	return writeMes(message.ScheduleMesType, message.ScheduleMes{CancelId: id})
+}
+
+func outputScheduleRes(mes *message.Message) {
+	var scheduleResMes message.ScheduleResMes
+	err := json.Unmarshal([]byte(mes.Data), &scheduleResMes)
+	if err != nil {
+		fmt.Println("json.Unmarshal err=", err)
+		return
+	}
+	switch {
+		case scheduleResMes.Code != 200 && scheduleResMes.Cancelled:
+			fmt.Printf("取消定时任务%d 失败: %s\n", scheduleResMes.Id, scheduleResMes.Error)
+		case scheduleResMes.Code != 200:
+			fmt.Println("创建定时任务失败:", scheduleResMes.Error)
+		case scheduleResMes.Cancelled:
+			fmt.Printf("已取消定时任务%d\n", scheduleResMes.Id)
+		default:
+			sendAt := time.Unix(scheduleResMes.SendAt, 0).Format("01-02 15:04:05")
+			fmt.Printf("已创建定时任务%d, 将在 %s 执行, 输入 /unschedule %d 取消\n",
+				scheduleResMes.Id, sendAt, scheduleResMes.Id)
+	}
+}
+
+func outputReminder(mes *message.Message) {
+	var reminderMes message.ReminderMes
+	err := json.Unmarshal([]byte(mes.Data), &reminderMes)
+	if err != nil {
+		fmt.Println("json.Unmarshal err=", err)
+		return
+	}
+	sendAt := time.Unix(reminderMes.SendAt, 0).Format("01-02 15:04")
+	fmt.Printf("[提醒] %s %s\n", sendAt, reminderMes.Content)
+}
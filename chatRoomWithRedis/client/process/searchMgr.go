@@ -0,0 +1,137 @@
+package process
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"go_code/chatroom/common/message"
+)
+
+//搜索聊天记录
+//搜索内容中可以带上条件, 比如 /search from:100 in:golang since:2024-01-01 部署
+//	from:<用户id>   只搜这个用户发的消息
+//	in:<房间>       只搜这个房间, in:lobby 表示大厅
+//	with:<用户id>   只搜和这个用户的私聊
+//	since:<日期> until:<日期>  发送时间的范围, 日期格式 2006-01-02
+//不带参数的 /search 显示上一次搜索的下一页
+
+const dateLayout = "2006-01-02"
+
+var (
+	//上一次的搜索, 用来翻页
+	lastSearch     *message.SearchMes
+	lastSearchLock sync.Mutex
+)
+
+//把输入解析成SearchMes, 不认识的条件当作搜索内容
+func parseSearch(text string) (searchMes *message.SearchMes, err error) {
+	searchMes = &message.SearchMes{}
+	var words []string
+	for _, field := range strings.Fields(text) {
+		name, value, ok := strings.Cut(field, ":")
+		if !ok || value == "" {
+			words = append(words, field)
+			continue
+		}
+		switch strings.ToLower(name) {
+			case "from", "with":
+				id, err := strconv.Atoi(value)
+				if err != nil || id <= 0 {
+					return nil, fmt.Errorf("%s: 后面需要用户id", name)
+				}
+				if strings.ToLower(name) == "from" {
+					searchMes.FromUserId = id
+				} else {
+					searchMes.ToUserId = id
+				}
+			case "in":
+				searchMes.InRoom = true
+				searchMes.RoomId = roomArg(value)
+			case "since", "until":
+				t, err := time.ParseInLocation(dateLayout, value, time.Local)
+				if err != nil {
+					return nil, fmt.Errorf("%s: 后面需要日期, 比如 %s", name, time.Now().Format(dateLayout))
+				}
+				if strings.ToLower(name) == "since" {
+					searchMes.Since = t.Unix()
+				} else {
+					//包含这一整天
+					searchMes.Until = t.AddDate(0, 0, 1).Unix() - 1
+				}
+			default:
+				words = append(words, field)
+		}
+	}
+	searchMes.Query = strings.Join(words, " ")
+	if searchMes.Query == "" {
+		return nil, fmt.Errorf("请输入要搜索的内容")
+	}
+	return
+}
+
+//开始新的搜索, text 为空时显示上一次搜索的下一页
+func SearchMes(text string) (err error) {
+	var searchMes message.SearchMes
+	if strings.TrimSpace(text) == "" {
+		lastSearchLock.Lock()
+		if lastSearch == nil || lastSearch.BeforeId == 0 {
+			lastSearchLock.Unlock()
+			fmt.Println("没有更多的搜索结果")
+			return
+		}
+		searchMes = *lastSearch
+		lastSearchLock.Unlock()
+	} else {
+		parsed, err := parseSearch(text)
+		if err != nil {
+			fmt.Println(err)
+			return err
+		}
+		searchMes = *parsed
+		lastSearchLock.Lock()
+		lastSearch = parsed
+		lastSearchLock.Unlock()
+	}
+	return writeMes(message.SearchMesType, searchMes)
+}
+
+//显示搜索结果, 记下翻页的位置
+func outputSearchRes(mes *message.Message) {
+	var searchResMes message.SearchResMes
+	err := json.Unmarshal([]byte(mes.Data), &searchResMes)
+	if err != nil {
+		fmt.Println("json.Unmarshal err=", err)
+		return
+	}
+	if searchResMes.Code != 200 {
+		fmt.Println("搜索失败:", searchResMes.Error)
+		return
+	}
+	lastSearchLock.Lock()
+	if lastSearch != nil {
+		lastSearch.BeforeId = searchResMes.NextBeforeId
+	}
+	lastSearchLock.Unlock()
+
+	if len(searchResMes.Messages) == 0 {
+		fmt.Println("没有找到")
+	}
+	for _, smsMes := range searchResMes.Messages {
+		sendTime := time.Unix(smsMes.SendTime, 0).Format("01-02 15:04:05")
+		switch {
+			case smsMes.UserId == CurUser.UserId && smsMes.ToUserId != 0:
+				fmt.Printf("%s [消息%d] 我对用户%d 说: %s\n", sendTime, smsMes.MesId, smsMes.ToUserId, smsText(&smsMes))
+			case smsMes.ToUserId != 0:
+				fmt.Printf("%s [消息%d] 用户%d 对我说: %s\n", sendTime, smsMes.MesId, smsMes.UserId, smsText(&smsMes))
+			default:
+				fmt.Printf("%s [%s] [消息%d] 用户%d: %s\n", sendTime, roomName(smsMes.RoomId), smsMes.MesId, smsMes.UserId, smsText(&smsMes))
+		}
+	}
+	if searchResMes.NextBeforeId != 0 {
+		fmt.Println("---- 输入 /search 查看更多结果 ----")
+	}
+}
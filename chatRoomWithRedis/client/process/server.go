@@ -17,9 +17,18 @@ func ShowMenu() {
 	fmt.Println("-------2. 发送消息---------")
 	fmt.Println("-------3. 信息列表---------")
 	fmt.Println("-------4. 退出系统---------")
-	fmt.Println("请选择(1-4):")
+	fmt.Println("-------5. 文件传输---------")
+	fmt.Println("-------6. 发送私聊消息---------")
+	fmt.Println("-------7. 设置状态---------")
+	if CurUser.Role >= message.RoleModerator {
+		fmt.Println("-------8. 管理---------")
+		fmt.Println("请选择(1-8):")
+	} else {
+		fmt.Println("请选择(1-7):")
+	}
 	var key int 
 	var content string
+	var toUserId int
 
 	//因为，我们总会使用到SmsProcess实例，因此我们将其定义在swtich外部
 	smsProcess := &SmsProcess{}
@@ -30,13 +39,31 @@ func ShowMenu() {
 			outputOnlineUser()
 		case 2:
 			fmt.Println("你想对大家说的什么:)")
+			sendTyping(0)
 			fmt.Scanf("%s\n", &content)
 			smsProcess.SendGroupMes(content)
 		case 3:
-			fmt.Println("信息列表")
+			outputMesList()
 		case 4:
 			fmt.Println("你选择退出了系统...")
 			os.Exit(0)
+		case 5:
+			showFileMenu()
+		case 6:
+			fmt.Println("请输入对方的用户id:")
+			fmt.Scanf("%d\n", &toUserId)
+			fmt.Println("你想对他说的什么:)")
+			sendTyping(toUserId)
+			fmt.Scanf("%s\n", &content)
+			smsProcess.SendPrivateMes(toUserId, content)
+		case 7:
+			showStatusMenu()
+		case 8:
+			if CurUser.Role < message.RoleModerator {
+				fmt.Println("你输入的选项不正确..")
+				break
+			}
+			showModerateMenu()
 		default :
 			fmt.Println("你输入的选项不正确..")
 	}
@@ -67,8 +94,56 @@ func serverProcessMes(conn net.Conn) {
 				//2. 把这个用户的信息，状态保存到客户map[int]User中
 				updateUserStatus(&notifyUserStatusMes)
 				//处理
-			case message.SmsMesType : //有人群发消息
+			case message.SmsMesType : //有人群发消息或者私聊
 				outputGroupMes(&mes)
+			case message.SmsResMesType : //服务器收到了我发的消息
+				updateSmsRes(&mes)
+			case message.DeliveredMesType, message.ReadMesType : //对方的回执
+				updateSmsStatus(&mes)
+			case message.EditMesType : //有人修改了消息
+				onEditMes(&mes)
+			case message.DeleteMesType :
+				onDeleteMes(&mes)
+			case message.ReactMesType :
+				onReactMes(&mes)
+			case message.MesActionResMesType :
+				outputMesActionRes(&mes)
+			case message.MentionMesType : //有人@了我, 或者提到了我的关键词
+				onMention(&mes)
+			case message.NotifySettingsResMesType :
+				onNotifySettingsRes(&mes)
+			case message.TypingMesType : //有人正在输入
+				outputTyping(&mes)
+			case message.JoinRoomResMesType : //进入房间的结果
+				outputJoinRoomRes(&mes)
+			case message.HistoryResMesType : //聊天记录
+				outputHistory(&mes)
+			case message.SearchResMesType : //搜索聊天记录的结果
+				outputSearchRes(&mes)
+			case message.ScheduleResMesType : //定时任务的结果
+				outputScheduleRes(&mes)
+			case message.ReminderMesType : //到时间的提醒
+				outputReminder(&mes)
+			case message.GetKeyResMesType : //对方的公钥
+				onGetKeyRes(&mes)
+			case message.PublishKeyResMesType :
+				onPublishKeyRes(&mes)
+			case message.ModerateResMesType : //管理操作的结果
+				outputModerateRes(&mes)
+			case message.ModerateMesType : //我被管理员操作了
+				outputModerate(&mes)
+			case message.FileOfferMesType : //有人要发文件给我
+				onFileOffer(&mes)
+			case message.FileOfferResMesType :
+				onFileOfferRes(&mes)
+			case message.FileAnswerMesType :
+				onFileAnswer(&mes)
+			case message.FileChunkMesType :
+				onFileChunk(&mes)
+			case message.FileAckMesType :
+				onFileAck(&mes)
+			case message.FileListResMesType :
+				onFileListRes(&mes)
 			default :
 				fmt.Println("服务器端返回了未知的消息类型")
 		}
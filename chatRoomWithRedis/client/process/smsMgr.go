@@ -1,10 +1,42 @@
 package process
 import (
 	"fmt"
+	"sync"
+	"time"
 	"go_code/chatroom/common/message"
+	"go_code/chatroom/client/utils"
 	"encoding/json"
 )
 
+//客户端保存的聊天记录, 按发出或收到的顺序
+//读取服务器消息的协程和菜单协程都会访问，需要加锁
+var (
+	smsList []*message.SmsMes
+	smsLock sync.Mutex
+	localSeq int //给自己发出的消息编号
+)
+
+//投递状态对应的显示
+func mesStatusText(status int) string {
+	switch status {
+		case message.MesDelivered:
+			return "已送达"
+		case message.MesRead:
+			return "已读"
+		default:
+			return "已发送"
+	}
+}
+
+//发出消息前先记录下来，等服务器返回mesId
+func addLocalSms(smsMes *message.SmsMes) {
+	smsLock.Lock()
+	defer smsLock.Unlock()
+	localSeq++
+	smsMes.LocalId = localSeq
+	smsList = append(smsList, smsMes)
+}
+
 func outputGroupMes(mes *message.Message) { //这个地方mes一定SmsMes
 	//显示即可
 	//1. 反序列化mes.Data
@@ -15,11 +47,137 @@ func outputGroupMes(mes *message.Message) { //这个地方mes一定SmsMes
 		return	
 	}
 
+	smsLock.Lock()
+	smsList = append(smsList, &smsMes)
+	smsLock.Unlock()
+
+	if smsMes.ToUserId != 0 {
+		outputPrivateMes(&smsMes)
+		return
+	}
+
 	//显示信息
-	info := fmt.Sprintf("用户id:\t%d 对大家说:\t%s", 
-		smsMes.UserId, smsMes.Content)
+	info := fmt.Sprintf("[%s] [消息%d] 用户id:\t%d 对大家说:\t%s", 
+		roomName(smsMes.RoomId), smsMes.MesId, smsMes.UserId, smsText(&smsMes))
 	fmt.Println(info)
 	fmt.Println()
 
 	
-}
\ No newline at end of file
+}
+
+//显示私聊消息，并告诉服务器已经送达
+func outputPrivateMes(smsMes *message.SmsMes) {
+
+	checkSenderKey(smsMes)
+
+	info := fmt.Sprintf("[消息%d] 用户id:\t%d 对你说:\t%s", 
+		smsMes.MesId, smsMes.UserId, smsText(smsMes))
+	fmt.Println(info)
+	fmt.Println()
+
+	smsLock.Lock()
+	smsMes.MesStatus = message.MesDelivered
+	smsLock.Unlock()
+
+	tf := &utils.Transfer{
+		Conn : CurUser.Conn,
+	}
+	err := tf.WriteMes(message.DeliveredMesType, message.DeliveredMes{MesIds: []int{smsMes.MesId}})
+	if err != nil {
+		fmt.Println("发送送达回执失败 err=", err)
+	}
+}
+
+//服务器收到了我发的消息
+func updateSmsRes(mes *message.Message) {
+	var smsResMes message.SmsResMes
+	err := json.Unmarshal([]byte(mes.Data), &smsResMes) 
+	if err != nil {
+		fmt.Println("json.Unmarshal err=", err.Error())
+		return	
+	}
+
+	smsLock.Lock()
+	defer smsLock.Unlock()
+	for _, smsMes := range smsList {
+		if smsMes.UserId == CurUser.UserId && smsMes.LocalId == smsResMes.LocalId {
+			if smsResMes.Code != 200 {
+				fmt.Println("消息发送失败:", smsResMes.Error)
+				return
+			}
+			smsMes.MesId = smsResMes.MesId
+			smsMes.SendTime = smsResMes.SendTime
+			if smsMes.ToUserId != 0 {
+				fmt.Printf("[消息%d] %s\n", smsMes.MesId, mesStatusText(smsMes.MesStatus))
+			}
+			return
+		}
+	}
+}
+
+//对方的送达回执或已读回执
+func updateSmsStatus(mes *message.Message) {
+	var mesIds []int
+	var userId int
+	var status int
+	if mes.Type == message.DeliveredMesType {
+		var deliveredMes message.DeliveredMes
+		json.Unmarshal([]byte(mes.Data), &deliveredMes)
+		mesIds, userId, status = deliveredMes.MesIds, deliveredMes.UserId, message.MesDelivered
+	} else {
+		var readMes message.ReadMes
+		json.Unmarshal([]byte(mes.Data), &readMes)
+		mesIds, userId, status = readMes.MesIds, readMes.UserId, message.MesRead
+	}
+
+	smsLock.Lock()
+	defer smsLock.Unlock()
+	for _, mesId := range mesIds {
+		for _, smsMes := range smsList {
+			if smsMes.MesId == mesId && smsMes.UserId == CurUser.UserId && smsMes.MesStatus < status {
+				smsMes.MesStatus = status
+				fmt.Printf("[消息%d] 用户%d %s\n", mesId, userId, mesStatusText(status))
+			}
+		}
+	}
+}
+
+//显示信息列表, 别人发给我的私聊消息在这里被看到后，发送已读回执
+func outputMesList() {
+
+	var readIds []int
+	smsLock.Lock()
+	fmt.Println("信息列表:")
+	for _, smsMes := range smsList {
+		sendTime := time.Unix(smsMes.SendTime, 0).Format("15:04:05")
+		switch {
+			case smsMes.UserId == CurUser.UserId && smsMes.ToUserId != 0:
+				fmt.Printf("%s [消息%d] 我对用户%d 说: %s (%s)\n", sendTime, smsMes.MesId,
+					smsMes.ToUserId, smsText(smsMes), mesStatusText(smsMes.MesStatus))
+			case smsMes.UserId == CurUser.UserId:
+				fmt.Printf("%s [消息%d] 我对大家说: %s\n", sendTime, smsMes.MesId, smsText(smsMes))
+			case smsMes.ToUserId != 0:
+				fmt.Printf("%s [消息%d] 用户%d 对我说: %s\n", sendTime, smsMes.MesId,
+					smsMes.UserId, smsText(smsMes))
+				if smsMes.MesStatus != message.MesRead {
+					smsMes.MesStatus = message.MesRead
+					readIds = append(readIds, smsMes.MesId)
+				}
+			default:
+				fmt.Printf("%s [消息%d] 用户%d 对大家说: %s\n", sendTime, smsMes.MesId,
+					smsMes.UserId, smsText(smsMes))
+		}
+	}
+	smsLock.Unlock()
+
+	if len(readIds) == 0 {
+		return
+	}
+	tf := &utils.Transfer{
+		Conn : CurUser.Conn,
+	}
+	err := tf.WriteMes(message.ReadMesType, message.ReadMes{MesIds: readIds})
+	if err != nil {
+		fmt.Println("发送已读回执失败 err=", err)
+	}
+}
@@ -12,21 +12,32 @@ type SmsProcess struct {
 
 //发送群聊的消息
 func (this *SmsProcess) SendGroupMes(content string) (err error) {
+	return this.SendPrivateMes(0, content)
+}
+
+//发送私聊的消息, toUserId 为 0 时就是群聊
+func (this *SmsProcess) SendPrivateMes(toUserId int, content string) (err error) {
 
 	//1 创建一个Mes
 	var mes message.Message
 	mes.Type = message.SmsMesType
 
 	//2 创建一个SmsMes 实例
-	var smsMes message.SmsMes
+	smsMes := &message.SmsMes{}
 	smsMes.Content = content //内容.
 	smsMes.UserId = CurUser.UserId //
 	smsMes.UserStatus = CurUser.UserStatus //
+	smsMes.ToUserId = toUserId
+	if toUserId == 0 {
+		smsMes.RoomId = CurUser.Room
+	}
+	//记录到本地的信息列表，并分配LocalId
+	addLocalSms(smsMes)
 
 	//3.序列化 smsMes
 	data, err := json.Marshal(smsMes)
 	if err != nil {
-		fmt.Println("SendGroupMes json.Marshal fail =", err.Error())
+		fmt.Println("SendPrivateMes json.Marshal fail =", err.Error())
 		return
 	}
 
@@ -35,7 +46,7 @@ func (this *SmsProcess) SendGroupMes(content string) (err error) {
 	//4. 对mes再次序列化
 	data, err = json.Marshal(mes)
 	if err != nil {
-		fmt.Println("SendGroupMes json.Marshal fail =", err.Error())
+		fmt.Println("SendPrivateMes json.Marshal fail =", err.Error())
 		return
 	}
 
@@ -46,7 +57,7 @@ func (this *SmsProcess) SendGroupMes(content string) (err error) {
 	//6.发送
 	err = tf.WritePkg(data)
 	if err != nil {
-		fmt.Println("SendGroupMes err=", err.Error())
+		fmt.Println("SendPrivateMes err=", err.Error())
 		return 
 	}
 
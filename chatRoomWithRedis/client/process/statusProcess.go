@@ -0,0 +1,95 @@
+package process
+
+import (
+	"encoding/json"
+	"fmt"
+	"sync"
+	"time"
+
+	"go_code/chatroom/client/utils"
+	"go_code/chatroom/common/message"
+)
+
+//记录每个会话最后一次发送TypingMes的时间，避免频繁发送
+var (
+	typingTimes     = make(map[int]time.Time)
+	typingTimesLock sync.Mutex
+)
+
+//设置自己的状态
+func SetStatus(status int, statusText string) (err error) {
+	tf := &utils.Transfer{
+		Conn: CurUser.Conn,
+	}
+	err = tf.WriteMes(message.SetStatusMesType, message.SetStatusMes{
+		Status:     status,
+		StatusText: statusText,
+	})
+	if err != nil {
+		fmt.Println("SetStatus err=", err)
+		return
+	}
+	CurUser.UserStatus = status
+	CurUser.StatusText = statusText
+	return
+}
+
+//告诉对方我正在输入, toUserId 为 0 表示群聊
+func sendTyping(toUserId int) {
+	typingTimesLock.Lock()
+	last, ok := typingTimes[toUserId]
+	if ok && time.Since(last) < message.TypingInterval*time.Second {
+		typingTimesLock.Unlock()
+		return
+	}
+	typingTimes[toUserId] = time.Now()
+	typingTimesLock.Unlock()
+
+	tf := &utils.Transfer{
+		Conn: CurUser.Conn,
+	}
+	tf.WriteMes(message.TypingMesType, message.TypingMes{ToUserId: toUserId})
+}
+
+//显示谁正在输入
+func outputTyping(mes *message.Message) {
+	var typingMes message.TypingMes
+	err := json.Unmarshal([]byte(mes.Data), &typingMes)
+	if err != nil {
+		fmt.Println("json.Unmarshal err=", err)
+		return
+	}
+	if typingMes.ToUserId != 0 {
+		fmt.Printf("用户%d 正在给你输入消息...\n", typingMes.UserId)
+	} else {
+		fmt.Printf("用户%d 正在群聊中输入...\n", typingMes.UserId)
+	}
+}
+
+//设置状态的菜单
+func showStatusMenu() {
+
+	fmt.Println("-------1. 在线---------")
+	fmt.Println("-------2. 离开---------")
+	fmt.Println("-------3. 忙碌---------")
+	fmt.Println("-------4. 隐身---------")
+	fmt.Println("请选择(1-4):")
+	var key int
+	var statusText string
+	fmt.Scanf("%d\n", &key)
+
+	statuses := map[int]int{
+		1: message.UserOnline,
+		2: message.UserAway,
+		3: message.UserBusyStatus,
+		4: message.UserInvisible,
+	}
+	status, ok := statuses[key]
+	if !ok {
+		fmt.Println("你输入的选项不正确..")
+		return
+	}
+	fmt.Println("请输入状态说明(可以直接回车跳过):")
+	fmt.Scanf("%s\n", &statusText)
+	SetStatus(status, statusText)
+}
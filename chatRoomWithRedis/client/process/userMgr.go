@@ -14,15 +14,26 @@ var CurUser model.CurUser //我们在用户登录成功后，完成对CurUser初
 func outputOnlineUser() {
 	//遍历一把 onlineUsers
 	fmt.Println("当前在线用户列表:")
-	for id, _ := range onlineUsers{
+	for id, user := range onlineUsers{
 		//如果不显示自己.
-		fmt.Println("用户id:\t", id)
+		if user.StatusText != "" {
+			fmt.Printf("用户id:\t %d\t%s\t%s\n", id, message.StatusText(user.UserStatus), user.StatusText)
+		} else {
+			fmt.Printf("用户id:\t %d\t%s\n", id, message.StatusText(user.UserStatus))
+		}
 	}
 }
 
 //编写一个方法，处理返回的NotifyUserStatusMes
 func updateUserStatus(notifyUserStatusMes *message.NotifyUserStatusMes) {
 
+	//下线了，从列表中删除
+	if notifyUserStatusMes.Status == message.UserOffline {
+		delete(onlineUsers, notifyUserStatusMes.UserId)
+		outputOnlineUser()
+		return
+	}
+
 	//适当优化
 	user, ok := onlineUsers[notifyUserStatusMes.UserId]
 	if !ok { //原来没有
@@ -31,6 +42,7 @@ func updateUserStatus(notifyUserStatusMes *message.NotifyUserStatusMes) {
 		}
 	}
 	user.UserStatus = notifyUserStatusMes.Status
+	user.StatusText = notifyUserStatusMes.StatusText
 	onlineUsers[notifyUserStatusMes.UserId] = user
 
 	outputOnlineUser()
@@ -27,6 +27,13 @@ func (this *UserProcess) Register(userId int,
 	//延时关闭
 	defer conn.Close()
 
+	//先握手, 和注册的请求一起发出
+	err = sendHello(conn)
+	if err != nil {
+		fmt.Println("握手发送信息错误 err=", err)
+		return
+	}
+
 	//2. 准备通过conn发送消息给服务
 	var mes message.Message
 	mes.Type = message.RegisterMesType
@@ -64,7 +71,7 @@ func (this *UserProcess) Register(userId int,
 		fmt.Println("注册发送信息错误 err=", err)
 	}
 
-	mes, err = tf.ReadPkg() // mes 就是 RegisterResMes
+	mes, _, err = readReply(tf) // mes 就是 RegisterResMes
 	
 	if err != nil {
 		fmt.Println("readPkg(conn) err=", err)
@@ -103,6 +110,13 @@ func (this *UserProcess) Login(userId int, userPwd string) (err error) {
 	//延时关闭
 	defer conn.Close()
 
+	//先握手, 和登录的请求一起发出
+	err = sendHello(conn)
+	if err != nil {
+		fmt.Println("握手发送信息错误 err=", err)
+		return
+	}
+
 	//2. 准备通过conn发送消息给服务
 	var mes message.Message
 	mes.Type = message.LoginMesType
@@ -158,7 +172,7 @@ func (this *UserProcess) Login(userId int, userPwd string) (err error) {
 	tf := &utils.Transfer{
 		Conn : conn,
 	}
-	mes, err = tf.ReadPkg() // mes 就是
+	mes, protocol, err := readReply(tf) // mes 就是 LoginResMes
 	
 	if err != nil {
 		fmt.Println("readPkg(conn) err=", err)
@@ -173,6 +187,8 @@ func (this *UserProcess) Login(userId int, userPwd string) (err error) {
 		CurUser.Conn = conn
 		CurUser.UserId = userId
 		CurUser.UserStatus = message.UserOnline
+		CurUser.Role = loginResMes.Role
+		CurUser.Protocol = protocol
 
 		//fmt.Println("登录成功")
 		//可以显示当前在线用户列表,遍历loginResMes.UsersId
@@ -183,7 +199,6 @@ func (this *UserProcess) Login(userId int, userPwd string) (err error) {
 				continue
 			}
 
-			fmt.Println("用户id:\t", v)
 			//完成 客户端的 onlineUsers 完成初始化
 			user := &message.User{
 				UserId : v,
@@ -191,17 +206,27 @@ func (this *UserProcess) Login(userId int, userPwd string) (err error) {
 			}
 			onlineUsers[v]= user
 		}
+		//新的服务器会同时返回每个用户的状态
+		for _, u := range loginResMes.Users {
+			if user, ok := onlineUsers[u.UserId]; ok {
+				user.UserStatus = u.UserStatus
+				user.StatusText = u.StatusText
+			}
+		}
+		outputOnlineUser()
 		fmt.Print("\n\n")
 
 		//这里我们还需要在客户端启动一个协程
 		//该协程保持和服务器端的通讯.如果服务器有数据推送给客户端
 		//则接收并显示在客户端的终端.
 		go serverProcessMes(conn)
+		//端到端加密用的密钥
+		initKey()
+		//提醒设置保存在服务器
+		RequestNotifySettings()
 
-		//1. 显示我们的登录成功的菜单[循环]..
-		for {
-			ShowMenu()
-		}
+		//1. 读取用户输入的命令和聊天内容[循环], 原来的菜单可以用 /menu 打开
+		RunCommandLoop()
 		
 	} else  {
 		fmt.Println(loginResMes.Error)
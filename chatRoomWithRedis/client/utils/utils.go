@@ -5,13 +5,40 @@ import (
 	"go_code/chatroom/common/message"
 	"encoding/binary"
 	"encoding/json"
+	"errors"
+	"io"
+	"sync"
+	"go_code/chatroom/common/compress"
 )
 
+var (
+	ERROR_PKG_TOO_LARGE = errors.New("数据包太大")
+	ERROR_NOT_NEGOTIATED = errors.New("收到压缩的数据包, 但是握手时没有协商压缩")
+)
+
+//握手时协商了压缩的连接
+var compressConns sync.Map
+
+//握手成功后调用, 之后发出的大包会被压缩, 也可以接收压缩的包
+func EnableCompression(conn net.Conn) {
+	compressConns.Store(conn, true)
+}
+
+//连接断开后删除
+func DisableCompression(conn net.Conn) {
+	compressConns.Delete(conn)
+}
+
+func CompressionEnabled(conn net.Conn) bool {
+	_, ok := compressConns.Load(conn)
+	return ok
+}
+
 //这里将这些方法关联到结构体中
 type Transfer struct {
 	//分析它应该有哪些字段
 	Conn net.Conn
-	Buf [8096]byte //这时传输时，使用缓冲
+	Buf []byte //这时传输时，使用缓冲, 按需要增长, 最大 message.MaxPkgSize
 }
 
 
@@ -21,23 +48,48 @@ func (this *Transfer) ReadPkg() (mes message.Message, err error) {
 	fmt.Println("读取客户端发送的数据...")
 	//conn.Read 在conn没有被关闭的情况下，才会阻塞
 	//如果客户端关闭了 conn 则，就不会阻塞
-	_, err = this.Conn.Read(this.Buf[:4])
+	//一次Read不一定能读满，这里用io.ReadFull保证读到完整的包
+	var header [4]byte
+	_, err = io.ReadFull(this.Conn, header[:])
 	if err != nil {
 		//err = errors.New("read pkg header error")
 		return
 	}
 	//根据buf[:4] 转成一个 uint32类型
 	var pkgLen uint32
-	pkgLen = binary.BigEndian.Uint32(this.Buf[0:4])
+	pkgLen = binary.BigEndian.Uint32(header[:])
+	//最高位表示内容被压缩过
+	compressed := pkgLen&compress.FlagCompressed != 0
+	pkgLen &^= compress.FlagCompressed
+	//包的长度不能超过限制，否则直接报错，而不是分配很大的内存
+	if pkgLen > message.MaxPkgSize {
+		err = ERROR_PKG_TOO_LARGE
+		return
+	}
+	if uint32(len(this.Buf)) < pkgLen {
+		this.Buf = make([]byte, pkgLen)
+	}
 	//根据 pkgLen 读取消息内容
-	n, err := this.Conn.Read(this.Buf[:pkgLen])
-	if n != int(pkgLen) || err != nil {
+	_, err = io.ReadFull(this.Conn, this.Buf[:pkgLen])
+	if err != nil {
 		//err = errors.New("read pkg body error")
 		return 
 	}
+	data := this.Buf[:pkgLen]
+	if compressed {
+		if !CompressionEnabled(this.Conn) {
+			err = ERROR_NOT_NEGOTIATED
+			return
+		}
+		//解压后也不能超过限制
+		data, err = compress.Decompress(data, message.MaxPkgSize)
+		if err != nil {
+			return
+		}
+	}
 	//把pkgLen 反序列化成 -> message.Message
 	// 技术就是一层窗户纸 &mes！！
-	err = json.Unmarshal(this.Buf[:pkgLen], &mes)
+	err = json.Unmarshal(data, &mes)
 	if err != nil {
 		fmt.Println("json.Unmarsha err=", err)
 		return 
@@ -51,20 +103,45 @@ func (this *Transfer) WritePkg(data []byte) (err error) {
 	//先发送一个长度给对方
 	var pkgLen uint32
 	pkgLen = uint32(len(data)) 
-	//var buf [4]byte
-	binary.BigEndian.PutUint32(this.Buf[0:4], pkgLen)
-	// 发送长度
-	n, err := this.Conn.Write(this.Buf[:4])
-	if n != 4 || err != nil {
+	if len(data) > message.MaxPkgSize {
+		err = ERROR_PKG_TOO_LARGE
+		return
+	}
+	//协商了压缩时, 大包压缩后再发送
+	if CompressionEnabled(this.Conn) {
+		if res, ok := compress.Compress(data); ok {
+			data = res
+			pkgLen = uint32(len(data)) | compress.FlagCompressed
+		}
+	}
+	//长度和data本身放在一次Write中发送
+	//多个协程同时往一个conn写数据时，包就不会交错在一起
+	buf := make([]byte, 4+len(data))
+	binary.BigEndian.PutUint32(buf[0:4], pkgLen)
+	copy(buf[4:], data)
+	n, err := this.Conn.Write(buf)
+	if n != len(buf) || err != nil {
 		fmt.Println("conn.Write(bytes) fail", err)
 		return 
 	}
+	return 
+}
 
-	//发送data本身
-	n, err = this.Conn.Write(data)
-	if n != int(pkgLen) || err != nil {
-		fmt.Println("conn.Write(bytes) fail", err)
+//把一个具体的消息体序列化，并包装成Message发送出去
+func (this *Transfer) WriteMes(mesType string, v interface{}) (err error) {
+
+	data, err := json.Marshal(v)
+	if err != nil {
+		fmt.Println("json.Marshal err=", err)
 		return 
 	}
-	return 
+	var mes message.Message
+	mes.Type = mesType
+	mes.Data = string(data)
+	data, err = json.Marshal(mes)
+	if err != nil {
+		fmt.Println("json.Marshal err=", err)
+		return 
+	}
+	return this.WritePkg(data)
 }
\ No newline at end of file
@@ -0,0 +1,66 @@
+package compress
+
+import (
+	"bytes"
+	"compress/flate"
+	"errors"
+	"io"
+	"sync"
+)
+
+//数据包的压缩, 客户端和服务器共用
+//数据包的格式是 4字节长度 + 内容, 长度不会超过 message.MaxPkgSize,
+//所以用长度的最高位表示内容是否被压缩, 没有协商压缩的老客户端不会设置这一位
+//握手时协商了压缩以后, 超过Threshold 的包才压缩, 压缩后没有变小的包原样发送
+
+const (
+	//数据包长度中表示压缩的位
+	FlagCompressed = 1 << 31
+	//超过这个字节数的包才压缩, 小包压缩的收益不够抵消cpu的开销
+	Threshold = 1024
+)
+
+var (
+	ERROR_TOO_LARGE = errors.New("解压后的数据包太大")
+)
+
+//flate.NewWriter 需要分配比较大的内存, 这里复用
+var writers = sync.Pool{
+	New: func() interface{} {
+		w, _ := flate.NewWriter(nil, flate.BestSpeed)
+		return w
+	},
+}
+
+//压缩data, 没有超过Threshold 或者压缩后没有变小时ok为false, 应该发送原来的data
+func Compress(data []byte) (res []byte, ok bool) {
+	if len(data) < Threshold {
+		return data, false
+	}
+	var buf bytes.Buffer
+	w := writers.Get().(*flate.Writer)
+	defer writers.Put(w)
+	w.Reset(&buf)
+	_, err := w.Write(data)
+	if err == nil {
+		err = w.Close()
+	}
+	if err != nil || buf.Len() >= len(data) {
+		return data, false
+	}
+	return buf.Bytes(), true
+}
+
+//解压data, 解压后超过limit个字节时返回ERROR_TOO_LARGE, 防止很小的包解压出很大的数据
+func Decompress(data []byte, limit int) (res []byte, err error) {
+	r := flate.NewReader(bytes.NewReader(data))
+	defer r.Close()
+	res, err = io.ReadAll(io.LimitReader(r, int64(limit)+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(res) > limit {
+		return nil, ERROR_TOO_LARGE
+	}
+	return
+}
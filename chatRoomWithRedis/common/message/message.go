@@ -7,6 +7,44 @@ const (
 	RegisterResMesType 		= "RegisterResMes"
 	NotifyUserStatusMesType = "NotifyUserStatusMes"
 	SmsMesType				= "SmsMes"
+	FileOfferMesType		= "FileOfferMes"
+	FileOfferResMesType		= "FileOfferResMes"
+	FileAnswerMesType		= "FileAnswerMes"
+	FileChunkMesType		= "FileChunkMes"
+	FileAckMesType			= "FileAckMes"
+	FileListMesType			= "FileListMes"
+	FileListResMesType		= "FileListResMes"
+	FileFetchMesType		= "FileFetchMes"
+	SmsResMesType			= "SmsResMes"
+	DeliveredMesType		= "DeliveredMes"
+	ReadMesType				= "ReadMes"
+	SetStatusMesType		= "SetStatusMes"
+	TypingMesType			= "TypingMes"
+	ModerateMesType			= "ModerateMes"
+	ModerateResMesType		= "ModerateResMes"
+	JoinRoomMesType			= "JoinRoomMes"
+	JoinRoomResMesType		= "JoinRoomResMes"
+	HistoryMesType			= "HistoryMes"
+	HistoryResMesType		= "HistoryResMes"
+	PublishKeyMesType		= "PublishKeyMes"
+	PublishKeyResMesType	= "PublishKeyResMes"
+	GetKeyMesType			= "GetKeyMes"
+	GetKeyResMesType		= "GetKeyResMes"
+	EditMesType				= "EditMes"
+	DeleteMesType			= "DeleteMes"
+	ReactMesType			= "ReactMes"
+	MesActionResMesType		= "MesActionResMes"
+	MentionMesType			= "MentionMes"
+	GetNotifySettingsMesType	= "GetNotifySettingsMes"
+	SetNotifySettingsMesType	= "SetNotifySettingsMes"
+	NotifySettingsResMesType	= "NotifySettingsResMes"
+	SearchMesType			= "SearchMes"
+	SearchResMesType		= "SearchResMes"
+	HelloMesType			= "HelloMes"
+	HelloResMesType			= "HelloResMes"
+	ScheduleMesType			= "ScheduleMes"
+	ScheduleResMesType		= "ScheduleResMes"
+	ReminderMesType			= "ReminderMes"
 )
 
 //这里我们定义几个用户状态的常量
@@ -14,8 +52,31 @@ const (
 	UserOnline = iota
 	UserOffline 
 	UserBusyStatus 
+	UserAway       //离开, 一段时间没有操作后服务器会自动设置
+	UserInvisible  //隐身, 其他用户看到的是离线
 )
 
+//用户状态对应的显示
+func StatusText(status int) string {
+	switch status {
+		case UserOnline:
+			return "在线"
+		case UserOffline:
+			return "离线"
+		case UserBusyStatus:
+			return "忙碌"
+		case UserAway:
+			return "离开"
+		case UserInvisible:
+			return "隐身"
+	}
+	return "未知"
+}
+
+//一个数据包(不包括4字节的长度)最大的字节数
+//登录结果中带有所有的在线用户, 在线用户多的时候包会比较大
+const MaxPkgSize = 1024 * 1024
+
 type Message struct {
 	Type string `json:"type"`  //消息类型
 	Data string `json:"data"` //消息的类型
@@ -34,6 +95,8 @@ type LoginMes struct {
 type LoginResMes struct {
 	Code int  `json:"code"` // 返回状态码 500 表示该用户未注册 200表示登录成功
 	UsersId []int			// 增加字段，保存用户id的切片
+	Users []User `json:"users"` // 在线用户及其状态
+	Role int `json:"role"` // 当前用户的角色
 	Error string `json:"error"` // 返回错误信息
 }
 
@@ -49,12 +112,370 @@ type RegisterResMes struct {
 type NotifyUserStatusMes struct {
 	UserId int `json:"userId"` //用户id
 	Status int `json:"status"` //用户的状态
+	StatusText string `json:"statusText"` //自定义的状态说明
+}
+
+//客户端设置自己的状态
+type SetStatusMes struct {
+	Status int `json:"status"` //UserOnline UserBusyStatus UserAway UserInvisible
+	StatusText string `json:"statusText"` //自定义的状态说明，可以为空
+}
+
+//正在输入, ToUserId 为 0 表示在群聊中输入
+type TypingMes struct {
+	UserId int `json:"userId"` //由服务器填写
+	ToUserId int `json:"toUserId"`
+}
+
+//客户端最快多久发送一次TypingMes, 服务器也按这个间隔限流
+const TypingInterval = 3 //秒
+
+//管理操作
+const (
+	ModerateMute   = "mute"   //禁言, Duration秒后自动解除
+	ModerateUnmute = "unmute" //解除禁言
+	ModerateKick   = "kick"   //踢下线
+	ModerateBan    = "ban"    //按用户id或ip封禁, Duration为0表示永久
+	ModerateUnban  = "unban"  //解除封禁
+	ModerateRole   = "role"   //设置用户的角色，只有管理员可以操作
+)
+
+//版主和管理员发起的管理操作
+//服务器执行后，也会把这个消息发给被操作的用户，让他知道发生了什么
+type ModerateMes struct {
+	Action string `json:"action"`
+	UserId int `json:"userId"` //被操作的用户
+	Ip string `json:"ip"` //封禁ip时使用
+	Duration int `json:"duration"` //持续时间, 秒
+	Reason string `json:"reason"`
+	Role int `json:"role"` //设置角色时使用
+	OperatorId int `json:"operatorId"` //操作人, 由服务器填写
+}
+
+type ModerateResMes struct {
+	Code int `json:"code"` // 200 表示成功 401 表示没有权限 400 表示参数错误 500 表示用户不存在
+	Error string `json:"error"`
 }
 
+//消息的投递状态, 只有私聊消息才有送达和已读
+const (
+	MesSent = iota //服务器已收到
+	MesDelivered   //对方客户端已收到
+	MesRead        //对方已经看过
+)
+
 //增加一个SmsMes //发送的消息
 type SmsMes struct {
 	Content string `json:"content"` //内容
 	User //匿名结构体，继承
+	MesId int `json:"mesId"` //消息id, 由服务器分配
+	LocalId int `json:"localId"` //客户端自己的编号，服务器在SmsResMes中原样返回
+	ToUserId int `json:"toUserId"` //私聊的对象, 0 表示群聊
+	RoomId string `json:"roomId,omitempty"` //群聊所在的房间, 空表示大厅, 由服务器填写
+	SendTime int64 `json:"sendTime"` //服务器收到消息的时间, unix秒
+	MesStatus int `json:"mesStatus"` //投递状态
+	E2E *E2EInfo `json:"e2e,omitempty"` //不为空时Content是端到端加密后的密文, base64编码
+	EditTime int64 `json:"editTime,omitempty"` //最后一次修改的时间, 0 表示没有修改过
+	Deleted bool `json:"deleted,omitempty"` //已被删除, Content 为空
+	Reactions map[string][]int `json:"reactions,omitempty"` //表情 -> 回应的用户id
+}
+
+//端到端加密的私聊消息, 服务器只转发密文
+//发送方和接收方用自己的私钥和对方的公钥算出同一个密钥, 所以双方都能解密
+type E2EInfo struct {
+	SenderKey []byte `json:"senderKey"` //发送方加密时使用的公钥
+	ReceiverKey []byte `json:"receiverKey"` //加密时使用的接收方公钥
+	Nonce []byte `json:"nonce"`
+}
+
+//客户端发布自己的公钥, 服务器保存在用户信息中
+type PublishKeyMes struct {
+	PublicKey []byte `json:"publicKey"`
+}
+
+type PublishKeyResMes struct {
+	Code int `json:"code"` // 200 表示成功 403 表示未登录 400 表示公钥不合法 426 表示握手时没有协商e2e
+	Error string `json:"error"`
+}
+
+//查询某个用户的公钥
+type GetKeyMes struct {
+	UserId int `json:"userId"`
+}
+
+type GetKeyResMes struct {
+	Code int `json:"code"` // 200 表示成功 500 表示用户不存在 404 表示对方还没有发布公钥 426 表示握手时没有协商e2e
+	UserId int `json:"userId"`
+	PublicKey []byte `json:"publicKey"`
+	Error string `json:"error"`
+}
+
+// SmsResMes 服务器收到SmsMes后的回复
+type SmsResMes struct {
+	Code int `json:"code"` // 200 表示成功 403 表示未登录 500 表示私聊对象不存在 426 表示握手时没有协商需要的功能
+	LocalId int `json:"localId"`
+	MesId int `json:"mesId"`
+	SendTime int64 `json:"sendTime"`
+	Error string `json:"error"`
+}
+
+//修改一条消息, 只有发送方和版主可以修改
+//服务器修改成功后, 把这个消息发给能看到这条消息的所有在线用户, 包括修改的人
+type EditMes struct {
+	MesId int `json:"mesId"`
+	Content string `json:"content"`
+	UserId int `json:"userId"` //修改的人, 由服务器填写
+	EditTime int64 `json:"editTime"` //由服务器填写
+}
+
+//删除一条消息, 只有发送方和版主可以删除, 服务器删除后的处理和EditMes一样
+type DeleteMes struct {
+	MesId int `json:"mesId"`
+	UserId int `json:"userId"` //删除的人, 由服务器填写
+}
+
+//用表情回应一条消息, Remove 为true 时取消回应
+type ReactMes struct {
+	MesId int `json:"mesId"`
+	Emoji string `json:"emoji"`
+	Remove bool `json:"remove"`
+	UserId int `json:"userId"` //回应的人, 由服务器填写
+	Reactions map[string][]int `json:"reactions"` //修改后这条消息所有的回应, 由服务器填写
+}
+
+//表情最长的字节数
+const ReactionMaxLen = 32
+
+//EditMes DeleteMes ReactMes 的结果, 成功时客户端还会收到服务器转发的原消息
+type MesActionResMes struct {
+	Type string `json:"type"` //请求的消息类型
+	MesId int `json:"mesId"`
+	Code int `json:"code"` // 200 表示成功 403 表示未登录 401 表示没有权限 407 表示被禁言 400 表示参数不正确 500 表示消息不存在 505 表示服务器错误
+	Error string `json:"error"`
+}
+
+//提醒的原因
+const (
+	MentionAt      = "mention" //消息中 @用户名 或者 @用户id
+	MentionKeyword = "keyword" //消息中包含用户设置的关键词
+)
+
+//群聊消息中有人@了你, 或者包含你设置的关键词, 不管你在哪个房间都会收到
+//被@时如果不在线, 上线后再推送, 关键词只提醒在线的用户
+type MentionMes struct {
+	Reason string `json:"reason"` //MentionAt MentionKeyword
+	Keyword string `json:"keyword,omitempty"` //匹配到的关键词
+	SmsMes SmsMes `json:"smsMes"`
+}
+
+//每个用户的提醒设置, 保存在服务器
+type NotifySettings struct {
+	Keywords []string `json:"keywords"` //群聊消息中包含这些词时提醒, 不区分大小写
+	MutedRooms []string `json:"mutedRooms"` //这些房间中的@和关键词都不提醒, 空字符串表示大厅
+}
+
+const (
+	NotifyMaxKeywords   = 20
+	NotifyKeywordMaxLen = 32
+	NotifyMaxMutedRooms = 50
+)
+
+//查询自己的提醒设置
+type GetNotifySettingsMes struct {
+}
+
+//修改提醒设置, 用Settings替换原来的设置
+type SetNotifySettingsMes struct {
+	Settings NotifySettings `json:"settings"`
+}
+
+//GetNotifySettingsMes 和 SetNotifySettingsMes 的结果
+type NotifySettingsResMes struct {
+	Code int `json:"code"` // 200 表示成功 403 表示未登录 400 表示设置不合法 505 表示服务器错误
+	Settings NotifySettings `json:"settings"` //当前的设置
+	Error string `json:"error"`
+}
+
+//进入一个房间, 之后的群聊消息只发给同一个房间的用户
+//每个用户同一时间只在一个房间中, RoomId 为空表示回到大厅
+type JoinRoomMes struct {
+	RoomId string `json:"roomId"`
+}
+
+type JoinRoomResMes struct {
+	Code int `json:"code"` // 200 表示成功 403 表示未登录 400 表示房间名不合法 426 表示握手时没有协商rooms
+	RoomId string `json:"roomId"`
+	UsersId []int `json:"usersId"` //房间中的在线用户
+	Error string `json:"error"`
+}
+
+//查询最近的聊天记录, ToUserId 不为0时查询和该用户的私聊, 否则查询RoomId房间的群聊
+type HistoryMes struct {
+	RoomId string `json:"roomId"`
+	ToUserId int `json:"toUserId"`
+	Count int `json:"count"` //最多返回多少条, 服务器限制在HistoryMaxCount以内
+}
+
+//聊天记录可能很多, 服务器会分成多个HistoryResMes返回, 最后一个的Last为true
+type HistoryResMes struct {
+	Code int `json:"code"` // 200 表示成功 403 表示未登录 401 表示没有进入过这个房间 505 表示服务器错误 426 表示握手时没有协商rooms
+	RoomId string `json:"roomId"`
+	ToUserId int `json:"toUserId"`
+	Messages []SmsMes `json:"messages"` //按时间从早到晚
+	Last bool `json:"last"`
+	Error string `json:"error"`
+}
+
+const (
+	HistoryDefaultCount = 20
+	HistoryMaxCount     = 200
+)
+
+//搜索聊天记录, 只能搜到自己进入过的房间, 大厅, 和自己参与的私聊
+type SearchMes struct {
+	Query string `json:"query"` //多个词时消息要包含所有的词, 不区分大小写
+	InRoom bool `json:"inRoom"` //只搜索RoomId这个房间, RoomId为空表示大厅
+	RoomId string `json:"roomId"`
+	ToUserId int `json:"toUserId"` //只搜索和这个用户的私聊, 0表示不限
+	FromUserId int `json:"fromUserId"` //只搜索这个用户发的消息, 0表示不限
+	Since int64 `json:"since"` //发送时间的范围(unix秒), 0表示不限
+	Until int64 `json:"until"`
+	BeforeId int `json:"beforeId"` //翻页用, 只返回mesId小于它的消息, 0表示从最新的开始
+	Count int `json:"count"` //最多返回多少条, 服务器限制在SearchMaxCount以内
+}
+
+type SearchResMes struct {
+	Code int `json:"code"` // 200 表示成功 403 表示未登录 400 表示没有可以搜索的词 401 表示没有进入过这个房间 505 表示服务器错误 426 表示握手时没有协商rooms
+	Messages []SmsMes `json:"messages"` //按时间从晚到早
+	NextBeforeId int `json:"nextBeforeId"` //下一页的BeforeId, 0表示没有更多了
+	Error string `json:"error"`
+}
+
+const (
+	SearchDefaultCount = 20
+	SearchMaxCount     = 100
+)
+
+//定时任务的种类
+const (
+	ScheduleSend   = iota //到时间发送一条消息
+	ScheduleRemind        //到时间提醒自己
+)
+
+//定时发送消息, 或者让服务器到时间提醒自己
+//CancelId 不为0时取消自己创建的这个定时任务, 其它字段不用填
+type ScheduleMes struct {
+	Kind int `json:"kind"` //ScheduleSend ScheduleRemind
+	Content string `json:"content"`
+	ToUserId int `json:"toUserId"` //定时发送私聊消息的对象, 0 表示群聊
+	RoomId string `json:"roomId,omitempty"` //定时发送群聊消息的房间, 空表示大厅
+	SendAt int64 `json:"sendAt"` //发送或者提醒的时间, unix秒
+	CancelId int `json:"cancelId,omitempty"`
+}
+
+type ScheduleResMes struct {
+	Code int `json:"code"` // 200 表示成功 403 表示未登录 400 表示参数不合法 404 表示要取消的任务不存在 500 表示接收方不存在 505 表示服务器错误 426 表示握手时没有协商rooms
+	Id int `json:"id"` //定时任务的id, 取消时用
+	SendAt int64 `json:"sendAt"`
+	Cancelled bool `json:"cancelled"` //是取消任务的结果
+	Error string `json:"error"`
+}
+
+const (
+	ScheduleMaxAhead = 30 * 24 * 3600 //最多提前多少秒创建定时任务
+	ScheduleMaxPending = 100 //每个用户最多有多少个还没有到时间的定时任务
+)
+
+//到时间的提醒, 用户不在线时等他上线后再推送
+type ReminderMes struct {
+	Id int `json:"id"`
+	Content string `json:"content"`
+	SendAt int64 `json:"sendAt"`
+}
+
+//接收方客户端收到私聊消息后的确认
+type DeliveredMes struct {
+	MesIds []int `json:"mesIds"`
+	UserId int `json:"userId"` //接收方, 由服务器填写
+}
+
+//接收方看过私聊消息后的回执
+type ReadMes struct {
+	MesIds []int `json:"mesIds"`
+	UserId int `json:"userId"` //接收方, 由服务器填写
+}
+
+//文件传输时，每个FileChunkMes携带的最大字节数
+//分片不能太大，不能长时间占用连接，避免聊天消息被饿死
+const (
+	FileChunkSize  = 3 * 1024
+	FileWindowSize = 8                 //发送方最多允许多少个未确认的分片
+	FileMaxSize    = 100 * 1024 * 1024 //单个文件最大100M
+)
+
+//文件的状态
+const (
+	FileOffered = iota //发送方已发起，等待接收方确认
+	FileAccepted       //接收方同意接收
+	FileRejected       //接收方拒绝
+	FileStored         //文件已经完整保存在服务器端
+	FileUploading      //接收方不在线或者转发失败，发送方正在上传到服务器，传完后变成FileStored
+)
+
+//文件的基本信息, FileId 由服务器分配
+type FileInfo struct {
+	FileId     int    `json:"fileId"`
+	FromUserId int    `json:"fromUserId"` //发送方
+	ToUserId   int    `json:"toUserId"`   //接收方
+	FileName   string `json:"fileName"`
+	FileSize   int64  `json:"fileSize"`
+	FileHash   string `json:"fileHash"` //sha256, 十六进制
+	Status     int    `json:"status"`
+}
+
+//发送方发起文件传输，服务器分配FileId后再转发给接收方
+type FileOfferMes struct {
+	FileInfo
+}
+
+type FileOfferResMes struct {
+	Code     int    `json:"code"` // 200 表示成功 500 表示接收方不存在 400 表示文件信息不合法
+	FileInfo                      // 回填了FileId的文件信息
+	Offline  bool   `json:"offline"` // 接收方不在线, 文件会先保存在服务器端
+	Error    string `json:"error"`
+}
+
+//接收方同意或拒绝
+type FileAnswerMes struct {
+	FileId int  `json:"fileId"`
+	Accept bool `json:"accept"`
+}
+
+//文件分片, Data 在json中会被编码成base64
+type FileChunkMes struct {
+	FileId int    `json:"fileId"`
+	Seq    int    `json:"seq"` //分片序号，从0开始
+	Data   []byte `json:"data"`
+	Last   bool   `json:"last"` //是否是最后一个分片
 }
 
-// SmsReMes
\ No newline at end of file
+//收到分片后的确认，用来做流量控制
+type FileAckMes struct {
+	FileId int    `json:"fileId"`
+	Seq    int    `json:"seq"`
+	Code   int    `json:"code"` // 200 表示成功
+	Error  string `json:"error"`
+}
+
+//查询自己在服务器端还未取走的文件
+type FileListMes struct {
+}
+
+type FileListResMes struct {
+	Files []FileInfo `json:"files"`
+}
+
+//从服务器端获取离线文件
+type FileFetchMes struct {
+	FileId int `json:"fileId"`
+}
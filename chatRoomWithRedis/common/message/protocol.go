@@ -0,0 +1,85 @@
+package message
+
+//协议版本和握手
+//版本1 是最初的协议, 没有握手, 连接后第一条消息就是LoginMes或RegisterMes
+//版本2 开始, 客户端在登录之前先发HelloMes, 和服务器协商版本, 编码, 压缩和功能
+//服务器收到的第一条消息不是HelloMes时, 按版本1 处理, 老的客户端不需要任何修改
+const (
+	ProtocolLegacy     = 1
+	ProtocolVersion    = 2 //当前的版本
+	MinProtocolVersion = 1 //服务器还支持的最老的版本
+)
+
+//数据包的编码, 目前只有json: 4字节长度 + {"type":..,"data":..}
+const (
+	CodecJSON = "json"
+)
+
+//数据包的压缩, 见 common/compress
+const (
+	CompressionNone    = "none"
+	CompressionDeflate = "deflate"
+)
+
+//可选的功能
+const (
+	FeatureE2E   = "e2e"   //端到端加密的私聊
+	FeatureRooms = "rooms" //房间
+)
+
+//客户端在登录之前发送, 列表都按客户端的优先顺序
+type HelloMes struct {
+	Version      int      `json:"version"`      //客户端使用的版本
+	MinVersion   int      `json:"minVersion"`   //客户端还能使用的最老的版本, 0 表示和Version相同
+	Codecs       []string `json:"codecs"`       //为空表示json
+	Compressions []string `json:"compressions"` //为空表示不压缩
+	Features     []string `json:"features"`
+	Client       string   `json:"client"` //客户端的名字和版本, 只用于日志
+}
+
+type HelloResMes struct {
+	Code        int      `json:"code"`    // 200 成功 400 消息不合法或者重复握手 426 版本或编码不兼容, 服务器随后断开连接
+	Version     int      `json:"version"` //协商后的版本
+	Codec       string   `json:"codec"`
+	Compression string   `json:"compression"`
+	Features    []string `json:"features"` //双方都支持的功能
+	Error       string   `json:"error"`
+}
+
+//一个连接协商后的协议
+type Protocol struct {
+	Version     int
+	Codec       string
+	Compression string
+	Features    []string
+}
+
+//没有握手的老客户端
+func LegacyProtocol() *Protocol {
+	return &Protocol{
+		Version:     ProtocolLegacy,
+		Codec:       CodecJSON,
+		Compression: CompressionNone,
+		Features:    []string{FeatureE2E, FeatureRooms},
+	}
+}
+
+//是否支持某个功能
+func (this *Protocol) Has(feature string) bool {
+	for _, f := range this.Features {
+		if f == feature {
+			return true
+		}
+	}
+	return false
+}
+
+//从HelloResMes得到协商后的协议
+func (this *HelloResMes) Protocol() *Protocol {
+	return &Protocol{
+		Version:     this.Version,
+		Codec:       this.Codec,
+		Compression: this.Compression,
+		Features:    this.Features,
+	}
+}
@@ -1,5 +1,12 @@
 package message
 
+//用户的角色
+const (
+	RoleUser = iota //普通用户
+	RoleModerator   //版主, 可以禁言, 踢人, 封禁
+	RoleAdmin       //管理员, 还可以设置别人的角色
+)
+
 //定义一个用户的结构体
 
 type User struct {
@@ -11,4 +18,8 @@ type User struct {
 	UserName string `json:"userName"`
 	UserStatus int `json:"userStatus"` //用户状态..
 	Sex string `json:"sex"` //性别.
+	StatusText string `json:"statusText,omitempty"` //自定义的状态说明
+	Role int `json:"role"` //角色: RoleUser RoleModerator RoleAdmin
+	PublicKey []byte `json:"publicKey,omitempty"` //端到端加密用的公钥, X25519
+	Bot bool `json:"bot,omitempty"` //插件创建的机器人, 不能登录
 }
\ No newline at end of file
@@ -0,0 +1,185 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"go_code/chatroom/common/message"
+	"go_code/chatroom/server/utils"
+)
+
+//消息内容的前缀, 后面是发送时间(unix纳秒), 接收方据此计算延迟
+const contentPrefix = "loadgen "
+
+//一个模拟的客户端
+type simClient struct {
+	UserId int
+	Conn   net.Conn
+	tf     *utils.Transfer
+	//发送和接收在不同的协程中, 写需要加锁
+	writeLock sync.Mutex
+	//等待SmsResMes的消息, LocalId -> 发送时间
+	lock    sync.Mutex
+	pending map[int]time.Time
+	seq     int
+}
+
+//连接服务器并注册登录, 用户已经存在时直接登录
+func connect(addr string, userId int, pwd string) (c *simClient, err error) {
+	start := time.Now()
+	conn, err := net.DialTimeout("tcp", addr, 10*time.Second)
+	if err != nil {
+		atomic.AddInt64(&stats.ConnectErrors, 1)
+		return
+	}
+	c = &simClient{
+		UserId:  userId,
+		Conn:    conn,
+		tf:      &utils.Transfer{Conn: conn},
+		pending: make(map[int]time.Time),
+	}
+	conn.SetReadDeadline(time.Now().Add(30 * time.Second))
+	defer conn.SetReadDeadline(time.Time{})
+
+	code, err := c.register(pwd)
+	// 505 表示用户已经存在, 直接登录
+	if err == nil && (code == 200 || code == 505) {
+		code, err = c.login(pwd)
+	}
+	if err != nil {
+		atomic.AddInt64(&stats.ConnectErrors, 1)
+	} else if code != 200 {
+		stats.LoginErrors.Inc(code)
+		err = fmt.Errorf("用户%d 注册或登录失败 code=%d", userId, code)
+	}
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	stats.Connect.Add(time.Since(start))
+	return
+}
+
+func (this *simClient) register(pwd string) (code int, err error) {
+	err = this.tf.WriteMes(message.RegisterMesType, message.RegisterMes{
+		User: message.User{
+			UserId:   this.UserId,
+			UserPwd:  pwd,
+			UserName: fmt.Sprintf("load%d", this.UserId),
+		},
+	})
+	if err != nil {
+		return
+	}
+	var registerResMes message.RegisterResMes
+	err = this.readUntil(message.RegisterResMesType, &registerResMes)
+	return registerResMes.Code, err
+}
+
+func (this *simClient) login(pwd string) (code int, err error) {
+	err = this.tf.WriteMes(message.LoginMesType, message.LoginMes{
+		UserId:  this.UserId,
+		UserPwd: pwd,
+	})
+	if err != nil {
+		return
+	}
+	var loginResMes message.LoginResMes
+	err = this.readUntil(message.LoginResMesType, &loginResMes)
+	return loginResMes.Code, err
+}
+
+//读取消息直到收到mesType类型的消息, 只在登录前使用
+func (this *simClient) readUntil(mesType string, v interface{}) (err error) {
+	for {
+		mes, err := this.tf.ReadPkg()
+		if err != nil {
+			return err
+		}
+		if mes.Type == mesType {
+			return json.Unmarshal([]byte(mes.Data), v)
+		}
+	}
+}
+
+//进入房间, 不等待结果, 结果由 readLoop 忽略
+func (this *simClient) joinRoom(roomId string) error {
+	this.writeLock.Lock()
+	defer this.writeLock.Unlock()
+	return this.tf.WriteMes(message.JoinRoomMesType, message.JoinRoomMes{RoomId: roomId})
+}
+
+//发送一条聊天消息, toUserId 为 0 表示群聊
+func (this *simClient) send(toUserId int) (err error) {
+	now := time.Now()
+	this.lock.Lock()
+	this.seq++
+	localId := this.seq
+	this.pending[localId] = now
+	this.lock.Unlock()
+
+	smsMes := message.SmsMes{
+		Content:  contentPrefix + strconv.FormatInt(now.UnixNano(), 10),
+		LocalId:  localId,
+		ToUserId: toUserId,
+	}
+	this.writeLock.Lock()
+	err = this.tf.WriteMes(message.SmsMesType, smsMes)
+	this.writeLock.Unlock()
+	if err != nil {
+		this.lock.Lock()
+		delete(this.pending, localId)
+		this.lock.Unlock()
+	}
+	return
+}
+
+//读取服务器推送的消息并统计, 连接断开后返回
+//用单独的Transfer, 避免和登录时使用的缓冲冲突
+func (this *simClient) readLoop() {
+	tf := &utils.Transfer{Conn: this.Conn}
+	for {
+		mes, err := tf.ReadPkg()
+		if err != nil {
+			return
+		}
+		switch mes.Type {
+			case message.SmsResMesType:
+				var smsResMes message.SmsResMes
+				if json.Unmarshal([]byte(mes.Data), &smsResMes) != nil {
+					continue
+				}
+				this.lock.Lock()
+				sendTime, ok := this.pending[smsResMes.LocalId]
+				delete(this.pending, smsResMes.LocalId)
+				this.lock.Unlock()
+				if smsResMes.Code != 200 {
+					stats.ServerErrors.Inc(smsResMes.Code)
+					continue
+				}
+				atomic.AddInt64(&stats.Acked, 1)
+				if ok {
+					stats.Ack.Add(time.Since(sendTime))
+				}
+			case message.SmsMesType:
+				var smsMes message.SmsMes
+				if json.Unmarshal([]byte(mes.Data), &smsMes) != nil {
+					continue
+				}
+				if !strings.HasPrefix(smsMes.Content, contentPrefix) {
+					continue
+				}
+				nanos, err := strconv.ParseInt(smsMes.Content[len(contentPrefix):], 10, 64)
+				if err != nil {
+					continue
+				}
+				stats.Delivery.Add(time.Since(time.Unix(0, nanos)))
+		}
+	}
+}
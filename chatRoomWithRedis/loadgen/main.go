@@ -0,0 +1,187 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"math/rand"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"go_code/chatroom/server/chatserver"
+	"go_code/chatroom/server/logger"
+	"go_code/chatroom/server/memredis"
+)
+
+//压力测试工具
+//启动N个模拟的客户端, 按聊天协议注册登录, 然后按指定的速率发送消息
+//最后输出连接成功率, 消息延迟的分位数, 吞吐量和服务器返回的错误
+//例子:
+//	go run go_code/chatroom/loadgen -embed -users 200 -rate 500 -duration 30s
+//	go run go_code/chatroom/loadgen -addr 127.0.0.1:8889 -mode private
+
+var (
+	addr        = flag.String("addr", "127.0.0.1:8889", "服务器的地址")
+	embed       = flag.Bool("embed", false, "在进程内启动一个使用内存数据的服务器, 忽略 -addr")
+	users       = flag.Int("users", 100, "模拟的用户数")
+	rate        = flag.Float64("rate", 100, "所有用户每秒一共发送多少条消息")
+	duration    = flag.Duration("duration", 10*time.Second, "发送消息的时间")
+	mode        = flag.String("mode", "group", "group: 群聊, 每条消息发给房间中的所有人; private: 私聊, 随机发给一个用户")
+	room        = flag.String("room", "", "群聊时进入的房间, 为空表示大厅")
+	idBase      = flag.Int("id-base", 100000, "模拟用户的id从这里开始")
+	pwd         = flag.String("pwd", "loadgen", "模拟用户的密码")
+	concurrency = flag.Int("concurrency", 50, "同时进行注册登录的用户数")
+	drain       = flag.Duration("drain", 2*time.Second, "停止发送后等待消息送达的时间")
+)
+
+//统计数据
+var stats struct {
+	ConnectErrors int64 //网络错误, 原子操作
+	LoginErrors   codeCounter
+	Sent          int64
+	SendErrors    int64
+	Acked         int64 //服务器返回200的消息数
+	ServerErrors  codeCounter
+	Connect       latencies //连接加注册登录的耗时
+	Ack           latencies //发送到收到SmsResMes的耗时
+	Delivery      latencies //发送到另一个客户端收到的耗时
+}
+
+func main() {
+	flag.Parse()
+	if *users < 2 || *rate <= 0 {
+		fmt.Println("users 至少为2, rate 必须大于0")
+		os.Exit(2)
+	}
+	if *mode != "group" && *mode != "private" {
+		fmt.Println("不支持的 mode:", *mode)
+		os.Exit(2)
+	}
+
+	target := *addr
+	if *embed {
+		logger.Init("error", "text")
+		server := chatserver.NewServer(memredis.NewStore().NewPool(), os.TempDir())
+		err := server.Listen("127.0.0.1:0")
+		if err != nil {
+			fmt.Println("启动服务器失败 err=", err)
+			os.Exit(1)
+		}
+		go server.Serve()
+		defer server.Close()
+		target = server.Addr().String()
+	}
+
+	fmt.Printf("连接 %s, %d 个用户...\n", target, *users)
+	start := time.Now()
+	clients := connectAll(target)
+	connectTime := time.Since(start)
+	if len(clients) < 2 {
+		report(clients, connectTime, 0)
+		os.Exit(1)
+	}
+	for _, c := range clients {
+		if *mode == "group" && *room != "" {
+			c.joinRoom(*room)
+		}
+		go c.readLoop()
+	}
+
+	fmt.Printf("发送消息 %v, 每秒 %v 条...\n", *duration, *rate)
+	start = time.Now()
+	sendLoop(clients)
+	sendTime := time.Since(start)
+	time.Sleep(*drain)
+	for _, c := range clients {
+		c.Conn.Close()
+	}
+	report(clients, connectTime, sendTime)
+}
+
+//并发地连接所有的用户, 返回成功的客户端
+func connectAll(target string) (clients []*simClient) {
+	var lock sync.Mutex
+	var wg sync.WaitGroup
+	ids := make(chan int)
+	for i := 0; i < *concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for userId := range ids {
+				c, err := connect(target, userId, *pwd)
+				if err != nil {
+					continue
+				}
+				lock.Lock()
+				clients = append(clients, c)
+				lock.Unlock()
+			}
+		}()
+	}
+	for i := 0; i < *users; i++ {
+		ids <- *idBase + i
+	}
+	close(ids)
+	wg.Wait()
+	return
+}
+
+//按速率轮流让每个用户发送消息, 直到时间结束
+func sendLoop(clients []*simClient) {
+	interval := time.Duration(float64(time.Second) / *rate)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	deadline := time.After(*duration)
+	for i := 0; ; i++ {
+		select {
+			case <-deadline:
+				return
+			case <-ticker.C:
+		}
+		c := clients[i%len(clients)]
+		toUserId := 0
+		if *mode == "private" {
+			//随机选一个别的用户
+			other := clients[(i+1+rand.Intn(len(clients)-1))%len(clients)]
+			toUserId = other.UserId
+		}
+		atomic.AddInt64(&stats.Sent, 1)
+		if c.send(toUserId) != nil {
+			atomic.AddInt64(&stats.SendErrors, 1)
+		}
+	}
+}
+
+func perSecond(n int64, d time.Duration) float64 {
+	if d <= 0 {
+		return 0
+	}
+	return float64(n) / d.Seconds()
+}
+
+func report(clients []*simClient, connectTime time.Duration, sendTime time.Duration) {
+	fmt.Println("==== 连接 ====")
+	fmt.Printf("模拟用户: %d, 成功: %d, 网络错误: %d, 注册登录失败: %s, 耗时: %v\n",
+		*users, len(clients), atomic.LoadInt64(&stats.ConnectErrors), stats.LoginErrors.String(),
+		connectTime.Round(time.Millisecond))
+	fmt.Printf("连接加登录延迟: %s\n", stats.Connect.Summary())
+	if sendTime == 0 {
+		return
+	}
+
+	sent := atomic.LoadInt64(&stats.Sent)
+	acked := atomic.LoadInt64(&stats.Acked)
+	delivered := int64(stats.Delivery.Count())
+	//群聊时每条消息发给其它所有的模拟用户
+	expected := acked
+	if *mode == "group" {
+		expected = acked * int64(len(clients)-1)
+	}
+	fmt.Println("==== 消息 ====")
+	fmt.Printf("发送: %d (%.1f 条/秒), 发送失败: %d\n", sent, perSecond(sent, sendTime), atomic.LoadInt64(&stats.SendErrors))
+	fmt.Printf("服务器确认: %d, 服务器错误: %s\n", acked, stats.ServerErrors.String())
+	fmt.Printf("确认延迟: %s\n", stats.Ack.Summary())
+	fmt.Printf("送达: %d / 期望 %d (%.1f 条/秒)\n", delivered, expected, perSecond(delivered, sendTime))
+	fmt.Printf("端到端延迟: %s\n", stats.Delivery.Summary())
+}
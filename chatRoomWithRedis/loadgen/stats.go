@@ -0,0 +1,105 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+)
+
+//收集延迟, 结束时计算分位数
+type latencies struct {
+	lock   sync.Mutex
+	values []time.Duration
+}
+
+func (this *latencies) Add(d time.Duration) {
+	this.lock.Lock()
+	this.values = append(this.values, d)
+	this.lock.Unlock()
+}
+
+func (this *latencies) Count() int {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	return len(this.values)
+}
+
+//返回排好序的副本
+func (this *latencies) sorted() []time.Duration {
+	this.lock.Lock()
+	values := append([]time.Duration(nil), this.values...)
+	this.lock.Unlock()
+	sort.Slice(values, func(i, j int) bool {
+		return values[i] < values[j]
+	})
+	return values
+}
+
+//p50 p90 p99 和最大值, 没有数据时输出 -
+func (this *latencies) Summary() string {
+	values := this.sorted()
+	if len(values) == 0 {
+		return "-"
+	}
+	return fmt.Sprintf("p50=%v p90=%v p99=%v max=%v",
+		percentile(values, 0.50), percentile(values, 0.90), percentile(values, 0.99), values[len(values)-1])
+}
+
+//values 必须已经排好序
+func percentile(values []time.Duration, p float64) time.Duration {
+	idx := int(float64(len(values))*p+0.5) - 1
+	if idx < 0 {
+		idx = 0
+	}
+	if idx >= len(values) {
+		idx = len(values) - 1
+	}
+	return values[idx].Round(time.Microsecond)
+}
+
+//按错误码计数
+type codeCounter struct {
+	lock   sync.Mutex
+	counts map[int]int
+}
+
+func (this *codeCounter) Inc(code int) {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	if this.counts == nil {
+		this.counts = make(map[int]int)
+	}
+	this.counts[code]++
+}
+
+func (this *codeCounter) Total() (total int) {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	for _, n := range this.counts {
+		total += n
+	}
+	return
+}
+
+//比如 403x2 505x10, 没有时输出 无
+func (this *codeCounter) String() string {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	if len(this.counts) == 0 {
+		return "无"
+	}
+	codes := make([]int, 0, len(this.counts))
+	for code := range this.counts {
+		codes = append(codes, code)
+	}
+	sort.Ints(codes)
+	s := ""
+	for i, code := range codes {
+		if i > 0 {
+			s += " "
+		}
+		s += fmt.Sprintf("%dx%d", code, this.counts[code])
+	}
+	return s
+}
@@ -0,0 +1,270 @@
+package chatserver
+
+import (
+	"net"
+	"go_code/chatroom/common/message"
+	"go_code/chatroom/server/logger"
+	"go_code/chatroom/server/metrics"
+	"go_code/chatroom/server/plugin"
+	"go_code/chatroom/server/utils"
+	"go_code/chatroom/server/process"
+	"io"
+)
+
+//先创建一个Processor 的结构体体
+type Processor struct {
+	Conn net.Conn
+	//登录成功后，记录该连接对应的用户id
+	UserId int
+	//插件在服务器处理之前先看到每条消息, 可以为nil
+	Plugins *plugin.Manager
+	//握手协商的协议, 收到第一条消息之前为nil
+	Protocol *message.Protocol
+}
+
+//编写一个ServerProcessMes 函数
+//功能：根据客户端发送消息种类不同，决定调用哪个函数来处理
+func (this *Processor) serverProcessMes(mes *message.Message) (err error) {
+
+	metrics.MessagesReceived.Inc(mes.Type)
+	logger.For(this.Conn, this.UserId).Debug("收到消息", "type", mes.Type, "size", len(mes.Data))
+
+	//握手只能是第一条消息, 第一条消息不是握手的是老客户端
+	if mes.Type == message.HelloMesType {
+		return this.serverProcessHello(mes)
+	}
+	if this.Protocol == nil {
+		this.Protocol = process2.LegacyClient(this.Conn)
+	}
+	//没有协商的功能不能使用
+	if feature := process2.RequiredFeature(mes); feature != "" && !this.Protocol.Has(feature) {
+		return process2.RejectFeature(this.Conn, this.UserId, mes, feature)
+	}
+
+	//用户主动的操作，用来判断用户是否离开
+	switch mes.Type {
+		case message.SmsMesType, message.TypingMesType, message.SetStatusMesType,
+			message.ReadMesType, message.FileOfferMesType, message.EditMesType,
+			message.DeleteMesType, message.ReactMesType, message.ScheduleMesType :
+			process2.TouchUser(this.Conn, this.UserId)
+	}
+
+	if this.Plugins != nil {
+		ctx := &plugin.Context{
+			Conn : this.Conn,
+			UserId : this.UserId,
+		}
+		if this.Plugins.OnMessage(ctx, mes) {
+			logger.For(this.Conn, this.UserId).Debug("消息已由插件处理", "type", mes.Type)
+			return
+		}
+	}
+
+	switch mes.Type {
+		case message.LoginMesType :
+		   //处理登录登录
+		   //创建一个UserProcess实例
+			up := &process2.UserProcess{
+				Conn : this.Conn,
+			}
+			err = up.ServerProcessLogin(mes)
+			if err == nil && up.UserId != 0 {
+				this.UserId = up.UserId
+				//登录成功后，告诉用户有哪些离线文件
+				fp := &process2.FileProcess{
+					Conn : this.Conn,
+					UserId : this.UserId,
+				}
+				fp.NotifyPendingFiles()
+				//推送不在线时收到的私聊消息
+				smsProcess := &process2.SmsProcess{
+					Conn : this.Conn,
+					UserId : this.UserId,
+				}
+				smsProcess.SendOfflineMes()
+				smsProcess.SendOfflineMentions()
+				//推送不在线时到时间的提醒
+				scheduleProcess := &process2.ScheduleProcess{
+					Conn : this.Conn,
+					UserId : this.UserId,
+				}
+				scheduleProcess.SendOfflineReminders()
+			}
+		case message.RegisterMesType :
+		   //处理注册
+		   up := &process2.UserProcess{
+				Conn : this.Conn,
+			}
+			err = up.ServerProcessRegister(mes) // type : data
+		case message.SmsMesType :
+			//创建一个SmsProcess实例完成转发群聊或私聊消息.
+			smsProcess := &process2.SmsProcess{
+				Conn : this.Conn,
+				UserId : this.UserId,
+			}
+			err = smsProcess.ServerProcessSms(mes)
+		case message.SetStatusMesType :
+			up := &process2.UserProcess{
+				Conn : this.Conn,
+				UserId : this.UserId,
+			}
+			err = up.ServerProcessSetStatus(mes)
+		case message.TypingMesType :
+			up := &process2.UserProcess{
+				Conn : this.Conn,
+				UserId : this.UserId,
+			}
+			err = up.ServerProcessTyping(mes)
+		case message.JoinRoomMesType :
+			rp := &process2.RoomProcess{
+				Conn : this.Conn,
+				UserId : this.UserId,
+			}
+			err = rp.ServerProcessJoinRoom(mes)
+		case message.HistoryMesType :
+			rp := &process2.RoomProcess{
+				Conn : this.Conn,
+				UserId : this.UserId,
+			}
+			err = rp.ServerProcessHistory(mes)
+		case message.SearchMesType :
+			rp := &process2.RoomProcess{
+				Conn : this.Conn,
+				UserId : this.UserId,
+			}
+			err = rp.ServerProcessSearch(mes)
+		case message.PublishKeyMesType :
+			kp := &process2.KeyProcess{
+				Conn : this.Conn,
+				UserId : this.UserId,
+			}
+			err = kp.ServerProcessPublishKey(mes)
+		case message.GetKeyMesType :
+			kp := &process2.KeyProcess{
+				Conn : this.Conn,
+				UserId : this.UserId,
+			}
+			err = kp.ServerProcessGetKey(mes)
+		case message.ModerateMesType :
+			//版主和管理员的管理操作
+			mp := &process2.ModerateProcess{
+				Conn : this.Conn,
+				UserId : this.UserId,
+			}
+			err = mp.ServerProcessModerate(mes)
+		case message.DeliveredMesType, message.ReadMesType :
+			//送达回执和已读回执
+			smsProcess := &process2.SmsProcess{
+				Conn : this.Conn,
+				UserId : this.UserId,
+			}
+			err = smsProcess.ServerProcessReceipt(mes)
+		case message.GetNotifySettingsMesType, message.SetNotifySettingsMesType :
+			np := &process2.NotifyProcess{
+				Conn : this.Conn,
+				UserId : this.UserId,
+			}
+			err = np.ServerProcessNotifySettings(mes)
+		case message.ScheduleMesType :
+			//定时消息和提醒
+			sp := &process2.ScheduleProcess{
+				Conn : this.Conn,
+				UserId : this.UserId,
+			}
+			err = sp.ServerProcessSchedule(mes)
+		case message.EditMesType, message.DeleteMesType, message.ReactMesType :
+			//修改, 删除和回应消息
+			smsProcess := &process2.SmsProcess{
+				Conn : this.Conn,
+				UserId : this.UserId,
+			}
+			switch mes.Type {
+				case message.EditMesType :
+					err = smsProcess.ServerProcessEdit(mes)
+				case message.DeleteMesType :
+					err = smsProcess.ServerProcessDelete(mes)
+				case message.ReactMesType :
+					err = smsProcess.ServerProcessReact(mes)
+			}
+		case message.FileOfferMesType, message.FileAnswerMesType, message.FileChunkMesType,
+			message.FileAckMesType, message.FileListMesType, message.FileFetchMesType :
+			//文件传输相关的消息
+			err = this.serverProcessFileMes(mes)
+		default :
+			logger.For(this.Conn, this.UserId).Warn("消息类型不存在，无法处理", "type", mes.Type)
+	}
+	return 
+}
+
+func (this *Processor) serverProcessHello(mes *message.Message) (err error) {
+
+	if this.Protocol != nil {
+		return process2.RejectHello(this.Conn)
+	}
+	hp := &process2.HelloProcess{
+		Conn : this.Conn,
+	}
+	//版本不兼容时返回错误, 断开连接
+	this.Protocol, err = hp.ServerProcessHello(mes)
+	return
+}
+
+func (this *Processor) serverProcessFileMes(mes *message.Message) (err error) {
+
+	fp := &process2.FileProcess{
+		Conn : this.Conn,
+		UserId : this.UserId,
+	}
+	switch mes.Type {
+		case message.FileOfferMesType :
+			err = fp.ServerProcessFileOffer(mes)
+		case message.FileAnswerMesType :
+			err = fp.ServerProcessFileAnswer(mes)
+		case message.FileChunkMesType :
+			err = fp.ServerProcessFileChunk(mes)
+		case message.FileAckMesType :
+			err = fp.ServerProcessFileAck(mes)
+		case message.FileListMesType :
+			err = fp.ServerProcessFileList(mes)
+		case message.FileFetchMesType :
+			err = fp.ServerProcessFileFetch(mes)
+	}
+	return
+}
+
+func (this *Processor) process2() (err error) {
+
+	//连接断开后，把该用户从在线列表中删除
+	defer func() {
+		up := &process2.UserProcess{
+			Conn : this.Conn,
+			UserId : this.UserId,
+		}
+		up.ServerProcessLogout()
+	}()
+
+	//循环的客户端发送的信息
+	for {
+		//这里我们将读取数据包，直接封装成一个函数readPkg(), 返回Message, Err
+		//创建一个Transfer 实例完成读包任务
+		tf := &utils.Transfer{
+			Conn : this.Conn,
+		}
+		mes, err := tf.ReadPkg()
+		if err != nil {
+			if err == io.EOF {
+				logger.For(this.Conn, this.UserId).Info("客户端退出，与服务器端的连接断开")
+				return err 
+			} else {
+				logger.For(this.Conn, this.UserId).Warn("readPkg fail", "err", err)
+				return err
+			}
+			
+		}
+		err = this.serverProcessMes(&mes)
+		if err != nil {
+			return err
+		}
+	}
+
+}
\ No newline at end of file
@@ -0,0 +1,152 @@
+package chatserver
+
+import (
+	"errors"
+	"log/slog"
+	"net"
+	"sync"
+
+	"github.com/garyburd/redigo/redis"
+	"go_code/chatroom/server/logger"
+	"go_code/chatroom/server/metrics"
+	"go_code/chatroom/server/model"
+	"go_code/chatroom/server/plugin"
+	"go_code/chatroom/server/process"
+	"go_code/chatroom/server/utils"
+)
+
+//聊天服务器, 负责监听端口, 接受连接, 每个连接启动一个协程处理
+//main 和集成测试都通过它启动服务器, redis的连接池由调用方传入
+//各个Dao和在线用户列表都是全局变量, 所以一个进程中同时只能运行一个Server
+type Server struct {
+	Pool *redis.Pool
+	//离线文件保存的目录
+	FileDir string
+	//启用的插件, 为nil时不使用插件, 需要在Serve之前设置
+	Plugins *plugin.Manager
+	listener net.Listener
+	lock sync.Mutex
+	conns map[net.Conn]bool //当前的客户端连接, Close 时一起关闭
+	closed bool
+}
+
+func NewServer(pool *redis.Pool, fileDir string) (server *Server) {
+	server = &Server{
+		Pool : pool,
+		FileDir : fileDir,
+		conns : make(map[net.Conn]bool),
+	}
+	server.initDao()
+	return
+}
+
+//这里我们编写一个函数，完成对UserDao的初始化任务
+func (this *Server) initDao() {
+	model.MyUserDao = model.NewUserDao(this.Pool)
+	model.MyFileDao = model.NewFileDao(this.Pool, this.FileDir)
+	model.MyMessageDao = model.NewMessageDao(this.Pool)
+	model.MyModerationDao = model.NewModerationDao(this.Pool)
+	model.MyScheduleDao = model.NewScheduleDao(this.Pool)
+}
+
+//监听addr, 端口为0 时使用随机的端口, 用 Addr 取得实际监听的地址
+func (this *Server) Listen(addr string) (err error) {
+	this.listener, err = net.Listen("tcp", addr)
+	return
+}
+
+func (this *Server) Addr() net.Addr {
+	return this.listener.Addr()
+}
+
+//等待客户端连接, 直到 Close 被调用
+//Serve 期间同时处理到期的定时消息和提醒
+func (this *Server) Serve() (err error) {
+	stopScheduler := process2.StartScheduler(process2.ScheduleInterval)
+	defer stopScheduler()
+	for {
+		conn, err := this.listener.Accept()
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return nil
+			}
+			slog.Error("listen.Accept fail", "err", err)
+			continue
+		}
+		connId := logger.Register(conn)
+		slog.Info("客户端已建立连接", "conn", connId, "addr", conn.RemoteAddr().String())
+
+		//被封禁的ip直接断开
+		if banned, _ := model.MyModerationDao.IsIpBanned(process2.ConnIp(conn)); banned {
+			slog.Info("客户端的ip已被封禁", "conn", connId, "addr", conn.RemoteAddr().String())
+			logger.Unregister(conn)
+			conn.Close()
+			continue
+		}
+
+		if !this.addConn(conn) {
+			logger.Unregister(conn)
+			conn.Close()
+			return nil
+		}
+		//一旦链接成功，则启动一个协程和客户端保持通讯。。
+		go this.process(conn)
+	}
+}
+
+//停止监听并断开所有的客户端, 然后关闭插件
+func (this *Server) Close() (err error) {
+	this.lock.Lock()
+	this.closed = true
+	conns := this.conns
+	this.conns = make(map[net.Conn]bool)
+	this.lock.Unlock()
+
+	err = this.listener.Close()
+	for conn := range conns {
+		conn.Close()
+	}
+	if this.Plugins != nil {
+		this.Plugins.Close()
+	}
+	return
+}
+
+func (this *Server) addConn(conn net.Conn) bool {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	if this.closed {
+		return false
+	}
+	this.conns[conn] = true
+	return true
+}
+
+func (this *Server) removeConn(conn net.Conn) {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	delete(this.conns, conn)
+}
+
+//处理和客户端的通讯
+func (this *Server) process(conn net.Conn) {
+	//这里需要延时关闭conn
+	defer conn.Close()
+	defer this.removeConn(conn)
+
+	metrics.ActiveConnections.Inc()
+	defer metrics.ActiveConnections.Dec()
+	defer logger.Unregister(conn)
+	defer utils.DisableCompression(conn)
+
+	//这里调用总控, 创建一个
+	processor := &Processor{
+		Conn : conn,
+		Plugins : this.Plugins,
+	}
+	err := processor.process2()
+	if err != nil {
+		logger.For(conn, processor.UserId).Info("连接结束", "err", err)
+		return
+	}
+}
@@ -0,0 +1,309 @@
+package delayqueue
+
+import (
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/garyburd/redigo/redis"
+)
+
+//redis的延时队列, 设计见 goredis/redisDelayQueue.go 和 goredis/delayqueue
+//delay:名字            zset  score 为到期时间(unix毫秒), member 为任务id
+//delay:名字:inflight   zset  已经被取出还没有处理完的任务, score 为可见性超时的时间
+//delay:名字:data       hash  任务id -> 任务的内容
+//delay:名字:attempts   hash  任务id -> 处理失败的次数
+//delay:名字:seq        string 用来分配任务id
+//
+//取任务时在一个事务中把到期的任务从 delay:名字 移到 inflight, 同时读出任务的内容
+//事务之前WATCH这两个key, 多个服务器同时取同一个队列时只有一个事务能执行, 其它的重新取, 一个任务只会被一个服务器取到
+//goredis/delayqueue 用lua脚本做同样的事, 这里不能直接用它:
+//服务器所有的DAO都用 redigo 的连接池, goredis/delayqueue 需要 go-redis 的客户端和lua脚本, 测试用的 memredis 不支持lua
+//处理完以后 Ack 才删除任务, 服务器在处理之前崩溃的话, 超过 VisibilityTimeout 以后任务会被重新取出
+//处理失败时 Nack 按 RetryDelay 放回 delay:名字, 失败超过 MaxRetries 次以后丢弃
+//所以任务至少被处理一次, 处理任务的逻辑需要能接受偶尔重复
+
+//取出的任务超过这个时间还没有Ack, 认为处理它的服务器已经崩溃, 重新交给别的服务器
+const VisibilityTimeout = time.Minute
+
+//处理失败以后最多重试几次
+const MaxRetries = 5
+
+//第attempts次处理失败以后过多久重试, 从1秒开始每次翻倍, 最多1分钟
+func RetryDelay(attempts int) time.Duration {
+	d := time.Second
+	for i := 1; i < attempts && d < time.Minute; i++ {
+		d *= 2
+	}
+	if d > time.Minute {
+		d = time.Minute
+	}
+	return d
+}
+
+//一个到期的任务
+type Item struct {
+	Id   int
+	Data []byte
+}
+
+type Queue struct {
+	pool *redis.Pool
+	key  string
+}
+
+func New(pool *redis.Pool, name string) *Queue {
+	return &Queue{
+		pool: pool,
+		key:  "delay:" + name,
+	}
+}
+
+func (this *Queue) dataKey() string {
+	return this.key + ":data"
+}
+
+func (this *Queue) inflightKey() string {
+	return this.key + ":inflight"
+}
+
+func (this *Queue) attemptsKey() string {
+	return this.key + ":attempts"
+}
+
+//添加一个任务, 在at之后被取出, 返回任务id
+func (this *Queue) Add(data []byte, at time.Time) (id int, err error) {
+
+	conn := this.pool.Get()
+	defer conn.Close()
+
+	id, err = redis.Int(conn.Do("Incr", this.key+":seq"))
+	if err != nil {
+		return
+	}
+	//先保存内容再加入队列, 取到id时内容一定已经存在
+	_, err = conn.Do("HSet", this.dataKey(), id, data)
+	if err != nil {
+		return
+	}
+	_, err = conn.Do("ZAdd", this.key, at.UnixMilli(), id)
+	return
+}
+
+//查看还没有被取出的任务, 不存在时返回 redis.ErrNil
+func (this *Queue) Get(id int) (data []byte, err error) {
+
+	conn := this.pool.Get()
+	defer conn.Close()
+
+	//已经被取出的任务的内容在Ack之前还在, 以 delay:名字 中有没有为准
+	_, err = redis.Float64(conn.Do("ZScore", this.key, id))
+	if err != nil {
+		return
+	}
+	return redis.Bytes(conn.Do("HGet", this.dataKey(), id))
+}
+
+//取消一个任务, 任务已经被取出或者不存在时ok为false
+func (this *Queue) Remove(id int) (ok bool, err error) {
+
+	conn := this.pool.Get()
+	defer conn.Close()
+
+	ok, err = redis.Bool(conn.Do("ZRem", this.key, id))
+	if err != nil || !ok {
+		return
+	}
+	conn.Send("MULTI")
+	conn.Send("HDel", this.dataKey(), id)
+	conn.Send("HDel", this.attemptsKey(), id)
+	_, err = conn.Do("EXEC")
+	return
+}
+
+//队列中还没有被取出的任务数
+func (this *Queue) Len() (n int, err error) {
+
+	conn := this.pool.Get()
+	defer conn.Close()
+	return redis.Int(conn.Do("ZCard", this.key))
+}
+
+//取出最多count个在now之前到期的任务, 先取超过可见性超时的任务, 再按到期时间从早到晚取
+//取出的任务处理完以后要调用Ack, 否则now+VisibilityTimeout之后会被再次取出
+func (this *Queue) Claim(now time.Time, count int) (items []*Item, err error) {
+
+	conn := this.pool.Get()
+	defer conn.Close()
+
+	for {
+		items, err = this.claim(conn, now, count)
+		//WATCH 的key被别的服务器修改了, 重新取
+		if err != redis.ErrNil {
+			return
+		}
+	}
+}
+
+func (this *Queue) claim(conn redis.Conn, now time.Time, count int) (items []*Item, err error) {
+
+	_, err = conn.Do("Watch", this.key, this.inflightKey())
+	if err != nil {
+		return
+	}
+	ids, err := redis.Ints(conn.Do("ZRangeByScore", this.inflightKey(), "-inf", now.UnixMilli(), "LIMIT", 0, count))
+	if err != nil {
+		return
+	}
+	expired := len(ids)
+	if expired < count {
+		due, err := redis.Ints(conn.Do("ZRangeByScore", this.key, "-inf", now.UnixMilli(), "LIMIT", 0, count-expired))
+		if err != nil {
+			return nil, err
+		}
+		ids = append(ids, due...)
+	}
+	if len(ids) == 0 {
+		_, err = conn.Do("Unwatch")
+		return
+	}
+
+	deadline := now.Add(VisibilityTimeout).UnixMilli()
+	conn.Send("MULTI")
+	for i, id := range ids {
+		if i >= expired {
+			conn.Send("ZRem", this.key, id)
+		}
+		conn.Send("ZAdd", this.inflightKey(), deadline, id)
+		conn.Send("HGet", this.dataKey(), id)
+	}
+	//被别的服务器抢先修改时EXEC返回nil, redis.Values 返回 redis.ErrNil
+	res, err := redis.Values(conn.Do("EXEC"))
+	if err != nil {
+		return
+	}
+	for i, id := range ids {
+		//每个任务的最后一个结果是HGet的结果
+		if i >= expired {
+			res = res[1:]
+		}
+		data, dataErr := redis.Bytes(res[1], nil)
+		res = res[2:]
+		if dataErr != nil {
+			slog.Error("延时任务的内容不存在", "queue", this.key, "id", id, "err", dataErr)
+			this.Ack(id)
+			continue
+		}
+		if i < expired {
+			slog.Warn("延时任务处理超时, 重新处理", "queue", this.key, "id", id)
+		}
+		items = append(items, &Item{
+			Id:   id,
+			Data: data,
+		})
+	}
+	return
+}
+
+//任务处理完了, 从队列中删除
+func (this *Queue) Ack(id int) (err error) {
+
+	conn := this.pool.Get()
+	defer conn.Close()
+
+	conn.Send("MULTI")
+	conn.Send("ZRem", this.inflightKey(), id)
+	conn.Send("HDel", this.dataKey(), id)
+	conn.Send("HDel", this.attemptsKey(), id)
+	_, err = conn.Do("EXEC")
+	return
+}
+
+//任务处理失败, 在 now+RetryDelay 之后重新取出
+//失败超过 MaxRetries 次时删除任务, dropped 为true
+func (this *Queue) Nack(id int, now time.Time) (dropped bool, err error) {
+
+	conn := this.pool.Get()
+	defer conn.Close()
+
+	attempts, err := redis.Int(conn.Do("HIncrBy", this.attemptsKey(), id, 1))
+	if err != nil {
+		return
+	}
+	if attempts > MaxRetries {
+		return true, this.Ack(id)
+	}
+	conn.Send("MULTI")
+	conn.Send("ZRem", this.inflightKey(), id)
+	conn.Send("ZAdd", this.key, now.Add(RetryDelay(attempts)).UnixMilli(), id)
+	_, err = conn.Do("EXEC")
+	return
+}
+
+//启动一个协程, 每隔interval取出到期的任务交给handler处理, 一次最多取batch个
+//handler 返回nil时 Ack, 返回错误时 Nack 稍后重试
+//一次取满batch个时不等待, 马上继续取
+//返回的stop停止轮询, 等正在处理的任务完成后返回
+func (this *Queue) Poll(interval time.Duration, batch int, handler func(item *Item) error) (stop func()) {
+
+	done := make(chan struct{})
+	exited := make(chan struct{})
+	go func() {
+		defer close(exited)
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+				case <-done:
+					return
+				case <-ticker.C:
+			}
+			for {
+				items, err := this.Claim(time.Now(), batch)
+				if err != nil {
+					slog.Error("取出延时任务失败", "queue", this.key, "err", err)
+				}
+				for _, item := range items {
+					this.finish(item, this.handle(handler, item))
+				}
+				if len(items) < batch {
+					break
+				}
+			}
+		}
+	}()
+	return func() {
+		close(done)
+		<-exited
+	}
+}
+
+//handler panic 时当作处理失败, 不影响后面的任务
+func (this *Queue) handle(handler func(item *Item) error, item *Item) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("panic: %v", r)
+		}
+	}()
+	return handler(item)
+}
+
+//根据处理的结果 Ack 或者 Nack
+func (this *Queue) finish(item *Item, handleErr error) {
+
+	if handleErr == nil {
+		if err := this.Ack(item.Id); err != nil {
+			slog.Error("删除处理完的延时任务失败", "queue", this.key, "id", item.Id, "err", err)
+		}
+		return
+	}
+	dropped, err := this.Nack(item.Id, time.Now())
+	if err != nil {
+		//没有放回队列, VisibilityTimeout 以后还会被重新取出
+		slog.Error("放回处理失败的延时任务失败", "queue", this.key, "id", item.Id, "err", err, "handleErr", handleErr)
+	} else if dropped {
+		slog.Error("延时任务失败次数太多, 丢弃", "queue", this.key, "id", item.Id, "err", handleErr)
+	} else {
+		slog.Warn("处理延时任务失败, 稍后重试", "queue", this.key, "id", item.Id, "err", handleErr)
+	}
+}
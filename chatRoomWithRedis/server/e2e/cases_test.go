@@ -0,0 +1,715 @@
+package e2e
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"go_code/chatroom/common/message"
+	"go_code/chatroom/server/memredis"
+	"go_code/chatroom/server/model"
+)
+
+//code 不是期望的值时, 用例失败
+func expectCode(t *testing.T, what string, code int, want int) {
+	t.Helper()
+	if code != want {
+		t.Fatalf("%s: code=%d, 期望%d", what, code, want)
+	}
+}
+
+func contains(ids []int, id int) bool {
+	for _, v := range ids {
+		if v == id {
+			return true
+		}
+	}
+	return false
+}
+
+//注册成功返回200, 重复注册返回505
+func TestRegister(t *testing.T) {
+	c := connect(t)
+
+	userId := newUserId()
+	code, err := c.register(userId, "123456")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectCode(t, "注册", code, 200)
+	code, err = c.register(userId, "654321")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectCode(t, "重复注册", code, 505)
+}
+
+//用户不存在返回500, 密码错误返回403, 登录成功后在线列表中有自己
+func TestLogin(t *testing.T) {
+	c := connect(t)
+
+	userId := newUserId()
+	loginResMes, err := c.login(userId, "123456")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectCode(t, "未注册的用户登录", loginResMes.Code, 500)
+
+	if _, err := c.register(userId, "123456"); err != nil {
+		t.Fatal(err)
+	}
+	loginResMes, err = c.login(userId, "wrong")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectCode(t, "密码错误", loginResMes.Code, 403)
+
+	loginResMes, err = c.login(userId, "123456")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectCode(t, "登录", loginResMes.Code, 200)
+	if !contains(loginResMes.UsersId, userId) {
+		t.Fatalf("在线列表%v 中没有自己%d", loginResMes.UsersId, userId)
+	}
+}
+
+//别人上线和下线时收到通知, 后登录的用户在登录结果中看到先登录的用户
+func TestPresence(t *testing.T) {
+	a := loginNewUser(t)
+
+	b := connect(t)
+	bId := newUserId()
+	if _, err := b.register(bId, "123456"); err != nil {
+		t.Fatal(err)
+	}
+	loginResMes, err := b.login(bId, "123456")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !contains(loginResMes.UsersId, a.UserId) {
+		t.Fatalf("用户%d 的在线列表%v 中没有用户%d", bId, loginResMes.UsersId, a.UserId)
+	}
+	if err := a.expectStatus(bId, message.UserOnline); err != nil {
+		t.Fatal(err)
+	}
+
+	b.Close()
+	if err := a.expectStatus(bId, message.UserOffline); err != nil {
+		t.Fatal(err)
+	}
+}
+
+//群聊消息发给大厅中的其他人, 发送方收到SmsResMes, 不会收到自己的消息
+func TestGroupMes(t *testing.T) {
+	var clients []*client
+	for i := 0; i < 3; i++ {
+		clients = append(clients, loginNewUser(t))
+	}
+	sender := clients[0]
+	smsResMes, err := sender.sendSms(0, "大家好")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectCode(t, "群聊", smsResMes.Code, 200)
+	if smsResMes.MesId == 0 {
+		t.Fatalf("群聊消息没有分配id")
+	}
+	for _, c := range clients[1:] {
+		var smsMes message.SmsMes
+		err = c.expect(message.SmsMesType, &smsMes, func() bool {
+			return smsMes.MesId == smsResMes.MesId
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if smsMes.UserId != sender.UserId || smsMes.Content != "大家好" {
+			t.Fatalf("用户%d 收到的消息不对: %+v", c.UserId, smsMes)
+		}
+	}
+	if err := sender.expectNone(message.SmsMesType, 200*time.Millisecond); err != nil {
+		t.Fatal(err)
+	}
+}
+
+//私聊消息只发给对方, 对方不存在时返回500
+func TestPrivateMes(t *testing.T) {
+	a := loginNewUser(t)
+	b := loginNewUser(t)
+
+	smsResMes, err := a.sendSms(b.UserId, "你好")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectCode(t, "私聊", smsResMes.Code, 200)
+	var smsMes message.SmsMes
+	err = b.expect(message.SmsMesType, &smsMes, func() bool {
+		return smsMes.MesId == smsResMes.MesId
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if smsMes.UserId != a.UserId || smsMes.ToUserId != b.UserId || smsMes.Content != "你好" {
+		t.Fatalf("收到的私聊消息不对: %+v", smsMes)
+	}
+
+	smsResMes, err = a.sendSms(newUserId(), "你好")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectCode(t, "私聊不存在的用户", smsResMes.Code, 500)
+}
+
+//对方不在线时私聊消息先保存, 上线后推送
+func TestOfflineMes(t *testing.T) {
+	a := loginNewUser(t)
+	b := connect(t)
+	bId := newUserId()
+	if _, err := b.register(bId, "123456"); err != nil {
+		t.Fatal(err)
+	}
+
+	smsResMes, err := a.sendSms(bId, "在吗")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectCode(t, "离线私聊", smsResMes.Code, 200)
+	if _, err := b.login(bId, "123456"); err != nil {
+		t.Fatal(err)
+	}
+	var smsMes message.SmsMes
+	if err := b.expect(message.SmsMesType, &smsMes, func() bool {
+		return smsMes.MesId == smsResMes.MesId
+	}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+//等待消息操作的结果
+func (this *client) expectActionRes(mesType string) (resMes message.MesActionResMes, err error) {
+	err = this.expect(message.MesActionResMesType, &resMes, func() bool {
+		return resMes.Type == mesType
+	})
+	return
+}
+
+//只有发送方能修改和删除, 所有人都能回应, 操作会转发给房间中的用户并保存到聊天记录
+func TestMesAction(t *testing.T) {
+	a := loginNewUser(t)
+	b := loginNewUser(t)
+	//进入单独的房间, 不受其它用例的影响
+	room := fmt.Sprintf("action%d", a.UserId)
+	for _, c := range []*client{a, b} {
+		if err := c.send(message.JoinRoomMesType, message.JoinRoomMes{RoomId: room}); err != nil {
+			t.Fatal(err)
+		}
+		var joinRoomResMes message.JoinRoomResMes
+		if err := c.expect(message.JoinRoomResMesType, &joinRoomResMes, nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+	smsResMes, err := a.sendSms(0, "原来的内容")
+	if err != nil {
+		t.Fatal(err)
+	}
+	mesId := smsResMes.MesId
+
+	//别人不能修改
+	if err := b.send(message.EditMesType, message.EditMes{MesId: mesId, Content: "x"}); err != nil {
+		t.Fatal(err)
+	}
+	resMes, err := b.expectActionRes(message.EditMesType)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectCode(t, "修改别人的消息", resMes.Code, 401)
+
+	if err := a.send(message.EditMesType, message.EditMes{MesId: mesId, Content: "修改后的内容"}); err != nil {
+		t.Fatal(err)
+	}
+	if resMes, err = a.expectActionRes(message.EditMesType); err != nil {
+		t.Fatal(err)
+	}
+	expectCode(t, "修改消息", resMes.Code, 200)
+	var editMes message.EditMes
+	err = b.expect(message.EditMesType, &editMes, func() bool {
+		return editMes.MesId == mesId
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if editMes.Content != "修改后的内容" || editMes.UserId != a.UserId {
+		t.Fatalf("收到的修改不对: %+v", editMes)
+	}
+
+	if err := b.send(message.ReactMesType, message.ReactMes{MesId: mesId, Emoji: "👍"}); err != nil {
+		t.Fatal(err)
+	}
+	var reactMes message.ReactMes
+	err = a.expect(message.ReactMesType, &reactMes, func() bool {
+		return reactMes.MesId == mesId
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(reactMes.Reactions["👍"]) != 1 || reactMes.Reactions["👍"][0] != b.UserId {
+		t.Fatalf("收到的回应不对: %+v", reactMes)
+	}
+
+	//聊天记录中是修改后的内容
+	if err := a.send(message.HistoryMesType, message.HistoryMes{RoomId: room}); err != nil {
+		t.Fatal(err)
+	}
+	var historyResMes message.HistoryResMes
+	if err := a.expect(message.HistoryResMesType, &historyResMes, nil); err != nil {
+		t.Fatal(err)
+	}
+	if len(historyResMes.Messages) != 1 || historyResMes.Messages[0].Content != "修改后的内容" ||
+		historyResMes.Messages[0].EditTime == 0 || len(historyResMes.Messages[0].Reactions["👍"]) != 1 {
+		t.Fatalf("聊天记录不对: %+v", historyResMes.Messages)
+	}
+
+	if err := a.send(message.DeleteMesType, message.DeleteMes{MesId: mesId}); err != nil {
+		t.Fatal(err)
+	}
+	var deleteMes message.DeleteMes
+	err = b.expect(message.DeleteMesType, &deleteMes, func() bool {
+		return deleteMes.MesId == mesId
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	//删除后不能再回应
+	if err := b.send(message.ReactMesType, message.ReactMes{MesId: mesId, Emoji: "👍"}); err != nil {
+		t.Fatal(err)
+	}
+	if resMes, err = b.expectActionRes(message.ReactMesType); err != nil {
+		t.Fatal(err)
+	}
+	expectCode(t, "回应已删除的消息", resMes.Code, 400)
+}
+
+//没有进入过房间的用户看不到房间中的消息, 不能回应, 版主也不能删除
+func TestMesActionRoomAccess(t *testing.T) {
+	a := loginNewUser(t)
+	outsider := loginNewUser(t)
+	moderator := loginWithRole(t, message.RoleModerator)
+	room := fmt.Sprintf("access%d", a.UserId)
+	if err := a.joinRoom(room); err != nil {
+		t.Fatal(err)
+	}
+	smsResMes, err := a.sendSms(0, "房间里的消息")
+	if err != nil {
+		t.Fatal(err)
+	}
+	mesId := smsResMes.MesId
+
+	if err := outsider.send(message.ReactMesType, message.ReactMes{MesId: mesId, Emoji: "👍"}); err != nil {
+		t.Fatal(err)
+	}
+	resMes, err := outsider.expectActionRes(message.ReactMesType)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectCode(t, "回应没有进入过的房间中的消息", resMes.Code, 500)
+
+	if err := moderator.send(message.DeleteMesType, message.DeleteMes{MesId: mesId}); err != nil {
+		t.Fatal(err)
+	}
+	if resMes, err = moderator.expectActionRes(message.DeleteMesType); err != nil {
+		t.Fatal(err)
+	}
+	expectCode(t, "版主删除没有进入过的房间中的消息", resMes.Code, 500)
+	smsMes, err := model.MyMessageDao.GetMessageById(mesId)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if smsMes.Deleted || len(smsMes.Reactions) != 0 {
+		t.Fatalf("没有权限的操作修改了消息: %+v", smsMes)
+	}
+
+	//进入过房间以后可以操作, 离开以后也可以
+	if err := moderator.joinRoom(room); err != nil {
+		t.Fatal(err)
+	}
+	if err := moderator.joinRoom(""); err != nil {
+		t.Fatal(err)
+	}
+	if err := moderator.send(message.DeleteMesType, message.DeleteMes{MesId: mesId}); err != nil {
+		t.Fatal(err)
+	}
+	if resMes, err = moderator.expectActionRes(message.DeleteMesType); err != nil {
+		t.Fatal(err)
+	}
+	expectCode(t, "版主删除进入过的房间中的消息", resMes.Code, 200)
+}
+
+//查询聊天记录并等待最后一个回复
+func (this *client) history(historyMes message.HistoryMes) (historyResMes message.HistoryResMes, err error) {
+	if err = this.send(message.HistoryMesType, historyMes); err != nil {
+		return
+	}
+	err = this.expect(message.HistoryResMesType, &historyResMes, func() bool {
+		return historyResMes.Last
+	})
+	return
+}
+
+//只能查看进入过的房间的聊天记录
+func TestHistoryRoomAccess(t *testing.T) {
+	a := loginNewUser(t)
+	outsider := loginNewUser(t)
+	room := fmt.Sprintf("history%d", a.UserId)
+	if err := a.joinRoom(room); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := a.sendSms(0, "房间里的秘密"); err != nil {
+		t.Fatal(err)
+	}
+
+	historyResMes, err := outsider.history(message.HistoryMes{RoomId: room})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectCode(t, "查看没有进入过的房间的聊天记录", historyResMes.Code, 401)
+	if len(historyResMes.Messages) != 0 {
+		t.Fatalf("401 的回复中有消息: %+v", historyResMes.Messages)
+	}
+	//大厅不需要进入
+	historyResMes, err = outsider.history(message.HistoryMes{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectCode(t, "查看大厅的聊天记录", historyResMes.Code, 200)
+
+	//进入过以后, 回到大厅也能查看
+	if err = outsider.joinRoom(room); err != nil {
+		t.Fatal(err)
+	}
+	if err = outsider.joinRoom(""); err != nil {
+		t.Fatal(err)
+	}
+	historyResMes, err = outsider.history(message.HistoryMes{RoomId: room})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectCode(t, "查看进入过的房间的聊天记录", historyResMes.Code, 200)
+	if len(historyResMes.Messages) != 1 || historyResMes.Messages[0].Content != "房间里的秘密" {
+		t.Fatalf("聊天记录不对: %+v", historyResMes.Messages)
+	}
+}
+
+//两个服务器同时修改同一条消息, 每个修改都保存下来
+func TestUpdateMessageConcurrent(t *testing.T) {
+	c := loginNewUser(t)
+	smsResMes, err := c.sendSms(0, "大家来回应")
+	if err != nil {
+		t.Fatal(err)
+	}
+	mesId := smsResMes.MesId
+	const n = 200
+	var wg sync.WaitGroup
+	errs := make(chan error, 2*n)
+	for server := 0; server < 2; server++ {
+		messageDao := model.NewMessageDao(store.NewPool())
+		wg.Add(1)
+		go func(server int) {
+			defer wg.Done()
+			for i := 0; i < n; i++ {
+				userId := server*n + i
+				_, err := messageDao.UpdateMessage(mesId, func(smsMes *message.SmsMes) error {
+					smsMes.Reactions = map[string][]int{
+						"👍": append(smsMes.Reactions["👍"], userId),
+					}
+					return nil
+				})
+				errs <- err
+			}
+		}(server)
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	smsMes, err := model.MyMessageDao.GetMessageById(mesId)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(smsMes.Reactions["👍"]) != 2*n {
+		t.Fatalf("同时修改以后有%d 个回应, 期望%d", len(smsMes.Reactions["👍"]), 2*n)
+	}
+}
+
+//等待某条消息的提醒
+func (this *client) expectMention(mesId int) (mentionMes message.MentionMes, err error) {
+	err = this.expect(message.MentionMesType, &mentionMes, func() bool {
+		return mentionMes.SmsMes.MesId == mesId
+	})
+	return
+}
+
+//注册一个不登录的用户
+func registerOffline(t *testing.T) (c *client, userId int) {
+	t.Helper()
+	c = connect(t)
+	userId = newUserId()
+	if _, err := c.register(userId, "123456"); err != nil {
+		t.Fatal(err)
+	}
+	return
+}
+
+//设置关键词和屏蔽的房间
+func (this *client) setNotify(t *testing.T, settings message.NotifySettings) message.NotifySettings {
+	t.Helper()
+	err := this.send(message.SetNotifySettingsMesType, message.SetNotifySettingsMes{Settings: settings})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var resMes message.NotifySettingsResMes
+	if err := this.expect(message.NotifySettingsResMesType, &resMes, nil); err != nil {
+		t.Fatal(err)
+	}
+	expectCode(t, "修改提醒设置", resMes.Code, 200)
+	return resMes.Settings
+}
+
+//进入过房间的用户被@时不管在哪个房间都收到提醒, 不在线时上线后收到
+//关键词只提醒在线的用户, 屏蔽的房间和没进入过的房间都不提醒
+func TestMention(t *testing.T) {
+	a := loginNewUser(t)
+	b := loginNewUser(t)
+	//d在线但没进入过房间
+	d := loginNewUser(t)
+	c, cId := registerOffline(t)
+	//e不在线也没进入过房间
+	e, eId := registerOffline(t)
+
+	room := fmt.Sprintf("mention%d", a.UserId)
+	quiet := room + "q"
+	if err := a.joinRoom(room); err != nil {
+		t.Fatal(err)
+	}
+	for _, roomId := range []string{room, quiet, ""} {
+		if err := b.joinRoom(roomId); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := model.MyUserDao.AddJoinedRoom(cId, room); err != nil {
+		t.Fatal(err)
+	}
+	settings := b.setNotify(t, message.NotifySettings{Keywords: []string{" Deploy "}, MutedRooms: []string{quiet}})
+	if len(settings.Keywords) != 1 || settings.Keywords[0] != "deploy" {
+		t.Fatalf("关键词没有整理: %+v", settings)
+	}
+	d.setNotify(t, message.NotifySettings{Keywords: []string{"deploy"}})
+
+	//b回到了大厅, 用用户名@b, 用id@不在线的c
+	smsResMes, err := a.sendSms(0, fmt.Sprintf("@user%d，@%d @%d @%d deploy 完成了", b.UserId, cId, d.UserId, eId))
+	if err != nil {
+		t.Fatal(err)
+	}
+	mentionId := smsResMes.MesId
+	mentionMes, err := b.expectMention(mentionId)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if mentionMes.Reason != message.MentionAt || mentionMes.SmsMes.RoomId != room {
+		t.Fatalf("收到的提醒不对: %+v", mentionMes)
+	}
+
+	smsResMes, err = a.sendSms(0, "DEPLOY again")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if mentionMes, err = b.expectMention(smsResMes.MesId); err != nil {
+		t.Fatal(err)
+	}
+	if mentionMes.Reason != message.MentionKeyword || mentionMes.Keyword != "deploy" {
+		t.Fatalf("收到的关键词提醒不对: %+v", mentionMes)
+	}
+	//没进入过房间的d被@和关键词都不提醒
+	if err := d.expectNone(message.MentionMesType, 200*time.Millisecond); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := c.login(cId, "123456"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.expectMention(mentionId); err != nil {
+		t.Fatal(err)
+	}
+	//没进入过房间的e不保存离线提醒
+	if _, err := e.login(eId, "123456"); err != nil {
+		t.Fatal(err)
+	}
+	if err := e.expectNone(message.MentionMesType, 200*time.Millisecond); err != nil {
+		t.Fatal(err)
+	}
+
+	//屏蔽的房间中@和关键词都不提醒
+	if err := a.joinRoom(quiet); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := a.sendSms(0, fmt.Sprintf("@%d deploy", b.UserId)); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.expectNone(message.MentionMesType, 200*time.Millisecond); err != nil {
+		t.Fatal(err)
+	}
+}
+
+//搜索并等待结果
+func (this *client) search(searchMes message.SearchMes) (searchResMes message.SearchResMes, err error) {
+	if err = this.send(message.SearchMesType, searchMes); err != nil {
+		return
+	}
+	err = this.expect(message.SearchResMesType, &searchResMes, nil)
+	return
+}
+
+//搜索结果中消息的id, 按顺序
+func resultIds(searchResMes message.SearchResMes) (ids []int) {
+	for _, smsMes := range searchResMes.Messages {
+		ids = append(ids, smsMes.MesId)
+	}
+	return
+}
+
+func sameIds(t *testing.T, got []int, want ...int) {
+	t.Helper()
+	if fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Fatalf("搜索结果%v, 期望%v", got, want)
+	}
+}
+
+//只能搜到大厅, 进入过的房间和自己的私聊, 修改后的内容重新索引, 结果可以翻页
+func TestSearch(t *testing.T) {
+	a := loginNewUser(t)
+	b := loginNewUser(t)
+	c := loginNewUser(t)
+
+	//每次运行用不同的词, 不受其它用例的影响
+	word := fmt.Sprintf("kw%d", a.UserId)
+	room := "search" + word
+	lobbyRes, err := a.sendSms(0, "部署完成 "+word)
+	if err != nil {
+		t.Fatal(err)
+	}
+	privateRes, err := a.sendSms(b.UserId, "私聊说一下部署的事 "+strings.ToUpper(word))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := a.joinRoom(room); err != nil {
+		t.Fatal(err)
+	}
+	roomRes, err := a.sendSms(0, word+", 开始部署golang服务")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	query := "部署 " + word
+	searchResMes, err := a.search(message.SearchMes{Query: query})
+	if err != nil {
+		t.Fatal(err)
+	}
+	sameIds(t, resultIds(searchResMes), roomRes.MesId, privateRes.MesId, lobbyRes.MesId)
+	//b 没进入过房间, 能搜到私聊和大厅
+	if searchResMes, err = b.search(message.SearchMes{Query: query}); err != nil {
+		t.Fatal(err)
+	}
+	sameIds(t, resultIds(searchResMes), privateRes.MesId, lobbyRes.MesId)
+	//c 只能搜到大厅
+	if searchResMes, err = c.search(message.SearchMes{Query: query}); err != nil {
+		t.Fatal(err)
+	}
+	sameIds(t, resultIds(searchResMes), lobbyRes.MesId)
+	if searchResMes, err = c.search(message.SearchMes{Query: query, InRoom: true, RoomId: room}); err != nil {
+		t.Fatal(err)
+	}
+	expectCode(t, "搜索没进入过的房间", searchResMes.Code, 401)
+	if searchResMes, err = a.search(message.SearchMes{Query: query, ToUserId: b.UserId}); err != nil {
+		t.Fatal(err)
+	}
+	sameIds(t, resultIds(searchResMes), privateRes.MesId)
+
+	//翻页
+	searchMes := message.SearchMes{Query: word, FromUserId: a.UserId, Count: 2}
+	if searchResMes, err = a.search(searchMes); err != nil {
+		t.Fatal(err)
+	}
+	sameIds(t, resultIds(searchResMes), roomRes.MesId, privateRes.MesId)
+	searchMes.BeforeId = searchResMes.NextBeforeId
+	if searchResMes, err = a.search(searchMes); err != nil {
+		t.Fatal(err)
+	}
+	sameIds(t, resultIds(searchResMes), lobbyRes.MesId)
+	if searchResMes.NextBeforeId != 0 {
+		t.Fatalf("最后一页的NextBeforeId=%d", searchResMes.NextBeforeId)
+	}
+
+	//修改后搜不到原来的内容
+	if err := a.send(message.EditMesType, message.EditMes{MesId: roomRes.MesId, Content: "改掉了"}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := a.expectActionRes(message.EditMesType); err != nil {
+		t.Fatal(err)
+	}
+	if searchResMes, err = a.search(message.SearchMes{Query: word, InRoom: true, RoomId: room}); err != nil {
+		t.Fatal(err)
+	}
+	sameIds(t, resultIds(searchResMes))
+	if searchResMes, err = a.search(message.SearchMes{Query: "  ,"}); err != nil {
+		t.Fatal(err)
+	}
+	expectCode(t, "没有可以搜索的词", searchResMes.Code, 400)
+}
+
+//没有登录时发消息和查聊天记录返回403
+func TestNotLogin(t *testing.T) {
+	c := connect(t)
+
+	smsResMes, err := c.sendSms(0, "hello")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectCode(t, "未登录发消息", smsResMes.Code, 403)
+
+	if err := c.send(message.HistoryMesType, message.HistoryMes{}); err != nil {
+		t.Fatal(err)
+	}
+	var historyResMes message.HistoryResMes
+	if err := c.expect(message.HistoryResMesType, &historyResMes, nil); err != nil {
+		t.Fatal(err)
+	}
+	expectCode(t, "未登录查聊天记录", historyResMes.Code, 403)
+}
+
+//redis不可用时, 登录和发消息返回505
+func TestRedisError(t *testing.T) {
+	a := loginNewUser(t)
+	b := connect(t)
+	bId := newUserId()
+	if _, err := b.register(bId, "123456"); err != nil {
+		t.Fatal(err)
+	}
+
+	store.SetError(memredis.ErrUnavailable)
+	defer store.SetError(nil)
+
+	loginResMes, err := b.login(bId, "123456")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectCode(t, "redis出错时登录", loginResMes.Code, 505)
+	smsResMes, err := a.sendSms(0, "hello")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectCode(t, "redis出错时发消息", smsResMes.Code, 505)
+}
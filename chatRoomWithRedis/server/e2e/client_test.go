@@ -0,0 +1,161 @@
+package e2e
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"time"
+
+	"go_code/chatroom/common/message"
+	"go_code/chatroom/server/utils"
+)
+
+//等待服务器回复的最长时间
+var replyTimeout = 3 * time.Second
+
+//测试用的客户端, 直接收发协议中的消息
+type client struct {
+	UserId int
+	Conn   net.Conn
+	tf     *utils.Transfer
+}
+
+func dial(addr string) (c *client, err error) {
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		return
+	}
+	c = &client{
+		Conn: conn,
+		tf: &utils.Transfer{
+			Conn: conn,
+		},
+	}
+	return
+}
+
+func (this *client) Close() {
+	utils.DisableCompression(this.Conn)
+	this.Conn.Close()
+}
+
+func (this *client) send(mesType string, v interface{}) error {
+	return this.tf.WriteMes(mesType, v)
+}
+
+//读取消息, 直到收到类型为mesType, 并且match返回true的消息, 其它的消息忽略
+//match 为nil时收到这个类型的消息就返回
+func (this *client) expect(mesType string, v interface{}, match func() bool) (err error) {
+	deadline := time.Now().Add(replyTimeout)
+	this.Conn.SetReadDeadline(deadline)
+	defer this.Conn.SetReadDeadline(time.Time{})
+	for {
+		mes, err := this.tf.ReadPkg()
+		if err != nil {
+			if errors.Is(err, os.ErrDeadlineExceeded) {
+				return fmt.Errorf("用户%d 等待%s 超时", this.UserId, mesType)
+			}
+			return fmt.Errorf("用户%d 等待%s 时读取失败: %v", this.UserId, mesType, err)
+		}
+		if mes.Type != mesType {
+			continue
+		}
+		err = json.Unmarshal([]byte(mes.Data), v)
+		if err != nil {
+			return err
+		}
+		if match == nil || match() {
+			return nil
+		}
+	}
+}
+
+//在wait时间内不应该收到类型为mesType的消息
+func (this *client) expectNone(mesType string, wait time.Duration) (err error) {
+	this.Conn.SetReadDeadline(time.Now().Add(wait))
+	defer this.Conn.SetReadDeadline(time.Time{})
+	for {
+		mes, err := this.tf.ReadPkg()
+		if err != nil {
+			if errors.Is(err, os.ErrDeadlineExceeded) {
+				return nil
+			}
+			return err
+		}
+		if mes.Type == mesType {
+			return fmt.Errorf("用户%d 不应该收到%s: %s", this.UserId, mesType, mes.Data)
+		}
+	}
+}
+
+func (this *client) register(userId int, pwd string) (code int, err error) {
+	err = this.send(message.RegisterMesType, message.RegisterMes{
+		User: message.User{
+			UserId:   userId,
+			UserPwd:  pwd,
+			UserName: fmt.Sprintf("user%d", userId),
+		},
+	})
+	if err != nil {
+		return
+	}
+	var registerResMes message.RegisterResMes
+	err = this.expect(message.RegisterResMesType, &registerResMes, nil)
+	return registerResMes.Code, err
+}
+
+func (this *client) login(userId int, pwd string) (loginResMes message.LoginResMes, err error) {
+	err = this.send(message.LoginMesType, message.LoginMes{
+		UserId:  userId,
+		UserPwd: pwd,
+	})
+	if err != nil {
+		return
+	}
+	err = this.expect(message.LoginResMesType, &loginResMes, nil)
+	if err == nil && loginResMes.Code == 200 {
+		this.UserId = userId
+	}
+	return
+}
+
+//发送一条聊天消息, 返回服务器的回复
+func (this *client) sendSms(toUserId int, content string) (smsResMes message.SmsResMes, err error) {
+	localId := int(time.Now().UnixNano() % 1000000)
+	err = this.send(message.SmsMesType, message.SmsMes{
+		Content:  content,
+		LocalId:  localId,
+		ToUserId: toUserId,
+	})
+	if err != nil {
+		return
+	}
+	err = this.expect(message.SmsResMesType, &smsResMes, func() bool {
+		return smsResMes.LocalId == localId
+	})
+	return
+}
+
+//进入房间并等待结果
+func (this *client) joinRoom(roomId string) (err error) {
+	err = this.send(message.JoinRoomMesType, message.JoinRoomMes{RoomId: roomId})
+	if err != nil {
+		return
+	}
+	var joinRoomResMes message.JoinRoomResMes
+	err = this.expect(message.JoinRoomResMesType, &joinRoomResMes, nil)
+	if err == nil && joinRoomResMes.Code != 200 {
+		err = fmt.Errorf("用户%d 进入房间%s 失败: %s", this.UserId, roomId, joinRoomResMes.Error)
+	}
+	return
+}
+
+//等待某个用户的状态通知
+func (this *client) expectStatus(userId int, status int) error {
+	var notifyMes message.NotifyUserStatusMes
+	return this.expect(message.NotifyUserStatusMesType, &notifyMes, func() bool {
+		return notifyMes.UserId == userId && notifyMes.Status == status
+	})
+}
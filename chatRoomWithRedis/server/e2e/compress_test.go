@@ -0,0 +1,160 @@
+package e2e
+
+import (
+	"bytes"
+	"compress/flate"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"go_code/chatroom/common/compress"
+	"go_code/chatroom/common/message"
+	"go_code/chatroom/server/utils"
+)
+
+//记录从连接读了多少字节
+type countConn struct {
+	net.Conn
+	read int64
+}
+
+func (this *countConn) Read(b []byte) (n int, err error) {
+	n, err = this.Conn.Read(b)
+	atomic.AddInt64(&this.read, int64(n))
+	return
+}
+
+//握手并协商压缩, 然后注册和登录, 用例结束时自动关闭
+func loginCompressed(t *testing.T) (c *client, counter *countConn) {
+	t.Helper()
+	conn, err := net.Dial("tcp", serverAddr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	counter = &countConn{Conn: conn}
+	c = &client{
+		Conn: counter,
+		tf: &utils.Transfer{
+			Conn: counter,
+		},
+	}
+	t.Cleanup(c.Close)
+	helloResMes, err := c.hello(message.HelloMes{
+		Version:      message.ProtocolVersion,
+		Compressions: []string{message.CompressionDeflate},
+		Features:     []string{message.FeatureRooms},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if helloResMes.Compression != message.CompressionDeflate {
+		t.Fatalf("没有协商压缩 %+v", helloResMes)
+	}
+	userId := newUserId()
+	code, err := c.register(userId, "123456")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectCode(t, "注册", code, 200)
+	loginResMes, err := c.login(userId, "123456")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectCode(t, "登录", loginResMes.Code, 200)
+	return
+}
+
+//直接写一个设置了压缩位的数据包
+func writeCompressed(conn net.Conn, data []byte) (err error) {
+	var buf bytes.Buffer
+	w, _ := flate.NewWriter(&buf, flate.BestCompression)
+	w.Write(data)
+	w.Close()
+	var header [4]byte
+	binary.BigEndian.PutUint32(header[:], uint32(buf.Len())|compress.FlagCompressed)
+	_, err = conn.Write(append(header[:], buf.Bytes()...))
+	return
+}
+
+//服务器应该断开连接
+func expectClosed(c *client) error {
+	c.Conn.SetReadDeadline(time.Now().Add(replyTimeout))
+	for {
+		_, err := c.tf.ReadPkg()
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("服务器应该断开连接, err=%v", err)
+		}
+	}
+}
+
+//协商了压缩的客户端收发压缩的大包, 老客户端收到的还是不压缩的包, 不能用压缩包攻击服务器
+func TestCompression(t *testing.T) {
+	a, counter := loginCompressed(t)
+	b := loginNewUser(t)
+	room := fmt.Sprintf("compress%d", a.UserId)
+	for _, c := range []*client{a, b} {
+		if err := c.joinRoom(room); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	//老客户端发的大消息, 服务器压缩后发给a
+	content := strings.Repeat("压缩测试 compression ", 1000)
+	before := atomic.LoadInt64(&counter.read)
+	if _, err := b.sendSms(0, content); err != nil {
+		t.Fatal(err)
+	}
+	var smsMes message.SmsMes
+	err := a.expect(message.SmsMesType, &smsMes, func() bool {
+		return smsMes.UserId == b.UserId
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if smsMes.Content != content {
+		t.Fatalf("解压后的消息内容不正确")
+	}
+	read := atomic.LoadInt64(&counter.read) - before
+	if read >= int64(len(content))/2 {
+		t.Fatalf("收到%d 字节的消息读了%d 字节, 没有压缩", len(content), read)
+	}
+
+	//a 发的压缩的大消息, 老客户端收到不压缩的
+	if _, err := a.sendSms(0, content+"!"); err != nil {
+		t.Fatal(err)
+	}
+	err = b.expect(message.SmsMesType, &smsMes, func() bool {
+		return smsMes.UserId == a.UserId
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if smsMes.Content != content+"!" {
+		t.Fatalf("老客户端收到的消息内容不正确")
+	}
+
+	//解压后超过限制的包, 服务器断开连接
+	if err := writeCompressed(a.Conn, make([]byte, 2*message.MaxPkgSize)); err != nil {
+		t.Fatal(err)
+	}
+	if err := expectClosed(a); err != nil {
+		t.Fatalf("解压后太大的包: %v", err)
+	}
+	//没有协商压缩时不能发压缩的包
+	c := connect(t)
+	if err := writeCompressed(c.Conn, []byte(`{"type":"LoginMes","data":"{}"}`)); err != nil {
+		t.Fatal(err)
+	}
+	if err := expectClosed(c); err != nil {
+		t.Fatalf("没有协商压缩: %v", err)
+	}
+}
@@ -0,0 +1,77 @@
+package e2e
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"go_code/chatroom/common/message"
+	"go_code/chatroom/server/events"
+)
+
+//服务器发布的事件保存在内存中, 不需要Kafka
+var fakeEvents = events.NewFake()
+
+//等待事件的时间, 事件在回复客户端之后才发布
+const eventWait = 2 * time.Second
+
+//登录, 发消息, 修改, 下线都会发布事件, 群聊消息的key是房间
+func TestEvents(t *testing.T) {
+	a := loginNewUser(t)
+	userId := a.UserId
+	_, ok := fakeEvents.Wait(func(event *events.Event) bool {
+		return event.Type == events.TypeLogin && event.UserId == userId
+	}, eventWait)
+	if !ok {
+		t.Fatalf("没有收到用户%d 的登录事件", userId)
+	}
+
+	room := fmt.Sprintf("events%d", userId)
+	if err := a.joinRoom(room); err != nil {
+		t.Fatal(err)
+	}
+	smsResMes, err := a.sendSms(0, "事件")
+	if err != nil {
+		t.Fatal(err)
+	}
+	mesId := smsResMes.MesId
+	event, ok := fakeEvents.Wait(func(event *events.Event) bool {
+		return event.Type == events.TypeMessage && event.Message != nil && event.Message.MesId == mesId
+	}, eventWait)
+	if !ok {
+		t.Fatalf("没有收到消息%d 的事件", mesId)
+	}
+	if event.UserId != userId || event.Message.Content != "事件" {
+		t.Fatalf("消息事件不正确 %+v", event)
+	}
+	if event.Key() != "room:"+room {
+		t.Fatalf("消息事件的key=%q, 应该是房间", event.Key())
+	}
+	if event.Message.UserPwd != "" {
+		t.Fatalf("消息事件中不能有密码")
+	}
+
+	if err := a.send(message.EditMesType, message.EditMes{MesId: mesId, Content: "修改后的事件"}); err != nil {
+		t.Fatal(err)
+	}
+	resMes, err := a.expectActionRes(message.EditMesType)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectCode(t, "修改消息", resMes.Code, 200)
+	_, ok = fakeEvents.Wait(func(event *events.Event) bool {
+		return event.Type == events.TypeEdit && event.Message != nil &&
+			event.Message.MesId == mesId && event.Message.Content == "修改后的事件"
+	}, eventWait)
+	if !ok {
+		t.Fatalf("没有收到消息%d 的修改事件", mesId)
+	}
+
+	a.Close()
+	_, ok = fakeEvents.Wait(func(event *events.Event) bool {
+		return event.Type == events.TypeLogout && event.UserId == userId
+	}, eventWait)
+	if !ok {
+		t.Fatalf("没有收到用户%d 的下线事件", userId)
+	}
+}
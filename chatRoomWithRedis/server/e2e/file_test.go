@@ -0,0 +1,173 @@
+package e2e
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"strings"
+	"testing"
+
+	"go_code/chatroom/common/message"
+)
+
+//发起文件传输, 返回服务器分配了FileId的结果
+func (this *client) offerFile(toUserId int, name string, data []byte) (resMes message.FileOfferResMes, err error) {
+	sum := sha256.Sum256(data)
+	err = this.send(message.FileOfferMesType, message.FileOfferMes{FileInfo: message.FileInfo{
+		ToUserId: toUserId,
+		FileName: name,
+		FileSize: int64(len(data)),
+		FileHash: hex.EncodeToString(sum[:]),
+	}})
+	if err != nil {
+		return
+	}
+	err = this.expect(message.FileOfferResMesType, &resMes, nil)
+	return
+}
+
+//上传第seq个分片并等待服务器的确认
+func (this *client) uploadChunk(fileId int, data []byte, seq int) (ackMes message.FileAckMes, err error) {
+	start := seq * message.FileChunkSize
+	end := start + message.FileChunkSize
+	if end > len(data) {
+		end = len(data)
+	}
+	err = this.send(message.FileChunkMesType, message.FileChunkMes{
+		FileId: fileId,
+		Seq:    seq,
+		Data:   data[start:end],
+		Last:   end == len(data),
+	})
+	if err != nil {
+		return
+	}
+	err = this.expect(message.FileAckMesType, &ackMes, func() bool {
+		return ackMes.FileId == fileId && ackMes.Seq == seq
+	})
+	return
+}
+
+//接收一个文件的所有分片并逐个确认, 返回拼起来的内容
+func (this *client) receiveFile(fileId int) (data []byte, err error) {
+	for {
+		var chunk message.FileChunkMes
+		err = this.expect(message.FileChunkMesType, &chunk, func() bool {
+			return chunk.FileId == fileId
+		})
+		if err != nil {
+			return
+		}
+		if chunk.Seq*message.FileChunkSize != len(data) {
+			return data, fmt.Errorf("收到第%d 个分片时只有%d 字节, 分片不连续", chunk.Seq, len(data))
+		}
+		data = append(data, chunk.Data...)
+		err = this.send(message.FileAckMesType, message.FileAckMes{FileId: fileId, Seq: chunk.Seq, Code: 200})
+		if err != nil || chunk.Last {
+			return
+		}
+	}
+}
+
+//接收方在线时同意后实时转发, 离线文件上传途中同意会被拒绝, 上传完成后作为离线文件获取
+func TestFileTransfer(t *testing.T) {
+	a := loginNewUser(t)
+	b := loginNewUser(t)
+	data := []byte(strings.Repeat("文件内容 file ", 500))
+
+	//在线: 先同意, 再上传, 分片转发给b
+	resMes, err := a.offerFile(b.UserId, "online.txt", data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectCode(t, "发起文件传输", resMes.Code, 200)
+	if resMes.Offline {
+		t.Fatalf("接收方在线, 不应该是离线文件")
+	}
+	fileId := resMes.FileId
+	var offerMes message.FileOfferMes
+	err = b.expect(message.FileOfferMesType, &offerMes, func() bool {
+		return offerMes.FileId == fileId
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := b.send(message.FileAnswerMesType, message.FileAnswerMes{FileId: fileId, Accept: true}); err != nil {
+		t.Fatal(err)
+	}
+	var answerMes message.FileAnswerMes
+	if err := a.expect(message.FileAnswerMesType, &answerMes, nil); err != nil {
+		t.Fatal(err)
+	}
+	received := make(chan []byte, 1)
+	go func() {
+		data, err := b.receiveFile(fileId)
+		if err != nil {
+			t.Error(err)
+		}
+		received <- data
+	}()
+	for seq := 0; seq*message.FileChunkSize < len(data); seq++ {
+		ackMes, err := a.uploadChunk(fileId, data, seq)
+		if err != nil {
+			t.Fatal(err)
+		}
+		expectCode(t, "上传分片", ackMes.Code, 200)
+	}
+	if got := <-received; !bytes.Equal(got, data) {
+		t.Fatalf("实时转发收到%d 字节, 应该是%d 字节", len(got), len(data))
+	}
+
+	//离线: 上传途中接收方上线并同意, 不能只收到后面的分片
+	c := connect(t)
+	cId := newUserId()
+	if _, err := c.register(cId, "123456"); err != nil {
+		t.Fatal(err)
+	}
+	resMes, err = a.offerFile(cId, "offline.txt", data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !resMes.Offline {
+		t.Fatalf("接收方不在线, 应该是离线文件")
+	}
+	fileId = resMes.FileId
+	ackMes, err := a.uploadChunk(fileId, data, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectCode(t, "上传第一个分片", ackMes.Code, 200)
+
+	if _, err := c.login(cId, "123456"); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.send(message.FileAnswerMesType, message.FileAnswerMes{FileId: fileId, Accept: true}); err != nil {
+		t.Fatal(err)
+	}
+	err = c.expect(message.FileAckMesType, &ackMes, func() bool {
+		return ackMes.FileId == fileId
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectCode(t, "上传途中同意", ackMes.Code, 409)
+
+	for seq := 1; seq*message.FileChunkSize < len(data); seq++ {
+		ackMes, err := a.uploadChunk(fileId, data, seq)
+		if err != nil {
+			t.Fatal(err)
+		}
+		expectCode(t, "上传分片", ackMes.Code, 200)
+	}
+	if err := c.send(message.FileFetchMesType, message.FileFetchMes{FileId: fileId}); err != nil {
+		t.Fatal(err)
+	}
+	got, err := c.receiveFile(fileId)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, data) {
+		t.Fatalf("离线文件收到%d 字节, 应该是%d 字节", len(got), len(data))
+	}
+}
@@ -0,0 +1,239 @@
+package e2e
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	"go_code/chatroom/common/message"
+	"go_code/chatroom/server/utils"
+)
+
+func (this *client) hello(helloMes message.HelloMes) (helloResMes message.HelloResMes, err error) {
+	err = this.send(message.HelloMesType, helloMes)
+	if err != nil {
+		return
+	}
+	err = this.expect(message.HelloResMesType, &helloResMes, nil)
+	if err == nil && helloResMes.Code == 200 && helloResMes.Compression != message.CompressionNone {
+		utils.EnableCompression(this.Conn)
+	}
+	return
+}
+
+//版本不兼容时服务器回复426 后断开连接, 不处理后面的消息
+func (this *client) expectRejected(helloMes message.HelloMes) (err error) {
+	err = this.send(message.HelloMesType, helloMes)
+	if err != nil {
+		return
+	}
+	//和真正的客户端一样, 不等回复就发出登录请求
+	err = this.send(message.LoginMesType, message.LoginMes{UserId: 1, UserPwd: "123456"})
+	if err != nil {
+		return
+	}
+	var helloResMes message.HelloResMes
+	err = this.expect(message.HelloResMesType, &helloResMes, nil)
+	if err != nil {
+		return
+	}
+	if helloResMes.Code != 426 {
+		return fmt.Errorf("不兼容的握手: code=%d, 期望426", helloResMes.Code)
+	}
+	if helloResMes.Error == "" {
+		return fmt.Errorf("426 的回复中应该说明原因")
+	}
+	this.Conn.SetReadDeadline(time.Now().Add(replyTimeout))
+	mes, err := this.tf.ReadPkg()
+	if err == nil {
+		return fmt.Errorf("被拒绝后不应该再收到消息 %s", mes.Type)
+	}
+	if !errors.Is(err, io.EOF) {
+		return fmt.Errorf("被拒绝后服务器应该断开连接, err=%v", err)
+	}
+	return nil
+}
+
+//握手协商版本和功能, 不兼容的客户端被拒绝, 没有握手的老客户端可以正常使用(其它用例都没有握手)
+func TestHello(t *testing.T) {
+	c := connect(t)
+	helloResMes, err := c.hello(message.HelloMes{
+		Version:      message.ProtocolVersion + 1,
+		MinVersion:   message.ProtocolLegacy,
+		Codecs:       []string{"msgpack", message.CodecJSON},
+		Compressions: []string{"zstd"},
+		Features:     []string{"video", message.FeatureRooms, message.FeatureE2E},
+		Client:       "e2e",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectCode(t, "握手", helloResMes.Code, 200)
+	if helloResMes.Version != message.ProtocolVersion || helloResMes.Codec != message.CodecJSON ||
+		helloResMes.Compression != message.CompressionNone {
+		t.Fatalf("协商的结果不正确 %+v", helloResMes)
+	}
+	if strings.Join(helloResMes.Features, ",") != "rooms,e2e" {
+		t.Fatalf("协商的功能是%v, 应该是[rooms e2e]", helloResMes.Features)
+	}
+	//握手之后可以正常注册和登录
+	userId := newUserId()
+	code, err := c.register(userId, "123456")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectCode(t, "握手后注册", code, 200)
+	loginResMes, err := c.login(userId, "123456")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectCode(t, "握手后登录", loginResMes.Code, 200)
+	//只能握手一次
+	helloResMes, err = c.hello(message.HelloMes{Version: message.ProtocolVersion})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectCode(t, "重复握手", helloResMes.Code, 400)
+
+	rejected := []message.HelloMes{
+		{Version: 99, MinVersion: 99},
+		{Version: message.ProtocolVersion, Codecs: []string{"protobuf"}},
+		{Version: 0},
+	}
+	for _, helloMes := range rejected {
+		c, err := dial(serverAddr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = c.expectRejected(helloMes)
+		c.Close()
+		if err != nil {
+			t.Fatalf("%+v: %v", helloMes, err)
+		}
+	}
+}
+
+//握手时只协商了features的客户端, 注册并登录
+func helloAndLogin(t *testing.T, features []string) *client {
+	t.Helper()
+	c := connect(t)
+	helloResMes, err := c.hello(message.HelloMes{
+		Version:    message.ProtocolVersion,
+		MinVersion: message.ProtocolLegacy,
+		Features:   features,
+		Client:     "e2e",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectCode(t, "握手", helloResMes.Code, 200)
+	userId := newUserId()
+	code, err := c.register(userId, "123456")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectCode(t, "注册", code, 200)
+	loginResMes, err := c.login(userId, "123456")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectCode(t, "登录", loginResMes.Code, 200)
+	return c
+}
+
+//没有协商的功能回复426, 不断开连接, 不需要这个功能的消息照常处理
+func TestFeatureNotNegotiated(t *testing.T) {
+	c := helloAndLogin(t, nil)
+	other := loginNewUser(t)
+
+	err := c.send(message.PublishKeyMesType, message.PublishKeyMes{PublicKey: make([]byte, 32)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var publishKeyResMes message.PublishKeyResMes
+	if err = c.expect(message.PublishKeyResMesType, &publishKeyResMes, nil); err != nil {
+		t.Fatal(err)
+	}
+	expectCode(t, "没有协商e2e时发布公钥", publishKeyResMes.Code, 426)
+
+	err = c.send(message.GetKeyMesType, message.GetKeyMes{UserId: other.UserId})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var getKeyResMes message.GetKeyResMes
+	if err = c.expect(message.GetKeyResMesType, &getKeyResMes, nil); err != nil {
+		t.Fatal(err)
+	}
+	expectCode(t, "没有协商e2e时查询公钥", getKeyResMes.Code, 426)
+	if getKeyResMes.UserId != other.UserId {
+		t.Fatalf("426 的回复中userId=%d, 期望%d", getKeyResMes.UserId, other.UserId)
+	}
+
+	err = c.send(message.SmsMesType, message.SmsMes{
+		Content:  "密文",
+		LocalId:  1,
+		ToUserId: other.UserId,
+		E2E:      &message.E2EInfo{},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var smsResMes message.SmsResMes
+	if err = c.expect(message.SmsResMesType, &smsResMes, nil); err != nil {
+		t.Fatal(err)
+	}
+	expectCode(t, "没有协商e2e时发送加密消息", smsResMes.Code, 426)
+	if smsResMes.LocalId != 1 {
+		t.Fatalf("426 的回复中localId=%d, 期望1", smsResMes.LocalId)
+	}
+	if err = other.expectNone(message.SmsMesType, 200*time.Millisecond); err != nil {
+		t.Fatal(err)
+	}
+
+	err = c.send(message.JoinRoomMesType, message.JoinRoomMes{RoomId: "features"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var joinRoomResMes message.JoinRoomResMes
+	if err = c.expect(message.JoinRoomResMesType, &joinRoomResMes, nil); err != nil {
+		t.Fatal(err)
+	}
+	expectCode(t, "没有协商rooms时进入房间", joinRoomResMes.Code, 426)
+
+	err = c.send(message.HistoryMesType, message.HistoryMes{RoomId: "features"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var historyResMes message.HistoryResMes
+	if err = c.expect(message.HistoryResMesType, &historyResMes, nil); err != nil {
+		t.Fatal(err)
+	}
+	expectCode(t, "没有协商rooms时查询房间记录", historyResMes.Code, 426)
+	if !historyResMes.Last {
+		t.Fatalf("426 的回复应该是最后一个HistoryResMes")
+	}
+
+	//连接没有断开, 明文私聊不需要协商功能
+	smsResMes, err = c.sendSms(other.UserId, "明文")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectCode(t, "明文私聊", smsResMes.Code, 200)
+
+	//只协商了rooms的客户端可以进入房间, 不能发布公钥
+	c = helloAndLogin(t, []string{message.FeatureRooms})
+	if err = c.joinRoom("features"); err != nil {
+		t.Fatal(err)
+	}
+	err = c.send(message.PublishKeyMesType, message.PublishKeyMes{PublicKey: make([]byte, 32)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = c.expect(message.PublishKeyResMesType, &publishKeyResMes, nil); err != nil {
+		t.Fatal(err)
+	}
+	expectCode(t, "只协商rooms时发布公钥", publishKeyResMes.Code, 426)
+}
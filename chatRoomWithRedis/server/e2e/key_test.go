@@ -0,0 +1,125 @@
+package e2e
+
+import (
+	"bytes"
+	"fmt"
+	"sync"
+	"testing"
+
+	"go_code/chatroom/common/message"
+	"go_code/chatroom/server/model"
+)
+
+//发布公钥并等待结果
+func (this *client) publishKey(publicKey []byte) (resMes message.PublishKeyResMes, err error) {
+	if err = this.send(message.PublishKeyMesType, message.PublishKeyMes{PublicKey: publicKey}); err != nil {
+		return
+	}
+	err = this.expect(message.PublishKeyResMesType, &resMes, nil)
+	return
+}
+
+//查询公钥并等待结果
+func (this *client) getKey(userId int) (resMes message.GetKeyResMes, err error) {
+	if err = this.send(message.GetKeyMesType, message.GetKeyMes{UserId: userId}); err != nil {
+		return
+	}
+	err = this.expect(message.GetKeyResMesType, &resMes, func() bool {
+		return resMes.UserId == userId
+	})
+	return
+}
+
+//服务器保存和返回公钥, 不合法的公钥, 没有发布过公钥和不存在的用户
+func TestPublishKey(t *testing.T) {
+	a := loginNewUser(t)
+	b := loginNewUser(t)
+	publicKey := bytes.Repeat([]byte{7}, 32)
+
+	anonymous := connect(t)
+	publishKeyResMes, err := anonymous.publishKey(publicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectCode(t, "未登录时发布公钥", publishKeyResMes.Code, 403)
+	getKeyResMes, err := anonymous.getKey(a.UserId)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectCode(t, "未登录时查询公钥", getKeyResMes.Code, 403)
+
+	publishKeyResMes, err = a.publishKey(publicKey[:31])
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectCode(t, "发布长度不对的公钥", publishKeyResMes.Code, 400)
+	getKeyResMes, err = b.getKey(a.UserId)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectCode(t, "还没有发布公钥", getKeyResMes.Code, 404)
+
+	publishKeyResMes, err = a.publishKey(publicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectCode(t, "发布公钥", publishKeyResMes.Code, 200)
+	getKeyResMes, err = b.getKey(a.UserId)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectCode(t, "查询公钥", getKeyResMes.Code, 200)
+	if !bytes.Equal(getKeyResMes.PublicKey, publicKey) {
+		t.Fatalf("查询到的公钥是%v, 期望%v", getKeyResMes.PublicKey, publicKey)
+	}
+	//发布公钥不影响用户的其它信息
+	loginResMes, err := connect(t).login(a.UserId, "123456")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectCode(t, "发布公钥后登录", loginResMes.Code, 200)
+
+	getKeyResMes, err = b.getKey(newUserId())
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectCode(t, "查询不存在的用户的公钥", getKeyResMes.Code, 500)
+}
+
+//同时设置角色和公钥, 两个修改都不会丢失
+func TestUpdateUserConcurrent(t *testing.T) {
+	c := loginNewUser(t)
+	const n = 500
+	var wg sync.WaitGroup
+	errs := make(chan error, 2*n)
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		for i := 1; i <= n; i++ {
+			errs <- model.MyUserDao.SetRole(c.UserId, i%2+1)
+		}
+	}()
+	go func() {
+		defer wg.Done()
+		for i := 1; i <= n; i++ {
+			errs <- model.MyUserDao.SetPublicKey(c.UserId, []byte(fmt.Sprintf("%032d", i)))
+		}
+	}()
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	user, err := model.MyUserDao.GetUserById(c.UserId)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if user.Role != n%2+1 || !bytes.Equal(user.PublicKey, []byte(fmt.Sprintf("%032d", n))) {
+		t.Fatalf("同时修改以后role=%d publicKey=%s", user.Role, user.PublicKey)
+	}
+	if err = model.MyUserDao.SetRole(newUserId(), message.RoleAdmin); err != model.ERROR_USER_NOTEXISTS {
+		t.Fatalf("设置不存在的用户的角色返回%v, 期望ERROR_USER_NOTEXISTS", err)
+	}
+}
@@ -0,0 +1,110 @@
+package e2e
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"sync/atomic"
+	"testing"
+
+	"go_code/chatroom/server/chatserver"
+	"go_code/chatroom/server/events"
+	"go_code/chatroom/server/logger"
+	"go_code/chatroom/server/memredis"
+)
+
+//端到端的集成测试
+//在随机端口启动一个完整的服务器, redis 使用内存中的 memredis, 不需要任何外部服务
+//然后用测试客户端按协议收发消息, 检查服务器的回复
+//运行: go test go_code/chatroom/server/e2e [-run 名字] [-v] [-debug]
+
+var debug = flag.Bool("debug", false, "输出服务器的debug日志")
+
+//所有用例共享的服务器
+var (
+	serverAddr string
+	store      *memredis.Store
+	//每个用例使用不同的用户id, 避免互相影响
+	lastUserId int64 = 1000
+)
+
+func newUserId() int {
+	return int(atomic.AddInt64(&lastUserId, 1))
+}
+
+//连接服务器, 用例结束时自动关闭
+func connect(t *testing.T) *client {
+	t.Helper()
+	c, err := dial(serverAddr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(c.Close)
+	return c
+}
+
+//注册并登录一个新用户, 用例结束时自动关闭
+func loginNewUser(t *testing.T) *client {
+	t.Helper()
+	c := connect(t)
+	userId := newUserId()
+	code, err := c.register(userId, "123456")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if code != 200 {
+		t.Fatalf("注册用户%d 失败 code=%d", userId, code)
+	}
+	loginResMes, err := c.login(userId, "123456")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if loginResMes.Code != 200 {
+		t.Fatalf("用户%d 登录失败 code=%d", userId, loginResMes.Code)
+	}
+	return c
+}
+
+func TestMain(m *testing.M) {
+	flag.Parse()
+	level := "error"
+	if *debug {
+		level = "debug"
+	}
+	logger.Init(level, "text")
+
+	code, err := runTests(m)
+	if err != nil {
+		fmt.Println("启动服务器失败 err=", err)
+		os.Exit(1)
+	}
+	os.Exit(code)
+}
+
+//启动服务器并运行所有的用例
+func runTests(m *testing.M) (code int, err error) {
+	fileDir, err := os.MkdirTemp("", "chatroom-e2e")
+	if err != nil {
+		return
+	}
+	defer os.RemoveAll(fileDir)
+
+	store = memredis.NewStore()
+	server := chatserver.NewServer(store.NewPool(), fileDir)
+	server.Plugins, err = newPluginManager()
+	if err != nil {
+		return
+	}
+	events.SetPublisher(fakeEvents)
+	defer events.SetPublisher(nil)
+
+	err = server.Listen("127.0.0.1:0")
+	if err != nil {
+		return
+	}
+	go server.Serve()
+	defer server.Close()
+
+	serverAddr = server.Addr().String()
+	return m.Run(), nil
+}
@@ -0,0 +1,123 @@
+package e2e
+
+import (
+	"bufio"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+
+	"go_code/chatroom/common/message"
+	"go_code/chatroom/server/metrics"
+)
+
+//抓取一次指标, 返回每个序列的值, 比如 chat_messages_received_total{type="SmsMes"} -> 3
+//同时检查每个指标都有HELP和TYPE
+func scrape(t *testing.T, url string) map[string]float64 {
+	t.Helper()
+	resp, err := http.Get(url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK || !strings.HasPrefix(resp.Header.Get("Content-Type"), "text/plain; version=0.0.4") {
+		t.Fatalf("抓取指标返回%d %s", resp.StatusCode, resp.Header.Get("Content-Type"))
+	}
+	values := make(map[string]float64)
+	types := make(map[string]string)
+	scanner := bufio.NewScanner(resp.Body)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if strings.HasPrefix(line, "# TYPE ") {
+			fields := strings.Fields(line)
+			types[fields[2]] = fields[3]
+			continue
+		}
+		if strings.HasPrefix(line, "#") {
+			continue
+		}
+		i := strings.LastIndex(line, " ")
+		if i < 0 {
+			t.Fatalf("格式错误的行: %q", line)
+		}
+		value, err := strconv.ParseFloat(line[i+1:], 64)
+		if err != nil {
+			t.Fatalf("格式错误的行: %q", line)
+		}
+		series := line[:i]
+		name := series
+		if j := strings.Index(name, "{"); j >= 0 {
+			name = name[:j]
+		}
+		if types[name] == "" {
+			//直方图的序列名是指标名加上_bucket, _sum 和 _count
+			base := strings.TrimSuffix(strings.TrimSuffix(strings.TrimSuffix(name, "_bucket"), "_sum"), "_count")
+			if types[base] != "histogram" {
+				t.Fatalf("%s 前面没有TYPE", series)
+			}
+		}
+		values[series] = value
+	}
+	if err = scanner.Err(); err != nil {
+		t.Fatal(err)
+	}
+	return values
+}
+
+//登录和发消息以后, /metrics 中的计数增加, 直方图的桶是累加的
+func TestMetrics(t *testing.T) {
+	server := httptest.NewServer(metrics.Handler())
+	defer server.Close()
+	before := scrape(t, server.URL)
+
+	a := loginNewUser(t)
+	b := loginNewUser(t)
+	if _, err := a.sendSms(0, "大家好"); err != nil {
+		t.Fatal(err)
+	}
+	smsResMes, err := a.sendSms(b.UserId, "你好")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectCode(t, "私聊", smsResMes.Code, 200)
+	after := scrape(t, server.URL)
+
+	increased := map[string]float64{
+		`chat_messages_received_total{type="` + message.RegisterMesType + `"}`: 2,
+		`chat_messages_received_total{type="` + message.LoginMesType + `"}`:    2,
+		`chat_messages_received_total{type="` + message.SmsMesType + `"}`:      2,
+		`chat_fanout_duration_seconds_count{kind="group"}`:                     1,
+		`chat_fanout_duration_seconds_count{kind="private"}`:                   1,
+		//没有握手的测试客户端
+		`chat_protocol_clients_total{version="1"}`: 2,
+	}
+	for series, delta := range increased {
+		if after[series]-before[series] < delta {
+			t.Fatalf("%s 从%v 变成%v, 期望至少增加%v", series, before[series], after[series], delta)
+		}
+	}
+	if after["chat_active_connections"] < 2 {
+		t.Fatalf("chat_active_connections 是%v, 期望至少2", after["chat_active_connections"])
+	}
+
+	//直方图: 桶按le累加, +Inf 等于count
+	for _, direction := range []string{"in", "out"} {
+		labels := `{direction="` + direction + `"`
+		count := after["chat_frame_bytes_count"+labels+"}"]
+		if count == 0 || after["chat_frame_bytes_sum"+labels+"}"] == 0 {
+			t.Fatalf("%s 方向没有数据包", direction)
+		}
+		last := 0.0
+		for _, bound := range metrics.ExponentialBuckets(64, 2, 12) {
+			value, ok := after["chat_frame_bytes_bucket"+labels+`,le="`+strconv.FormatFloat(bound, 'g', -1, 64)+`"}`]
+			if !ok || value < last {
+				t.Fatalf("%s 方向le=%v 的桶是%v, 前一个桶是%v", direction, bound, value, last)
+			}
+			last = value
+		}
+		if inf := after["chat_frame_bytes_bucket"+labels+`,le="+Inf"}`]; inf != count || inf < last {
+			t.Fatalf("%s 方向+Inf 的桶是%v, count是%v", direction, inf, count)
+		}
+	}
+}
@@ -0,0 +1,248 @@
+package e2e
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/garyburd/redigo/redis"
+	"go_code/chatroom/common/message"
+	"go_code/chatroom/server/model"
+)
+
+//登录一个新用户并设置角色
+func loginWithRole(t *testing.T, role int) *client {
+	t.Helper()
+	c := loginNewUser(t)
+	if err := model.MyUserDao.SetRole(c.UserId, role); err != nil {
+		t.Fatal(err)
+	}
+	return c
+}
+
+//发送管理操作并等待结果
+func (this *client) moderate(moderateMes message.ModerateMes) (resMes message.ModerateResMes, err error) {
+	if err = this.send(message.ModerateMesType, moderateMes); err != nil {
+		return
+	}
+	err = this.expect(message.ModerateResMesType, &resMes, nil)
+	return
+}
+
+//只有管理员能按ip封禁, 同一个ip上角色不低于操作人的用户不会被断开
+func TestModerateIpBan(t *testing.T) {
+	admin := loginWithRole(t, message.RoleAdmin)
+	otherAdmin := loginWithRole(t, message.RoleAdmin)
+	moderator := loginWithRole(t, message.RoleModerator)
+	user := loginNewUser(t)
+
+	//测试客户端都在本机, 用例结束后解除封禁, 不影响后面的用例
+	ip := "127.0.0.1"
+	t.Cleanup(func() {
+		model.MyModerationDao.UnbanIp(ip)
+	})
+
+	resMes, err := moderator.moderate(message.ModerateMes{Action: message.ModerateBan, Ip: ip, Duration: 60})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectCode(t, "版主按ip封禁", resMes.Code, 401)
+
+	resMes, err = admin.moderate(message.ModerateMes{Action: message.ModerateBan, Ip: ip, Duration: 60})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectCode(t, "管理员按ip封禁", resMes.Code, 200)
+	for _, c := range []*client{moderator, user} {
+		if err := expectClosed(c); err != nil {
+			t.Fatalf("用户%d: %v", c.UserId, err)
+		}
+	}
+	//另一个管理员和操作人自己还在线
+	for _, c := range []*client{admin, otherAdmin} {
+		smsResMes, err := c.sendSms(0, "还在吗")
+		if err != nil {
+			t.Fatal(err)
+		}
+		expectCode(t, "封禁后管理员发消息", smsResMes.Code, 200)
+	}
+}
+
+//等待管理操作的通知
+func (this *client) expectModerate(action string) (moderateMes message.ModerateMes, err error) {
+	err = this.expect(message.ModerateMesType, &moderateMes, func() bool {
+		return moderateMes.Action == action
+	})
+	return
+}
+
+//某个操作人的审计日志, 按时间从早到晚
+func auditLogs(t *testing.T, operatorId int) (logs []model.AuditLog) {
+	t.Helper()
+	conn := store.NewPool().Get()
+	defer conn.Close()
+	list, err := redis.Strings(conn.Do("LRange", "audit", 0, -1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, data := range list {
+		var log model.AuditLog
+		if err := json.Unmarshal([]byte(data), &log); err != nil {
+			t.Fatal(err)
+		}
+		if log.OperatorId == operatorId {
+			logs = append(logs, log)
+		}
+	}
+	return
+}
+
+//禁言以后发消息返回407, 解除以后恢复, 踢人断开对方的连接, 每个成功的操作都写审计日志
+func TestModerateMuteKick(t *testing.T) {
+	moderator := loginWithRole(t, message.RoleModerator)
+	user := loginNewUser(t)
+	observer := loginNewUser(t)
+
+	resMes, err := moderator.moderate(message.ModerateMes{Action: message.ModerateMute, UserId: user.UserId,
+		Duration: 60, Reason: "刷屏"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectCode(t, "禁言", resMes.Code, 200)
+	moderateMes, err := user.expectModerate(message.ModerateMute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if moderateMes.OperatorId != moderator.UserId || moderateMes.Reason != "刷屏" {
+		t.Fatalf("被禁言的用户收到的通知是%+v", moderateMes)
+	}
+	for _, toUserId := range []int{0, moderator.UserId} {
+		smsResMes, err := user.sendSms(toUserId, "还能说话吗")
+		if err != nil {
+			t.Fatal(err)
+		}
+		expectCode(t, "被禁言时发消息", smsResMes.Code, 407)
+		if !strings.Contains(smsResMes.Error, "秒解除") {
+			t.Fatalf("407 的回复中没有剩余时间: %q", smsResMes.Error)
+		}
+	}
+
+	resMes, err = moderator.moderate(message.ModerateMes{Action: message.ModerateUnmute, UserId: user.UserId})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectCode(t, "解除禁言", resMes.Code, 200)
+	smsResMes, err := user.sendSms(0, "可以说话了")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectCode(t, "解除禁言后发消息", smsResMes.Code, 200)
+
+	resMes, err = moderator.moderate(message.ModerateMes{Action: message.ModerateKick, UserId: user.UserId, Reason: "警告"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectCode(t, "踢人", resMes.Code, 200)
+	if _, err = user.expectModerate(message.ModerateKick); err != nil {
+		t.Fatal(err)
+	}
+	if err = expectClosed(user); err != nil {
+		t.Fatal(err)
+	}
+	if err = observer.expectStatus(user.UserId, message.UserOffline); err != nil {
+		t.Fatal(err)
+	}
+	//被踢以后可以重新登录
+	loginResMes, err := connect(t).login(user.UserId, "123456")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectCode(t, "被踢后登录", loginResMes.Code, 200)
+
+	logs := auditLogs(t, moderator.UserId)
+	if len(logs) != 3 {
+		t.Fatalf("审计日志有%d 条, 期望3条: %+v", len(logs), logs)
+	}
+	for i, action := range []string{message.ModerateMute, message.ModerateUnmute, message.ModerateKick} {
+		if logs[i].Action != action || logs[i].UserId != user.UserId || logs[i].Time == 0 {
+			t.Fatalf("第%d 条审计日志是%+v, 期望%s", i, logs[i], action)
+		}
+	}
+	if logs[0].Duration != 60 || logs[0].Reason != "刷屏" || logs[2].Reason != "警告" {
+		t.Fatalf("审计日志中没有时长和原因: %+v", logs)
+	}
+}
+
+//只能管理角色比自己低的用户, 没有权限的操作不执行也不写审计日志
+func TestModeratePermission(t *testing.T) {
+	admin := loginWithRole(t, message.RoleAdmin)
+	otherAdmin := loginWithRole(t, message.RoleAdmin)
+	moderator := loginWithRole(t, message.RoleModerator)
+	otherModerator := loginWithRole(t, message.RoleModerator)
+	user := loginNewUser(t)
+	otherUser := loginNewUser(t)
+
+	denied := []struct {
+		what     string
+		operator *client
+		moderate message.ModerateMes
+	}{
+		{"普通用户禁言", user, message.ModerateMes{Action: message.ModerateMute, UserId: otherUser.UserId, Duration: 60}},
+		{"版主禁言版主", moderator, message.ModerateMes{Action: message.ModerateMute, UserId: otherModerator.UserId, Duration: 60}},
+		{"版主踢管理员", moderator, message.ModerateMes{Action: message.ModerateKick, UserId: admin.UserId}},
+		{"版主设置角色", moderator, message.ModerateMes{Action: message.ModerateRole, UserId: user.UserId, Role: message.RoleModerator}},
+		{"管理员封禁管理员", admin, message.ModerateMes{Action: message.ModerateBan, UserId: otherAdmin.UserId, Duration: 60}},
+		{"管理员修改管理员的角色", admin, message.ModerateMes{Action: message.ModerateRole, UserId: otherAdmin.UserId, Role: message.RoleUser}},
+	}
+	for _, test := range denied {
+		resMes, err := test.operator.moderate(test.moderate)
+		if err != nil {
+			t.Fatal(err)
+		}
+		expectCode(t, test.what, resMes.Code, 401)
+	}
+	//被拒绝的操作没有执行
+	for _, c := range []*client{otherUser, otherModerator, admin, otherAdmin} {
+		smsResMes, err := c.sendSms(0, "还在")
+		if err != nil {
+			t.Fatal(err)
+		}
+		expectCode(t, "被拒绝的操作以后发消息", smsResMes.Code, 200)
+	}
+	if adminUser, err := model.MyUserDao.GetUserById(otherAdmin.UserId); err != nil || adminUser.Role != message.RoleAdmin {
+		t.Fatalf("管理员的角色被修改了: %+v %v", adminUser, err)
+	}
+
+	resMes, err := moderator.moderate(message.ModerateMes{Action: message.ModerateMute, UserId: newUserId(), Duration: 60})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectCode(t, "禁言不存在的用户", resMes.Code, 500)
+	resMes, err = moderator.moderate(message.ModerateMes{Action: message.ModerateMute, UserId: user.UserId})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectCode(t, "禁言没有时长", resMes.Code, 400)
+
+	//管理员可以把版主降为普通用户, 之后版主不能再禁言
+	resMes, err = admin.moderate(message.ModerateMes{Action: message.ModerateRole, UserId: moderator.UserId, Role: message.RoleUser})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectCode(t, "管理员修改版主的角色", resMes.Code, 200)
+	resMes, err = moderator.moderate(message.ModerateMes{Action: message.ModerateMute, UserId: user.UserId, Duration: 60})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectCode(t, "降级以后禁言", resMes.Code, 401)
+
+	for _, operator := range []*client{user, moderator} {
+		if logs := auditLogs(t, operator.UserId); len(logs) != 0 {
+			t.Fatalf("失败的操作写了审计日志: %+v", logs)
+		}
+	}
+	if logs := auditLogs(t, admin.UserId); len(logs) != 1 || logs[0].Action != message.ModerateRole ||
+		logs[0].UserId != moderator.UserId || logs[0].Role != message.RoleUser {
+		t.Fatalf("管理员的审计日志是%+v", logs)
+	}
+}
@@ -0,0 +1,176 @@
+package e2e
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"go_code/chatroom/common/message"
+	"go_code/chatroom/server/model"
+	"go_code/chatroom/server/plugin"
+	_ "go_code/chatroom/server/plugin/bots"
+)
+
+//测试用的插件配置, 机器人的id小于测试用户的id, 不会冲突
+const (
+	echoBotId   = 900
+	remindBotId = 901
+)
+
+var pluginConfig = `{"plugins": [
+	{"name": "echo", "config": {"botId": 900, "botName": "echo"}},
+	{"name": "remind", "config": {"botId": 901, "botName": "remind"}},
+	{"name": "filter", "config": {"words": ["坏词"]}},
+	{"name": "e2e-panic"}
+]}`
+
+func init() {
+	plugin.Register("e2e-panic", func() plugin.Plugin { return &panicPlugin{} })
+}
+
+//会panic的插件, 用来检查panic不会断开客户端的连接
+type panicPlugin struct {
+}
+
+func (this *panicPlugin) Init(host *plugin.Host, config json.RawMessage) error {
+	return host.RegisterCommand("boom", "panic", func(ctx *plugin.Context, smsMes *message.SmsMes, args string) {
+		panic("boom")
+	})
+}
+
+func (this *panicPlugin) OnMessage(ctx *plugin.Context, mes *message.Message) bool {
+	if mes.Type == message.SmsMesType && strings.Contains(mes.Data, "panic!") {
+		panic("panic in OnMessage")
+	}
+	return true
+}
+
+func newPluginManager() (mgr *plugin.Manager, err error) {
+	var config plugin.Config
+	err = json.Unmarshal([]byte(pluginConfig), &config)
+	if err != nil {
+		return
+	}
+	mgr = plugin.NewManager()
+	err = mgr.Load(&config)
+	return
+}
+
+//斜杠命令由插件处理, 机器人的回复发到同一个房间, 插件可以修改消息, 插件panic不影响连接
+func TestPlugin(t *testing.T) {
+	a := loginNewUser(t)
+	b := loginNewUser(t)
+	room := fmt.Sprintf("plugin%d", a.UserId)
+	for _, c := range []*client{a, b} {
+		if err := c.joinRoom(room); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	smsResMes, err := a.sendSms(0, "/echo 你好")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectCode(t, "斜杠命令", smsResMes.Code, 200)
+	var smsMes message.SmsMes
+	err = b.expect(message.SmsMesType, &smsMes, func() bool {
+		return smsMes.UserId == echoBotId
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if smsMes.Content != "你好" || smsMes.RoomId != room {
+		t.Fatalf("机器人的回复不对: %+v", smsMes)
+	}
+
+	if _, err := a.sendSms(0, "这是坏词"); err != nil {
+		t.Fatal(err)
+	}
+	err = b.expect(message.SmsMesType, &smsMes, func() bool {
+		return smsMes.UserId == a.UserId
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if smsMes.Content != "这是**" {
+		t.Fatalf("敏感词没有过滤: %s", smsMes.Content)
+	}
+
+	//命令和OnMessage panic后, 连接还能继续使用, OnMessage panic的消息照常处理
+	if _, err := a.sendSms(0, "/boom"); err != nil {
+		t.Fatal(err)
+	}
+	if smsResMes, err = a.sendSms(0, "panic! 还在吗"); err != nil {
+		t.Fatal(err)
+	}
+	expectCode(t, "插件panic后发消息", smsResMes.Code, 200)
+	err = b.expect(message.SmsMesType, &smsMes, func() bool {
+		return smsMes.MesId == smsResMes.MesId
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	//斜杠命令本身不转发
+	if err := b.expectNone(message.SmsMesType, 100*time.Millisecond); err != nil {
+		t.Fatal(err)
+	}
+
+	//被禁言的用户不能用斜杠命令让机器人发言
+	if err := model.MyModerationDao.Mute(a.UserId, 60, "e2e"); err != nil {
+		t.Fatal(err)
+	}
+	if smsResMes, err = a.sendSms(0, "/echo 禁言了"); err != nil {
+		t.Fatal(err)
+	}
+	expectCode(t, "禁言后的斜杠命令", smsResMes.Code, 407)
+	if err := b.expectNone(message.SmsMesType, 100*time.Millisecond); err != nil {
+		t.Fatal(err)
+	}
+	if err := model.MyModerationDao.Unmute(a.UserId); err != nil {
+		t.Fatal(err)
+	}
+
+	//机器人不能登录
+	c := connect(t)
+	loginResMes, err := c.login(echoBotId, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectCode(t, "机器人登录", loginResMes.Code, 403)
+}
+
+//提醒机器人的 /remind 和客户端的 /remind 一样保存为定时任务, 到时间后收到ReminderMes
+func TestRemindBot(t *testing.T) {
+	a := loginNewUser(t)
+	smsResMes, err := a.sendSms(0, "/remind +1s 喝水")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectCode(t, "/remind", smsResMes.Code, 200)
+	var smsMes message.SmsMes
+	err = a.expect(message.SmsMesType, &smsMes, func() bool {
+		return smsMes.UserId == remindBotId
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var id int
+	if i := strings.Index(smsMes.Content, "/unschedule "); i < 0 {
+		t.Fatalf("机器人的回复不对: %s", smsMes.Content)
+	} else if _, err := fmt.Sscan(smsMes.Content[i+len("/unschedule "):], &id); err != nil {
+		t.Fatalf("机器人的回复中没有任务id: %s", smsMes.Content)
+	}
+
+	var reminderMes message.ReminderMes
+	err = a.expectScheduled(message.ReminderMesType, &reminderMes, func() bool {
+		return reminderMes.Id == id
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if reminderMes.Content != "喝水" {
+		t.Fatalf("提醒的内容不对: %+v", reminderMes)
+	}
+}
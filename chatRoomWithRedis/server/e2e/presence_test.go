@@ -0,0 +1,255 @@
+package e2e
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"go_code/chatroom/common/message"
+	process2 "go_code/chatroom/server/process"
+)
+
+//注册并登录一个新用户, 返回登录结果中的在线用户
+func loginWithUsers(t *testing.T) (c *client, users []message.User) {
+	t.Helper()
+	c = connect(t)
+	userId := newUserId()
+	if _, err := c.register(userId, "123456"); err != nil {
+		t.Fatal(err)
+	}
+	loginResMes, err := c.login(userId, "123456")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectCode(t, "登录", loginResMes.Code, 200)
+	return c, loginResMes.Users
+}
+
+func findUser(users []message.User, userId int) (user message.User, ok bool) {
+	for _, user = range users {
+		if user.UserId == userId {
+			return user, true
+		}
+	}
+	return
+}
+
+func (this *client) setStatus(status int, statusText string) error {
+	return this.send(message.SetStatusMesType, message.SetStatusMes{Status: status, StatusText: statusText})
+}
+
+//等待某个用户的状态和状态说明, 这个用户其它的状态通知忽略
+func (this *client) expectStatusText(userId int, status int, statusText string) error {
+	var notifyMes message.NotifyUserStatusMes
+	err := this.expect(message.NotifyUserStatusMesType, &notifyMes, func() bool {
+		return notifyMes.UserId == userId && notifyMes.Status == status && notifyMes.StatusText == statusText
+	})
+	if err != nil {
+		return fmt.Errorf("没有收到用户%d 的状态%d %q: %v", userId, status, statusText, err)
+	}
+	return nil
+}
+
+//在wait时间内不应该收到某个用户的状态通知, 其他用户的通知忽略
+func (this *client) expectNoStatus(userId int, wait time.Duration) error {
+	this.Conn.SetReadDeadline(time.Now().Add(wait))
+	defer this.Conn.SetReadDeadline(time.Time{})
+	for {
+		mes, err := this.tf.ReadPkg()
+		if err != nil {
+			if errors.Is(err, os.ErrDeadlineExceeded) {
+				return nil
+			}
+			return err
+		}
+		if mes.Type != message.NotifyUserStatusMesType {
+			continue
+		}
+		var notifyMes message.NotifyUserStatusMes
+		if err = json.Unmarshal([]byte(mes.Data), &notifyMes); err != nil {
+			return err
+		}
+		if notifyMes.UserId == userId {
+			return fmt.Errorf("用户%d 不应该收到用户%d 的状态: %s", this.UserId, userId, mes.Data)
+		}
+	}
+}
+
+//忙碌, 离开和隐身, 状态说明会通知其他人, 隐身的用户对其他人来说是离线
+func TestPresenceStatus(t *testing.T) {
+	a := loginNewUser(t)
+	b := loginNewUser(t)
+
+	if err := b.setStatus(message.UserBusyStatus, "开会中"); err != nil {
+		t.Fatal(err)
+	}
+	if err := a.expectStatusText(b.UserId, message.UserBusyStatus, "开会中"); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.setStatus(message.UserAway, "吃饭去了"); err != nil {
+		t.Fatal(err)
+	}
+	if err := a.expectStatusText(b.UserId, message.UserAway, "吃饭去了"); err != nil {
+		t.Fatal(err)
+	}
+	//后登录的用户在登录结果中看到状态和说明
+	c, users := loginWithUsers(t)
+	user, ok := findUser(users, b.UserId)
+	if !ok || user.UserStatus != message.UserAway || user.StatusText != "吃饭去了" {
+		t.Fatalf("登录结果中用户%d 是%+v", b.UserId, user)
+	}
+
+	//状态说明太长时截断, 按字符而不是字节
+	if err := b.setStatus(message.UserBusyStatus, strings.Repeat("忙", 100)); err != nil {
+		t.Fatal(err)
+	}
+	if err := a.expectStatusText(b.UserId, message.UserBusyStatus, strings.Repeat("忙", 64)); err != nil {
+		t.Fatal(err)
+	}
+	//无效的状态忽略
+	if err := b.setStatus(message.UserOffline, ""); err != nil {
+		t.Fatal(err)
+	}
+	if err := a.expectNoStatus(b.UserId, 200*time.Millisecond); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := b.setStatus(message.UserInvisible, "隐身的说明"); err != nil {
+		t.Fatal(err)
+	}
+	for _, observer := range []*client{a, c} {
+		if err := observer.expectStatusText(b.UserId, message.UserOffline, ""); err != nil {
+			t.Fatal(err)
+		}
+	}
+	//已经隐身时修改说明不通知别人
+	if err := b.setStatus(message.UserInvisible, "另一个说明"); err != nil {
+		t.Fatal(err)
+	}
+	if err := a.expectNoStatus(b.UserId, 200*time.Millisecond); err != nil {
+		t.Fatal(err)
+	}
+	_, users = loginWithUsers(t)
+	if user, ok = findUser(users, b.UserId); ok {
+		t.Fatalf("隐身的用户出现在登录结果中: %+v", user)
+	}
+	//隐身的用户输入时不通知别人
+	if err := b.send(message.TypingMesType, message.TypingMes{ToUserId: a.UserId}); err != nil {
+		t.Fatal(err)
+	}
+	if err := a.expectNone(message.TypingMesType, 200*time.Millisecond); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := b.setStatus(message.UserOnline, ""); err != nil {
+		t.Fatal(err)
+	}
+	if err := a.expectStatusText(b.UserId, message.UserOnline, ""); err != nil {
+		t.Fatal(err)
+	}
+}
+
+//一段时间没有操作的在线用户自动设置为离开, 有操作以后恢复在线
+//忙碌和手动设置的离开不受影响
+func TestAutoAway(t *testing.T) {
+	stop := process2.StartAwayChecker(300 * time.Millisecond)
+	defer stop()
+	a := loginNewUser(t)
+	b := loginNewUser(t)
+	busy := loginNewUser(t)
+	if err := busy.setStatus(message.UserBusyStatus, "勿扰"); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.setStatus(message.UserOnline, "在工位"); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := a.expectStatusText(b.UserId, message.UserOnline, "在工位"); err != nil {
+		t.Fatal(err)
+	}
+	//自动离开时保留状态说明
+	if err := a.expectStatusText(b.UserId, message.UserAway, "在工位"); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.send(message.TypingMesType, message.TypingMes{ToUserId: a.UserId}); err != nil {
+		t.Fatal(err)
+	}
+	if err := a.expectStatusText(b.UserId, message.UserOnline, "在工位"); err != nil {
+		t.Fatal(err)
+	}
+	_, users := loginWithUsers(t)
+	if user, _ := findUser(users, busy.UserId); user.UserStatus != message.UserBusyStatus {
+		t.Fatalf("忙碌的用户被自动设置为%d", user.UserStatus)
+	}
+
+	//手动设置的离开, 有操作以后也不恢复在线
+	//先等自动离开, 之后自动检查不会再修改b的状态
+	if err := a.expectStatusText(b.UserId, message.UserAway, "在工位"); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.setStatus(message.UserAway, "出去了"); err != nil {
+		t.Fatal(err)
+	}
+	if err := a.expectStatusText(b.UserId, message.UserAway, "出去了"); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.send(message.TypingMesType, message.TypingMes{ToUserId: a.UserId}); err != nil {
+		t.Fatal(err)
+	}
+	if err := a.expectNoStatus(b.UserId, 500*time.Millisecond); err != nil {
+		t.Fatal(err)
+	}
+}
+
+//同一个会话在TypingInterval内只转发一次, 不同的会话分别计算
+func TestTypingThrottle(t *testing.T) {
+	a := loginNewUser(t)
+	b := loginNewUser(t)
+	c := loginNewUser(t)
+	room := fmt.Sprintf("typing%d", a.UserId)
+	for _, user := range []*client{a, c} {
+		if err := user.joinRoom(room); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	expectTyping := func(to *client, toUserId int) {
+		t.Helper()
+		var typingMes message.TypingMes
+		err := to.expect(message.TypingMesType, &typingMes, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if typingMes.UserId != a.UserId || typingMes.ToUserId != toUserId {
+			t.Fatalf("收到的正在输入是%+v", typingMes)
+		}
+	}
+	if err := a.send(message.TypingMesType, message.TypingMes{ToUserId: b.UserId}); err != nil {
+		t.Fatal(err)
+	}
+	expectTyping(b, b.UserId)
+	if err := a.send(message.TypingMesType, message.TypingMes{ToUserId: b.UserId}); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.expectNone(message.TypingMesType, 200*time.Millisecond); err != nil {
+		t.Fatal(err)
+	}
+	//房间中的正在输入是另一个会话, 只发给同一个房间的用户
+	if err := a.send(message.TypingMesType, message.TypingMes{}); err != nil {
+		t.Fatal(err)
+	}
+	expectTyping(c, 0)
+	if err := b.expectNone(message.TypingMesType, 200*time.Millisecond); err != nil {
+		t.Fatal(err)
+	}
+
+	time.Sleep(message.TypingInterval * time.Second)
+	if err := a.send(message.TypingMesType, message.TypingMes{ToUserId: b.UserId}); err != nil {
+		t.Fatal(err)
+	}
+	expectTyping(b, b.UserId)
+}
@@ -0,0 +1,186 @@
+package e2e
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"go_code/chatroom/common/message"
+	"go_code/chatroom/server/model"
+)
+
+//发送送达或已读回执, 不等回复
+func (this *client) receipt(mesType string, mesIds ...int) error {
+	if mesType == message.DeliveredMesType {
+		return this.send(mesType, message.DeliveredMes{MesIds: mesIds})
+	}
+	return this.send(mesType, message.ReadMes{MesIds: mesIds})
+}
+
+//发送方等待回执, DeliveredMes 和 ReadMes 的结构一样, 都用DeliveredMes 解析
+func (this *client) expectReceipt(mesType string, from int, mesIds ...int) error {
+	var receiptMes message.DeliveredMes
+	err := this.expect(mesType, &receiptMes, nil)
+	if err != nil {
+		return err
+	}
+	if receiptMes.UserId != from || fmt.Sprint(receiptMes.MesIds) != fmt.Sprint(mesIds) {
+		return fmt.Errorf("收到的%s 是%+v, 期望用户%d 的%v", mesType, receiptMes, from, mesIds)
+	}
+	return nil
+}
+
+func expectMesStatus(t *testing.T, mesId int, status int) {
+	t.Helper()
+	smsMes, err := model.MyMessageDao.GetMessageById(mesId)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if smsMes.MesStatus != status {
+		t.Fatalf("消息%d 的状态是%d, 期望%d", mesId, smsMes.MesStatus, status)
+	}
+}
+
+//私聊消息的状态: 已发送 -> 已送达 -> 已读, 只能往前走, 回执转发给发送方
+func TestPrivateReceipt(t *testing.T) {
+	a := loginNewUser(t)
+	b := loginNewUser(t)
+	smsResMes, err := a.sendSms(b.UserId, "收到请回复")
+	if err != nil {
+		t.Fatal(err)
+	}
+	mesId := smsResMes.MesId
+	var smsMes message.SmsMes
+	err = b.expect(message.SmsMesType, &smsMes, func() bool {
+		return smsMes.MesId == mesId
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if smsMes.MesStatus != message.MesSent {
+		t.Fatalf("接收方收到的消息状态是%d", smsMes.MesStatus)
+	}
+	expectMesStatus(t, mesId, message.MesSent)
+
+	//不存在的消息忽略, 其它的正常处理
+	if err = b.receipt(message.DeliveredMesType, mesId+1000000, mesId); err != nil {
+		t.Fatal(err)
+	}
+	if err = a.expectReceipt(message.DeliveredMesType, b.UserId, mesId); err != nil {
+		t.Fatal(err)
+	}
+	expectMesStatus(t, mesId, message.MesDelivered)
+	//重复的回执不转发
+	if err = b.receipt(message.DeliveredMesType, mesId); err != nil {
+		t.Fatal(err)
+	}
+	if err = a.expectNone(message.DeliveredMesType, 200*time.Millisecond); err != nil {
+		t.Fatal(err)
+	}
+
+	if err = b.receipt(message.ReadMesType, mesId); err != nil {
+		t.Fatal(err)
+	}
+	if err = a.expectReceipt(message.ReadMesType, b.UserId, mesId); err != nil {
+		t.Fatal(err)
+	}
+	expectMesStatus(t, mesId, message.MesRead)
+	//已读以后不会退回已送达
+	if err = b.receipt(message.DeliveredMesType, mesId); err != nil {
+		t.Fatal(err)
+	}
+	if err = a.expectNone(message.DeliveredMesType, 200*time.Millisecond); err != nil {
+		t.Fatal(err)
+	}
+	expectMesStatus(t, mesId, message.MesRead)
+
+	//没有送达回执也可以直接已读
+	smsResMes, err = a.sendSms(b.UserId, "第二条")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = b.receipt(message.ReadMesType, smsResMes.MesId); err != nil {
+		t.Fatal(err)
+	}
+	if err = a.expectReceipt(message.ReadMesType, b.UserId, smsResMes.MesId); err != nil {
+		t.Fatal(err)
+	}
+	expectMesStatus(t, smsResMes.MesId, message.MesRead)
+
+	//聊天记录中是最新的状态
+	historyResMes, err := a.history(message.HistoryMes{ToUserId: b.UserId})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(historyResMes.Messages) != 2 || historyResMes.Messages[0].MesStatus != message.MesRead ||
+		historyResMes.Messages[1].MesStatus != message.MesRead {
+		t.Fatalf("聊天记录中的状态不对: %+v", historyResMes.Messages)
+	}
+}
+
+//只有私聊的接收方能回执, 发送方和其他人的回执忽略
+func TestReceiptNotRecipient(t *testing.T) {
+	a := loginNewUser(t)
+	b := loginNewUser(t)
+	outsider := loginNewUser(t)
+	smsResMes, err := a.sendSms(b.UserId, "只给b看")
+	if err != nil {
+		t.Fatal(err)
+	}
+	mesId := smsResMes.MesId
+
+	for _, c := range []*client{outsider, a} {
+		for _, mesType := range []string{message.DeliveredMesType, message.ReadMesType} {
+			if err = c.receipt(mesType, mesId); err != nil {
+				t.Fatal(err)
+			}
+			if err = a.expectNone(mesType, 200*time.Millisecond); err != nil {
+				t.Fatalf("用户%d 的回执: %v", c.UserId, err)
+			}
+		}
+	}
+	expectMesStatus(t, mesId, message.MesSent)
+
+	//没有登录的连接
+	anonymous := connect(t)
+	if err = anonymous.receipt(message.ReadMesType, mesId); err != nil {
+		t.Fatal(err)
+	}
+	if err = a.expectNone(message.ReadMesType, 200*time.Millisecond); err != nil {
+		t.Fatal(err)
+	}
+	expectMesStatus(t, mesId, message.MesSent)
+}
+
+//群聊和房间中的消息没有送达和已读, 回执忽略
+func TestRoomReceipt(t *testing.T) {
+	a := loginNewUser(t)
+	b := loginNewUser(t)
+	room := fmt.Sprintf("receipt%d", a.UserId)
+	for _, c := range []*client{a, b} {
+		if err := c.joinRoom(room); err != nil {
+			t.Fatal(err)
+		}
+	}
+	smsResMes, err := a.sendSms(0, "房间里的消息")
+	if err != nil {
+		t.Fatal(err)
+	}
+	mesId := smsResMes.MesId
+	var smsMes message.SmsMes
+	err = b.expect(message.SmsMesType, &smsMes, func() bool {
+		return smsMes.MesId == mesId
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, mesType := range []string{message.DeliveredMesType, message.ReadMesType} {
+		if err = b.receipt(mesType, mesId); err != nil {
+			t.Fatal(err)
+		}
+		if err = a.expectNone(mesType, 200*time.Millisecond); err != nil {
+			t.Fatal(err)
+		}
+	}
+	expectMesStatus(t, mesId, message.MesSent)
+}
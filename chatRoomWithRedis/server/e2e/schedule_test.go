@@ -0,0 +1,332 @@
+package e2e
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/garyburd/redigo/redis"
+	"go_code/chatroom/common/message"
+	"go_code/chatroom/server/delayqueue"
+)
+
+//定时任务到时间后, 服务器最多要过这么久才能处理完
+//SendAt 是秒, 加上轮询的间隔
+const scheduleWait = 3 * time.Second
+
+func (this *client) schedule(scheduleMes message.ScheduleMes) (resMes message.ScheduleResMes, err error) {
+	err = this.send(message.ScheduleMesType, scheduleMes)
+	if err != nil {
+		return
+	}
+	err = this.expect(message.ScheduleResMesType, &resMes, nil)
+	return
+}
+
+//等待定时任务的结果, 比普通的回复多等一会
+func (this *client) expectScheduled(mesType string, v interface{}, match func() bool) error {
+	defer func(timeout time.Duration) {
+		replyTimeout = timeout
+	}(replyTimeout)
+	replyTimeout += scheduleWait
+	return this.expect(mesType, v, match)
+}
+
+//定时发送群聊和私聊消息, 取消, 提醒和离线提醒
+func TestSchedule(t *testing.T) {
+	a := loginNewUser(t)
+	b := loginNewUser(t)
+	roomId := fmt.Sprintf("schedule%d", b.UserId)
+	if err := b.joinRoom(roomId); err != nil {
+		t.Fatal(err)
+	}
+
+	//参数不合法
+	resMes, err := a.schedule(message.ScheduleMes{Content: "过去", SendAt: time.Now().Unix() - 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectCode(t, "过去的时间", resMes.Code, 400)
+
+	sendAt := time.Now().Unix() + 1
+	group, err := a.schedule(message.ScheduleMes{Content: "定时群聊", RoomId: roomId, SendAt: sendAt})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectCode(t, "定时群聊", group.Code, 200)
+	cancelled, err := a.schedule(message.ScheduleMes{Content: "取消的私聊", ToUserId: b.UserId, SendAt: sendAt})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectCode(t, "定时私聊", cancelled.Code, 200)
+	//只能取消自己的任务
+	resMes, err = b.schedule(message.ScheduleMes{CancelId: cancelled.Id})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectCode(t, "取消别人的任务", resMes.Code, 404)
+	resMes, err = a.schedule(message.ScheduleMes{CancelId: cancelled.Id})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectCode(t, "取消任务", resMes.Code, 200)
+	remind, err := a.schedule(message.ScheduleMes{Kind: message.ScheduleRemind, Content: "开会", SendAt: sendAt})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectCode(t, "提醒", remind.Code, 200)
+
+	var smsMes message.SmsMes
+	err = b.expectScheduled(message.SmsMesType, &smsMes, func() bool {
+		return smsMes.Content == "定时群聊"
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if smsMes.UserId != a.UserId || smsMes.RoomId != roomId || smsMes.SendTime < sendAt {
+		t.Fatalf("定时群聊的内容不对: %+v", smsMes)
+	}
+	var reminderMes message.ReminderMes
+	err = a.expectScheduled(message.ReminderMesType, &reminderMes, func() bool {
+		return reminderMes.Id == remind.Id
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if reminderMes.Content != "开会" {
+		t.Fatalf("提醒的内容不对: %+v", reminderMes)
+	}
+	//取消的私聊不会发送
+	if err := b.expectNone(message.SmsMesType, time.Second); err != nil {
+		t.Fatal(err)
+	}
+
+	//不在线时到时间的提醒, 上线后推送
+	remind, err = b.schedule(message.ScheduleMes{Kind: message.ScheduleRemind, Content: "下线后提醒", SendAt: time.Now().Unix() + 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectCode(t, "离线提醒", remind.Code, 200)
+	b.Close()
+	time.Sleep(scheduleWait)
+	c := connect(t)
+	if _, err := c.login(b.UserId, "123456"); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.expect(message.ReminderMesType, &reminderMes, func() bool {
+		return reminderMes.Id == remind.Id
+	}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+//多个服务器同时取同一个队列, 每个任务只会被取到一次, 没有Ack的任务超时后重新取出
+func TestDelayQueue(t *testing.T) {
+	const count = 200
+	name := fmt.Sprintf("e2e%d", newUserId())
+	queue := delayqueue.New(store.NewPool(), name)
+	at := time.Now().Add(-time.Second)
+	for i := 0; i < count; i++ {
+		if _, err := queue.Add([]byte(fmt.Sprint(i)), at); err != nil {
+			t.Fatal(err)
+		}
+	}
+	later, err := queue.Add([]byte("later"), time.Now().Add(time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var lock sync.Mutex
+	claimed := make(map[int]int)
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			//每个协程用自己的Queue, 相当于不同的服务器
+			queue := delayqueue.New(store.NewPool(), name)
+			for {
+				items, err := queue.Claim(time.Now(), 7)
+				if err != nil || len(items) == 0 {
+					return
+				}
+				lock.Lock()
+				for _, item := range items {
+					claimed[item.Id]++
+				}
+				lock.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+	if len(claimed) != count {
+		t.Fatalf("取到了%d 个任务, 应该是%d 个", len(claimed), count)
+	}
+	for id, n := range claimed {
+		if n != 1 {
+			t.Fatalf("任务%d 被取到了%d 次", id, n)
+		}
+	}
+	if n, _ := queue.Len(); n != 1 {
+		t.Fatalf("队列中还剩%d 个任务, 应该只剩没到时间的1 个", n)
+	}
+
+	//没有Ack的任务超过可见性超时以后重新取出, 相当于取出它的服务器崩溃了
+	future := time.Now().Add(delayqueue.VisibilityTimeout + time.Second)
+	redelivered := 0
+	for {
+		items, err := queue.Claim(future, 50)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(items) == 0 {
+			break
+		}
+		for _, item := range items {
+			if claimed[item.Id] != 1 || string(item.Data) == "" {
+				t.Fatalf("重新取出的任务不对: %+v", item)
+			}
+			redelivered++
+			if err := queue.Ack(item.Id); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	if redelivered != count {
+		t.Fatalf("重新取出了%d 个任务, 应该是%d 个", redelivered, count)
+	}
+	//Ack 以后不再取出
+	items, err := queue.Claim(future.Add(delayqueue.VisibilityTimeout), count)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(items) != 0 {
+		t.Fatalf("Ack 以后又取出了%d 个任务", len(items))
+	}
+
+	ok, err := queue.Remove(later)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !ok {
+		t.Fatalf("没有到时间的任务应该可以取消")
+	}
+}
+
+//WATCH 的key在EXEC之前被别的连接修改时事务不执行, 延时队列靠这个保证一个任务只被取到一次
+func TestWatch(t *testing.T) {
+	pool := store.NewPool()
+	a, b := pool.Get(), pool.Get()
+	defer a.Close()
+	defer b.Close()
+	key := fmt.Sprintf("watch%d", newUserId())
+
+	if _, err := a.Do("Watch", key); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := b.Do("Set", key, "b"); err != nil {
+		t.Fatal(err)
+	}
+	a.Send("MULTI")
+	a.Send("Set", key, "a")
+	if _, err := redis.Values(a.Do("EXEC")); err != redis.ErrNil {
+		t.Fatalf("WATCH 的key被修改以后事务应该不执行, err=%v", err)
+	}
+
+	if _, err := a.Do("Watch", key); err != nil {
+		t.Fatal(err)
+	}
+	a.Send("MULTI")
+	a.Send("Set", key, "a")
+	if _, err := redis.Values(a.Do("EXEC")); err != nil {
+		t.Fatal(err)
+	}
+	if value, err := redis.String(b.Do("Get", key)); err != nil || value != "a" {
+		t.Fatalf("事务执行以后的值是%q, err=%v", value, err)
+	}
+}
+
+//处理失败的任务 Nack 以后按退避时间重新取出, 失败次数太多时丢弃
+func TestDelayQueueRetry(t *testing.T) {
+	name := fmt.Sprintf("retry%d", newUserId())
+	queue := delayqueue.New(store.NewPool(), name)
+	now := time.Now()
+	id, err := queue.Add([]byte("job"), now.Add(-time.Second))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for attempts := 1; attempts <= delayqueue.MaxRetries+1; attempts++ {
+		items, err := queue.Claim(now, 10)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(items) != 1 || items[0].Id != id {
+			t.Fatalf("第%d 次取到的任务不对: %v", attempts, items)
+		}
+		dropped, err := queue.Nack(id, now)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if dropped != (attempts > delayqueue.MaxRetries) {
+			t.Fatalf("第%d 次失败以后 dropped=%v", attempts, dropped)
+		}
+		if dropped {
+			break
+		}
+		//退避时间之前不会取出
+		delay := delayqueue.RetryDelay(attempts)
+		if items, _ := queue.Claim(now.Add(delay-time.Millisecond), 10); len(items) != 0 {
+			t.Fatalf("第%d 次失败以后%v 之前又取到了任务", attempts, delay)
+		}
+		now = now.Add(delay)
+	}
+	if n, _ := queue.Len(); n != 0 {
+		t.Fatalf("丢弃以后队列中还有%d 个任务", n)
+	}
+	if items, _ := queue.Claim(now.Add(delayqueue.VisibilityTimeout), 10); len(items) != 0 {
+		t.Fatalf("丢弃的任务又被取到了: %v", items)
+	}
+}
+
+//Poll 中handler返回错误时不Ack, 稍后重试
+func TestPollRetry(t *testing.T) {
+	name := fmt.Sprintf("poll%d", newUserId())
+	queue := delayqueue.New(store.NewPool(), name)
+	if _, err := queue.Add([]byte("job"), time.Now()); err != nil {
+		t.Fatal(err)
+	}
+	handled := make(chan int, 10)
+	attempts := 0
+	stop := queue.Poll(20*time.Millisecond, 10, func(item *delayqueue.Item) error {
+		attempts++
+		handled <- attempts
+		if attempts == 1 {
+			return fmt.Errorf("redis 出错")
+		}
+		return nil
+	})
+	defer stop()
+
+	timeout := time.After(delayqueue.RetryDelay(1) + 2*time.Second)
+	for want := 1; want <= 2; want++ {
+		select {
+		case n := <-handled:
+			if n != want {
+				t.Fatalf("第%d 次处理, 期望第%d 次", n, want)
+			}
+		case <-timeout:
+			t.Fatalf("处理失败的任务没有重试")
+		}
+	}
+	//成功以后Ack, 不会再处理
+	select {
+	case n := <-handled:
+		t.Fatalf("处理成功以后又处理了第%d 次", n)
+	case <-time.After(200 * time.Millisecond):
+	}
+	if n, _ := queue.Len(); n != 0 {
+		t.Fatalf("处理成功以后队列中还有%d 个任务", n)
+	}
+}
@@ -0,0 +1,128 @@
+package events
+
+import (
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+
+	"go_code/chatroom/common/message"
+	"go_code/chatroom/server/metrics"
+)
+
+//聊天事件流
+//服务器把登录, 下线, 聊天消息, 消息的修改删除回应, 管理操作作为JSON事件发布出去, 给其它服务使用
+//默认不发布, main 根据启动参数设置Kafka的Publisher, 测试时使用Fake
+
+//事件的类型
+const (
+	TypeLogin      = "login"
+	TypeLogout     = "logout"
+	TypeMessage    = "message"
+	TypeEdit       = "edit"
+	TypeDelete     = "delete"
+	TypeReact      = "react"
+	TypeModeration = "moderation"
+)
+
+type Event struct {
+	Type   string `json:"type"`
+	Time   int64  `json:"time"` //发生的时间, unix毫秒
+	UserId int    `json:"userId"` //登录下线的用户, 发消息的人, 或者管理操作的操作人
+	//消息相关的事件
+	Message *message.SmsMes `json:"message,omitempty"`
+	//管理操作
+	Moderation *message.ModerateMes `json:"moderation,omitempty"`
+}
+
+//事件的key, 同一个key的事件保证按顺序消费
+//群聊消息按房间, 私聊按会话, 其它按用户
+func (this *Event) Key() string {
+	switch {
+		case this.Message != nil && this.Message.ToUserId == 0:
+			return "room:" + this.Message.RoomId
+		case this.Message != nil:
+			a, b := this.Message.UserId, this.Message.ToUserId
+			if a > b {
+				a, b = b, a
+			}
+			return fmt.Sprintf("private:%d:%d", a, b)
+		case this.Moderation != nil && this.Moderation.UserId != 0:
+			return fmt.Sprintf("user:%d", this.Moderation.UserId)
+		default:
+			return fmt.Sprintf("user:%d", this.UserId)
+	}
+}
+
+//事件的发布者, Publish 不能阻塞聊天消息的处理
+type Publisher interface {
+	Publish(event *Event) error
+	Close() error
+}
+
+//不发布任何事件
+type nopPublisher struct {
+}
+
+func (nopPublisher) Publish(event *Event) error {
+	return nil
+}
+
+func (nopPublisher) Close() error {
+	return nil
+}
+
+var (
+	publisher Publisher = nopPublisher{}
+	lock      sync.RWMutex
+)
+
+//设置全局的Publisher, 为nil时不发布, 返回原来的Publisher
+func SetPublisher(p Publisher) (old Publisher) {
+	if p == nil {
+		p = nopPublisher{}
+	}
+	lock.Lock()
+	defer lock.Unlock()
+	old, publisher = publisher, p
+	return
+}
+
+//发布一个事件, 失败时只记录日志, 不影响聊天
+func Publish(event *Event) {
+	if event.Time == 0 {
+		event.Time = time.Now().UnixMilli()
+	}
+	lock.RLock()
+	p := publisher
+	lock.RUnlock()
+	err := p.Publish(event)
+	if err != nil {
+		metrics.EventErrors.Inc(event.Type)
+		slog.Warn("发布事件失败", "type", event.Type, "key", event.Key(), "err", err)
+		return
+	}
+	metrics.EventsPublished.Inc(event.Type)
+}
+
+//下面是各个事件的快捷方式, 复制一份消息, 之后再修改也不影响已经发布的事件
+
+func Login(userId int) {
+	Publish(&Event{Type: TypeLogin, UserId: userId})
+}
+
+func Logout(userId int) {
+	Publish(&Event{Type: TypeLogout, UserId: userId})
+}
+
+//新消息, 修改, 删除, 回应, smsMes 是操作之后的消息
+func MessageEvent(eventType string, userId int, smsMes *message.SmsMes) {
+	mes := *smsMes
+	mes.UserPwd = ""
+	Publish(&Event{Type: eventType, UserId: userId, Message: &mes})
+}
+
+func Moderation(moderateMes *message.ModerateMes) {
+	mes := *moderateMes
+	Publish(&Event{Type: TypeModeration, UserId: mes.OperatorId, Moderation: &mes})
+}
@@ -0,0 +1,64 @@
+package events
+
+import (
+	"sync"
+	"time"
+)
+
+//把事件保存在内存中, 用于测试, 不需要Kafka
+type Fake struct {
+	lock   sync.Mutex
+	events []Event
+	//不为nil时Publish返回这个错误, 用来模拟Kafka不可用
+	Err error
+	//有新事件时通知等待的协程
+	notify chan struct{}
+}
+
+func NewFake() *Fake {
+	return &Fake{
+		notify: make(chan struct{}, 1),
+	}
+}
+
+func (this *Fake) Publish(event *Event) error {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	if this.Err != nil {
+		return this.Err
+	}
+	this.events = append(this.events, *event)
+	select {
+		case this.notify <- struct{}{}:
+		default:
+	}
+	return nil
+}
+
+func (this *Fake) Close() error {
+	return nil
+}
+
+//已经发布的所有事件
+func (this *Fake) Events() []Event {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	return append([]Event(nil), this.events...)
+}
+
+//等待满足match的事件, 超时返回false
+func (this *Fake) Wait(match func(event *Event) bool, timeout time.Duration) (event Event, ok bool) {
+	deadline := time.After(timeout)
+	for {
+		for _, e := range this.Events() {
+			if match(&e) {
+				return e, true
+			}
+		}
+		select {
+			case <-this.notify:
+			case <-deadline:
+				return
+		}
+	}
+}
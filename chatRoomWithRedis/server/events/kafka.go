@@ -0,0 +1,80 @@
+//go:build kafka
+
+package events
+
+import (
+	"encoding/json"
+	"errors"
+	"log/slog"
+
+	"github.com/Shopify/sarama"
+)
+
+//用sarama的异步生产者把事件发到Kafka
+//需要用 go build -tags kafka 编译, 这样不用Kafka的时候不需要sarama
+//默认的构建不会编译这个文件, 修改后用 go vet -tags kafka ./server/events/ 和 go test -tags kafka ./server/events/ 检查
+type KafkaPublisher struct {
+	producer sarama.AsyncProducer
+	topic    string
+	done     chan struct{}
+}
+
+var errQueueFull = errors.New("kafka的发送队列已满, 丢弃事件")
+
+//连接Kafka, brokers 是broker的地址列表
+func NewKafkaPublisher(brokers []string, topic string) (Publisher, error) {
+	config := sarama.NewConfig()
+	//leader 写入后就确认, 事件流允许在broker宕机时丢失少量事件
+	config.Producer.RequiredAcks = sarama.WaitForLocal
+	//按key分区, 同一个房间或会话的事件在同一个分区中, 保证顺序
+	config.Producer.Partitioner = sarama.NewHashPartitioner
+	config.Producer.Return.Successes = false
+	config.Producer.Return.Errors = true
+	config.ChannelBufferSize = 4096
+
+	producer, err := sarama.NewAsyncProducer(brokers, config)
+	if err != nil {
+		return nil, err
+	}
+	this := &KafkaPublisher{
+		producer: producer,
+		topic:    topic,
+		done:     make(chan struct{}),
+	}
+	go this.logErrors()
+	return this, nil
+}
+
+//异步发送的错误只能在这里记录
+func (this *KafkaPublisher) logErrors() {
+	defer close(this.done)
+	for err := range this.producer.Errors() {
+		slog.Warn("发送事件到kafka失败", "topic", this.topic, "err", err.Err)
+	}
+}
+
+//放入发送队列后立即返回, 队列满时丢弃事件, 不阻塞聊天
+func (this *KafkaPublisher) Publish(event *Event) error {
+	data, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	msg := &sarama.ProducerMessage{
+		Topic: this.topic,
+		Key:   sarama.StringEncoder(event.Key()),
+		Value: sarama.ByteEncoder(data),
+	}
+	select {
+		case this.producer.Input() <- msg:
+			return nil
+		default:
+			return errQueueFull
+	}
+}
+
+//发完队列中的事件后关闭
+func (this *KafkaPublisher) Close() error {
+	this.producer.AsyncClose()
+	<-this.done
+	return nil
+}
@@ -0,0 +1,12 @@
+//go:build !kafka
+
+package events
+
+import (
+	"errors"
+)
+
+//没有用 -tags kafka 编译时不支持Kafka
+func NewKafkaPublisher(brokers []string, topic string) (Publisher, error) {
+	return nil, errors.New("服务器编译时没有启用kafka, 请使用 go build -tags kafka")
+}
@@ -0,0 +1,123 @@
+//go:build kafka
+
+package events
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/Shopify/sarama"
+
+	"go_code/chatroom/common/message"
+)
+
+//只有加上kafka标签时才编译和运行:
+//go vet -tags kafka ./server/events/ && go test -tags kafka ./server/events/
+
+//代替sarama的异步生产者, 不需要Kafka, 没有实现的方法调用时panic
+type fakeProducer struct {
+	sarama.AsyncProducer
+	input  chan *sarama.ProducerMessage
+	errors chan *sarama.ProducerError
+}
+
+func (this *fakeProducer) Input() chan<- *sarama.ProducerMessage {
+	return this.input
+}
+
+func (this *fakeProducer) Errors() <-chan *sarama.ProducerError {
+	return this.errors
+}
+
+//和sarama一样, 关闭后Errors 的通道也关闭
+func (this *fakeProducer) AsyncClose() {
+	close(this.errors)
+}
+
+func newFakeKafka(queueSize int) (*KafkaPublisher, *fakeProducer) {
+	producer := &fakeProducer{
+		input:  make(chan *sarama.ProducerMessage, queueSize),
+		errors: make(chan *sarama.ProducerError),
+	}
+	this := &KafkaPublisher{
+		producer: producer,
+		topic:    "chat-events",
+		done:     make(chan struct{}),
+	}
+	go this.logErrors()
+	return this, producer
+}
+
+//事件编码成JSON, 按Key 分区
+func TestKafkaPublish(t *testing.T) {
+	publisher, producer := newFakeKafka(1)
+	event := &Event{
+		Type:    TypeMessage,
+		Time:    1700000000000,
+		UserId:  7,
+		Message: &message.SmsMes{Content: "你好", User: message.User{UserId: 7}, ToUserId: 3},
+	}
+	if err := publisher.Publish(event); err != nil {
+		t.Fatal(err)
+	}
+	msg := <-producer.input
+	if msg.Topic != "chat-events" {
+		t.Fatalf("topic 是%q", msg.Topic)
+	}
+	key, err := msg.Key.Encode()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(key) != "private:3:7" {
+		t.Fatalf("key 是%q, 期望private:3:7", key)
+	}
+	value, err := msg.Value.Encode()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got Event
+	if err = json.Unmarshal(value, &got); err != nil {
+		t.Fatal(err)
+	}
+	if got.Type != event.Type || got.Time != event.Time || got.Message == nil || got.Message.Content != "你好" {
+		t.Fatalf("发送的事件是%s", value)
+	}
+}
+
+//发送队列满时丢弃事件, 不阻塞, 发送失败只记录日志
+func TestKafkaQueueFull(t *testing.T) {
+	publisher, producer := newFakeKafka(1)
+	event := &Event{Type: TypeLogin, UserId: 1}
+	if err := publisher.Publish(event); err != nil {
+		t.Fatal(err)
+	}
+	done := make(chan error)
+	go func() {
+		done <- publisher.Publish(event)
+	}()
+	select {
+	case err := <-done:
+		if err != errQueueFull {
+			t.Fatalf("队列满时返回%v, 期望errQueueFull", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("队列满时Publish 阻塞")
+	}
+
+	producer.errors <- &sarama.ProducerError{Msg: <-producer.input, Err: errors.New("broker不可用")}
+	//Close 等待记录错误的协程结束
+	closed := make(chan error)
+	go func() {
+		closed <- publisher.Close()
+	}()
+	select {
+	case err := <-closed:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("Close 没有返回")
+	}
+}
@@ -0,0 +1,78 @@
+package logger
+
+import (
+	"fmt"
+	"log/slog"
+	"net"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+//服务器的日志, 使用结构化的分级日志
+//每个连接分配一个连接id, 日志中带上 conn user type 等字段, 方便按连接和用户查找
+
+var (
+	connIds    sync.Map //net.Conn -> 连接id
+	nextConnId uint64
+)
+
+//设置日志的级别和格式, level: debug info warn error, format: text json
+func Init(level string, format string) (err error) {
+
+	var lv slog.Level
+	switch strings.ToLower(level) {
+		case "debug":
+			lv = slog.LevelDebug
+		case "info", "":
+			lv = slog.LevelInfo
+		case "warn":
+			lv = slog.LevelWarn
+		case "error":
+			lv = slog.LevelError
+		default:
+			return fmt.Errorf("不支持的日志级别 %s", level)
+	}
+	opts := &slog.HandlerOptions{Level: lv}
+	var handler slog.Handler
+	switch strings.ToLower(format) {
+		case "text", "":
+			handler = slog.NewTextHandler(os.Stderr, opts)
+		case "json":
+			handler = slog.NewJSONHandler(os.Stderr, opts)
+		default:
+			return fmt.Errorf("不支持的日志格式 %s", format)
+	}
+	slog.SetDefault(slog.New(handler))
+	return
+}
+
+//新的连接, 分配一个连接id
+func Register(conn net.Conn) (connId uint64) {
+	connId = atomic.AddUint64(&nextConnId, 1)
+	connIds.Store(conn, connId)
+	return
+}
+
+//连接断开后删除
+func Unregister(conn net.Conn) {
+	connIds.Delete(conn)
+}
+
+//连接的id, 没有注册过的连接返回0
+func ConnId(conn net.Conn) uint64 {
+	if id, ok := connIds.Load(conn); ok {
+		return id.(uint64)
+	}
+	return 0
+}
+
+//带上连接id和用户id的日志, userId 为 0 表示还没有登录
+func For(conn net.Conn, userId int) *slog.Logger {
+	l := slog.Default().With("conn", ConnId(conn))
+	if userId != 0 {
+		l = l.With("user", userId)
+	}
+	return l
+}
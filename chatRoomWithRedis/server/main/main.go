@@ -1,19 +1,28 @@
 package main
 import (
-	"bytes"
-	"fmt"
-	"net"
-	"runtime"
+	"flag"
+	"log/slog"
+	"net/http"
+	"os"
 	"strconv"
+	"strings"
 	"time"
+	"go_code/chatroom/common/message"
+	"go_code/chatroom/server/chatserver"
+	"go_code/chatroom/server/events"
+	"go_code/chatroom/server/logger"
+	"go_code/chatroom/server/memredis"
+	"go_code/chatroom/server/metrics"
 	"go_code/chatroom/server/model"
+	"go_code/chatroom/server/plugin"
+	_ "go_code/chatroom/server/plugin/bots"
+	"go_code/chatroom/server/process"
 )
 
 
 // func readPkg(conn net.Conn) (mes message.Message, err error) {
 
 // 	buf := make([]byte, 8096)
-// 	fmt.Println("读取客户端发送的数据...")
 // 	//conn.Read 在conn没有被关闭的情况下，才会阻塞
 // 	//如果客户端关闭了 conn 则，就不会阻塞
 // 	_, err = conn.Read(buf[:4])
@@ -127,66 +136,115 @@ import (
 // 	 return 
 // }
 
-func GetGID() uint64 {
-	b := make([]byte, 64)
-	b = b[:runtime.Stack(b, false)]
-	b = bytes.TrimPrefix(b, []byte("goroutine "))
-	b = b[:bytes.IndexByte(b, ' ')]
-	n, _ := strconv.ParseUint(string(b), 10, 64)
-	return n
+//启动时把这些用户设置为管理员，用来创建第一个管理员
+var admins = flag.String("admin", "", "设置为管理员的用户id, 多个用逗号分隔")
+
+func initAdmins() {
+	for _, s := range strings.Split(*admins, ",") {
+		if s == "" {
+			continue
+		}
+		userId, err := strconv.Atoi(s)
+		if err != nil {
+			slog.Warn("无效的管理员id", "id", s)
+			continue
+		}
+		err = model.MyUserDao.SetRole(userId, message.RoleAdmin)
+		if err != nil {
+			slog.Error("设置管理员失败", "user", userId, "err", err)
+		}
+	}
 }
 
-//处理和客户端的通讯
-func process(conn net.Conn) {
-	//这里需要延时关闭conn
-	defer conn.Close()
-	
-	//这里调用总控, 创建一个
-	processor := &Processor{
-		Conn : conn,
+var (
+	addr        = flag.String("addr", "0.0.0.0:8889", "服务器监听的地址")
+	redisAddr   = flag.String("redis", "localhost:6379", "redis的地址, memory 表示使用内存中的数据, 重启后丢失, 用于测试")
+	logLevel    = flag.String("log-level", "info", "日志级别: debug info warn error")
+	logFormat   = flag.String("log-format", "text", "日志格式: text json")
+	metricsAddr = flag.String("metrics-addr", ":8890", "监控指标的http地址, 为空时不启动")
+	pluginsFile = flag.String("plugins", "", "插件的配置文件, 为空时不启用插件")
+	kafkaAddr   = flag.String("kafka", "", "Kafka的broker地址, 多个用逗号分隔, 为空时不发布聊天事件")
+	kafkaTopic  = flag.String("kafka-topic", "chat-events", "发布聊天事件的topic")
+)
+
+//按配置文件启用插件
+func loadPlugins(path string) (mgr *plugin.Manager, err error) {
+	if path == "" {
+		return
 	}
-	err := processor.process2()
+	config, err := plugin.LoadConfig(path)
 	if err != nil {
-		fmt.Printf("客户端[%v]和服务器[%v]通讯协程错误或结束，msg=%s\n", conn.RemoteAddr(), GetGID(), err)
-		return 
+		return
 	}
+	mgr = plugin.NewManager()
+	err = mgr.Load(config)
+	if err != nil {
+		mgr.Close()
+		return nil, err
+	}
+	return
 }
 
-func init() {
-	//当服务器启动时，我们就去初始化我们的redis的连接池
-	initPool("localhost:6379", 16, 0, 300 * time.Second)
-	initUserDao()
-}
-
-//这里我们编写一个函数，完成对UserDao的初始化任务
-func initUserDao() {
-	//这里的pool 本身就是一个全局的变量
-	//这里需要注意一个初始化顺序问题
-	//initPool, 在 initUserDao
-	model.MyUserDao = model.NewUserDao(pool)
+//在 /metrics 输出监控指标, 给Prometheus抓取
+func startMetrics(addr string) {
+	if addr == "" {
+		return
+	}
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", metrics.Handler())
+	go func() {
+		slog.Info("监控指标的http服务已启动", "addr", addr)
+		err := http.ListenAndServe(addr, mux)
+		if err != nil {
+			slog.Error("监控指标的http服务启动失败", "addr", addr, "err", err)
+		}
+	}()
 }
 
 func main() {
-	
-	//提示信息
-	fmt.Println("服务器在8889端口监听....")
-	listen, err := net.Listen("tcp", "0.0.0.0:8889")
-	defer listen.Close()
+	flag.Parse()
+	err := logger.Init(*logLevel, *logFormat)
 	if err != nil {
-		fmt.Println("net.Listen err=", err)
-		return
+		slog.Error("初始化日志失败", "err", err)
+		os.Exit(1)
 	}
-	//一旦监听成功，就等待客户端来链接服务器
-	for {
-		fmt.Println("服务器等待客户端连接的到来.....")
-		conn, err := listen.Accept()
+	//当服务器启动时，我们就去初始化我们的redis的连接池
+	if *redisAddr == "memory" {
+		pool = memredis.NewStore().NewPool()
+	} else {
+		initPool(*redisAddr, 16, 0, 300 * time.Second)
+	}
+	//文件内容保存在服务器当前目录下的files目录中
+	server := chatserver.NewServer(pool, "files")
+	initAdmins()
+	startMetrics(*metricsAddr)
+	server.Plugins, err = loadPlugins(*pluginsFile)
+	if err != nil {
+		slog.Error("启用插件失败", "err", err)
+		os.Exit(1)
+	}
+	if *kafkaAddr != "" {
+		publisher, err := events.NewKafkaPublisher(strings.Split(*kafkaAddr, ","), *kafkaTopic)
 		if err != nil {
-			fmt.Println("listen.Accept err=" ,err)
+			slog.Error("连接kafka失败", "addr", *kafkaAddr, "err", err)
+			os.Exit(1)
 		}
-		fmt.Printf("客户端[%s]与服务端已建立连接.\n", conn.RemoteAddr())
+		events.SetPublisher(publisher)
+		defer publisher.Close()
+		slog.Info("聊天事件发布到kafka", "addr", *kafkaAddr, "topic", *kafkaTopic)
+	}
 
-		//一旦链接成功，则启动一个协程和客户端保持通讯。。
-		go process(conn)
-		time.Sleep(time.Second * 1)
+	//5分钟没有操作的用户自动设置为离开
+	process2.StartAwayChecker(5 * time.Minute)
+	
+	err = server.Listen(*addr)
+	if err != nil {
+		slog.Error("net.Listen fail", "err", err)
+		return
 	}
+	defer server.Close()
+	//提示信息
+	slog.Info("服务器开始监听", "addr", server.Addr().String())
+	//一旦监听成功，就等待客户端来链接服务器
+	server.Serve()
 }
\ No newline at end of file
@@ -1,6 +1,8 @@
 package main
 import (
 	"github.com/garyburd/redigo/redis"
+	"go_code/chatroom/server/metrics"
+	"strings"
 	"time"
 )
 
@@ -14,7 +16,53 @@ func initPool(address string, maxIdle, maxActive int, idleTimeout time.Duration)
 		MaxActive: maxActive, // 表示和数据库的最大链接数， 0 表示没有限制
 		IdleTimeout: idleTimeout, // 最大空闲时间
 		Dial: func() (redis.Conn, error) { // 初始化链接的代码， 链接哪个ip的redis
-		return redis.Dial("tcp", address)
+		conn, err := redis.Dial("tcp", address)
+		if err != nil {
+			metrics.RedisErrors.Inc("DIAL")
+			return nil, err
+		}
+		return &metricsConn{Conn: conn}, nil
 		},
 	}
-}
\ No newline at end of file
+}
+
+//统计每个redis命令的耗时和出错次数
+//Send 只是把命令写进缓冲区, 回复在之后的Do 中一起读取, 没法单独计时,
+//所以这些命令算在读取回复的Do 里: MULTI 中的命令算在EXEC, 用Do("") 读取回复的算作PIPELINE
+//服务器没有直接调用Flush 和Receive, 它们不统计
+type metricsConn struct {
+	redis.Conn
+	//Send 以后还没有读取回复的命令数
+	pending int
+}
+
+func (this *metricsConn) Send(commandName string, args ...interface{}) (err error) {
+	err = this.Conn.Send(commandName, args...)
+	if err != nil {
+		metrics.RedisErrors.Inc(strings.ToUpper(commandName))
+		return
+	}
+	this.pending++
+	return
+}
+
+func (this *metricsConn) Do(commandName string, args ...interface{}) (reply interface{}, err error) {
+	pending := this.pending
+	this.pending = 0
+	command := strings.ToUpper(commandName)
+	if command == "" {
+		//pool 在归还连接时会调用 Do("") 检查连接, 没有Send 过命令时不统计
+		if pending == 0 {
+			return this.Conn.Do(commandName, args...)
+		}
+		command = "PIPELINE"
+	}
+	start := time.Now()
+	reply, err = this.Conn.Do(commandName, args...)
+	metrics.RedisDuration.Since(start, command)
+	//key不存在不算错误
+	if err != nil && err != redis.ErrNil {
+		metrics.RedisErrors.Inc(command)
+	}
+	return
+}
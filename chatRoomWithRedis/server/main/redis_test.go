@@ -0,0 +1,84 @@
+package main
+
+import (
+	"bytes"
+	"strconv"
+	"strings"
+	"testing"
+
+	"go_code/chatroom/server/memredis"
+	"go_code/chatroom/server/metrics"
+)
+
+//输出中某个序列的值, 没有这个序列时返回0
+func seriesValue(t *testing.T, series string) float64 {
+	t.Helper()
+	var buf bytes.Buffer
+	metrics.WriteTo(&buf)
+	for _, line := range strings.Split(buf.String(), "\n") {
+		if !strings.HasPrefix(line, series+" ") {
+			continue
+		}
+		value, err := strconv.ParseFloat(strings.TrimPrefix(line, series+" "), 64)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return value
+	}
+	return 0
+}
+
+func expectSeries(t *testing.T, series string, want float64) {
+	t.Helper()
+	if got := seriesValue(t, series); got != want {
+		t.Fatalf("%s 是%v, 期望%v", series, got, want)
+	}
+}
+
+//Do 的命令单独计时, Send 的命令算在读取回复的EXEC 或PIPELINE 里
+func TestMetricsConn(t *testing.T) {
+	conn := &metricsConn{Conn: memredis.NewStore().Dial()}
+	defer conn.Close()
+	count := func(command string) string {
+		return `chat_redis_command_duration_seconds_count{command="` + command + `"}`
+	}
+
+	//key不存在不算错误
+	if _, err := conn.Do("get", "missing"); err != nil {
+		t.Fatal(err)
+	}
+	expectSeries(t, count("GET"), 1)
+	expectSeries(t, `chat_redis_errors_total{command="GET"}`, 0)
+
+	conn.Send("Set", "counter", 1)
+	conn.Send("Incr", "counter")
+	if _, err := conn.Do(""); err != nil {
+		t.Fatal(err)
+	}
+	expectSeries(t, count("PIPELINE"), 1)
+	expectSeries(t, count("SET"), 0)
+	expectSeries(t, count("INCR"), 0)
+
+	conn.Send("MULTI")
+	conn.Send("Incr", "counter")
+	if _, err := conn.Do("EXEC"); err != nil {
+		t.Fatal(err)
+	}
+	expectSeries(t, count("EXEC"), 1)
+	expectSeries(t, count("MULTI"), 0)
+
+	//没有Send 过命令的Do("") 不统计
+	if _, err := conn.Do(""); err != nil {
+		t.Fatal(err)
+	}
+	expectSeries(t, count("PIPELINE"), 1)
+
+	if _, err := conn.Do("Set", "name", "chat"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := conn.Do("Incr", "name"); err == nil {
+		t.Fatalf("对字符串Incr 没有返回错误")
+	}
+	expectSeries(t, count("INCR"), 1)
+	expectSeries(t, `chat_redis_errors_total{command="INCR"}`, 1)
+}
@@ -0,0 +1,739 @@
+package memredis
+
+import (
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/garyburd/redigo/redis"
+)
+
+//一条命令的实现, 调用时已经持有Store的锁
+type command struct {
+	minArgs int  //最少的参数个数, 不包括命令名
+	even    bool //minArgs 之后的参数必须成对出现, 比如 HSET 的 field value
+	write   bool //会修改key, WATCH 这个key的事务会失败
+	fn      func(store *Store, args []string) interface{}
+}
+
+var commands map[string]command
+
+func init() {
+	commands = map[string]command{
+		"PING":             {0, false, false, ping},
+		"GET":              {1, false, false, get},
+		"SET":              {2, false, true, set},
+		"DEL":              {1, false, true, del},
+		"EXISTS":           {1, false, false, exists},
+		"EXPIRE":           {2, false, true, expire},
+		"TTL":              {1, false, false, ttl},
+		"INCR":             {1, false, true, incr},
+		"INCRBY":           {2, false, true, incrBy},
+		"HGET":             {2, false, false, hget},
+		"HSET":             {1, true, true, hset},
+		"HSETNX":           {3, false, true, hsetnx},
+		"HDEL":             {2, false, true, hdel},
+		"HINCRBY":          {3, false, true, hincrBy},
+		"HGETALL":          {1, false, false, hgetall},
+		"HLEN":             {1, false, false, hlen},
+		"RPUSH":            {2, false, true, rpush},
+		"LRANGE":           {3, false, false, lrange},
+		"LTRIM":            {3, false, true, ltrim},
+		"LLEN":             {1, false, false, llen},
+		"SADD":             {2, false, true, sadd},
+		"SREM":             {2, false, true, srem},
+		"SMEMBERS":         {1, false, false, smembers},
+		"SISMEMBER":        {2, false, false, sismember},
+		"ZADD":             {1, true, true, zadd},
+		"ZREM":             {2, false, true, zrem},
+		"ZSCORE":           {2, false, false, zscore},
+		"ZCARD":            {1, false, false, zcard},
+		"ZRANGE":           {3, false, false, zrange},
+		"ZREVRANGE":        {3, false, false, zrevrange},
+		"ZRANGEBYSCORE":    {3, false, false, zrangeByScore},
+		"ZREVRANGEBYSCORE": {3, false, false, zrevrangeByScore},
+	}
+}
+
+var (
+	errWrongType = redis.Error("WRONGTYPE Operation against a key holding the wrong kind of value")
+	errNotInt    = redis.Error("ERR value is not an integer or out of range")
+	errNotFloat  = redis.Error("ERR value is not a valid float")
+	errSyntax    = redis.Error("ERR syntax error")
+)
+
+func upper(s string) string {
+	return strings.ToUpper(s)
+}
+
+func ping(store *Store, args []string) interface{} {
+	return "PONG"
+}
+
+func get(store *Store, args []string) interface{} {
+	value, ok := store.get(args[0])
+	if !ok {
+		return nil
+	}
+	b, ok := value.([]byte)
+	if !ok {
+		return errWrongType
+	}
+	return b
+}
+
+//SET key value [EX seconds] [PX milliseconds] [NX|XX]
+func set(store *Store, args []string) interface{} {
+	key := args[0]
+	var ttl time.Duration
+	nx, xx := false, false
+	for i := 2; i < len(args); i++ {
+		switch upper(args[i]) {
+			case "NX":
+				nx = true
+			case "XX":
+				xx = true
+			case "EX", "PX":
+				if i+1 >= len(args) {
+					return errSyntax
+				}
+				n, err := strconv.ParseInt(args[i+1], 10, 64)
+				if err != nil || n <= 0 {
+					return errNotInt
+				}
+				if upper(args[i]) == "EX" {
+					ttl = time.Duration(n) * time.Second
+				} else {
+					ttl = time.Duration(n) * time.Millisecond
+				}
+				i++
+			default:
+				return errSyntax
+		}
+	}
+	_, exists := store.get(key)
+	if (nx && exists) || (xx && !exists) {
+		return nil
+	}
+	store.values[key] = []byte(args[1])
+	delete(store.expires, key)
+	if ttl > 0 {
+		store.expires[key] = time.Now().Add(ttl)
+	}
+	return "OK"
+}
+
+func del(store *Store, args []string) interface{} {
+	var n int64
+	for _, key := range args {
+		if store.del(key) {
+			n++
+		}
+	}
+	return n
+}
+
+func exists(store *Store, args []string) interface{} {
+	var n int64
+	for _, key := range args {
+		if _, ok := store.get(key); ok {
+			n++
+		}
+	}
+	return n
+}
+
+func expire(store *Store, args []string) interface{} {
+	seconds, err := strconv.ParseInt(args[1], 10, 64)
+	if err != nil {
+		return errNotInt
+	}
+	if _, ok := store.get(args[0]); !ok {
+		return int64(0)
+	}
+	if seconds <= 0 {
+		store.del(args[0])
+		return int64(1)
+	}
+	store.expires[args[0]] = time.Now().Add(time.Duration(seconds) * time.Second)
+	return int64(1)
+}
+
+//key不存在返回-2, 没有过期时间返回-1
+func ttl(store *Store, args []string) interface{} {
+	if _, ok := store.get(args[0]); !ok {
+		return int64(-2)
+	}
+	at, ok := store.expires[args[0]]
+	if !ok {
+		return int64(-1)
+	}
+	return int64(math.Ceil(time.Until(at).Seconds()))
+}
+
+func incr(store *Store, args []string) interface{} {
+	return incrBy(store, []string{args[0], "1"})
+}
+
+func incrBy(store *Store, args []string) interface{} {
+	delta, err := strconv.ParseInt(args[1], 10, 64)
+	if err != nil {
+		return errNotInt
+	}
+	var n int64
+	if value, ok := store.get(args[0]); ok {
+		b, ok := value.([]byte)
+		if !ok {
+			return errWrongType
+		}
+		n, err = strconv.ParseInt(string(b), 10, 64)
+		if err != nil {
+			return errNotInt
+		}
+	}
+	n += delta
+	store.values[args[0]] = []byte(strconv.FormatInt(n, 10))
+	return n
+}
+
+//取出hash, create为true时不存在就创建
+func getHash(store *Store, key string, create bool) (hash map[string][]byte, errReply interface{}) {
+	value, ok := store.get(key)
+	if !ok {
+		if create {
+			hash = make(map[string][]byte)
+			store.values[key] = hash
+		}
+		return
+	}
+	hash, ok = value.(map[string][]byte)
+	if !ok {
+		return nil, errWrongType
+	}
+	return
+}
+
+func hget(store *Store, args []string) interface{} {
+	hash, errReply := getHash(store, args[0], false)
+	if errReply != nil {
+		return errReply
+	}
+	value, ok := hash[args[1]]
+	if !ok {
+		return nil
+	}
+	return value
+}
+
+func hset(store *Store, args []string) interface{} {
+	if len(args) < 3 {
+		return redis.Error("ERR wrong number of arguments for 'hset' command")
+	}
+	hash, errReply := getHash(store, args[0], true)
+	if errReply != nil {
+		return errReply
+	}
+	var n int64
+	for i := 1; i+1 < len(args); i += 2 {
+		if _, ok := hash[args[i]]; !ok {
+			n++
+		}
+		hash[args[i]] = []byte(args[i+1])
+	}
+	return n
+}
+
+func hsetnx(store *Store, args []string) interface{} {
+	if len(args) != 3 {
+		return redis.Error("ERR wrong number of arguments for 'hsetnx' command")
+	}
+	hash, errReply := getHash(store, args[0], true)
+	if errReply != nil {
+		return errReply
+	}
+	if _, ok := hash[args[1]]; ok {
+		return int64(0)
+	}
+	hash[args[1]] = []byte(args[2])
+	return int64(1)
+}
+
+func hincrBy(store *Store, args []string) interface{} {
+	delta, err := strconv.ParseInt(args[2], 10, 64)
+	if err != nil {
+		return errNotInt
+	}
+	hash, errReply := getHash(store, args[0], true)
+	if errReply != nil {
+		return errReply
+	}
+	var n int64
+	if b, ok := hash[args[1]]; ok {
+		n, err = strconv.ParseInt(string(b), 10, 64)
+		if err != nil {
+			return redis.Error("ERR hash value is not an integer")
+		}
+	}
+	n += delta
+	hash[args[1]] = []byte(strconv.FormatInt(n, 10))
+	return n
+}
+
+func hdel(store *Store, args []string) interface{} {
+	hash, errReply := getHash(store, args[0], false)
+	if errReply != nil {
+		return errReply
+	}
+	var n int64
+	for _, field := range args[1:] {
+		if _, ok := hash[field]; ok {
+			delete(hash, field)
+			n++
+		}
+	}
+	if hash != nil && len(hash) == 0 {
+		store.del(args[0])
+	}
+	return n
+}
+
+func hgetall(store *Store, args []string) interface{} {
+	hash, errReply := getHash(store, args[0], false)
+	if errReply != nil {
+		return errReply
+	}
+	fields := make([]string, 0, len(hash))
+	for field := range hash {
+		fields = append(fields, field)
+	}
+	sort.Strings(fields)
+	reply := []interface{}{}
+	for _, field := range fields {
+		reply = append(reply, []byte(field), hash[field])
+	}
+	return reply
+}
+
+func hlen(store *Store, args []string) interface{} {
+	hash, errReply := getHash(store, args[0], false)
+	if errReply != nil {
+		return errReply
+	}
+	return int64(len(hash))
+}
+
+func getList(store *Store, key string) (list [][]byte, errReply interface{}) {
+	value, ok := store.get(key)
+	if !ok {
+		return
+	}
+	list, ok = value.([][]byte)
+	if !ok {
+		return nil, errWrongType
+	}
+	return
+}
+
+func rpush(store *Store, args []string) interface{} {
+	list, errReply := getList(store, args[0])
+	if errReply != nil {
+		return errReply
+	}
+	for _, value := range args[1:] {
+		list = append(list, []byte(value))
+	}
+	store.values[args[0]] = list
+	return int64(len(list))
+}
+
+//把redis的下标(可以是负数)转换成切片的范围, 范围为空时ok为false
+func listRange(length int, startArg string, stopArg string) (start int, stop int, ok bool, errReply interface{}) {
+	start, err1 := strconv.Atoi(startArg)
+	stop, err2 := strconv.Atoi(stopArg)
+	if err1 != nil || err2 != nil {
+		return 0, 0, false, errNotInt
+	}
+	if start < 0 {
+		start += length
+	}
+	if stop < 0 {
+		stop += length
+	}
+	if start < 0 {
+		start = 0
+	}
+	if stop >= length {
+		stop = length - 1
+	}
+	return start, stop, start <= stop, nil
+}
+
+func lrange(store *Store, args []string) interface{} {
+	list, errReply := getList(store, args[0])
+	if errReply != nil {
+		return errReply
+	}
+	start, stop, ok, errReply := listRange(len(list), args[1], args[2])
+	if errReply != nil {
+		return errReply
+	}
+	reply := []interface{}{}
+	if !ok {
+		return reply
+	}
+	for _, value := range list[start : stop+1] {
+		reply = append(reply, value)
+	}
+	return reply
+}
+
+func ltrim(store *Store, args []string) interface{} {
+	list, errReply := getList(store, args[0])
+	if errReply != nil {
+		return errReply
+	}
+	start, stop, ok, errReply := listRange(len(list), args[1], args[2])
+	if errReply != nil {
+		return errReply
+	}
+	if !ok {
+		store.del(args[0])
+		return "OK"
+	}
+	if list != nil {
+		store.values[args[0]] = append([][]byte(nil), list[start:stop+1]...)
+	}
+	return "OK"
+}
+
+func llen(store *Store, args []string) interface{} {
+	list, errReply := getList(store, args[0])
+	if errReply != nil {
+		return errReply
+	}
+	return int64(len(list))
+}
+
+func getSet(store *Store, key string, create bool) (set map[string]bool, errReply interface{}) {
+	value, ok := store.get(key)
+	if !ok {
+		if create {
+			set = make(map[string]bool)
+			store.values[key] = set
+		}
+		return
+	}
+	set, ok = value.(map[string]bool)
+	if !ok {
+		return nil, errWrongType
+	}
+	return
+}
+
+func sadd(store *Store, args []string) interface{} {
+	set, errReply := getSet(store, args[0], true)
+	if errReply != nil {
+		return errReply
+	}
+	var n int64
+	for _, member := range args[1:] {
+		if !set[member] {
+			set[member] = true
+			n++
+		}
+	}
+	return n
+}
+
+func srem(store *Store, args []string) interface{} {
+	set, errReply := getSet(store, args[0], false)
+	if errReply != nil {
+		return errReply
+	}
+	var n int64
+	for _, member := range args[1:] {
+		if set[member] {
+			delete(set, member)
+			n++
+		}
+	}
+	if set != nil && len(set) == 0 {
+		store.del(args[0])
+	}
+	return n
+}
+
+func smembers(store *Store, args []string) interface{} {
+	set, errReply := getSet(store, args[0], false)
+	if errReply != nil {
+		return errReply
+	}
+	members := make([]string, 0, len(set))
+	for member := range set {
+		members = append(members, member)
+	}
+	sort.Strings(members)
+	reply := []interface{}{}
+	for _, member := range members {
+		reply = append(reply, []byte(member))
+	}
+	return reply
+}
+
+func sismember(store *Store, args []string) interface{} {
+	set, errReply := getSet(store, args[0], false)
+	if errReply != nil {
+		return errReply
+	}
+	if set[args[1]] {
+		return int64(1)
+	}
+	return int64(0)
+}
+
+func getZset(store *Store, key string, create bool) (zset map[string]float64, errReply interface{}) {
+	value, ok := store.get(key)
+	if !ok {
+		if create {
+			zset = make(map[string]float64)
+			store.values[key] = zset
+		}
+		return
+	}
+	zset, ok = value.(map[string]float64)
+	if !ok {
+		return nil, errWrongType
+	}
+	return
+}
+
+//ZADD key score member [score member ...]
+func zadd(store *Store, args []string) interface{} {
+	if len(args) < 3 {
+		return redis.Error("ERR wrong number of arguments for 'zadd' command")
+	}
+	scores := make([]float64, 0, len(args)/2)
+	for i := 1; i+1 < len(args); i += 2 {
+		score, err := strconv.ParseFloat(args[i], 64)
+		if err != nil {
+			return errNotFloat
+		}
+		scores = append(scores, score)
+	}
+	zset, errReply := getZset(store, args[0], true)
+	if errReply != nil {
+		return errReply
+	}
+	var n int64
+	for i, score := range scores {
+		member := args[2+i*2]
+		if _, ok := zset[member]; !ok {
+			n++
+		}
+		zset[member] = score
+	}
+	return n
+}
+
+func zrem(store *Store, args []string) interface{} {
+	zset, errReply := getZset(store, args[0], false)
+	if errReply != nil {
+		return errReply
+	}
+	var n int64
+	for _, member := range args[1:] {
+		if _, ok := zset[member]; ok {
+			delete(zset, member)
+			n++
+		}
+	}
+	if zset != nil && len(zset) == 0 {
+		store.del(args[0])
+	}
+	return n
+}
+
+func zscore(store *Store, args []string) interface{} {
+	zset, errReply := getZset(store, args[0], false)
+	if errReply != nil {
+		return errReply
+	}
+	score, ok := zset[args[1]]
+	if !ok {
+		return nil
+	}
+	return []byte(formatScore(score))
+}
+
+func zcard(store *Store, args []string) interface{} {
+	zset, errReply := getZset(store, args[0], false)
+	if errReply != nil {
+		return errReply
+	}
+	return int64(len(zset))
+}
+
+//和redis一样, 整数的分数不用科学计数法, 比如毫秒时间戳
+func formatScore(score float64) string {
+	if score == math.Trunc(score) && math.Abs(score) < 1e17 {
+		return strconv.FormatFloat(score, 'f', -1, 64)
+	}
+	return strconv.FormatFloat(score, 'g', -1, 64)
+}
+
+//有序集合的一个成员
+type zmember struct {
+	member string
+	score  float64
+}
+
+//按分数从小到大排序, 分数相同时按成员的字典序
+func sortedZset(zset map[string]float64) (members []zmember) {
+	for member, score := range zset {
+		members = append(members, zmember{member, score})
+	}
+	sort.Slice(members, func(i, j int) bool {
+		if members[i].score != members[j].score {
+			return members[i].score < members[j].score
+		}
+		return members[i].member < members[j].member
+	})
+	return
+}
+
+func zrangeReply(members []zmember, withScores bool) interface{} {
+	reply := []interface{}{}
+	for _, m := range members {
+		reply = append(reply, []byte(m.member))
+		if withScores {
+			reply = append(reply, []byte(formatScore(m.score)))
+		}
+	}
+	return reply
+}
+
+//ZRANGE / ZREVRANGE key start stop [WITHSCORES]
+func zrangeByIndex(store *Store, args []string, rev bool) interface{} {
+	withScores := false
+	if len(args) > 3 {
+		if len(args) > 4 || upper(args[3]) != "WITHSCORES" {
+			return errSyntax
+		}
+		withScores = true
+	}
+	zset, errReply := getZset(store, args[0], false)
+	if errReply != nil {
+		return errReply
+	}
+	members := sortedZset(zset)
+	if rev {
+		for i, j := 0, len(members)-1; i < j; i, j = i+1, j-1 {
+			members[i], members[j] = members[j], members[i]
+		}
+	}
+	start, stop, ok, errReply := listRange(len(members), args[1], args[2])
+	if errReply != nil {
+		return errReply
+	}
+	if !ok {
+		return []interface{}{}
+	}
+	return zrangeReply(members[start:stop+1], withScores)
+}
+
+func zrange(store *Store, args []string) interface{} {
+	return zrangeByIndex(store, args, false)
+}
+
+func zrevrange(store *Store, args []string) interface{} {
+	return zrangeByIndex(store, args, true)
+}
+
+//分数区间的一端, 支持 -inf +inf 和表示不包含的 (
+func parseScoreBound(arg string) (score float64, exclusive bool, ok bool) {
+	if strings.HasPrefix(arg, "(") {
+		exclusive = true
+		arg = arg[1:]
+	}
+	switch strings.ToLower(arg) {
+		case "-inf":
+			return math.Inf(-1), exclusive, true
+		case "+inf", "inf":
+			return math.Inf(1), exclusive, true
+	}
+	score, err := strconv.ParseFloat(arg, 64)
+	return score, exclusive, err == nil
+}
+
+//ZRANGEBYSCORE key min max [WITHSCORES] [LIMIT offset count]
+//ZREVRANGEBYSCORE 的参数顺序是 max min
+func zrangeByScoreArgs(store *Store, args []string, rev bool) interface{} {
+	minArg, maxArg := args[1], args[2]
+	if rev {
+		minArg, maxArg = maxArg, minArg
+	}
+	min, minEx, ok1 := parseScoreBound(minArg)
+	max, maxEx, ok2 := parseScoreBound(maxArg)
+	if !ok1 || !ok2 {
+		return redis.Error("ERR min or max is not a float")
+	}
+	withScores := false
+	offset, count := 0, -1
+	for i := 3; i < len(args); i++ {
+		switch upper(args[i]) {
+			case "WITHSCORES":
+				withScores = true
+			case "LIMIT":
+				if i+2 >= len(args) {
+					return errSyntax
+				}
+				var err1, err2 error
+				offset, err1 = strconv.Atoi(args[i+1])
+				count, err2 = strconv.Atoi(args[i+2])
+				if err1 != nil || err2 != nil {
+					return errNotInt
+				}
+				i += 2
+			default:
+				return errSyntax
+		}
+	}
+	zset, errReply := getZset(store, args[0], false)
+	if errReply != nil {
+		return errReply
+	}
+	members := sortedZset(zset)
+	if rev {
+		for i, j := 0, len(members)-1; i < j; i, j = i+1, j-1 {
+			members[i], members[j] = members[j], members[i]
+		}
+	}
+	var res []zmember
+	for _, m := range members {
+		if m.score < min || (minEx && m.score == min) || m.score > max || (maxEx && m.score == max) {
+			continue
+		}
+		if offset > 0 {
+			offset--
+			continue
+		}
+		if count >= 0 && len(res) >= count {
+			break
+		}
+		res = append(res, m)
+	}
+	if offset < 0 {
+		res = nil
+	}
+	return zrangeReply(res, withScores)
+}
+
+func zrangeByScore(store *Store, args []string) interface{} {
+	return zrangeByScoreArgs(store, args, false)
+}
+
+func zrevrangeByScore(store *Store, args []string) interface{} {
+	return zrangeByScoreArgs(store, args, true)
+}
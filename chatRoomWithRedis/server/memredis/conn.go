@@ -0,0 +1,200 @@
+package memredis
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+
+	"github.com/garyburd/redigo/redis"
+)
+
+var errClosed = errors.New("memredis: 连接已关闭")
+
+//实现 redis.Conn 接口
+//Send 的命令立即执行, 结果保存起来, 由Receive或者下一次Do返回
+type conn struct {
+	store   *Store
+	closed  bool
+	pending []interface{} //Send 的命令的结果, 还没有被读取
+	//MULTI 之后的命令先放到队列中, EXEC 时一起执行
+	multi  bool
+	queued [][]string
+	//WATCH 的key和当时的版本号, EXEC 时有key被修改过则不执行事务
+	watched map[string]uint64
+}
+
+func (this *conn) Close() error {
+	this.closed = true
+	this.pending = nil
+	this.watched = nil
+	return nil
+}
+
+func (this *conn) Err() error {
+	if this.closed {
+		return errClosed
+	}
+	return nil
+}
+
+func (this *conn) Send(commandName string, args ...interface{}) error {
+	if this.closed {
+		return errClosed
+	}
+	reply, err := this.exec(commandName, args)
+	if err != nil {
+		reply = redis.Error(err.Error())
+	}
+	this.pending = append(this.pending, reply)
+	return nil
+}
+
+func (this *conn) Flush() error {
+	return this.Err()
+}
+
+func (this *conn) Receive() (reply interface{}, err error) {
+	if this.closed {
+		return nil, errClosed
+	}
+	if len(this.pending) == 0 {
+		return nil, errors.New("memredis: 没有等待读取的结果")
+	}
+	reply = this.pending[0]
+	this.pending = this.pending[1:]
+	if e, ok := reply.(redis.Error); ok {
+		return nil, e
+	}
+	return
+}
+
+//和redigo一样, 先读取Send的命令的结果, 再返回这个命令的结果
+//commandName 为空时只返回之前Send的命令的结果
+func (this *conn) Do(commandName string, args ...interface{}) (reply interface{}, err error) {
+	if this.closed {
+		return nil, errClosed
+	}
+	pending := this.pending
+	this.pending = nil
+	if commandName == "" {
+		if len(pending) == 0 {
+			return nil, nil
+		}
+		return pending, nil
+	}
+	reply, err = this.exec(commandName, args)
+	if err != nil {
+		return nil, err
+	}
+	for _, r := range append(pending, reply) {
+		if e, ok := r.(redis.Error); ok {
+			return reply, e
+		}
+	}
+	return
+}
+
+//处理事务相关的命令, 其它命令交给Store执行
+func (this *conn) exec(commandName string, args []interface{}) (reply interface{}, err error) {
+	cmd := append([]string{commandName}, argStrings(args)...)
+	this.store.lock.Lock()
+	defer this.store.lock.Unlock()
+	switch upper(commandName) {
+		case "WATCH":
+			if this.multi {
+				return redis.Error("ERR WATCH inside MULTI is not allowed"), nil
+			}
+			if len(cmd) < 2 {
+				return redis.Error("ERR wrong number of arguments for 'watch' command"), nil
+			}
+			if this.watched == nil {
+				this.watched = make(map[string]uint64)
+			}
+			for _, key := range cmd[1:] {
+				//已经过期的key先删除, 免得之后删除时被当作修改
+				this.store.get(key)
+				if _, ok := this.watched[key]; !ok {
+					this.watched[key] = this.store.versions[key]
+				}
+			}
+			return "OK", nil
+		case "UNWATCH":
+			this.watched = nil
+			return "OK", nil
+		case "MULTI":
+			if this.multi {
+				return redis.Error("ERR MULTI calls can not be nested"), nil
+			}
+			this.multi = true
+			this.queued = nil
+			return "OK", nil
+		case "DISCARD":
+			if !this.multi {
+				return redis.Error("ERR DISCARD without MULTI"), nil
+			}
+			this.multi = false
+			this.queued = nil
+			this.watched = nil
+			return "OK", nil
+		case "EXEC":
+			if !this.multi {
+				return redis.Error("ERR EXEC without MULTI"), nil
+			}
+			queued := this.queued
+			watched := this.watched
+			this.multi = false
+			this.queued = nil
+			this.watched = nil
+			//WATCH 之后key被修改过, 和redis一样返回nil, 不执行事务
+			for key, version := range watched {
+				if this.store.versions[key] != version {
+					return nil, nil
+				}
+			}
+			//持有锁执行所有的命令, 中间不会插入其它连接的命令
+			replies := make([]interface{}, len(queued))
+			for i, args := range queued {
+				replies[i], err = this.store.do(args)
+				if err != nil {
+					return nil, err
+				}
+			}
+			return replies, nil
+	}
+	if this.multi {
+		this.queued = append(this.queued, cmd)
+		return "QUEUED", nil
+	}
+	return this.store.do(cmd)
+}
+
+//和redigo一样把参数转成字符串
+func argStrings(args []interface{}) (list []string) {
+	for _, arg := range args {
+		switch v := arg.(type) {
+			case string:
+				list = append(list, v)
+			case []byte:
+				list = append(list, string(v))
+			case int:
+				list = append(list, strconv.Itoa(v))
+			case int64:
+				list = append(list, strconv.FormatInt(v, 10))
+			case float64:
+				list = append(list, strconv.FormatFloat(v, 'g', -1, 64))
+			case bool:
+				if v {
+					list = append(list, "1")
+				} else {
+					list = append(list, "0")
+				}
+			case nil:
+				list = append(list, "")
+			case redis.Argument:
+				list = append(list, fmt.Sprint(v.RedisArg()))
+			default:
+				list = append(list, fmt.Sprint(v))
+		}
+	}
+	return
+}
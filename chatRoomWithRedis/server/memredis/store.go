@@ -0,0 +1,128 @@
+package memredis
+
+import (
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/garyburd/redigo/redis"
+)
+
+//纯内存的redis替代品, 给集成测试使用, 不需要启动真正的redis
+//通过 NewPool 得到一个 *redis.Pool, 可以直接传给各个Dao
+//只实现了服务器用到的命令, 数据保存在Store中, 同一个Store的所有连接看到的是同一份数据
+
+var (
+	//不支持的命令
+	ErrUnknownCommand = errors.New("memredis: 不支持的命令")
+	//模拟redis不可用时默认返回的错误
+	ErrUnavailable = errors.New("memredis: redis不可用")
+)
+
+//保存所有的key
+type Store struct {
+	lock    sync.Mutex
+	values  map[string]interface{} //string:[]byte hash:map[string][]byte list:[][]byte set:map[string]bool zset:map[string]float64
+	expires map[string]time.Time
+	//每个key最后一次被修改时的版本号, 用来实现 WATCH
+	versions map[string]uint64
+	version  uint64
+	//不为nil时所有命令都返回这个错误, 用来模拟redis出错
+	failErr error
+}
+
+func NewStore() (store *Store) {
+	store = &Store{
+		values:   make(map[string]interface{}),
+		expires:  make(map[string]time.Time),
+		versions: make(map[string]uint64),
+	}
+	return
+}
+
+//让之后的所有命令都返回err, 传入nil恢复正常
+func (this *Store) SetError(err error) {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	this.failErr = err
+}
+
+//清空所有数据
+func (this *Store) FlushAll() {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	this.values = make(map[string]interface{})
+	this.expires = make(map[string]time.Time)
+	//清空以后修改过的key版本号都变了, WATCH 它们的事务会失败
+	this.versions = make(map[string]uint64)
+}
+
+//创建一个连接池, 池中的连接都操作这个Store
+func (this *Store) NewPool() *redis.Pool {
+	return &redis.Pool{
+		MaxIdle:     16,
+		IdleTimeout: 300 * time.Second,
+		Dial: func() (redis.Conn, error) {
+			return this.Dial(), nil
+		},
+	}
+}
+
+//创建一个连接
+func (this *Store) Dial() redis.Conn {
+	return &conn{
+		store: this,
+	}
+}
+
+//取出key的值, 已经过期的key会被删除
+//调用前需要持有锁
+func (this *Store) get(key string) (value interface{}, ok bool) {
+	if at, has := this.expires[key]; has && !time.Now().Before(at) {
+		delete(this.values, key)
+		delete(this.expires, key)
+		this.touch(key)
+	}
+	value, ok = this.values[key]
+	return
+}
+
+//删除key, 返回key是否存在
+func (this *Store) del(key string) bool {
+	_, ok := this.get(key)
+	delete(this.values, key)
+	delete(this.expires, key)
+	return ok
+}
+
+//执行一条命令, 返回的类型和redigo从真正的redis读到的一致
+//整数是int64, 字符串是[]byte, 状态是string, 数组是[]interface{}, redis返回的错误是redis.Error
+func (this *Store) do(args []string) (reply interface{}, err error) {
+	if this.failErr != nil {
+		return nil, this.failErr
+	}
+	cmd, ok := commands[upper(args[0])]
+	if !ok {
+		return nil, ErrUnknownCommand
+	}
+	if len(args)-1 < cmd.minArgs || (cmd.even && (len(args)-1-cmd.minArgs)%2 != 0) {
+		return redis.Error("ERR wrong number of arguments for '" + args[0] + "' command"), nil
+	}
+	reply = cmd.fn(this, args[1:])
+	if _, isErr := reply.(redis.Error); cmd.write && !isErr {
+		keys := args[1:2]
+		if upper(args[0]) == "DEL" {
+			keys = args[1:]
+		}
+		for _, key := range keys {
+			this.touch(key)
+		}
+	}
+	return reply, nil
+}
+
+//记录key被修改了, 调用前需要持有锁
+func (this *Store) touch(key string) {
+	this.version++
+	this.versions[key] = this.version
+}
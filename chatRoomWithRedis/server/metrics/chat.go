@@ -0,0 +1,29 @@
+package metrics
+
+//聊天服务器用到的指标
+var (
+	ActiveConnections = NewGauge("chat_active_connections",
+		"当前的客户端连接数")
+	MessagesReceived = NewCounterVec("chat_messages_received_total",
+		"收到的客户端消息数, 按消息类型", "type")
+	MessagesSent = NewCounterVec("chat_messages_sent_total",
+		"发给客户端的消息数, 按消息类型", "type")
+	FanoutDuration = NewHistogramVec("chat_fanout_duration_seconds",
+		"把一条聊天消息转发给所有接收方的耗时", DefBuckets, "kind")
+	RedisDuration = NewHistogramVec("chat_redis_command_duration_seconds",
+		"redis命令的耗时", DefBuckets, "command")
+	RedisErrors = NewCounterVec("chat_redis_errors_total",
+		"redis命令出错的次数, 不包括key不存在", "command")
+	FrameBytes = NewHistogramVec("chat_frame_bytes",
+		"数据包的大小, in 表示收到的, out 表示发出的", ExponentialBuckets(64, 2, 12), "direction")
+	PluginPanics = NewCounterVec("chat_plugin_panics_total",
+		"插件panic的次数, 按插件名", "plugin")
+	EventsPublished = NewCounterVec("chat_events_published_total",
+		"发布的聊天事件数, 按事件类型", "type")
+	EventErrors = NewCounterVec("chat_event_errors_total",
+		"发布失败的聊天事件数, 按事件类型", "type")
+	ProtocolClients = NewCounterVec("chat_protocol_clients_total",
+		"客户端连接使用的协议版本, 1 表示没有握手的老客户端, rejected 表示版本不兼容", "version")
+	CompressedFrames = NewCounterVec("chat_compressed_frames_total",
+		"压缩过的数据包数, in 表示收到的, out 表示发出的", "direction")
+)
@@ -0,0 +1,260 @@
+package metrics
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+//服务器的监控指标, 按Prometheus的文本格式通过http输出
+//只实现了用到的 counter, gauge, histogram, 不依赖第三方库
+
+//一个指标, 可以按标签分成多个序列
+type metric interface {
+	write(w io.Writer)
+}
+
+var (
+	registry     []metric
+	registryLock sync.Mutex
+)
+
+func register(m metric) {
+	registryLock.Lock()
+	defer registryLock.Unlock()
+	registry = append(registry, m)
+}
+
+//标签的值用这个字符连接成map的key
+const labelSep = "\xff"
+
+//序列的标签, 比如 {type="SmsMes"}
+func formatLabels(names []string, key string, extra ...string) string {
+	var pairs []string
+	if len(names) > 0 {
+		for i, value := range strings.Split(key, labelSep) {
+			pairs = append(pairs, fmt.Sprintf("%s=%q", names[i], value))
+		}
+	}
+	for i := 0; i+1 < len(extra); i += 2 {
+		pairs = append(pairs, fmt.Sprintf("%s=%q", extra[i], extra[i+1]))
+	}
+	if len(pairs) == 0 {
+		return ""
+	}
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+func labelKey(names []string, values []string) string {
+	if len(values) != len(names) {
+		panic(fmt.Sprintf("metrics: 需要%d 个标签, 传入了%d 个", len(names), len(values)))
+	}
+	return strings.Join(values, labelSep)
+}
+
+func formatFloat(v float64) string {
+	switch {
+		case math.IsInf(v, 1):
+			return "+Inf"
+		case math.IsInf(v, -1):
+			return "-Inf"
+	}
+	return fmt.Sprint(v)
+}
+
+//只增不减的计数
+type CounterVec struct {
+	name   string
+	help   string
+	labels []string
+	lock   sync.Mutex
+	values map[string]float64
+}
+
+func NewCounterVec(name string, help string, labels ...string) *CounterVec {
+	c := &CounterVec{
+		name:   name,
+		help:   help,
+		labels: labels,
+		values: make(map[string]float64),
+	}
+	register(c)
+	return c
+}
+
+func (this *CounterVec) Add(delta float64, labelValues ...string) {
+	key := labelKey(this.labels, labelValues)
+	this.lock.Lock()
+	this.values[key] += delta
+	this.lock.Unlock()
+}
+
+func (this *CounterVec) Inc(labelValues ...string) {
+	this.Add(1, labelValues...)
+}
+
+func (this *CounterVec) write(w io.Writer) {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s counter\n", this.name, this.help, this.name)
+	for _, key := range sortedKeys(this.values) {
+		fmt.Fprintf(w, "%s%s %s\n", this.name, formatLabels(this.labels, key), formatFloat(this.values[key]))
+	}
+}
+
+//可增可减的值
+type Gauge struct {
+	name  string
+	help  string
+	lock  sync.Mutex
+	value float64
+}
+
+func NewGauge(name string, help string) *Gauge {
+	g := &Gauge{
+		name: name,
+		help: help,
+	}
+	register(g)
+	return g
+}
+
+func (this *Gauge) Add(delta float64) {
+	this.lock.Lock()
+	this.value += delta
+	this.lock.Unlock()
+}
+
+func (this *Gauge) Inc() {
+	this.Add(1)
+}
+
+func (this *Gauge) Dec() {
+	this.Add(-1)
+}
+
+func (this *Gauge) write(w io.Writer) {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s gauge\n", this.name, this.help, this.name)
+	fmt.Fprintf(w, "%s %s\n", this.name, formatFloat(this.value))
+}
+
+//直方图, 统计延迟和大小的分布
+type HistogramVec struct {
+	name    string
+	help    string
+	labels  []string
+	buckets []float64
+	lock    sync.Mutex
+	series  map[string]*histogram
+}
+
+type histogram struct {
+	counts []uint64 //每个桶的计数, 不累加, 输出时再累加
+	count  uint64
+	sum    float64
+}
+
+//延迟的默认分桶, 单位秒
+var DefBuckets = []float64{.0005, .001, .0025, .005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5}
+
+//按factor倍增长的分桶
+func ExponentialBuckets(start float64, factor float64, count int) (buckets []float64) {
+	for i := 0; i < count; i++ {
+		buckets = append(buckets, start)
+		start *= factor
+	}
+	return
+}
+
+func NewHistogramVec(name string, help string, buckets []float64, labels ...string) *HistogramVec {
+	h := &HistogramVec{
+		name:    name,
+		help:    help,
+		labels:  labels,
+		buckets: buckets,
+		series:  make(map[string]*histogram),
+	}
+	register(h)
+	return h
+}
+
+func (this *HistogramVec) Observe(v float64, labelValues ...string) {
+	key := labelKey(this.labels, labelValues)
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	s, ok := this.series[key]
+	if !ok {
+		s = &histogram{
+			counts: make([]uint64, len(this.buckets)),
+		}
+		this.series[key] = s
+	}
+	idx := sort.SearchFloat64s(this.buckets, v)
+	if idx < len(this.buckets) {
+		s.counts[idx]++
+	}
+	s.count++
+	s.sum += v
+}
+
+//记录从start到现在的秒数
+func (this *HistogramVec) Since(start time.Time, labelValues ...string) {
+	this.Observe(time.Since(start).Seconds(), labelValues...)
+}
+
+func (this *HistogramVec) write(w io.Writer) {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s histogram\n", this.name, this.help, this.name)
+	keys := make([]string, 0, len(this.series))
+	for key := range this.series {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		s := this.series[key]
+		var cumulative uint64
+		for i, bound := range this.buckets {
+			cumulative += s.counts[i]
+			fmt.Fprintf(w, "%s_bucket%s %d\n", this.name,
+				formatLabels(this.labels, key, "le", formatFloat(bound)), cumulative)
+		}
+		fmt.Fprintf(w, "%s_bucket%s %d\n", this.name, formatLabels(this.labels, key, "le", "+Inf"), s.count)
+		fmt.Fprintf(w, "%s_sum%s %s\n", this.name, formatLabels(this.labels, key), formatFloat(s.sum))
+		fmt.Fprintf(w, "%s_count%s %d\n", this.name, formatLabels(this.labels, key), s.count)
+	}
+}
+
+func sortedKeys(m map[string]float64) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+//输出所有指标
+func WriteTo(w io.Writer) {
+	registryLock.Lock()
+	list := append([]metric(nil), registry...)
+	registryLock.Unlock()
+	for _, m := range list {
+		m.write(w)
+	}
+}
+
+//Prometheus 抓取用的http处理函数
+func Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		WriteTo(w)
+	})
+}
@@ -0,0 +1,121 @@
+package model
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/garyburd/redigo/redis"
+	"go_code/chatroom/common/message"
+)
+
+//导出和导入聊天记录, 给 transcript 工具使用
+//导入时保留原来的mesId和时间, 同一条消息已经存在时跳过, mesId 被另一条消息占用时返回 ERROR_MES_CONFLICT
+
+//按mesId从小到大取出会话中mesId大于afterId的消息, 最多count条
+//nextAfterId 为0表示已经取完了, 否则用它继续取下一批
+func (this *MessageDao) ScanHistory(key string, afterId int, count int) (messages []*message.SmsMes, nextAfterId int, err error) {
+
+	conn := this.pool.Get()
+	defer conn.Close()
+
+	ids, err := redis.Ints(conn.Do("ZRangeByScore", key, fmt.Sprintf("(%d", afterId), "+inf", "LIMIT", 0, count))
+	if err != nil {
+		return
+	}
+	for _, id := range ids {
+		smsMes, err := this.getMessageById(conn, id)
+		if err != nil {
+			continue
+		}
+		messages = append(messages, smsMes)
+	}
+	if len(ids) == count {
+		nextAfterId = ids[len(ids)-1]
+	}
+	return
+}
+
+//导入一条消息, mesId 已经存在时不修改, added 返回false
+//已经存在的是另一条消息时返回 ERROR_MES_CONFLICT, 是同一条消息时err为nil
+//导入会把 messages:seq 调到不小于mesId, 之后服务器分配的mesId不会和导入的消息重复
+//服务器运行时导入, 同时分配的mesId仍然可能和导入的消息冲突, 最好在服务器停止时导入
+func (this *MessageDao) ImportMessage(smsMes *message.SmsMes) (added bool, err error) {
+
+	if smsMes.MesId <= 0 {
+		err = fmt.Errorf("消息id不合法: %d", smsMes.MesId)
+		return
+	}
+	data, err := json.Marshal(smsMes)
+	if err != nil {
+		return
+	}
+
+	conn := this.pool.Get()
+	defer conn.Close()
+
+	//先调整seq, 再保存消息
+	err = this.raiseSeq(conn, smsMes.MesId)
+	if err != nil {
+		return
+	}
+
+	added, err = redis.Bool(conn.Do("HSetNX", "messages", smsMes.MesId, string(data)))
+	if err != nil {
+		return
+	}
+	if !added {
+		existing, err := this.getMessageById(conn, smsMes.MesId)
+		if err != nil {
+			return false, err
+		}
+		if !sameMessage(existing, smsMes) {
+			return false, ERROR_MES_CONFLICT
+		}
+		return false, nil
+	}
+	_, err = conn.Do("ZAdd", HistoryKey(smsMes), smsMes.MesId, smsMes.MesId)
+	if err != nil || smsMes.E2E != nil || smsMes.Deleted {
+		return
+	}
+	err = this.indexMessage(conn, smsMes.MesId, "", smsMes.Content)
+	return
+}
+
+//把 messages:seq 调到不小于mesId
+//WATCH 以后再比较和修改, 服务器同时分配mesId时事务不执行, 重新读出seq再比较, seq 不会变小
+func (this *MessageDao) raiseSeq(conn redis.Conn, mesId int) (err error) {
+
+	for {
+		_, err = conn.Do("Watch", "messages:seq")
+		if err != nil {
+			return
+		}
+		seq, err := redis.Int(conn.Do("Get", "messages:seq"))
+		if err != nil && err != redis.ErrNil {
+			conn.Do("Unwatch")
+			return err
+		}
+		if seq >= mesId {
+			_, err = conn.Do("Unwatch")
+			return err
+		}
+		conn.Send("MULTI")
+		conn.Send("Set", "messages:seq", mesId)
+		_, err = redis.Values(conn.Do("EXEC"))
+		if err != redis.ErrNil {
+			return err
+		}
+	}
+}
+
+//是不是同一条消息, 比较发送者, 会话, 时间和内容
+//修改或者删除过的消息内容会变, 这时只比较发送者, 会话和时间
+func sameMessage(a *message.SmsMes, b *message.SmsMes) bool {
+	if a.UserId != b.UserId || a.ToUserId != b.ToUserId || a.RoomId != b.RoomId || a.SendTime != b.SendTime {
+		return false
+	}
+	if a.EditTime != 0 || b.EditTime != 0 || a.Deleted || b.Deleted {
+		return true
+	}
+	return a.Content == b.Content
+}
@@ -7,4 +7,13 @@ var (
 	ERROR_USER_NOTEXISTS = errors.New("用户不存在..")
 	ERROR_USER_EXISTS = errors.New("用户已经存在...")
 	ERROR_USER_PWD = errors.New("密码不正确")
-)
\ No newline at end of file
+	ERROR_FILE_NOTEXISTS = errors.New("文件不存在..")
+	ERROR_FILE_INVALID = errors.New("文件信息不合法")
+	ERROR_FILE_UPLOADING = errors.New("文件还在上传, 上传完成后请作为离线文件获取")
+	ERROR_MES_NOTEXISTS = errors.New("消息不存在..")
+	ERROR_MES_CONFLICT = errors.New("消息id已经被另一条消息使用")
+	ERROR_USER_BANNED = errors.New("用户已被封禁")
+	ERROR_USER_MUTED = errors.New("你已被禁言")
+	ERROR_SCHEDULE_NOTEXISTS = errors.New("定时任务不存在或者已经到时间了")
+	ERROR_SCHEDULE_LIMIT = errors.New("定时任务太多了, 请先取消一些")
+)
\ No newline at end of file
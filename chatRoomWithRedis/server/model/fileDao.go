@@ -0,0 +1,218 @@
+package model
+
+import (
+	"log/slog"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+
+	"github.com/garyburd/redigo/redis"
+	"go_code/chatroom/common/message"
+)
+
+//服务器启动后，初始化一个全局的fileDao实例
+var (
+	MyFileDao *FileDao
+)
+
+//文件内容保存在服务器本地的目录，文件信息保存在redis中
+//files                 hash  fileId -> FileInfo的json
+//files:seq             string 用来分配fileId
+//files:pending:userId  set   该用户还没有取走的文件
+type FileDao struct {
+	pool  *redis.Pool
+	Dir   string //文件内容保存的目录
+}
+
+//使用工厂模式，创建一个FileDao实例
+func NewFileDao(pool *redis.Pool, dir string) (fileDao *FileDao) {
+
+	fileDao = &FileDao{
+		pool: pool,
+		Dir:  dir,
+	}
+	return
+}
+
+func pendingKey(userId int) string {
+	return "files:pending:" + strconv.Itoa(userId)
+}
+
+//保存一个新的文件信息, 并分配fileId
+func (this *FileDao) AddFile(info *message.FileInfo) (err error) {
+
+	conn := this.pool.Get()
+	defer conn.Close()
+
+	info.FileId, err = redis.Int(conn.Do("Incr", "files:seq"))
+	if err != nil {
+		return
+	}
+	info.Status = message.FileOffered
+	err = this.saveFile(conn, info)
+	if err != nil {
+		return
+	}
+	_, err = conn.Do("SAdd", pendingKey(info.ToUserId), info.FileId)
+	return
+}
+
+func (this *FileDao) saveFile(conn redis.Conn, info *message.FileInfo) (err error) {
+
+	data, err := json.Marshal(info)
+	if err != nil {
+		return
+	}
+	_, err = conn.Do("HSet", "files", info.FileId, string(data))
+	if err != nil {
+		slog.Error("保存文件信息错误", "file", info.FileId, "err", err)
+	}
+	return
+}
+
+//根据fileId返回文件信息
+func (this *FileDao) GetFileById(fileId int) (info *message.FileInfo, err error) {
+
+	conn := this.pool.Get()
+	defer conn.Close()
+
+	res, err := redis.String(conn.Do("HGet", "files", fileId))
+	if err != nil {
+		if err == redis.ErrNil {
+			err = ERROR_FILE_NOTEXISTS
+		}
+		return
+	}
+	info = &message.FileInfo{}
+	err = json.Unmarshal([]byte(res), info)
+	return
+}
+
+//更新文件的状态
+func (this *FileDao) UpdateStatus(fileId int, status int) (info *message.FileInfo, err error) {
+
+	info, err = this.GetFileById(fileId)
+	if err != nil {
+		return
+	}
+	conn := this.pool.Get()
+	defer conn.Close()
+
+	info.Status = status
+	err = this.saveFile(conn, info)
+	return
+}
+
+//返回某个用户还没取走的，并且已经完整保存在服务器端的文件
+func (this *FileDao) GetPendingFiles(userId int) (files []message.FileInfo, err error) {
+
+	conn := this.pool.Get()
+	defer conn.Close()
+
+	ids, err := redis.Ints(conn.Do("SMembers", pendingKey(userId)))
+	if err != nil {
+		return
+	}
+	for _, id := range ids {
+		res, err := redis.String(conn.Do("HGet", "files", id))
+		if err != nil {
+			continue
+		}
+		var info message.FileInfo
+		if json.Unmarshal([]byte(res), &info) != nil {
+			continue
+		}
+		if info.Status == message.FileStored {
+			files = append(files, info)
+		}
+	}
+	return
+}
+
+//文件已经被接收方取走或者被拒绝，删除文件信息和文件内容
+func (this *FileDao) RemoveFile(info *message.FileInfo) (err error) {
+
+	conn := this.pool.Get()
+	defer conn.Close()
+
+	_, err = conn.Do("SRem", pendingKey(info.ToUserId), info.FileId)
+	if err != nil {
+		return
+	}
+	_, err = conn.Do("HDel", "files", info.FileId)
+	if err != nil {
+		return
+	}
+	err = os.Remove(this.filePath(info.FileId))
+	if os.IsNotExist(err) {
+		err = nil
+	}
+	return
+}
+
+//文件内容按fileId保存，不使用客户端传来的文件名，避免路径穿越
+func (this *FileDao) filePath(fileId int) string {
+	return filepath.Join(this.Dir, strconv.Itoa(fileId))
+}
+
+//把一个分片写入到文件中对应的位置
+func (this *FileDao) WriteChunk(info *message.FileInfo, chunk *message.FileChunkMes) (err error) {
+
+	offset := int64(chunk.Seq) * message.FileChunkSize
+	if chunk.Seq < 0 || len(chunk.Data) > message.FileChunkSize ||
+		offset+int64(len(chunk.Data)) > info.FileSize {
+		err = ERROR_FILE_INVALID
+		return
+	}
+	err = os.MkdirAll(this.Dir, 0755)
+	if err != nil {
+		return
+	}
+	f, err := os.OpenFile(this.filePath(info.FileId), os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return
+	}
+	defer f.Close()
+	_, err = f.WriteAt(chunk.Data, offset)
+	return
+}
+
+//读取第seq个分片
+func (this *FileDao) ReadChunk(info *message.FileInfo, seq int) (data []byte, err error) {
+
+	f, err := os.Open(this.filePath(info.FileId))
+	if err != nil {
+		return
+	}
+	defer f.Close()
+	data = make([]byte, message.FileChunkSize)
+	n, err := f.ReadAt(data, int64(seq)*message.FileChunkSize)
+	if err == io.EOF {
+		err = nil
+	}
+	data = data[:n]
+	return
+}
+
+//所有分片写完后，校验文件的大小和hash
+func (this *FileDao) Verify(info *message.FileInfo) (err error) {
+
+	f, err := os.Open(this.filePath(info.FileId))
+	if err != nil {
+		return
+	}
+	defer f.Close()
+	h := sha256.New()
+	n, err := io.Copy(h, f)
+	if err != nil {
+		return
+	}
+	if n != info.FileSize || hex.EncodeToString(h.Sum(nil)) != info.FileHash {
+		err = ERROR_FILE_INVALID
+	}
+	return
+}
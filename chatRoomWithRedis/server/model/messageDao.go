@@ -0,0 +1,263 @@
+package model
+
+import (
+	"log/slog"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/garyburd/redigo/redis"
+	"go_code/chatroom/common/message"
+)
+
+//服务器启动后，初始化一个全局的messageDao实例
+var (
+	MyMessageDao *MessageDao
+)
+
+//聊天消息保存在redis中
+//messages              hash  mesId -> SmsMes的json
+//messages:seq          string 用来分配mesId
+//history:会话           zset  会话中的消息, score 为 mesId
+//                            大厅 history:group, 房间 history:room:roomId, 私聊 history:private:a:b
+//offline:userId        list  用户不在线时收到的私聊消息id
+//offline:mention:userId list 用户不在线时被@的群聊消息id
+//search:词              zset  聊天记录的倒排索引, 见 searchIndex.go
+type MessageDao struct {
+	pool *redis.Pool
+}
+
+//使用工厂模式，创建一个MessageDao实例
+func NewMessageDao(pool *redis.Pool) (messageDao *MessageDao) {
+
+	messageDao = &MessageDao{
+		pool: pool,
+	}
+	return
+}
+
+//消息所在会话的key
+//私聊按两个人的id排序，保证双方看到的是同一个会话
+func HistoryKey(smsMes *message.SmsMes) string {
+	if smsMes.ToUserId == 0 {
+		if smsMes.RoomId != "" {
+			return "history:room:" + smsMes.RoomId
+		}
+		return "history:group"
+	}
+	a, b := smsMes.UserId, smsMes.ToUserId
+	if a > b {
+		a, b = b, a
+	}
+	return fmt.Sprintf("history:private:%d:%d", a, b)
+}
+
+func offlineKey(userId int) string {
+	return "offline:" + strconv.Itoa(userId)
+}
+
+func offlineMentionKey(userId int) string {
+	return "offline:mention:" + strconv.Itoa(userId)
+}
+
+//保存一条新消息，分配mesId和时间
+func (this *MessageDao) AddMessage(smsMes *message.SmsMes) (err error) {
+
+	conn := this.pool.Get()
+	defer conn.Close()
+
+	smsMes.MesId, err = redis.Int(conn.Do("Incr", "messages:seq"))
+	if err != nil {
+		return
+	}
+	smsMes.SendTime = time.Now().Unix()
+	smsMes.MesStatus = message.MesSent
+	err = this.saveMessage(conn, smsMes)
+	if err != nil {
+		return
+	}
+	_, err = conn.Do("ZAdd", HistoryKey(smsMes), smsMes.MesId, smsMes.MesId)
+	if err != nil || smsMes.E2E != nil {
+		return
+	}
+	err = this.indexMessage(conn, smsMes.MesId, "", smsMes.Content)
+	return
+}
+
+func (this *MessageDao) saveMessage(conn redis.Conn, smsMes *message.SmsMes) (err error) {
+
+	data, err := json.Marshal(smsMes)
+	if err != nil {
+		return
+	}
+	_, err = conn.Do("HSet", "messages", smsMes.MesId, string(data))
+	if err != nil {
+		slog.Error("保存消息错误", "mes", smsMes.MesId, "err", err)
+	}
+	return
+}
+
+//根据mesId返回消息
+func (this *MessageDao) GetMessageById(mesId int) (smsMes *message.SmsMes, err error) {
+
+	conn := this.pool.Get()
+	defer conn.Close()
+	return this.getMessageById(conn, mesId)
+}
+
+func (this *MessageDao) getMessageById(conn redis.Conn, mesId int) (smsMes *message.SmsMes, err error) {
+
+	res, err := redis.String(conn.Do("HGet", "messages", mesId))
+	if err != nil {
+		if err == redis.ErrNil {
+			err = ERROR_MES_NOTEXISTS
+		}
+		return
+	}
+	smsMes = &message.SmsMes{}
+	err = json.Unmarshal([]byte(res), smsMes)
+	return
+}
+
+//修改一条消息, update 返回错误时不保存, 错误原样返回
+//返回修改后的消息, update 出错时返回修改前的消息
+//WATCH 以后再读出和写回, 别的服务器同时修改这条消息时事务不执行, 重新读出再调用update, 所以update 可能被调用多次
+func (this *MessageDao) UpdateMessage(mesId int, update func(smsMes *message.SmsMes) error) (smsMes *message.SmsMes, err error) {
+
+	conn := this.pool.Get()
+	defer conn.Close()
+
+	for {
+		_, err = conn.Do("Watch", "messages")
+		if err != nil {
+			return
+		}
+		smsMes, err = this.getMessageById(conn, mesId)
+		if err != nil {
+			conn.Do("Unwatch")
+			return
+		}
+		before := *smsMes
+		err = update(smsMes)
+		if err != nil {
+			conn.Do("Unwatch")
+			*smsMes = before
+			return
+		}
+		var data []byte
+		data, err = json.Marshal(smsMes)
+		if err != nil {
+			conn.Do("Unwatch")
+			return
+		}
+		//消息和搜索索引在同一个事务中修改, 索引和保存的内容一致
+		conn.Send("MULTI")
+		conn.Send("HSet", "messages", mesId, string(data))
+		if smsMes.Content != before.Content {
+			this.sendIndex(conn, mesId, before.Content, smsMes.Content)
+		}
+		_, err = redis.Values(conn.Do("EXEC"))
+		if err != redis.ErrNil {
+			if err != nil {
+				slog.Error("保存消息错误", "mes", mesId, "err", err)
+			}
+			return
+		}
+	}
+}
+
+var errUnchanged = errors.New("状态没有变化")
+
+//更新消息的投递状态，状态只能往前走: 已发送 -> 已送达 -> 已读
+//changed 表示状态是否真的发生了变化
+func (this *MessageDao) UpdateStatus(mesId int, status int) (smsMes *message.SmsMes, changed bool, err error) {
+
+	smsMes, err = this.UpdateMessage(mesId, func(smsMes *message.SmsMes) error {
+		if smsMes.MesStatus >= status {
+			return errUnchanged
+		}
+		smsMes.MesStatus = status
+		return nil
+	})
+	if err == errUnchanged {
+		return smsMes, false, nil
+	}
+	changed = err == nil
+	return
+}
+
+//返回某个会话最近的count条消息, 按时间从早到晚
+func (this *MessageDao) GetHistory(key string, count int) (messages []*message.SmsMes, err error) {
+
+	conn := this.pool.Get()
+	defer conn.Close()
+
+	ids, err := redis.Ints(conn.Do("ZRevRange", key, 0, count-1))
+	if err != nil {
+		return
+	}
+	for i := len(ids) - 1; i >= 0; i-- {
+		smsMes, err := this.getMessageById(conn, ids[i])
+		if err != nil {
+			continue
+		}
+		messages = append(messages, smsMes)
+	}
+	return
+}
+
+//接收方不在线，先记下来，等他上线后再推送
+func (this *MessageDao) AddOffline(userId int, mesId int) (err error) {
+	return this.pushOffline(offlineKey(userId), mesId)
+}
+
+//取出某个用户所有的离线消息
+func (this *MessageDao) PopOffline(userId int) (messages []*message.SmsMes, err error) {
+	return this.popOffline(offlineKey(userId))
+}
+
+//用户不在线时被@, 保存消息id, 上线后提醒
+func (this *MessageDao) AddOfflineMention(userId int, mesId int) (err error) {
+	return this.pushOffline(offlineMentionKey(userId), mesId)
+}
+
+//取出某个用户不在线时被@的消息
+func (this *MessageDao) PopOfflineMention(userId int) (messages []*message.SmsMes, err error) {
+	return this.popOffline(offlineMentionKey(userId))
+}
+
+func (this *MessageDao) pushOffline(key string, mesId int) (err error) {
+
+	conn := this.pool.Get()
+	defer conn.Close()
+	_, err = conn.Do("RPush", key, mesId)
+	return
+}
+
+func (this *MessageDao) popOffline(key string) (messages []*message.SmsMes, err error) {
+
+	conn := this.pool.Get()
+	defer conn.Close()
+
+	conn.Send("MULTI")
+	conn.Send("LRange", key, 0, -1)
+	conn.Send("Del", key)
+	res, err := redis.Values(conn.Do("EXEC"))
+	if err != nil {
+		return
+	}
+	ids, err := redis.Ints(res[0], nil)
+	if err != nil {
+		return
+	}
+	for _, id := range ids {
+		smsMes, err := this.getMessageById(conn, id)
+		if err != nil {
+			continue
+		}
+		messages = append(messages, smsMes)
+	}
+	return
+}
@@ -0,0 +1,149 @@
+package model
+
+import (
+	"encoding/json"
+	"strconv"
+	"time"
+
+	"github.com/garyburd/redigo/redis"
+	"go_code/chatroom/common/message"
+)
+
+//服务器启动后，初始化一个全局的moderationDao实例
+var (
+	MyModerationDao *ModerationDao
+)
+
+//禁言和封禁都是带过期时间的key, 过期后redis自动删除，也就自动解除了
+//mute:userId       禁言
+//ban:user:userId   按用户封禁
+//ban:ip:ip         按ip封禁
+//audit             list 管理操作的审计日志
+type ModerationDao struct {
+	pool *redis.Pool
+}
+
+//审计日志最多保留的条数
+const maxAuditLogs = 10000
+
+//一条审计日志
+type AuditLog struct {
+	Time int64 `json:"time"`
+	message.ModerateMes
+}
+
+//使用工厂模式，创建一个ModerationDao实例
+func NewModerationDao(pool *redis.Pool) (moderationDao *ModerationDao) {
+
+	moderationDao = &ModerationDao{
+		pool: pool,
+	}
+	return
+}
+
+func muteKey(userId int) string {
+	return "mute:" + strconv.Itoa(userId)
+}
+
+func banUserKey(userId int) string {
+	return "ban:user:" + strconv.Itoa(userId)
+}
+
+func banIpKey(ip string) string {
+	return "ban:ip:" + ip
+}
+
+//seconds 为 0 表示永久
+func (this *ModerationDao) setWithExpire(key string, value string, seconds int) (err error) {
+
+	conn := this.pool.Get()
+	defer conn.Close()
+	if seconds > 0 {
+		_, err = conn.Do("Set", key, value, "EX", seconds)
+	} else {
+		_, err = conn.Do("Set", key, value)
+	}
+	return
+}
+
+func (this *ModerationDao) del(key string) (err error) {
+
+	conn := this.pool.Get()
+	defer conn.Close()
+	_, err = conn.Do("Del", key)
+	return
+}
+
+//禁言seconds秒
+func (this *ModerationDao) Mute(userId int, seconds int, reason string) (err error) {
+	return this.setWithExpire(muteKey(userId), reason, seconds)
+}
+
+func (this *ModerationDao) Unmute(userId int) (err error) {
+	return this.del(muteKey(userId))
+}
+
+//返回用户是否被禁言，以及剩余的秒数
+func (this *ModerationDao) MutedFor(userId int) (muted bool, seconds int, err error) {
+
+	conn := this.pool.Get()
+	defer conn.Close()
+	//-2 表示key不存在, -1 表示没有过期时间
+	seconds, err = redis.Int(conn.Do("TTL", muteKey(userId)))
+	if err != nil {
+		return
+	}
+	muted = seconds != -2
+	return
+}
+
+func (this *ModerationDao) BanUser(userId int, seconds int, reason string) (err error) {
+	return this.setWithExpire(banUserKey(userId), reason, seconds)
+}
+
+func (this *ModerationDao) BanIp(ip string, seconds int, reason string) (err error) {
+	return this.setWithExpire(banIpKey(ip), reason, seconds)
+}
+
+func (this *ModerationDao) UnbanUser(userId int) (err error) {
+	return this.del(banUserKey(userId))
+}
+
+func (this *ModerationDao) UnbanIp(ip string) (err error) {
+	return this.del(banIpKey(ip))
+}
+
+func (this *ModerationDao) exists(key string) (ok bool, err error) {
+
+	conn := this.pool.Get()
+	defer conn.Close()
+	return redis.Bool(conn.Do("Exists", key))
+}
+
+func (this *ModerationDao) IsUserBanned(userId int) (banned bool, err error) {
+	return this.exists(banUserKey(userId))
+}
+
+func (this *ModerationDao) IsIpBanned(ip string) (banned bool, err error) {
+	return this.exists(banIpKey(ip))
+}
+
+//写一条审计日志
+func (this *ModerationDao) AddAudit(moderateMes *message.ModerateMes) (err error) {
+
+	data, err := json.Marshal(AuditLog{
+		Time:        time.Now().Unix(),
+		ModerateMes: *moderateMes,
+	})
+	if err != nil {
+		return
+	}
+	conn := this.pool.Get()
+	defer conn.Close()
+	_, err = conn.Do("RPush", "audit", string(data))
+	if err != nil {
+		return
+	}
+	_, err = conn.Do("LTrim", "audit", -maxAuditLogs, -1)
+	return
+}
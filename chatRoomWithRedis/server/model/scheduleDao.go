@@ -0,0 +1,156 @@
+package model
+
+import (
+	"encoding/json"
+	"log/slog"
+	"strconv"
+	"time"
+
+	"github.com/garyburd/redigo/redis"
+	"go_code/chatroom/common/message"
+	"go_code/chatroom/server/delayqueue"
+)
+
+//服务器启动后，初始化一个全局的scheduleDao实例
+var (
+	MyScheduleDao *ScheduleDao
+)
+
+//定时任务保存在延时队列 delay:schedule 中, 见 delayqueue 包
+//schedule:userId           set  用户还没有到时间的定时任务id
+//offline:reminder:userId   list 用户不在线时到时间的提醒, ReminderMes的json
+type ScheduleDao struct {
+	pool *redis.Pool
+	queue *delayqueue.Queue
+}
+
+//一个定时任务, 保存在延时队列中
+type ScheduledTask struct {
+	Id int `json:"-"` //延时队列分配的id
+	UserId int `json:"userId"` //创建任务的用户
+	message.ScheduleMes
+}
+
+//使用工厂模式，创建一个ScheduleDao实例
+func NewScheduleDao(pool *redis.Pool) (scheduleDao *ScheduleDao) {
+
+	scheduleDao = &ScheduleDao{
+		pool: pool,
+		queue: delayqueue.New(pool, "schedule"),
+	}
+	return
+}
+
+func scheduleKey(userId int) string {
+	return "schedule:" + strconv.Itoa(userId)
+}
+
+func offlineReminderKey(userId int) string {
+	return "offline:reminder:" + strconv.Itoa(userId)
+}
+
+//添加一个定时任务, 成功后task.Id 为任务的id
+func (this *ScheduleDao) Add(task *ScheduledTask) (err error) {
+
+	conn := this.pool.Get()
+	defer conn.Close()
+
+	ids, err := redis.Ints(conn.Do("SMembers", scheduleKey(task.UserId)))
+	if err != nil {
+		return
+	}
+	if len(ids) >= message.ScheduleMaxPending {
+		return ERROR_SCHEDULE_LIMIT
+	}
+	data, err := json.Marshal(task)
+	if err != nil {
+		return
+	}
+	task.Id, err = this.queue.Add(data, time.Unix(task.SendAt, 0))
+	if err != nil {
+		return
+	}
+	_, err = conn.Do("SAdd", scheduleKey(task.UserId), task.Id)
+	return
+}
+
+//取消自己的定时任务, 不是自己的或者已经到时间了返回 ERROR_SCHEDULE_NOTEXISTS
+func (this *ScheduleDao) Cancel(userId int, id int) (err error) {
+
+	conn := this.pool.Get()
+	defer conn.Close()
+
+	ok, err := redis.Bool(conn.Do("SRem", scheduleKey(userId), id))
+	if err != nil {
+		return
+	}
+	if !ok {
+		return ERROR_SCHEDULE_NOTEXISTS
+	}
+	ok, err = this.queue.Remove(id)
+	if err == nil && !ok {
+		err = ERROR_SCHEDULE_NOTEXISTS
+	}
+	return
+}
+
+//启动一个协程, 每隔interval取出到期的定时任务交给handler
+//多个服务器共用一个redis时, 一个任务只会交给其中一个服务器处理
+//handler 返回错误时稍后重试, 处理任务时服务器崩溃的话, delayqueue.VisibilityTimeout 之后会交给别的服务器重新处理
+func (this *ScheduleDao) Poll(interval time.Duration, handler func(task *ScheduledTask) error) (stop func()) {
+
+	return this.queue.Poll(interval, 100, func(item *delayqueue.Item) error {
+		task := &ScheduledTask{}
+		err := json.Unmarshal(item.Data, task)
+		if err != nil {
+			//重试也不会成功, 丢掉
+			slog.Error("定时任务格式错误", "id", item.Id, "err", err)
+			return nil
+		}
+		task.Id = item.Id
+		conn := this.pool.Get()
+		conn.Do("SRem", scheduleKey(task.UserId), task.Id)
+		conn.Close()
+		return handler(task)
+	})
+}
+
+//用户不在线时到时间的提醒, 等他上线后再推送
+func (this *ScheduleDao) AddOfflineReminder(userId int, reminder *message.ReminderMes) (err error) {
+
+	data, err := json.Marshal(reminder)
+	if err != nil {
+		return
+	}
+	conn := this.pool.Get()
+	defer conn.Close()
+	_, err = conn.Do("RPush", offlineReminderKey(userId), data)
+	return
+}
+
+//取出用户所有的离线提醒
+func (this *ScheduleDao) PopOfflineReminders(userId int) (reminders []*message.ReminderMes, err error) {
+
+	conn := this.pool.Get()
+	defer conn.Close()
+
+	conn.Send("MULTI")
+	conn.Send("LRange", offlineReminderKey(userId), 0, -1)
+	conn.Send("Del", offlineReminderKey(userId))
+	res, err := redis.Values(conn.Do("EXEC"))
+	if err != nil {
+		return
+	}
+	values, err := redis.ByteSlices(res[0], nil)
+	if err != nil {
+		return
+	}
+	for _, data := range values {
+		reminder := &message.ReminderMes{}
+		if json.Unmarshal(data, reminder) != nil {
+			continue
+		}
+		reminders = append(reminders, reminder)
+	}
+	return
+}
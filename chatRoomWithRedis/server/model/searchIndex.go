@@ -0,0 +1,224 @@
+package model
+
+import (
+	"strconv"
+	"strings"
+	"unicode"
+
+	"github.com/garyburd/redigo/redis"
+	"go_code/chatroom/common/message"
+)
+
+//聊天记录的倒排索引, 保存消息时更新
+//search:词   zset  包含这个词的消息, score 和 member 都是 mesId
+//英文和数字按单词索引, 中文没有空格, 按单字和相邻的两个字索引
+//加密消息服务器看不到内容, 不建立索引
+
+const (
+	//一条消息最多索引多少个词
+	maxIndexTerms = 200
+	//太长的词截断
+	maxTermLen = 32
+	//每次从索引中取出多少个mesId
+	searchBatch = 100
+	//一次搜索最多检查多少条消息, 没检查完时返回nextBeforeId让客户端继续
+	searchMaxScan = 5000
+)
+
+func searchKey(term string) string {
+	return "search:" + term
+}
+
+//消息内容中需要索引的词
+func IndexTerms(content string) []string {
+	return tokenize(content, true)
+}
+
+//搜索内容中的词, 中文只用相邻的两个字, 只有一个字时才用单字
+func QueryTerms(query string) []string {
+	return tokenize(query, false)
+}
+
+func tokenize(content string, index bool) (terms []string) {
+	seen := make(map[string]bool)
+	add := func(term string) {
+		if len(term) > maxTermLen {
+			term = term[:maxTermLen]
+			//不要截断在一个字符的中间
+			term = strings.ToValidUTF8(term, "")
+		}
+		if seen[term] || len(terms) >= maxIndexTerms {
+			return
+		}
+		seen[term] = true
+		terms = append(terms, term)
+	}
+	var word []rune
+	var han []rune
+	flushWord := func() {
+		if len(word) > 0 {
+			add(string(word))
+			word = word[:0]
+		}
+	}
+	flushHan := func() {
+		for i := range han {
+			if index || len(han) == 1 {
+				add(string(han[i]))
+			}
+			if i+1 < len(han) {
+				add(string(han[i : i+2]))
+			}
+		}
+		han = han[:0]
+	}
+	for _, r := range strings.ToLower(content) {
+		switch {
+			case unicode.Is(unicode.Han, r):
+				flushWord()
+				han = append(han, r)
+			case unicode.IsLetter(r) || unicode.IsDigit(r):
+				flushHan()
+				word = append(word, r)
+			default:
+				flushWord()
+				flushHan()
+		}
+	}
+	flushWord()
+	flushHan()
+	return
+}
+
+//消息内容从oldContent变成newContent时更新索引, 新消息的oldContent为空
+//删除消息时newContent为空
+func (this *MessageDao) indexMessage(conn redis.Conn, mesId int, oldContent string, newContent string) (err error) {
+
+	this.sendIndex(conn, mesId, oldContent, newContent)
+	_, err = conn.Do("")
+	return
+}
+
+//只发送更新索引的命令不等回复, 在MULTI 中调用时和其它修改一起执行
+func (this *MessageDao) sendIndex(conn redis.Conn, mesId int, oldContent string, newContent string) {
+
+	oldTerms := make(map[string]bool)
+	for _, term := range IndexTerms(oldContent) {
+		oldTerms[term] = true
+	}
+	newTerms := make(map[string]bool)
+	for _, term := range IndexTerms(newContent) {
+		newTerms[term] = true
+	}
+	for term := range oldTerms {
+		if !newTerms[term] {
+			conn.Send("ZRem", searchKey(term), mesId)
+		}
+	}
+	for term := range newTerms {
+		if !oldTerms[term] {
+			conn.Send("ZAdd", searchKey(term), mesId, mesId)
+		}
+	}
+}
+
+//搜索包含所有terms的消息, 按mesId从大到小
+//conversationKey 不为空时只在这个会话中搜索, 它和索引一样是以mesId为score的有序集合
+//从beforeId往前找(不包含beforeId), 0表示从最新的开始, 最多返回count条
+//filter 用来检查权限和其它条件, 返回false的消息跳过
+//nextBeforeId 不为0表示可能还有更多的结果
+func (this *MessageDao) Search(terms []string, conversationKey string, beforeId int, count int,
+	filter func(smsMes *message.SmsMes) bool) (messages []*message.SmsMes, nextBeforeId int, err error) {
+
+	conn := this.pool.Get()
+	defer conn.Close()
+
+	var keys []string
+	for _, term := range terms {
+		keys = append(keys, searchKey(term))
+	}
+	if conversationKey != "" {
+		keys = append(keys, conversationKey)
+	}
+	if len(keys) == 0 {
+		return
+	}
+
+	//从最小的集合开始找, 再检查是否在其它的集合中
+	for _, key := range keys {
+		conn.Send("ZCard", key)
+	}
+	cards, err := redis.Ints(conn.Do(""))
+	if err != nil {
+		return
+	}
+	smallest := 0
+	for i, n := range cards {
+		if n < cards[smallest] {
+			smallest = i
+		}
+	}
+	if cards[smallest] == 0 {
+		return
+	}
+	others := append(append([]string(nil), keys[:smallest]...), keys[smallest+1:]...)
+
+	max := "+inf"
+	if beforeId > 0 {
+		max = "(" + strconv.Itoa(beforeId)
+	}
+	scanned := 0
+	for {
+		ids, err := redis.Ints(conn.Do("ZRevRangeByScore", keys[smallest], max, "-inf", "LIMIT", 0, searchBatch))
+		if err != nil || len(ids) == 0 {
+			return messages, 0, err
+		}
+		matched, err := this.inAll(conn, ids, others)
+		if err != nil {
+			return messages, 0, err
+		}
+		for i, id := range ids {
+			scanned++
+			if matched[i] {
+				smsMes, err := this.getMessageById(conn, id)
+				if err == nil && filter(smsMes) {
+					messages = append(messages, smsMes)
+				}
+			}
+			if len(messages) >= count || scanned >= searchMaxScan {
+				return messages, id, nil
+			}
+		}
+		max = "(" + strconv.Itoa(ids[len(ids)-1])
+	}
+}
+
+//每个id是否在所有的keys中
+func (this *MessageDao) inAll(conn redis.Conn, ids []int, keys []string) (matched []bool, err error) {
+
+	matched = make([]bool, len(ids))
+	if len(keys) == 0 {
+		for i := range matched {
+			matched[i] = true
+		}
+		return
+	}
+	for _, id := range ids {
+		for _, key := range keys {
+			conn.Send("ZScore", key, id)
+		}
+	}
+	replies, err := redis.Values(conn.Do(""))
+	if err != nil {
+		return
+	}
+	for i := range ids {
+		matched[i] = true
+		for j := range keys {
+			if replies[i*len(keys)+j] == nil {
+				matched[i] = false
+			}
+		}
+	}
+	return
+}
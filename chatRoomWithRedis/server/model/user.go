@@ -9,4 +9,7 @@ type User struct {
 	UserId int `json:"userId"` 
 	UserPwd string `json:"userPwd"`
 	UserName string `json:"userName"`
+	Role int `json:"role"` //角色, 见message.RoleUser等
+	PublicKey []byte `json:"publicKey,omitempty"` //端到端加密用的公钥
+	Bot bool `json:"bot,omitempty"` //插件创建的机器人, 不能登录
 }
\ No newline at end of file
@@ -1,7 +1,9 @@
 package model
 
 import (
-	"fmt"
+	"log/slog"
+	"strconv"
+	"strings"
 	"github.com/garyburd/redigo/redis"
 	"go_code/chatroom/common/message"
 	"encoding/json"
@@ -15,6 +17,10 @@ var (
 
 //定义一个UserDao 结构体体
 //完成对User 结构体的各种操作.
+//users            hash  userId -> User的json
+//users:name       hash  小写的用户名 -> userId, 同名时只记录最先注册的用户, 用来解析 @用户名
+//settings:notify  hash  userId -> NotifySettings的json
+//rooms:user:userId set  用户进入过的房间, 搜索聊天记录时只能搜这些房间
 
 type UserDao struct {
 	pool  *redis.Pool
@@ -46,12 +52,20 @@ func (this *UserDao) getUserById(conn redis.Conn, id int) (user *User, err error
 	//这里我们需要把res 反序列化成User实例
 	err = json.Unmarshal([]byte(res), user)
 	if err != nil {
-		fmt.Println("json.Unmarshal err=", err)
+		slog.Error("用户信息格式错误", "user", id, "err", err)
 		return 
 	}
 	return 
 }
 
+//根据用户id 返回 一个User实例, 给其它模块使用
+func (this *UserDao) GetUserById(id int) (user *User, err error) {
+
+	conn := this.pool.Get() 
+	defer conn.Close()
+	return this.getUserById(conn, id)
+}
+
 //完成登录的校验 Login
 //1. Login 完成对用户的验证
 //2. 如果用户的id和pwd都正确，则返回一个user实例
@@ -66,10 +80,12 @@ func (this *UserDao) Login(userId int, userPwd string) (user *User, err error) {
 		return 
 	}
 	//这时证明这个用户是获取到.
-	if user.UserPwd != userPwd {
+	if user.UserPwd != userPwd || user.Bot {
 		err = ERROR_USER_PWD
 		return 
 	}
+	//以前注册的用户没有用户名索引, 登录时补上
+	this.indexName(conn, user.UserName, user.UserId)
 	return 
 }
 
@@ -92,8 +108,158 @@ func (this *UserDao) Register(user *message.User) (err error) {
 	//入库
 	_, err = conn.Do("HSet", "users", user.UserId, string(data))
 	if err != nil {
-		fmt.Println("保存注册用户错误 err=", err)
+		slog.Error("保存注册用户错误", "user", user.UserId, "err", err)
 		return 
 	}
+	this.indexName(conn, user.UserName, user.UserId)
 	return 
-}
\ No newline at end of file
+}
+
+//记录用户名对应的id, 用户名已经被别人用过时不覆盖
+func (this *UserDao) indexName(conn redis.Conn, userName string, userId int) {
+	name := strings.ToLower(strings.TrimSpace(userName))
+	if name == "" {
+		return
+	}
+	_, err := conn.Do("HSetNX", "users:name", name, userId)
+	if err != nil {
+		slog.Error("保存用户名索引错误", "user", userId, "err", err)
+	}
+}
+
+//根据用户名查找用户id, 不区分大小写
+func (this *UserDao) GetUserIdByName(userName string) (userId int, err error) {
+
+	conn := this.pool.Get() 
+	defer conn.Close()
+	userId, err = redis.Int(conn.Do("HGet", "users:name", strings.ToLower(userName)))
+	if err == redis.ErrNil {
+		err = ERROR_USER_NOTEXISTS
+	}
+	return
+}
+
+//读取用户的提醒设置, 没有设置过时返回空的设置
+func (this *UserDao) GetNotifySettings(userId int) (settings message.NotifySettings, err error) {
+
+	conn := this.pool.Get() 
+	defer conn.Close()
+	res, err := redis.String(conn.Do("HGet", "settings:notify", userId))
+	if err != nil {
+		if err == redis.ErrNil {
+			err = nil
+		}
+		return 
+	}
+	err = json.Unmarshal([]byte(res), &settings)
+	if err != nil {
+		slog.Error("提醒设置格式错误", "user", userId, "err", err)
+	}
+	return
+}
+
+//保存用户的提醒设置
+func (this *UserDao) SetNotifySettings(userId int, settings message.NotifySettings) (err error) {
+
+	conn := this.pool.Get() 
+	defer conn.Close()
+	data, err := json.Marshal(settings)
+	if err != nil {
+		return 
+	}
+	_, err = conn.Do("HSet", "settings:notify", userId, string(data))
+	return
+}
+
+//设置用户的角色
+func (this *UserDao) SetRole(userId int, role int) (err error) {
+	return this.updateUser(userId, func(user *message.User) {
+		user.Role = role
+	})
+}
+
+//保存用户发布的公钥
+func (this *UserDao) SetPublicKey(userId int, publicKey []byte) (err error) {
+	return this.updateUser(userId, func(user *message.User) {
+		user.PublicKey = publicKey
+	})
+}
+
+//修改用户信息中的某些字段
+//WATCH 以后再读出和写回, 同时有别的修改时事务不执行, 重新读出再修改, 不会丢失别的字段的更新
+func (this *UserDao) updateUser(userId int, update func(user *message.User)) (err error) {
+
+	conn := this.pool.Get() 
+	defer conn.Close()
+	for {
+		_, err = conn.Do("Watch", "users")
+		if err != nil {
+			return
+		}
+		var data []byte
+		data, err = this.updatedUser(conn, userId, update)
+		if err != nil {
+			conn.Do("Unwatch")
+			return
+		}
+		conn.Send("MULTI")
+		conn.Send("HSet", "users", userId, string(data))
+		_, err = redis.Values(conn.Do("EXEC"))
+		if err != redis.ErrNil {
+			return
+		}
+	}
+}
+
+//读出用户信息并修改, 返回修改后的json
+//注册时保存的是message.User, 这里按原样读出来再写回去，不丢失其它字段
+func (this *UserDao) updatedUser(conn redis.Conn, userId int, update func(user *message.User)) (data []byte, err error) {
+
+	res, err := redis.String(conn.Do("HGet", "users", userId))
+	if err != nil {
+		if err == redis.ErrNil {
+			err = ERROR_USER_NOTEXISTS
+		}
+		return 
+	}
+	var user message.User
+	err = json.Unmarshal([]byte(res), &user)
+	if err != nil {
+		return 
+	}
+	update(&user)
+	return json.Marshal(user)
+}
+
+//记录用户进入过的房间
+func (this *UserDao) AddJoinedRoom(userId int, roomId string) (err error) {
+
+	conn := this.pool.Get() 
+	defer conn.Close()
+	_, err = conn.Do("SAdd", "rooms:user:" + strconv.Itoa(userId), roomId)
+	return
+}
+
+//用户是否进入过这个房间
+func (this *UserDao) HasJoinedRoom(userId int, roomId string) (joined bool, err error) {
+
+	conn := this.pool.Get() 
+	defer conn.Close()
+	return redis.Bool(conn.Do("SIsMember", "rooms:user:" + strconv.Itoa(userId), roomId))
+}
+
+//用户进入过的房间
+func (this *UserDao) GetJoinedRooms(userId int) (rooms map[string]bool, err error) {
+
+	conn := this.pool.Get() 
+	defer conn.Close()
+	list, err := redis.Strings(conn.Do("SMembers", "rooms:user:" + strconv.Itoa(userId)))
+	if err != nil {
+		return
+	}
+	rooms = make(map[string]bool)
+	for _, roomId := range list {
+		rooms[roomId] = true
+	}
+	return
+}
@@ -0,0 +1,56 @@
+package bots
+
+import (
+	"encoding/json"
+
+	"go_code/chatroom/common/message"
+	"go_code/chatroom/server/plugin"
+)
+
+//服务器自带的插件, 在main中导入这个包后就可以在配置文件中启用
+
+func init() {
+	plugin.Register("echo", func() plugin.Plugin { return &echoPlugin{} })
+	plugin.Register("remind", func() plugin.Plugin { return &remindPlugin{} })
+	plugin.Register("webhook", func() plugin.Plugin { return &webhookPlugin{} })
+	plugin.Register("filter", func() plugin.Plugin { return &filterPlugin{} })
+}
+
+//机器人的配置, 各个插件共用
+type botConfig struct {
+	BotId   int    `json:"botId"`
+	BotName string `json:"botName"`
+}
+
+//解析配置, 没有配置时使用默认值
+func parseConfig(config json.RawMessage, v interface{}) error {
+	if len(config) == 0 {
+		return nil
+	}
+	return json.Unmarshal(config, v)
+}
+
+//回声机器人: /echo <内容> 让机器人把内容再说一遍
+type echoPlugin struct {
+	bot *plugin.Bot
+}
+
+func (this *echoPlugin) Init(host *plugin.Host, config json.RawMessage) (err error) {
+	conf := botConfig{BotId: 9001, BotName: "echo"}
+	err = parseConfig(config, &conf)
+	if err != nil {
+		return
+	}
+	this.bot, err = host.NewBot(conf.BotId, conf.BotName)
+	if err != nil {
+		return
+	}
+	return host.RegisterCommand("echo", "/echo <内容> 机器人重复你说的话", this.echo)
+}
+
+func (this *echoPlugin) echo(ctx *plugin.Context, smsMes *message.SmsMes, args string) {
+	if args == "" {
+		args = "用法: /echo <内容>"
+	}
+	this.bot.Reply(smsMes, args)
+}
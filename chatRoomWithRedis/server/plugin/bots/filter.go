@@ -0,0 +1,65 @@
+package bots
+
+import (
+	"encoding/json"
+	"strings"
+
+	"go_code/chatroom/common/message"
+	"go_code/chatroom/server/plugin"
+)
+
+//敏感词过滤: 把群聊和私聊消息中的敏感词替换成 *
+//加密消息服务器看不到内容, 不处理
+type filterPlugin struct {
+	replacer *strings.Replacer
+}
+
+type filterConfig struct {
+	Words []string `json:"words"`
+	Mask  string   `json:"mask"` //用来替换的字符, 默认是 *
+}
+
+func (this *filterPlugin) Init(host *plugin.Host, config json.RawMessage) (err error) {
+	conf := filterConfig{Mask: "*"}
+	err = parseConfig(config, &conf)
+	if err != nil {
+		return
+	}
+	var pairs []string
+	for _, word := range conf.Words {
+		if word == "" {
+			continue
+		}
+		pairs = append(pairs, word, strings.Repeat(conf.Mask, len([]rune(word))))
+	}
+	this.replacer = strings.NewReplacer(pairs...)
+	return
+}
+
+func (this *filterPlugin) OnMessage(ctx *plugin.Context, mes *message.Message) bool {
+	if mes.Type != message.SmsMesType && mes.Type != message.EditMesType {
+		return true
+	}
+	//只修改内容, 其它字段原样保留
+	var fields map[string]json.RawMessage
+	if json.Unmarshal([]byte(mes.Data), &fields) != nil {
+		return true
+	}
+	if e2e, ok := fields["e2e"]; ok && string(e2e) != "null" {
+		return true
+	}
+	var content string
+	if json.Unmarshal(fields["content"], &content) != nil {
+		return true
+	}
+	filtered := this.replacer.Replace(content)
+	if filtered == content {
+		return true
+	}
+	fields["content"], _ = json.Marshal(filtered)
+	data, err := json.Marshal(fields)
+	if err == nil {
+		mes.Data = string(data)
+	}
+	return true
+}
@@ -0,0 +1,69 @@
+package bots
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"go_code/chatroom/common/message"
+	"go_code/chatroom/server/plugin"
+)
+
+//提醒机器人: /remind 10m 喝水, 和客户端的 /remind 是同一个功能
+//提醒交给服务器的定时任务保存在redis中, 服务器重启后不会丢失, 到时间后用户收到ReminderMes
+//客户端会自己处理 /remind, 只有不认识这个命令的客户端才会发到这里
+type remindPlugin struct {
+	host *plugin.Host
+	bot  *plugin.Bot
+	//最长的提醒时间, 每个用户最多的提醒数和定时消息一起由服务器限制
+	maxDelay time.Duration
+}
+
+type remindConfig struct {
+	botConfig
+	MaxDelay string `json:"maxDelay"`
+}
+
+func (this *remindPlugin) Init(host *plugin.Host, config json.RawMessage) (err error) {
+	conf := remindConfig{
+		botConfig: botConfig{BotId: 9002, BotName: "remind"},
+		MaxDelay:  "24h",
+	}
+	err = parseConfig(config, &conf)
+	if err != nil {
+		return
+	}
+	this.maxDelay, err = time.ParseDuration(conf.MaxDelay)
+	if err != nil {
+		return
+	}
+	this.host = host
+	this.bot, err = host.NewBot(conf.BotId, conf.BotName)
+	if err != nil {
+		return
+	}
+	return host.RegisterCommand("remind", "/remind <时间> <内容> 到时间后提醒我, 比如 /remind 10m 喝水", this.remind)
+}
+
+func (this *remindPlugin) remind(ctx *plugin.Context, smsMes *message.SmsMes, args string) {
+	delayArg, text, _ := strings.Cut(args, " ")
+	text = strings.TrimSpace(text)
+	//和客户端一样可以写成 +10m
+	delay, err := time.ParseDuration(strings.TrimPrefix(delayArg, "+"))
+	if err != nil || delay <= 0 || text == "" {
+		this.bot.SendPrivate(ctx.UserId, "用法: /remind <时间> <内容>, 时间比如 30s 10m 2h")
+		return
+	}
+	if delay > this.maxDelay {
+		this.bot.SendPrivate(ctx.UserId, fmt.Sprintf("最多只能提醒%v 以后的事", this.maxDelay))
+		return
+	}
+
+	id, err := this.host.ScheduleReminder(ctx.UserId, text, time.Now().Add(delay))
+	if err != nil {
+		this.bot.SendPrivate(ctx.UserId, "设置提醒失败: "+err.Error())
+		return
+	}
+	this.bot.SendPrivate(ctx.UserId, fmt.Sprintf("好的, %v 后提醒你: %s, 可以用 /unschedule %d 取消", delay, text, id))
+}
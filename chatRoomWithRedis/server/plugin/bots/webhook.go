@@ -0,0 +1,113 @@
+package bots
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	"go_code/chatroom/server/plugin"
+)
+
+//构建通知机器人: 监听一个http地址, CI 等外部系统 POST 文本后, 机器人把它发到房间中
+//例子:
+//	curl -H 'X-Token: secret' -d '构建 #42 成功' 'http://127.0.0.1:8891/notify?room=ci'
+//没有指定room时发到配置中的房间
+type webhookPlugin struct {
+	host   *plugin.Host
+	bot    *plugin.Bot
+	room   string
+	token  string
+	server *http.Server
+}
+
+type webhookConfig struct {
+	botConfig
+	Addr  string `json:"addr"`
+	Room  string `json:"room"`
+	Token string `json:"token"` //不为空时请求必须带上 X-Token 头
+}
+
+//一条通知的最大长度
+const webhookMaxBody = 4096
+
+func (this *webhookPlugin) Init(host *plugin.Host, config json.RawMessage) (err error) {
+	conf := webhookConfig{
+		botConfig: botConfig{BotId: 9003, BotName: "ci"},
+		Addr:      "127.0.0.1:8891",
+	}
+	err = parseConfig(config, &conf)
+	if err != nil {
+		return
+	}
+	this.host = host
+	this.room = conf.Room
+	this.token = conf.Token
+	this.bot, err = host.NewBot(conf.BotId, conf.BotName)
+	if err != nil {
+		return
+	}
+
+	listener, err := net.Listen("tcp", conf.Addr)
+	if err != nil {
+		return
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/notify", this.notify)
+	this.server = &http.Server{
+		Handler:     mux,
+		ReadTimeout: 10 * time.Second,
+	}
+	host.Go(func() {
+		host.Logger().Info("构建通知的http服务已启动", "addr", listener.Addr().String())
+		err := this.server.Serve(listener)
+		if err != nil && !errors.Is(err, http.ErrServerClosed) {
+			host.Logger().Error("构建通知的http服务出错", "err", err)
+		}
+	})
+	return
+}
+
+func (this *webhookPlugin) notify(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "只支持POST", http.StatusMethodNotAllowed)
+		return
+	}
+	if this.token != "" && r.Header.Get("X-Token") != this.token {
+		http.Error(w, "token不正确", http.StatusUnauthorized)
+		return
+	}
+	body, err := io.ReadAll(io.LimitReader(r.Body, webhookMaxBody+1))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if len(body) > webhookMaxBody {
+		http.Error(w, fmt.Sprintf("内容不能超过%d个字节", webhookMaxBody), http.StatusRequestEntityTooLarge)
+		return
+	}
+	text := strings.TrimSpace(string(body))
+	if text == "" {
+		http.Error(w, "内容不能为空", http.StatusBadRequest)
+		return
+	}
+	room := this.room
+	if r.URL.Query().Has("room") {
+		room = r.URL.Query().Get("room")
+	}
+	err = this.bot.SendGroup(room, text)
+	if err != nil {
+		this.host.Logger().Error("发送构建通知失败", "room", room, "err", err)
+		http.Error(w, "发送失败", http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (this *webhookPlugin) Close() error {
+	return this.server.Close()
+}
@@ -0,0 +1,308 @@
+package plugin
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"runtime/debug"
+	"strings"
+	"sync"
+	"time"
+
+	"go_code/chatroom/common/message"
+	"go_code/chatroom/server/metrics"
+	"go_code/chatroom/server/model"
+	"go_code/chatroom/server/process"
+	"go_code/chatroom/server/utils"
+)
+
+//管理所有启用的插件, 由Processor在处理每条消息前调用
+type Manager struct {
+	plugins []*entry
+	lock     sync.RWMutex
+	commands map[string]*command
+}
+
+//一个启用的插件
+type entry struct {
+	name   string
+	plugin Plugin
+	hook   MessageHook
+}
+
+type command struct {
+	plugin  string
+	help    string
+	handler CommandFunc
+}
+
+func NewManager() *Manager {
+	return &Manager{
+		commands: make(map[string]*command),
+	}
+}
+
+//按配置创建并初始化插件, 任何一个失败都返回错误
+func (this *Manager) Load(config *Config) (err error) {
+	for _, pc := range config.Plugins {
+		p, err := newPlugin(pc.Name)
+		if err != nil {
+			return err
+		}
+		host := &Host{
+			mgr:  this,
+			name: pc.Name,
+		}
+		this.safe(pc.Name, func() {
+			err = p.Init(host, pc.Config)
+		}, func() {
+			err = fmt.Errorf("插件%s 初始化时panic", pc.Name)
+		})
+		if err != nil {
+			return fmt.Errorf("插件%s 初始化失败: %v", pc.Name, err)
+		}
+		e := &entry{
+			name:   pc.Name,
+			plugin: p,
+		}
+		e.hook, _ = p.(MessageHook)
+		this.plugins = append(this.plugins, e)
+		slog.Info("插件已启用", "plugin", pc.Name)
+	}
+	return
+}
+
+//调用fn, fn panic时记录日志并调用onPanic
+func (this *Manager) safe(name string, fn func(), onPanic func()) {
+	defer func() {
+		if r := recover(); r != nil {
+			metrics.PluginPanics.Inc(name)
+			slog.Error("插件panic", "plugin", name, "panic", r, "stack", string(debug.Stack()))
+			if onPanic != nil {
+				onPanic()
+			}
+		}
+	}()
+	fn()
+}
+
+//客户端发来的消息先交给插件, 返回true表示插件已经处理了这条消息, 服务器不再处理
+func (this *Manager) OnMessage(ctx *Context, mes *message.Message) (handled bool) {
+	for _, e := range this.plugins {
+		if e.hook == nil {
+			continue
+		}
+		keep := true
+		this.safe(e.name, func() {
+			keep = e.hook.OnMessage(ctx, mes)
+		}, nil)
+		if !keep {
+			return true
+		}
+	}
+	if mes.Type == message.SmsMesType && ctx.UserId != 0 {
+		return this.runCommand(ctx, mes)
+	}
+	return false
+}
+
+//群聊或私聊消息的内容是插件注册的斜杠命令时, 交给插件处理, 不再转发
+func (this *Manager) runCommand(ctx *Context, mes *message.Message) bool {
+	var smsMes message.SmsMes
+	err := json.Unmarshal([]byte(mes.Data), &smsMes)
+	if err != nil || smsMes.E2E != nil || !strings.HasPrefix(smsMes.Content, "/") {
+		return false
+	}
+	name, args, _ := strings.Cut(smsMes.Content[1:], " ")
+	this.lock.RLock()
+	cmd, ok := this.commands[strings.ToLower(name)]
+	this.lock.RUnlock()
+	if !ok {
+		return false
+	}
+
+	//命令不保存也不转发, 告诉客户端已经收到
+	//被禁言的用户和发普通消息一样返回407, 不能通过命令让机器人替他发言
+	resMes := message.SmsResMes{
+		Code:    200,
+		LocalId: smsMes.LocalId,
+	}
+	muted, seconds, _ := model.MyModerationDao.MutedFor(ctx.UserId)
+	if muted {
+		resMes.Code = 407
+		if seconds > 0 {
+			resMes.Error = fmt.Sprintf("%s, 还剩%d秒解除", model.ERROR_USER_MUTED.Error(), seconds)
+		} else {
+			resMes.Error = model.ERROR_USER_MUTED.Error()
+		}
+	}
+	tf := &utils.Transfer{
+		Conn: ctx.Conn,
+	}
+	err = tf.WriteMes(message.SmsResMesType, resMes)
+	if err != nil || muted {
+		return true
+	}
+	smsMes.UserId = ctx.UserId
+	smsMes.RoomId = ""
+	if smsMes.ToUserId == 0 {
+		smsMes.RoomId = process2.UserRoom(ctx.Conn, ctx.UserId)
+	}
+	smsMes.SendTime = time.Now().Unix()
+	this.safe(cmd.plugin, func() {
+		cmd.handler(ctx, &smsMes, strings.TrimSpace(args))
+	}, nil)
+	return true
+}
+
+//所有插件注册的命令, 命令名 -> 说明
+func (this *Manager) Commands() map[string]string {
+	this.lock.RLock()
+	defer this.lock.RUnlock()
+	res := make(map[string]string)
+	for name, cmd := range this.commands {
+		res[name] = cmd.help
+	}
+	return res
+}
+
+//关闭所有实现了Closer的插件
+func (this *Manager) Close() {
+	for i := len(this.plugins) - 1; i >= 0; i-- {
+		e := this.plugins[i]
+		closer, ok := e.plugin.(Closer)
+		if !ok {
+			continue
+		}
+		this.safe(e.name, func() {
+			if err := closer.Close(); err != nil {
+				slog.Warn("关闭插件失败", "plugin", e.name, "err", err)
+			}
+		}, nil)
+	}
+}
+
+//插件调用服务器的接口, 每个插件一个
+type Host struct {
+	mgr  *Manager
+	name string
+}
+
+//插件的名字
+func (this *Host) Name() string {
+	return this.name
+}
+
+//带有插件名的日志
+func (this *Host) Logger() *slog.Logger {
+	return slog.Default().With("plugin", this.name)
+}
+
+//注册一个斜杠命令, name 不包括 /, 不区分大小写
+func (this *Host) RegisterCommand(name string, help string, handler CommandFunc) error {
+	name = strings.ToLower(name)
+	if name == "" || strings.ContainsAny(name, " \t\r\n/") {
+		return fmt.Errorf("命令名不合法: %q", name)
+	}
+	this.mgr.lock.Lock()
+	defer this.mgr.lock.Unlock()
+	if cmd, ok := this.mgr.commands[name]; ok {
+		return fmt.Errorf("命令/%s 已经被插件%s 注册", name, cmd.plugin)
+	}
+	this.mgr.commands[name] = &command{
+		plugin:  this.name,
+		help:    help,
+		handler: handler,
+	}
+	return nil
+}
+
+//在新的协程中运行fn, panic时只记录日志
+func (this *Host) Go(fn func()) {
+	go this.mgr.safe(this.name, fn, nil)
+}
+
+//d 时间后在新的协程中运行fn, panic时只记录日志
+func (this *Host) AfterFunc(d time.Duration, fn func()) *time.Timer {
+	return time.AfterFunc(d, func() {
+		this.mgr.safe(this.name, fn, nil)
+	})
+}
+
+//sendAt 时提醒用户, 和客户端的 /remind 一样保存在redis中, 服务器重启后不会丢失
+//到时间后用户收到ReminderMes, 可以用 /unschedule 加返回的id取消
+func (this *Host) ScheduleReminder(userId int, content string, sendAt time.Time) (id int, err error) {
+	resMes := process2.ScheduleReminder(userId, content, sendAt)
+	if resMes.Code != 200 {
+		return 0, errors.New(resMes.Error)
+	}
+	return resMes.Id, nil
+}
+
+//创建机器人用户, 已经存在时直接使用
+//机器人的密码是随机的, 并且不能登录, 已经存在的普通用户不能当作机器人
+func (this *Host) NewBot(userId int, name string) (bot *Bot, err error) {
+	if userId <= 0 {
+		return nil, fmt.Errorf("机器人的id不合法: %d", userId)
+	}
+	pwd := make([]byte, 16)
+	_, err = rand.Read(pwd)
+	if err != nil {
+		return
+	}
+	err = model.MyUserDao.Register(&message.User{
+		UserId:   userId,
+		UserPwd:  hex.EncodeToString(pwd),
+		UserName: name,
+		Bot:      true,
+	})
+	if err == model.ERROR_USER_EXISTS {
+		var user *model.User
+		user, err = model.MyUserDao.GetUserById(userId)
+		if err == nil && !user.Bot {
+			err = fmt.Errorf("用户%d 已经存在, 并且不是机器人", userId)
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &Bot{
+		UserId: userId,
+		Name:   name,
+	}, nil
+}
+
+//机器人用户, 发出的消息和普通用户的消息一样保存和转发
+type Bot struct {
+	UserId int
+	Name   string
+}
+
+//发群聊消息到某个房间, 空字符串表示大厅
+func (this *Bot) SendGroup(roomId string, content string) error {
+	return process2.SendBotMes(&message.SmsMes{
+		Content: content,
+		User:    message.User{UserId: this.UserId},
+		RoomId:  roomId,
+	})
+}
+
+//发私聊消息, 对方不在线时上线后收到
+func (this *Bot) SendPrivate(toUserId int, content string) error {
+	return process2.SendBotMes(&message.SmsMes{
+		Content:  content,
+		User:     message.User{UserId: this.UserId},
+		ToUserId: toUserId,
+	})
+}
+
+//回复一条消息: 群聊时发到同一个房间, 私聊时私聊回复发送方
+func (this *Bot) Reply(smsMes *message.SmsMes, content string) error {
+	if smsMes.ToUserId != 0 {
+		return this.SendPrivate(smsMes.UserId, content)
+	}
+	return this.SendGroup(smsMes.RoomId, content)
+}
@@ -0,0 +1,117 @@
+package plugin
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"os"
+	"sort"
+	"sync"
+
+	"go_code/chatroom/common/message"
+)
+
+//服务器插件, 用来在服务器中运行机器人, 比如回声, 提醒, 构建通知
+//插件包在init中调用Register注册自己, 服务器启动时按配置文件创建并初始化启用的插件
+//插件可以:
+//	1. 实现MessageHook, 观察或修改客户端发来的每条消息
+//	2. 在Init中用Host.RegisterCommand注册斜杠命令, 比如群聊中输入的 /echo hello
+//	3. 用Host.NewBot创建机器人用户, 以机器人的身份发群聊和私聊消息
+//插件的panic会被recover并记录, 不会影响客户端的连接
+
+type Plugin interface {
+	//服务器启动时调用, config 是配置文件中这个插件的配置, 没有配置时为nil
+	Init(host *Host, config json.RawMessage) error
+}
+
+//可选的接口, 客户端发来的每条消息在服务器处理之前先交给插件
+//可以修改mes, 返回false时服务器丢弃这条消息
+type MessageHook interface {
+	OnMessage(ctx *Context, mes *message.Message) bool
+}
+
+//可选的接口, 服务器关闭时调用
+type Closer interface {
+	Close() error
+}
+
+//消息来自哪个连接
+type Context struct {
+	Conn net.Conn
+	//0 表示还没有登录
+	UserId int
+}
+
+//斜杠命令的处理函数, smsMes 是用户发的消息, 发送方和房间已经由服务器填好
+//args 是命令名后面的内容
+type CommandFunc func(ctx *Context, smsMes *message.SmsMes, args string)
+
+var (
+	factories = make(map[string]func() Plugin)
+	factoriesLock sync.Mutex
+)
+
+//注册一个插件, 同名的插件注册两次会panic
+func Register(name string, factory func() Plugin) {
+	factoriesLock.Lock()
+	defer factoriesLock.Unlock()
+	if _, ok := factories[name]; ok {
+		panic("plugin: 插件重复注册 " + name)
+	}
+	factories[name] = factory
+}
+
+//已经注册的插件名, 按字母顺序
+func Names() (names []string) {
+	factoriesLock.Lock()
+	defer factoriesLock.Unlock()
+	for name := range factories {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return
+}
+
+func newPlugin(name string) (Plugin, error) {
+	factoriesLock.Lock()
+	defer factoriesLock.Unlock()
+	factory, ok := factories[name]
+	if !ok {
+		return nil, fmt.Errorf("插件%s 不存在, 已注册的插件: %v", name, namesLocked())
+	}
+	return factory(), nil
+}
+
+func namesLocked() (names []string) {
+	for name := range factories {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return
+}
+
+//配置文件的格式, 比如
+//	{"plugins": [{"name": "echo", "config": {"botId": 9001}}]}
+//插件按顺序初始化, MessageHook也按这个顺序调用
+type Config struct {
+	Plugins []PluginConfig `json:"plugins"`
+}
+
+type PluginConfig struct {
+	Name   string          `json:"name"`
+	Config json.RawMessage `json:"config"`
+}
+
+//读取配置文件
+func LoadConfig(path string) (config *Config, err error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return
+	}
+	config = &Config{}
+	err = json.Unmarshal(data, config)
+	if err != nil {
+		return nil, fmt.Errorf("插件配置文件%s 格式错误: %v", path, err)
+	}
+	return
+}
@@ -0,0 +1,266 @@
+package process2
+
+import (
+	"encoding/json"
+	"strings"
+	"time"
+
+	"go_code/chatroom/common/message"
+	"go_code/chatroom/server/events"
+	"go_code/chatroom/server/logger"
+	"go_code/chatroom/server/model"
+	"go_code/chatroom/server/utils"
+)
+
+//修改, 删除和回应消息
+//修改后的消息保存在redis中, 查询聊天记录时看到的是修改后的内容
+//同时把操作转发给能看到这条消息的在线用户, 让客户端更新已经显示的内容
+
+//消息操作失败的原因, Code 是返回给客户端的状态码
+type mesActionError struct {
+	Code int
+	Text string
+}
+
+func (this *mesActionError) Error() string {
+	return this.Text
+}
+
+var (
+	errMesNotLogin     = &mesActionError{403, "请先登录"}
+	errMesNoPermission = &mesActionError{401, "只有发送方和版主可以操作这条消息"}
+	errMesNotExists    = &mesActionError{500, model.ERROR_MES_NOTEXISTS.Error()}
+	errMesDeleted      = &mesActionError{400, "消息已被删除"}
+	errMesEmpty        = &mesActionError{400, "内容不能为空"}
+	errMesE2E          = &mesActionError{400, "加密消息不能修改"}
+	errBadEmoji        = &mesActionError{400, "表情不能为空, 不能包含空白字符, 并且不能太长"}
+)
+
+//消息操作对应的事件类型
+var mesEventTypes = map[string]string{
+	message.EditMesType:   events.TypeEdit,
+	message.DeleteMesType: events.TypeDelete,
+	message.ReactMesType:  events.TypeReact,
+}
+
+//处理修改消息
+func (this *SmsProcess) ServerProcessEdit(mes *message.Message) (err error) {
+
+	var editMes message.EditMes
+	err = json.Unmarshal([]byte(mes.Data), &editMes)
+	if err != nil {
+		logger.For(this.Conn, this.UserId).Warn("消息格式错误", "type", mes.Type, "err", err)
+		return
+	}
+	editMes.UserId = this.UserId
+	editMes.EditTime = time.Now().Unix()
+	return this.mesAction(mes.Type, editMes.MesId, true, func(smsMes *message.SmsMes) error {
+		if strings.TrimSpace(editMes.Content) == "" {
+			return errMesEmpty
+		}
+		if smsMes.E2E != nil {
+			return errMesE2E
+		}
+		smsMes.Content = editMes.Content
+		smsMes.EditTime = editMes.EditTime
+		return nil
+	}, editMes)
+}
+
+//处理删除消息, 消息保留在聊天记录中, 只是内容被清空
+func (this *SmsProcess) ServerProcessDelete(mes *message.Message) (err error) {
+
+	var deleteMes message.DeleteMes
+	err = json.Unmarshal([]byte(mes.Data), &deleteMes)
+	if err != nil {
+		logger.For(this.Conn, this.UserId).Warn("消息格式错误", "type", mes.Type, "err", err)
+		return
+	}
+	deleteMes.UserId = this.UserId
+	return this.mesAction(mes.Type, deleteMes.MesId, true, func(smsMes *message.SmsMes) error {
+		smsMes.Deleted = true
+		smsMes.Content = ""
+		smsMes.E2E = nil
+		smsMes.Reactions = nil
+		return nil
+	}, deleteMes)
+}
+
+//处理表情回应, 能看到这条消息的用户都可以回应
+func (this *SmsProcess) ServerProcessReact(mes *message.Message) (err error) {
+
+	var reactMes message.ReactMes
+	err = json.Unmarshal([]byte(mes.Data), &reactMes)
+	if err != nil {
+		logger.For(this.Conn, this.UserId).Warn("消息格式错误", "type", mes.Type, "err", err)
+		return
+	}
+	reactMes.UserId = this.UserId
+	return this.mesAction(mes.Type, reactMes.MesId, false, func(smsMes *message.SmsMes) error {
+		if reactMes.Emoji == "" || len(reactMes.Emoji) > message.ReactionMaxLen ||
+			strings.ContainsAny(reactMes.Emoji, " \t\r\n") {
+			return errBadEmoji
+		}
+		//复制一份再修改, 出错时不影响原来的消息
+		reactions := make(map[string][]int)
+		for emoji, usersId := range smsMes.Reactions {
+			reactions[emoji] = append([]int(nil), usersId...)
+		}
+		usersId := removeId(reactions[reactMes.Emoji], this.UserId)
+		if !reactMes.Remove {
+			usersId = append(usersId, this.UserId)
+		}
+		if len(usersId) == 0 {
+			delete(reactions, reactMes.Emoji)
+		} else {
+			reactions[reactMes.Emoji] = usersId
+		}
+		if len(reactions) == 0 {
+			reactions = nil
+		}
+		smsMes.Reactions = reactions
+		reactMes.Reactions = reactions
+		return nil
+	}, &reactMes)
+}
+
+func removeId(ids []int, id int) (res []int) {
+	for _, v := range ids {
+		if v != id {
+			res = append(res, v)
+		}
+	}
+	return
+}
+
+//检查权限后修改消息, 回复操作的结果, 成功后把notice转发给能看到这条消息的在线用户
+//ownerOnly 为true时只有发送方和版主可以操作
+func (this *SmsProcess) mesAction(mesType string, mesId int, ownerOnly bool,
+	update func(smsMes *message.SmsMes) error, notice interface{}) (err error) {
+
+	var smsMes *message.SmsMes
+	actionErr := this.checkMesActor(mesType)
+	if actionErr == nil {
+		smsMes, actionErr = model.MyMessageDao.UpdateMessage(mesId, func(smsMes *message.SmsMes) error {
+			err := this.checkMesAccess(smsMes, ownerOnly)
+			if err != nil {
+				return err
+			}
+			return update(smsMes)
+		})
+	}
+
+	resMes := message.MesActionResMes{
+		Type:  mesType,
+		MesId: mesId,
+	}
+	if e, ok := actionErr.(*mesActionError); ok {
+		resMes.Code = e.Code
+		resMes.Error = e.Text
+	} else if actionErr == model.ERROR_MES_NOTEXISTS {
+		resMes.Code = 500
+		resMes.Error = actionErr.Error()
+	} else if actionErr != nil {
+		logger.For(this.Conn, this.UserId).Error("修改消息错误", "type", mesType, "mes", mesId, "err", actionErr)
+		resMes.Code = 505
+		resMes.Error = "服务器内部错误..."
+	} else {
+		resMes.Code = 200
+	}
+	tf := &utils.Transfer{
+		Conn: this.Conn,
+	}
+	err = tf.WriteMes(message.MesActionResMesType, resMes)
+	if err != nil || actionErr != nil {
+		return
+	}
+	events.MessageEvent(mesEventTypes[mesType], this.UserId, smsMes)
+	this.sendToAudience(smsMes, mesType, notice)
+	return
+}
+
+//操作的人必须已经登录, 被禁言时只能删除消息
+func (this *SmsProcess) checkMesActor(mesType string) error {
+	if this.UserId == 0 {
+		return errMesNotLogin
+	}
+	if mesType == message.DeleteMesType {
+		return nil
+	}
+	if muted, _, _ := model.MyModerationDao.MutedFor(this.UserId); muted {
+		return &mesActionError{407, model.ERROR_USER_MUTED.Error()}
+	}
+	return nil
+}
+
+//检查当前用户能否操作这条消息
+func (this *SmsProcess) checkMesAccess(smsMes *message.SmsMes, ownerOnly bool) error {
+	//看不到的私聊消息当作不存在
+	if smsMes.ToUserId != 0 && smsMes.UserId != this.UserId && smsMes.ToUserId != this.UserId {
+		return errMesNotExists
+	}
+	//和搜索一样, 没有进入过的房间中的消息也看不到, 大厅所有人都在
+	if smsMes.ToUserId == 0 && smsMes.RoomId != "" {
+		joined, err := model.MyUserDao.HasJoinedRoom(this.UserId, smsMes.RoomId)
+		if err != nil {
+			return err
+		}
+		if !joined {
+			return errMesNotExists
+		}
+	}
+	if smsMes.Deleted {
+		return errMesDeleted
+	}
+	if !ownerOnly || smsMes.UserId == this.UserId {
+		return nil
+	}
+	user, err := model.MyUserDao.GetUserById(this.UserId)
+	if err != nil {
+		return err
+	}
+	if user.Role < message.RoleModerator {
+		return errMesNoPermission
+	}
+	return nil
+}
+
+//能看到这条消息的在线用户: 私聊的双方, 或者群聊所在房间中的所有人
+func mesAudience(smsMes *message.SmsMes) (ups []*UserProcess) {
+	if smsMes.ToUserId != 0 {
+		for _, id := range []int{smsMes.UserId, smsMes.ToUserId} {
+			up, err := userMgr.GetOnlineUserById(id)
+			if err == nil {
+				ups = append(ups, up)
+			}
+		}
+		return
+	}
+	for _, up := range userMgr.GetAllOnlineUser() {
+		if up.GetRoom() == smsMes.RoomId {
+			ups = append(ups, up)
+		}
+	}
+	return
+}
+
+//把消息的修改转发给能看到这条消息的在线用户
+func (this *SmsProcess) sendToAudience(smsMes *message.SmsMes, mesType string, v interface{}) {
+
+	data, err := json.Marshal(v)
+	if err != nil {
+		logger.For(this.Conn, this.UserId).Error("json.Marshal fail", "err", err)
+		return
+	}
+	data, err = json.Marshal(message.Message{
+		Type: mesType,
+		Data: string(data),
+	})
+	if err != nil {
+		logger.For(this.Conn, this.UserId).Error("json.Marshal fail", "err", err)
+		return
+	}
+	for _, up := range mesAudience(smsMes) {
+		this.SendMesToEachOnlineUser(data, up.Conn)
+	}
+}
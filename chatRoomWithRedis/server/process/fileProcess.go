@@ -0,0 +1,360 @@
+package process2
+
+import (
+	"encoding/json"
+	"net"
+	"sync"
+	"time"
+
+	"go_code/chatroom/common/message"
+	"go_code/chatroom/server/model"
+	"go_code/chatroom/server/utils"
+	"go_code/chatroom/server/logger"
+)
+
+//服务器往客户端推送离线文件时，等待客户端确认的通道
+//key 为 fileId, 同一个文件只会有一个接收方
+var (
+	fileAcks     = make(map[int]*fileAck)
+	fileAcksLock sync.Mutex
+)
+
+//发送方上传分片和接收方应答在不同的连接上处理
+//文件状态的读取和修改要加锁，避免接收方在上传途中同意后只收到后半部分
+var fileStatusLock sync.Mutex
+
+type fileAck struct {
+	UserId int //接收方
+	Acks   chan message.FileAckMes
+}
+
+type FileProcess struct {
+	Conn net.Conn
+	//当前连接登录的用户
+	UserId int
+}
+
+func (this *FileProcess) writeMes(mesType string, v interface{}) (err error) {
+	tf := &utils.Transfer{
+		Conn: this.Conn,
+	}
+	return tf.WriteMes(mesType, v)
+}
+
+//把消息转发给某个在线用户
+func (this *FileProcess) forward(userId int, mesType string, v interface{}) (err error) {
+	up, err := userMgr.GetOnlineUserById(userId)
+	if err != nil {
+		return
+	}
+	tf := &utils.Transfer{
+		Conn: up.Conn,
+	}
+	return tf.WriteMes(mesType, v)
+}
+
+//处理发送方发起的文件传输
+func (this *FileProcess) ServerProcessFileOffer(mes *message.Message) (err error) {
+
+	var fileOfferMes message.FileOfferMes
+	err = json.Unmarshal([]byte(mes.Data), &fileOfferMes)
+	if err != nil {
+		logger.For(this.Conn, this.UserId).Warn("消息格式错误", "type", mes.Type, "err", err)
+		return
+	}
+
+	var fileOfferResMes message.FileOfferResMes
+	info := fileOfferMes.FileInfo
+	//发送方以当前连接的用户为准
+	info.FromUserId = this.UserId
+
+	if this.UserId == 0 || info.FileSize < 0 || info.FileSize > message.FileMaxSize ||
+		info.FileName == "" || len(info.FileHash) != 64 {
+		fileOfferResMes.Code = 400
+		fileOfferResMes.Error = model.ERROR_FILE_INVALID.Error()
+	} else if _, err = model.MyUserDao.GetUserById(info.ToUserId); err != nil {
+		if err == model.ERROR_USER_NOTEXISTS {
+			fileOfferResMes.Code = 500
+			fileOfferResMes.Error = err.Error()
+		} else {
+			fileOfferResMes.Code = 505
+			fileOfferResMes.Error = "服务器内部错误..."
+		}
+	} else if err = model.MyFileDao.AddFile(&info); err != nil {
+		fileOfferResMes.Code = 505
+		fileOfferResMes.Error = "服务器内部错误..."
+	} else {
+		fileOfferResMes.Code = 200
+		//接收方在线，则把文件信息转发给接收方，由接收方决定是否接收
+		//接收方不在线，发送方直接把文件上传到服务器，接收方上线后再获取
+		err = this.forward(info.ToUserId, message.FileOfferMesType, message.FileOfferMes{FileInfo: info})
+		fileOfferResMes.Offline = err != nil
+	}
+	fileOfferResMes.FileInfo = info
+
+	return this.writeMes(message.FileOfferResMesType, fileOfferResMes)
+}
+
+//处理接收方的同意或拒绝
+func (this *FileProcess) ServerProcessFileAnswer(mes *message.Message) (err error) {
+
+	var fileAnswerMes message.FileAnswerMes
+	err = json.Unmarshal([]byte(mes.Data), &fileAnswerMes)
+	if err != nil {
+		logger.For(this.Conn, this.UserId).Warn("消息格式错误", "type", mes.Type, "err", err)
+		return
+	}
+
+	fileStatusLock.Lock()
+	info, err := model.MyFileDao.GetFileById(fileAnswerMes.FileId)
+	if err == nil && info.ToUserId == this.UserId && info.Status == message.FileOffered {
+		if fileAnswerMes.Accept {
+			_, err = model.MyFileDao.UpdateStatus(info.FileId, message.FileAccepted)
+		} else {
+			err = model.MyFileDao.RemoveFile(info)
+		}
+	}
+	fileStatusLock.Unlock()
+
+	if info == nil || info.ToUserId != this.UserId {
+		logger.For(this.Conn, this.UserId).Warn("无效的文件应答", "file", fileAnswerMes.FileId)
+		return nil
+	}
+	if info.Status == message.FileUploading {
+		//发送方已经开始上传了，这时再转发只能收到后面的分片
+		//告诉接收方等上传完成后再获取离线文件
+		return this.writeMes(message.FileAckMesType, message.FileAckMes{
+			FileId: info.FileId,
+			Code:   409,
+			Error:  model.ERROR_FILE_UPLOADING.Error(),
+		})
+	}
+	if info.Status != message.FileOffered {
+		logger.For(this.Conn, this.UserId).Warn("无效的文件应答", "file", fileAnswerMes.FileId)
+		return nil
+	}
+	if err != nil {
+		logger.For(this.Conn, this.UserId).Error("更新文件状态错误", "file", info.FileId, "err", err)
+		return nil
+	}
+	//通知发送方
+	err = this.forward(info.FromUserId, message.FileAnswerMesType, fileAnswerMes)
+	if err != nil {
+		logger.For(this.Conn, this.UserId).Warn("通知文件发送方失败", "file", info.FileId, "err", err)
+	}
+	return nil
+}
+
+//处理发送方上传的分片, 保存到服务器端, 接收方已同意且在线时同时转发给接收方
+func (this *FileProcess) ServerProcessFileChunk(mes *message.Message) (err error) {
+
+	var chunk message.FileChunkMes
+	err = json.Unmarshal([]byte(mes.Data), &chunk)
+	if err != nil {
+		logger.For(this.Conn, this.UserId).Warn("消息格式错误", "type", mes.Type, "err", err)
+		return
+	}
+
+	fileAckMes := message.FileAckMes{
+		FileId: chunk.FileId,
+		Seq:    chunk.Seq,
+		Code:   200,
+	}
+	fileStatusLock.Lock()
+	info, err := model.MyFileDao.GetFileById(chunk.FileId)
+	if err == nil && info.FromUserId == this.UserId && info.Status == message.FileOffered {
+		//接收方还没同意就开始上传，说明是离线文件，之后不能再同意
+		_, err = model.MyFileDao.UpdateStatus(info.FileId, message.FileUploading)
+		info.Status = message.FileUploading
+	}
+	fileStatusLock.Unlock()
+	if err != nil || info.FromUserId != this.UserId ||
+		(info.Status != message.FileAccepted && info.Status != message.FileUploading) {
+		fileAckMes.Code = 500
+		fileAckMes.Error = model.ERROR_FILE_NOTEXISTS.Error()
+		return this.writeMes(message.FileAckMesType, fileAckMes)
+	}
+
+	err = model.MyFileDao.WriteChunk(info, &chunk)
+	if err == nil && chunk.Last {
+		err = model.MyFileDao.Verify(info)
+	}
+	if err != nil {
+		logger.For(this.Conn, this.UserId).Warn("保存文件分片错误", "file", info.FileId, "seq", chunk.Seq, "err", err)
+		fileAckMes.Code = 400
+		fileAckMes.Error = model.ERROR_FILE_INVALID.Error()
+		model.MyFileDao.RemoveFile(info)
+		return this.writeMes(message.FileAckMesType, fileAckMes)
+	}
+
+	relayed := false
+	if info.Status == message.FileAccepted {
+		relayed = this.forward(info.ToUserId, message.FileChunkMesType, chunk) == nil
+		if !relayed {
+			//接收方已经收不全了，剩下的分片只保存，传完后作为离线文件重新获取
+			fileStatusLock.Lock()
+			_, err = model.MyFileDao.UpdateStatus(info.FileId, message.FileUploading)
+			fileStatusLock.Unlock()
+			if err != nil {
+				logger.For(this.Conn, this.UserId).Error("更新文件状态错误", "file", info.FileId, "err", err)
+			}
+		}
+	}
+	if chunk.Last {
+		if relayed {
+			//接收方已经实时收完了，不需要再保存
+			err = model.MyFileDao.RemoveFile(info)
+		} else {
+			_, err = model.MyFileDao.UpdateStatus(info.FileId, message.FileStored)
+		}
+		if err != nil {
+			logger.For(this.Conn, this.UserId).Error("更新文件状态错误", "file", info.FileId, "err", err)
+		}
+	}
+
+	//回复确认后，发送方才会继续发送后面的分片
+	return this.writeMes(message.FileAckMesType, fileAckMes)
+}
+
+//返回当前用户还没取走的离线文件
+func (this *FileProcess) ServerProcessFileList(mes *message.Message) (err error) {
+
+	var fileListResMes message.FileListResMes
+	fileListResMes.Files, err = model.MyFileDao.GetPendingFiles(this.UserId)
+	if err != nil {
+		logger.For(this.Conn, this.UserId).Error("GetPendingFiles fail", "err", err)
+	}
+	return this.writeMes(message.FileListResMesType, fileListResMes)
+}
+
+//登录成功后，如果有离线文件，就告诉该用户
+func (this *FileProcess) NotifyPendingFiles() (err error) {
+
+	var fileListResMes message.FileListResMes
+	fileListResMes.Files, err = model.MyFileDao.GetPendingFiles(this.UserId)
+	if err != nil || len(fileListResMes.Files) == 0 {
+		return
+	}
+	return this.writeMes(message.FileListResMesType, fileListResMes)
+}
+
+//接收方获取离线文件，单独启动一个协程推送，不阻塞该连接上的其它消息
+func (this *FileProcess) ServerProcessFileFetch(mes *message.Message) (err error) {
+
+	var fileFetchMes message.FileFetchMes
+	err = json.Unmarshal([]byte(mes.Data), &fileFetchMes)
+	if err != nil {
+		logger.For(this.Conn, this.UserId).Warn("消息格式错误", "type", mes.Type, "err", err)
+		return
+	}
+
+	info, err := model.MyFileDao.GetFileById(fileFetchMes.FileId)
+	if err != nil || info.ToUserId != this.UserId || info.Status != message.FileStored {
+		return this.writeMes(message.FileAckMesType, message.FileAckMes{
+			FileId: fileFetchMes.FileId,
+			Code:   500,
+			Error:  model.ERROR_FILE_NOTEXISTS.Error(),
+		})
+	}
+
+	acks := make(chan message.FileAckMes, message.FileWindowSize)
+	fileAcksLock.Lock()
+	_, ok := fileAcks[info.FileId]
+	if !ok {
+		fileAcks[info.FileId] = &fileAck{
+			UserId: this.UserId,
+			Acks:   acks,
+		}
+	}
+	fileAcksLock.Unlock()
+	if ok {
+		//已经在推送了
+		return nil
+	}
+	go this.pushFile(info, acks)
+	return nil
+}
+
+func (this *FileProcess) pushFile(info *message.FileInfo, acks chan message.FileAckMes) {
+
+	defer func() {
+		fileAcksLock.Lock()
+		delete(fileAcks, info.FileId)
+		fileAcksLock.Unlock()
+	}()
+
+	inflight := 0
+	waitAck := func() bool {
+		select {
+		case ack := <-acks:
+			inflight--
+			return ack.Code == 200
+		case <-time.After(30 * time.Second):
+			return false
+		}
+	}
+
+	for seq := 0; ; seq++ {
+		data, err := model.MyFileDao.ReadChunk(info, seq)
+		if err != nil {
+			logger.For(this.Conn, this.UserId).Error("读取文件分片错误", "file", info.FileId, "seq", seq, "err", err)
+			return
+		}
+		last := int64(seq+1)*message.FileChunkSize >= info.FileSize
+		//未确认的分片太多时，先等接收方确认
+		for inflight >= message.FileWindowSize {
+			if !waitAck() {
+				logger.For(this.Conn, this.UserId).Warn("推送文件中断", "file", info.FileId)
+				return
+			}
+		}
+		chunk := message.FileChunkMes{
+			FileId: info.FileId,
+			Seq:    seq,
+			Data:   data,
+			Last:   last,
+		}
+		err = this.writeMes(message.FileChunkMesType, chunk)
+		if err != nil {
+			return
+		}
+		inflight++
+		if last {
+			break
+		}
+	}
+	for inflight > 0 {
+		if !waitAck() {
+			logger.For(this.Conn, this.UserId).Warn("推送文件中断", "file", info.FileId)
+			return
+		}
+	}
+	//接收方已经全部收到
+	err := model.MyFileDao.RemoveFile(info)
+	if err != nil {
+		logger.For(this.Conn, this.UserId).Error("删除文件错误", "file", info.FileId, "err", err)
+	}
+}
+
+//接收方的确认, 交给正在推送该文件的协程
+func (this *FileProcess) ServerProcessFileAck(mes *message.Message) (err error) {
+
+	var fileAckMes message.FileAckMes
+	err = json.Unmarshal([]byte(mes.Data), &fileAckMes)
+	if err != nil {
+		logger.For(this.Conn, this.UserId).Warn("消息格式错误", "type", mes.Type, "err", err)
+		return
+	}
+	fileAcksLock.Lock()
+	fa, ok := fileAcks[fileAckMes.FileId]
+	fileAcksLock.Unlock()
+	if !ok || fa.UserId != this.UserId {
+		//实时转发的分片，服务器不需要处理接收方的确认
+		return nil
+	}
+	select {
+	case fa.Acks <- fileAckMes:
+	default:
+	}
+	return nil
+}
@@ -0,0 +1,233 @@
+package process2
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"time"
+
+	"go_code/chatroom/common/message"
+	"go_code/chatroom/server/logger"
+	"go_code/chatroom/server/metrics"
+	"go_code/chatroom/server/utils"
+)
+
+//登录之前的握手, 协商协议版本, 编码, 压缩和功能
+//握手是可选的, 没有握手的老客户端按 message.LegacyProtocol 处理
+
+var (
+	ERROR_PROTOCOL_MISMATCH = errors.New("客户端的协议版本不兼容")
+)
+
+//服务器支持的编码, 压缩和功能, 按服务器的优先顺序
+var (
+	serverCodecs       = []string{message.CodecJSON}
+	serverCompressions = []string{message.CompressionDeflate, message.CompressionNone}
+	serverFeatures     = []string{message.FeatureE2E, message.FeatureRooms}
+)
+
+//拒绝客户端后最多等待多久
+const drainTimeout = 2 * time.Second
+
+type HelloProcess struct {
+	Conn net.Conn
+}
+
+//处理HelloMes, 返回协商后的协议
+//版本不兼容时回复426 并返回ERROR_PROTOCOL_MISMATCH, 调用方需要断开连接
+func (this *HelloProcess) ServerProcessHello(mes *message.Message) (protocol *message.Protocol, err error) {
+	var helloMes message.HelloMes
+	err = json.Unmarshal([]byte(mes.Data), &helloMes)
+	if err != nil {
+		logger.For(this.Conn, 0).Warn("json.Unmarshal fail", "err", err)
+		return
+	}
+	resMes, ok := negotiate(&helloMes)
+	tf := &utils.Transfer{
+		Conn: this.Conn,
+	}
+	err = tf.WriteMes(message.HelloResMesType, resMes)
+	if !ok {
+		metrics.ProtocolClients.Inc("rejected")
+		logger.For(this.Conn, 0).Info("客户端的协议不兼容", "version", helloMes.Version,
+			"minVersion", helloMes.MinVersion, "client", helloMes.Client, "err", resMes.Error)
+		if err == nil {
+			drainConn(this.Conn)
+		}
+		return nil, ERROR_PROTOCOL_MISMATCH
+	}
+	if err != nil {
+		return
+	}
+	protocol = resMes.Protocol()
+	//HelloResMes 本身不压缩, 之后的数据包才可以压缩
+	if protocol.Compression != message.CompressionNone {
+		utils.EnableCompression(this.Conn)
+	}
+	metrics.ProtocolClients.Inc(strconv.Itoa(protocol.Version))
+	logger.For(this.Conn, 0).Debug("握手成功", "version", protocol.Version, "codec", protocol.Codec,
+		"compression", protocol.Compression, "features", protocol.Features, "client", helloMes.Client)
+	return
+}
+
+//没有握手就发来其它消息的老客户端
+func LegacyClient(conn net.Conn) *message.Protocol {
+	metrics.ProtocolClients.Inc(strconv.Itoa(message.ProtocolLegacy))
+	logger.For(conn, 0).Debug("客户端没有握手, 使用版本1 的协议")
+	return message.LegacyProtocol()
+}
+
+//握手已经完成, 或者已经按老客户端处理之后, 又收到HelloMes
+func RejectHello(conn net.Conn) error {
+	tf := &utils.Transfer{
+		Conn: conn,
+	}
+	return tf.WriteMes(message.HelloResMesType, message.HelloResMes{
+		Code:  400,
+		Error: "握手只能在连接后的第一条消息中进行",
+	})
+}
+
+//需要在握手中协商过某个功能才能发送的消息, 返回需要的功能, 不需要时返回""
+//端到端加密: 发布和查询公钥, 加密的私聊
+//房间: 进入房间, 查询, 搜索和定时发送房间中的消息, 大厅不需要
+func RequiredFeature(mes *message.Message) string {
+	switch mes.Type {
+		case message.PublishKeyMesType, message.GetKeyMesType:
+			return message.FeatureE2E
+		case message.JoinRoomMesType:
+			return message.FeatureRooms
+		case message.SmsMesType, message.HistoryMesType, message.SearchMesType, message.ScheduleMesType:
+		default:
+			return ""
+	}
+	//格式错误的消息交给各自的处理函数
+	var fields featureFields
+	if json.Unmarshal([]byte(mes.Data), &fields) != nil {
+		return ""
+	}
+	switch {
+		case mes.Type == message.SmsMesType && fields.E2E != nil:
+			return message.FeatureE2E
+		//SmsMes 的RoomId由服务器填写
+		case mes.Type != message.SmsMesType && fields.RoomId != "":
+			return message.FeatureRooms
+	}
+	return ""
+}
+
+//各种消息中和功能有关的字段, 回复时原样带回
+type featureFields struct {
+	LocalId  int              `json:"localId"`
+	UserId   int              `json:"userId"`
+	ToUserId int              `json:"toUserId"`
+	RoomId   string           `json:"roomId"`
+	E2E      *message.E2EInfo `json:"e2e"`
+}
+
+//客户端没有协商feature就发来需要它的消息, 用这种消息的回复返回426, 不断开连接
+func RejectFeature(conn net.Conn, userId int, mes *message.Message, feature string) error {
+
+	var fields featureFields
+	json.Unmarshal([]byte(mes.Data), &fields)
+	code := 426
+	errText := fmt.Sprintf("握手时没有协商%s功能", feature)
+	logger.For(conn, userId).Info("客户端没有协商需要的功能", "type", mes.Type, "feature", feature)
+
+	var resType string
+	var resMes interface{}
+	switch mes.Type {
+		case message.PublishKeyMesType:
+			resType, resMes = message.PublishKeyResMesType, message.PublishKeyResMes{Code: code, Error: errText}
+		case message.GetKeyMesType:
+			resType, resMes = message.GetKeyResMesType, message.GetKeyResMes{Code: code, UserId: fields.UserId, Error: errText}
+		case message.JoinRoomMesType:
+			resType, resMes = message.JoinRoomResMesType, message.JoinRoomResMes{Code: code, RoomId: fields.RoomId, Error: errText}
+		case message.SmsMesType:
+			resType, resMes = message.SmsResMesType, message.SmsResMes{Code: code, LocalId: fields.LocalId, Error: errText}
+		case message.HistoryMesType:
+			resType, resMes = message.HistoryResMesType, message.HistoryResMes{Code: code, RoomId: fields.RoomId,
+				ToUserId: fields.ToUserId, Last: true, Error: errText}
+		case message.SearchMesType:
+			resType, resMes = message.SearchResMesType, message.SearchResMes{Code: code, Error: errText}
+		case message.ScheduleMesType:
+			resType, resMes = message.ScheduleResMesType, message.ScheduleResMes{Code: code, Error: errText}
+		default:
+			return nil
+	}
+	tf := &utils.Transfer{
+		Conn: conn,
+	}
+	return tf.WriteMes(resType, resMes)
+}
+
+//客户端通常把登录请求和HelloMes一起发出, 连接中还有没读的数据时直接关闭会发送RST,
+//客户端可能收不到426 的回复, 所以先关闭写的一端, 等客户端断开或者超时再关闭
+func drainConn(conn net.Conn) {
+	if tcpConn, ok := conn.(*net.TCPConn); ok {
+		tcpConn.CloseWrite()
+	}
+	conn.SetReadDeadline(time.Now().Add(drainTimeout))
+	io.Copy(io.Discard, conn)
+}
+
+//按客户端的优先顺序选择双方都支持的版本, 编码和压缩, ok 为false时表示不兼容
+func negotiate(helloMes *message.HelloMes) (resMes message.HelloResMes, ok bool) {
+	minVersion := helloMes.MinVersion
+	if minVersion == 0 {
+		minVersion = helloMes.Version
+	}
+	if helloMes.Version <= 0 || minVersion > helloMes.Version {
+		resMes.Code = 426
+		resMes.Error = fmt.Sprintf("协议版本不合法: version=%d minVersion=%d", helloMes.Version, helloMes.MinVersion)
+		return
+	}
+	if helloMes.Version < message.MinProtocolVersion || minVersion > message.ProtocolVersion {
+		resMes.Code = 426
+		resMes.Error = fmt.Sprintf("协议版本不兼容: 客户端支持%d-%d, 服务器支持%d-%d, 请升级",
+			minVersion, helloMes.Version, message.MinProtocolVersion, message.ProtocolVersion)
+		return
+	}
+	resMes.Version = helloMes.Version
+	if resMes.Version > message.ProtocolVersion {
+		resMes.Version = message.ProtocolVersion
+	}
+
+	resMes.Codec = message.CodecJSON
+	if len(helloMes.Codecs) > 0 {
+		resMes.Codec = choose(helloMes.Codecs, serverCodecs)
+		if resMes.Codec == "" {
+			resMes.Code = 426
+			resMes.Error = fmt.Sprintf("不支持客户端的编码%v, 服务器支持%v", helloMes.Codecs, serverCodecs)
+			return
+		}
+	}
+	//压缩是可选的, 没有双方都支持的压缩时不压缩
+	resMes.Compression = choose(helloMes.Compressions, serverCompressions)
+	if resMes.Compression == "" {
+		resMes.Compression = message.CompressionNone
+	}
+	resMes.Features = []string{}
+	for _, feature := range helloMes.Features {
+		if choose([]string{feature}, serverFeatures) != "" && choose([]string{feature}, resMes.Features) == "" {
+			resMes.Features = append(resMes.Features, feature)
+		}
+	}
+	resMes.Code = 200
+	return resMes, true
+}
+
+//返回wants中第一个在supported中的
+func choose(wants []string, supported []string) string {
+	for _, want := range wants {
+		for _, s := range supported {
+			if want == s {
+				return want
+			}
+		}
+	}
+	return ""
+}
@@ -0,0 +1,92 @@
+package process2
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+
+	"go_code/chatroom/common/message"
+	"go_code/chatroom/server/model"
+	"go_code/chatroom/server/utils"
+	"go_code/chatroom/server/logger"
+)
+
+//X25519 公钥的长度
+const publicKeyLen = 32
+
+//处理端到端加密的公钥, 服务器只保存和转发公钥, 不接触私钥
+type KeyProcess struct {
+	Conn net.Conn
+	//当前连接登录的用户
+	UserId int
+}
+
+//保存客户端发布的公钥
+func (this *KeyProcess) ServerProcessPublishKey(mes *message.Message) (err error) {
+
+	var publishKeyMes message.PublishKeyMes
+	err = json.Unmarshal([]byte(mes.Data), &publishKeyMes)
+	if err != nil {
+		logger.For(this.Conn, this.UserId).Warn("消息格式错误", "type", mes.Type, "err", err)
+		return
+	}
+
+	var publishKeyResMes message.PublishKeyResMes
+	if this.UserId == 0 {
+		publishKeyResMes.Code = 403
+		publishKeyResMes.Error = "请先登录"
+	} else if len(publishKeyMes.PublicKey) != publicKeyLen {
+		publishKeyResMes.Code = 400
+		publishKeyResMes.Error = "公钥不合法"
+	} else if err = model.MyUserDao.SetPublicKey(this.UserId, publishKeyMes.PublicKey); err != nil {
+		logger.For(this.Conn, this.UserId).Error("SetPublicKey fail", "err", err)
+		publishKeyResMes.Code = 505
+		publishKeyResMes.Error = "服务器内部错误..."
+	} else {
+		publishKeyResMes.Code = 200
+	}
+
+	tf := &utils.Transfer{
+		Conn: this.Conn,
+	}
+	return tf.WriteMes(message.PublishKeyResMesType, publishKeyResMes)
+}
+
+//返回某个用户的公钥
+func (this *KeyProcess) ServerProcessGetKey(mes *message.Message) (err error) {
+
+	var getKeyMes message.GetKeyMes
+	err = json.Unmarshal([]byte(mes.Data), &getKeyMes)
+	if err != nil {
+		logger.For(this.Conn, this.UserId).Warn("消息格式错误", "type", mes.Type, "err", err)
+		return
+	}
+
+	getKeyResMes := message.GetKeyResMes{
+		UserId: getKeyMes.UserId,
+	}
+	user, err := model.MyUserDao.GetUserById(getKeyMes.UserId)
+	switch {
+		case this.UserId == 0:
+			getKeyResMes.Code = 403
+			getKeyResMes.Error = "请先登录"
+		case err == model.ERROR_USER_NOTEXISTS:
+			getKeyResMes.Code = 500
+			getKeyResMes.Error = err.Error()
+		case err != nil:
+			logger.For(this.Conn, this.UserId).Error("GetUserById fail", "err", err)
+			getKeyResMes.Code = 505
+			getKeyResMes.Error = "服务器内部错误..."
+		case len(user.PublicKey) == 0:
+			getKeyResMes.Code = 404
+			getKeyResMes.Error = fmt.Sprintf("用户%d 还没有发布公钥", getKeyMes.UserId)
+		default:
+			getKeyResMes.Code = 200
+			getKeyResMes.PublicKey = user.PublicKey
+	}
+
+	tf := &utils.Transfer{
+		Conn: this.Conn,
+	}
+	return tf.WriteMes(message.GetKeyResMesType, getKeyResMes)
+}
@@ -0,0 +1,313 @@
+package process2
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"go_code/chatroom/common/message"
+	"go_code/chatroom/server/logger"
+	"go_code/chatroom/server/model"
+	"go_code/chatroom/server/utils"
+)
+
+//群聊消息中的 @提醒 和关键词提醒
+//@用户名 或 @用户id 的用户会收到MentionMes, 不在线时保存下来, 上线后推送
+//关键词由每个用户自己设置, 只提醒在线的用户
+//用户可以屏蔽某些房间的提醒, 这些设置都保存在redis中, 由服务器过滤
+
+//一条消息最多提醒多少个用户, 避免一条消息@很多人刷屏
+const maxMentions = 10
+
+type NotifyProcess struct {
+	Conn net.Conn
+	//当前连接登录的用户
+	UserId int
+}
+
+//用户的提醒设置, 登录时从redis读取, 修改时同时更新
+func (this *UserProcess) GetNotifySettings() message.NotifySettings {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	return this.Notify
+}
+
+func (this *UserProcess) SetNotifySettings(settings message.NotifySettings) {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	this.Notify = settings
+}
+
+func roomMuted(settings *message.NotifySettings, roomId string) bool {
+	for _, room := range settings.MutedRooms {
+		if room == roomId {
+			return true
+		}
+	}
+	return false
+}
+
+//整理客户端发来的设置: 关键词去掉空白并转成小写, 去掉重复的
+//设置不合法时返回错误的说明
+func normalizeNotifySettings(settings message.NotifySettings) (res message.NotifySettings, errText string) {
+	res.Keywords = []string{}
+	res.MutedRooms = []string{}
+	seen := make(map[string]bool)
+	for _, keyword := range settings.Keywords {
+		keyword = strings.ToLower(strings.TrimSpace(keyword))
+		if keyword == "" || seen[keyword] {
+			continue
+		}
+		if len(keyword) > message.NotifyKeywordMaxLen {
+			return res, fmt.Sprintf("关键词不能超过%d个字节", message.NotifyKeywordMaxLen)
+		}
+		seen[keyword] = true
+		res.Keywords = append(res.Keywords, keyword)
+	}
+	if len(res.Keywords) > message.NotifyMaxKeywords {
+		return res, fmt.Sprintf("最多设置%d个关键词", message.NotifyMaxKeywords)
+	}
+	seen = make(map[string]bool)
+	for _, roomId := range settings.MutedRooms {
+		if !validRoomId(roomId) {
+			return res, fmt.Sprintf("房间名不能包含空白字符, 并且不能超过%d个字节", maxRoomIdLen)
+		}
+		if seen[roomId] {
+			continue
+		}
+		seen[roomId] = true
+		res.MutedRooms = append(res.MutedRooms, roomId)
+	}
+	if len(res.MutedRooms) > message.NotifyMaxMutedRooms {
+		return res, fmt.Sprintf("最多屏蔽%d个房间", message.NotifyMaxMutedRooms)
+	}
+	return
+}
+
+//处理查询和修改提醒设置
+func (this *NotifyProcess) ServerProcessNotifySettings(mes *message.Message) (err error) {
+
+	var resMes message.NotifySettingsResMes
+	up, loginErr := getLoginProcess(this.Conn, this.UserId)
+	if loginErr != nil {
+		resMes.Code = 403
+		resMes.Error = "请先登录"
+	} else if mes.Type == message.GetNotifySettingsMesType {
+		resMes.Code = 200
+		resMes.Settings = up.GetNotifySettings()
+	} else {
+		var setMes message.SetNotifySettingsMes
+		err = json.Unmarshal([]byte(mes.Data), &setMes)
+		if err != nil {
+			logger.For(this.Conn, this.UserId).Warn("消息格式错误", "type", mes.Type, "err", err)
+			return
+		}
+		settings, errText := normalizeNotifySettings(setMes.Settings)
+		if errText != "" {
+			resMes.Code = 400
+			resMes.Error = errText
+			resMes.Settings = up.GetNotifySettings()
+		} else if err = model.MyUserDao.SetNotifySettings(this.UserId, settings); err != nil {
+			logger.For(this.Conn, this.UserId).Error("保存提醒设置错误", "err", err)
+			resMes.Code = 505
+			resMes.Error = "服务器内部错误..."
+			resMes.Settings = up.GetNotifySettings()
+		} else {
+			up.SetNotifySettings(settings)
+			resMes.Code = 200
+			resMes.Settings = settings
+		}
+	}
+
+	tf := &utils.Transfer{
+		Conn: this.Conn,
+	}
+	return tf.WriteMes(message.NotifySettingsResMesType, resMes)
+}
+
+//找出消息中所有的 @名字, 名字到空白或标点为止
+//前面紧跟着英文字母或数字的@不算, 比如邮箱地址
+func parseMentions(content string) (names []string) {
+	runes := []rune(content)
+	for i := 0; i < len(runes); i++ {
+		if runes[i] != '@' {
+			continue
+		}
+		if i > 0 && runes[i-1] < utf8.RuneSelf && (unicode.IsLetter(runes[i-1]) || unicode.IsDigit(runes[i-1])) {
+			continue
+		}
+		j := i + 1
+		for j < len(runes) && mentionRune(runes[j]) {
+			j++
+		}
+		if j > i+1 {
+			names = append(names, string(runes[i+1:j]))
+		}
+		i = j - 1
+	}
+	return
+}
+
+//用户名中可以出现的字符
+func mentionRune(r rune) bool {
+	if r == '_' || r == '-' {
+		return true
+	}
+	return r != '@' && !unicode.IsSpace(r) && !unicode.IsPunct(r) && !unicode.IsSymbol(r)
+}
+
+//把 @后面的名字解析成用户id, 先当作用户id, 不存在时再当作用户名
+func resolveMention(name string) (userId int, err error) {
+	if id, err := strconv.Atoi(name); err == nil && id > 0 {
+		_, err = model.MyUserDao.GetUserById(id)
+		if err == nil {
+			return id, nil
+		}
+		if err != model.ERROR_USER_NOTEXISTS {
+			return 0, err
+		}
+	}
+	return model.MyUserDao.GetUserIdByName(name)
+}
+
+//用户是否在房间中, 和搜索一样以进入过的房间为准, 大厅所有人都在
+func joinedRoom(userId int, roomId string) (ok bool, err error) {
+	if roomId == "" {
+		return true, nil
+	}
+	rooms, err := model.MyUserDao.GetJoinedRooms(userId)
+	if err != nil {
+		return
+	}
+	return rooms[roomId], nil
+}
+
+//群聊消息发出后, 提醒被@的用户和设置了关键词的在线用户
+//只提醒进入过这个房间的用户, 不然没进过房间的人也能通过提醒看到房间中的消息
+func (this *SmsProcess) SendMentions(smsMes *message.SmsMes) {
+
+	mentioned := make(map[int]bool)
+	for _, name := range parseMentions(smsMes.Content) {
+		if len(mentioned) >= maxMentions {
+			break
+		}
+		userId, err := resolveMention(name)
+		if err != nil {
+			if err != model.ERROR_USER_NOTEXISTS {
+				logger.For(this.Conn, this.UserId).Error("查找被@的用户错误", "name", name, "err", err)
+			}
+			continue
+		}
+		if userId == smsMes.UserId || mentioned[userId] {
+			continue
+		}
+		mentioned[userId] = true
+		if this.canMention(userId, smsMes.RoomId) {
+			this.sendMention(userId, smsMes)
+		}
+	}
+
+	content := strings.ToLower(smsMes.Content)
+	for id, up := range userMgr.GetAllOnlineUser() {
+		if id == smsMes.UserId || mentioned[id] {
+			continue
+		}
+		settings := up.GetNotifySettings()
+		if roomMuted(&settings, smsMes.RoomId) {
+			continue
+		}
+		for _, keyword := range settings.Keywords {
+			if strings.Contains(content, keyword) {
+				if !this.canMention(id, smsMes.RoomId) {
+					break
+				}
+				this.writeMention(up.Conn, message.MentionMes{
+					Reason:  message.MentionKeyword,
+					Keyword: keyword,
+					SmsMes:  *smsMes,
+				})
+				break
+			}
+		}
+	}
+}
+
+//用户不在房间中, 或者查询出错时不提醒
+func (this *SmsProcess) canMention(userId int, roomId string) bool {
+	ok, err := joinedRoom(userId, roomId)
+	if err != nil {
+		logger.For(this.Conn, this.UserId).Error("GetJoinedRooms fail", "user", userId, "err", err)
+		return false
+	}
+	return ok
+}
+
+//提醒一个被@的用户, 不在线时放入离线队列
+func (this *SmsProcess) sendMention(userId int, smsMes *message.SmsMes) {
+
+	var err error
+	up, onlineErr := userMgr.GetOnlineUserById(userId)
+	if onlineErr == nil {
+		settings := up.GetNotifySettings()
+		if roomMuted(&settings, smsMes.RoomId) {
+			return
+		}
+		err = this.writeMention(up.Conn, message.MentionMes{
+			Reason: message.MentionAt,
+			SmsMes: *smsMes,
+		})
+		if err == nil {
+			return
+		}
+	}
+	settings, err := model.MyUserDao.GetNotifySettings(userId)
+	if err != nil {
+		logger.For(this.Conn, this.UserId).Error("读取提醒设置错误", "user", userId, "err", err)
+		return
+	}
+	if roomMuted(&settings, smsMes.RoomId) {
+		return
+	}
+	err = model.MyMessageDao.AddOfflineMention(userId, smsMes.MesId)
+	if err != nil {
+		logger.For(this.Conn, this.UserId).Error("AddOfflineMention fail", "mes", smsMes.MesId, "err", err)
+	}
+}
+
+func (this *SmsProcess) writeMention(conn net.Conn, mentionMes message.MentionMes) (err error) {
+	tf := &utils.Transfer{
+		Conn: conn,
+	}
+	err = tf.WriteMes(message.MentionMesType, mentionMes)
+	if err != nil {
+		logger.For(conn, 0).Debug("发送提醒失败", "err", err)
+	}
+	return
+}
+
+//用户上线后, 推送他不在线时被@的消息, 已经删除的消息不再提醒
+func (this *SmsProcess) SendOfflineMentions() {
+
+	messages, err := model.MyMessageDao.PopOfflineMention(this.UserId)
+	if err != nil {
+		logger.For(this.Conn, this.UserId).Error("PopOfflineMention fail", "err", err)
+		return
+	}
+	for _, smsMes := range messages {
+		if smsMes.Deleted {
+			continue
+		}
+		err = this.writeMention(this.Conn, message.MentionMes{
+			Reason: message.MentionAt,
+			SmsMes: *smsMes,
+		})
+		if err != nil {
+			//没发出去，重新放回离线队列
+			model.MyMessageDao.AddOfflineMention(this.UserId, smsMes.MesId)
+		}
+	}
+}
@@ -0,0 +1,194 @@
+package process2
+
+import (
+	"log/slog"
+	"encoding/json"
+	"fmt"
+	"net"
+
+	"go_code/chatroom/common/message"
+	"go_code/chatroom/server/model"
+	"go_code/chatroom/server/utils"
+	"go_code/chatroom/server/logger"
+	"go_code/chatroom/server/events"
+)
+
+type ModerateProcess struct {
+	Conn net.Conn
+	//当前连接登录的用户, 即操作人
+	UserId int
+}
+
+//返回连接的ip
+func ConnIp(conn net.Conn) string {
+	host, _, err := net.SplitHostPort(conn.RemoteAddr().String())
+	if err != nil {
+		return conn.RemoteAddr().String()
+	}
+	return host
+}
+
+//处理版主和管理员的管理操作
+func (this *ModerateProcess) ServerProcessModerate(mes *message.Message) (err error) {
+
+	var moderateMes message.ModerateMes
+	err = json.Unmarshal([]byte(mes.Data), &moderateMes)
+	if err != nil {
+		logger.For(this.Conn, this.UserId).Warn("消息格式错误", "type", mes.Type, "err", err)
+		return
+	}
+	moderateMes.OperatorId = this.UserId
+
+	var moderateResMes message.ModerateResMes
+	err = this.moderate(&moderateMes)
+	switch err {
+		case nil:
+			moderateResMes.Code = 200
+			events.Moderation(&moderateMes)
+		case errNoPermission:
+			moderateResMes.Code = 401
+			moderateResMes.Error = err.Error()
+		case errBadModerate:
+			moderateResMes.Code = 400
+			moderateResMes.Error = err.Error()
+		case model.ERROR_USER_NOTEXISTS:
+			moderateResMes.Code = 500
+			moderateResMes.Error = err.Error()
+		default:
+			logger.For(this.Conn, this.UserId).Error("管理操作失败", "action", moderateMes.Action, "err", err)
+			moderateResMes.Code = 505
+			moderateResMes.Error = "服务器内部错误..."
+	}
+
+	tf := &utils.Transfer{
+		Conn: this.Conn,
+	}
+	return tf.WriteMes(message.ModerateResMesType, moderateResMes)
+}
+
+var (
+	errNoPermission = fmt.Errorf("没有权限")
+	errBadModerate  = fmt.Errorf("管理操作的参数不正确")
+)
+
+func (this *ModerateProcess) moderate(moderateMes *message.ModerateMes) (err error) {
+
+	if this.UserId == 0 {
+		return errNoPermission
+	}
+	operator, err := model.MyUserDao.GetUserById(this.UserId)
+	if err != nil {
+		return
+	}
+	if operator.Role < message.RoleModerator {
+		return errNoPermission
+	}
+	if moderateMes.Action == message.ModerateRole && operator.Role < message.RoleAdmin {
+		return errNoPermission
+	}
+	//按ip封禁会影响这个ip上的所有用户, 只有管理员可以操作
+	if moderateMes.Ip != "" && operator.Role < message.RoleAdmin {
+		return errNoPermission
+	}
+	//只能管理角色比自己低的用户
+	if moderateMes.UserId != 0 {
+		target, err := model.MyUserDao.GetUserById(moderateMes.UserId)
+		if err != nil {
+			return err
+		}
+		if target.Role >= operator.Role {
+			return errNoPermission
+		}
+	}
+
+	dao := model.MyModerationDao
+	switch moderateMes.Action {
+		case message.ModerateMute:
+			if moderateMes.UserId == 0 || moderateMes.Duration <= 0 {
+				return errBadModerate
+			}
+			err = dao.Mute(moderateMes.UserId, moderateMes.Duration, moderateMes.Reason)
+		case message.ModerateUnmute:
+			if moderateMes.UserId == 0 {
+				return errBadModerate
+			}
+			err = dao.Unmute(moderateMes.UserId)
+		case message.ModerateKick:
+			if moderateMes.UserId == 0 {
+				return errBadModerate
+			}
+		case message.ModerateBan:
+			if moderateMes.UserId == 0 && moderateMes.Ip == "" {
+				return errBadModerate
+			}
+			if moderateMes.UserId != 0 {
+				err = dao.BanUser(moderateMes.UserId, moderateMes.Duration, moderateMes.Reason)
+			}
+			if err == nil && moderateMes.Ip != "" {
+				err = dao.BanIp(moderateMes.Ip, moderateMes.Duration, moderateMes.Reason)
+			}
+		case message.ModerateUnban:
+			if moderateMes.UserId == 0 && moderateMes.Ip == "" {
+				return errBadModerate
+			}
+			if moderateMes.UserId != 0 {
+				err = dao.UnbanUser(moderateMes.UserId)
+			}
+			if err == nil && moderateMes.Ip != "" {
+				err = dao.UnbanIp(moderateMes.Ip)
+			}
+		case message.ModerateRole:
+			if moderateMes.UserId == 0 || moderateMes.Role < message.RoleUser || moderateMes.Role > message.RoleAdmin {
+				return errBadModerate
+			}
+			err = model.MyUserDao.SetRole(moderateMes.UserId, moderateMes.Role)
+		default:
+			return errBadModerate
+	}
+	if err != nil {
+		return
+	}
+
+	//每一个管理操作都记录审计日志
+	if err := dao.AddAudit(moderateMes); err != nil {
+		slog.Error("写审计日志失败", "err", err)
+	}
+
+	//通知被操作的用户, 踢人和封禁还要断开他的连接
+	switch moderateMes.Action {
+		case message.ModerateKick, message.ModerateBan:
+			KickUsers(moderateMes, operator.Role)
+		default:
+			if up, err := userMgr.GetOnlineUserById(moderateMes.UserId); err == nil {
+				tf := &utils.Transfer{
+					Conn: up.Conn,
+				}
+				tf.WriteMes(message.ModerateMesType, moderateMes)
+			}
+	}
+	return nil
+}
+
+//把被踢或被封禁的用户断开连接，并从userMgr中删除
+//按ip封禁时，该ip上在线的用户也会被断开，但不包括角色不低于操作人的用户
+func KickUsers(moderateMes *message.ModerateMes, operatorRole int) {
+
+	for id, up := range userMgr.GetAllOnlineUser() {
+		if id != moderateMes.UserId {
+			if moderateMes.Ip == "" || ConnIp(up.Conn) != moderateMes.Ip {
+				continue
+			}
+			user, err := model.MyUserDao.GetUserById(id)
+			if err != nil || user.Role >= operatorRole {
+				continue
+			}
+		}
+		tf := &utils.Transfer{
+			Conn: up.Conn,
+		}
+		tf.WriteMes(message.ModerateMesType, moderateMes)
+		//先从在线列表中删除并通知其他人，连接的协程退出时就不会再处理一次
+		up.ServerProcessLogout()
+		up.Conn.Close()
+	}
+}
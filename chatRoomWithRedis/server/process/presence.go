@@ -0,0 +1,223 @@
+package process2
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"go_code/chatroom/common/message"
+	"go_code/chatroom/server/utils"
+	"go_code/chatroom/server/logger"
+)
+
+//自定义状态说明的最大长度
+const maxStatusTextLen = 64
+
+//记录每个用户在每个会话中最后一次发送TypingMes的时间，用来限流
+//key 为 userId:toUserId
+var (
+	typingTimes     = make(map[string]time.Time)
+	typingTimesLock sync.Mutex
+)
+
+//其他用户看到的状态，隐身的用户对其他人来说就是离线
+func (this *UserProcess) VisibleStatus() (status int, statusText string) {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	if this.Status == message.UserInvisible {
+		return message.UserOffline, ""
+	}
+	return this.Status, this.StatusText
+}
+
+//修改状态，并通知其它在线用户
+func (this *UserProcess) SetStatus(status int, statusText string, autoAway bool) {
+
+	this.lock.Lock()
+	oldVisible := this.Status != message.UserInvisible
+	changed := this.Status != status || this.StatusText != statusText
+	this.Status = status
+	this.StatusText = statusText
+	this.AutoAway = autoAway
+	this.lock.Unlock()
+
+	if !changed {
+		return
+	}
+	notifyUserStatusMes := message.NotifyUserStatusMes{
+		UserId:     this.UserId,
+		Status:     status,
+		StatusText: statusText,
+	}
+	if status == message.UserInvisible {
+		if !oldVisible {
+			//一直是隐身，别人看到的一直是离线
+			return
+		}
+		notifyUserStatusMes.Status = message.UserOffline
+		notifyUserStatusMes.StatusText = ""
+	}
+	this.NotifyOthersStatus(notifyUserStatusMes)
+}
+
+//用户有操作了，如果是自动设置的离开，就恢复在线
+func (this *UserProcess) Touch() {
+	this.lock.Lock()
+	this.LastActive = time.Now()
+	autoAway := this.AutoAway
+	statusText := this.StatusText
+	this.lock.Unlock()
+
+	if autoAway {
+		this.SetStatus(message.UserOnline, statusText, false)
+	}
+}
+
+//根据连接找到登录时保存在userMgr中的UserProcess
+func getLoginProcess(conn net.Conn, userId int) (up *UserProcess, err error) {
+	up, err = userMgr.GetOnlineUserById(userId)
+	if err != nil {
+		return
+	}
+	if up.Conn != conn {
+		err = fmt.Errorf("用户%d 已在别处登录", userId)
+	}
+	return
+}
+
+//记录用户有操作, 由Processor在收到用户主动发起的消息时调用
+func TouchUser(conn net.Conn, userId int) {
+	up, err := getLoginProcess(conn, userId)
+	if err != nil {
+		return
+	}
+	up.Touch()
+}
+
+//处理客户端设置状态
+func (this *UserProcess) ServerProcessSetStatus(mes *message.Message) (err error) {
+
+	var setStatusMes message.SetStatusMes
+	err = json.Unmarshal([]byte(mes.Data), &setStatusMes)
+	if err != nil {
+		logger.For(this.Conn, this.UserId).Warn("消息格式错误", "type", mes.Type, "err", err)
+		return
+	}
+	switch setStatusMes.Status {
+	case message.UserOnline, message.UserBusyStatus, message.UserAway, message.UserInvisible:
+	default:
+		logger.For(this.Conn, this.UserId).Warn("无效的用户状态", "status", setStatusMes.Status)
+		return nil
+	}
+	statusText := []rune(setStatusMes.StatusText)
+	if len(statusText) > maxStatusTextLen {
+		statusText = statusText[:maxStatusTextLen]
+	}
+
+	up, err := getLoginProcess(this.Conn, this.UserId)
+	if err != nil {
+		return nil
+	}
+	up.SetStatus(setStatusMes.Status, string(statusText), false)
+	return nil
+}
+
+//转发正在输入，同一个会话在TypingInterval内只转发一次
+func (this *UserProcess) ServerProcessTyping(mes *message.Message) (err error) {
+
+	var typingMes message.TypingMes
+	err = json.Unmarshal([]byte(mes.Data), &typingMes)
+	if err != nil {
+		logger.For(this.Conn, this.UserId).Warn("消息格式错误", "type", mes.Type, "err", err)
+		return
+	}
+	up, err := getLoginProcess(this.Conn, this.UserId)
+	if err != nil {
+		return nil
+	}
+	//隐身的用户不发送正在输入，否则会暴露自己
+	if status, _ := up.VisibleStatus(); status == message.UserOffline {
+		return nil
+	}
+	typingMes.UserId = this.UserId
+
+	key := fmt.Sprintf("%d:%d", typingMes.UserId, typingMes.ToUserId)
+	now := time.Now()
+	typingTimesLock.Lock()
+	last, ok := typingTimes[key]
+	if ok && now.Sub(last) < message.TypingInterval*time.Second {
+		typingTimesLock.Unlock()
+		return nil
+	}
+	typingTimes[key] = now
+	typingTimesLock.Unlock()
+
+	if typingMes.ToUserId != 0 {
+		to, err := userMgr.GetOnlineUserById(typingMes.ToUserId)
+		if err != nil {
+			return nil
+		}
+		tf := &utils.Transfer{
+			Conn: to.Conn,
+		}
+		tf.WriteMes(message.TypingMesType, typingMes)
+		return nil
+	}
+	//群聊中正在输入只告诉同一个房间的用户
+	room := up.GetRoom()
+	for id, to := range userMgr.GetAllOnlineUser() {
+		if id == this.UserId || to.GetRoom() != room {
+			continue
+		}
+		tf := &utils.Transfer{
+			Conn: to.Conn,
+		}
+		tf.WriteMes(message.TypingMesType, typingMes)
+	}
+	return nil
+}
+
+//用户下线后，清理他的限流记录
+func clearTyping(userId int) {
+	prefix := fmt.Sprintf("%d:", userId)
+	typingTimesLock.Lock()
+	defer typingTimesLock.Unlock()
+	for key := range typingTimes {
+		if strings.HasPrefix(key, prefix) {
+			delete(typingTimes, key)
+		}
+	}
+}
+
+//启动一个协程，定期检查在线用户，超过timeout没有操作的自动设置为离开
+//调用返回的stop 停止检查
+func StartAwayChecker(timeout time.Duration) (stop func()) {
+	done := make(chan struct{})
+	go func() {
+		ticker := time.NewTicker(timeout / 10)
+		defer ticker.Stop()
+		for {
+			select {
+				case <-ticker.C:
+				case <-done:
+					return
+			}
+			for _, up := range userMgr.GetAllOnlineUser() {
+				up.lock.Lock()
+				idle := up.Status == message.UserOnline && time.Since(up.LastActive) > timeout
+				statusText := up.StatusText
+				up.lock.Unlock()
+				if idle {
+					up.SetStatus(message.UserAway, statusText, true)
+				}
+			}
+		}
+	}()
+	var once sync.Once
+	return func() {
+		once.Do(func() { close(done) })
+	}
+}
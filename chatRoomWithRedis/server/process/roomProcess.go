@@ -0,0 +1,188 @@
+package process2
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"strings"
+
+	"go_code/chatroom/common/message"
+	"go_code/chatroom/server/model"
+	"go_code/chatroom/server/utils"
+	"go_code/chatroom/server/logger"
+)
+
+//房间名的最大长度
+const maxRoomIdLen = 32
+
+//每个HistoryResMes中消息的json最多占多少字节, 避免一个包太大
+const historyBatchSize = 3000
+
+type RoomProcess struct {
+	Conn net.Conn
+	//当前连接登录的用户
+	UserId int
+}
+
+//房间名不能有空白字符，也不能太长
+func validRoomId(roomId string) bool {
+	return len(roomId) <= maxRoomIdLen && !strings.ContainsAny(roomId, " \t\r\n")
+}
+
+//用户当前所在的房间
+func (this *UserProcess) GetRoom() string {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	return this.Room
+}
+
+func (this *UserProcess) SetRoom(roomId string) {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	this.Room = roomId
+}
+
+//连接登录的用户当前所在的房间, 没有登录时返回大厅
+func UserRoom(conn net.Conn, userId int) string {
+	up, err := getLoginProcess(conn, userId)
+	if err != nil {
+		return ""
+	}
+	return up.GetRoom()
+}
+
+//返回某个房间中的在线用户, 隐身的用户不返回
+func roomUsers(roomId string) (usersId []int) {
+	for id, up := range userMgr.GetAllOnlineUser() {
+		if up.GetRoom() != roomId {
+			continue
+		}
+		if status, _ := up.VisibleStatus(); status == message.UserOffline {
+			continue
+		}
+		usersId = append(usersId, id)
+	}
+	return
+}
+
+//处理用户进入房间
+func (this *RoomProcess) ServerProcessJoinRoom(mes *message.Message) (err error) {
+
+	var joinRoomMes message.JoinRoomMes
+	err = json.Unmarshal([]byte(mes.Data), &joinRoomMes)
+	if err != nil {
+		logger.For(this.Conn, this.UserId).Warn("消息格式错误", "type", mes.Type, "err", err)
+		return
+	}
+
+	joinRoomResMes := message.JoinRoomResMes{
+		RoomId: joinRoomMes.RoomId,
+	}
+	up, loginErr := getLoginProcess(this.Conn, this.UserId)
+	if loginErr != nil {
+		joinRoomResMes.Code = 403
+		joinRoomResMes.Error = "请先登录"
+	} else if !validRoomId(joinRoomMes.RoomId) {
+		joinRoomResMes.Code = 400
+		joinRoomResMes.Error = fmt.Sprintf("房间名不能包含空白字符, 并且不能超过%d个字节", maxRoomIdLen)
+	} else {
+		up.SetRoom(joinRoomMes.RoomId)
+		if joinRoomMes.RoomId != "" {
+			err = model.MyUserDao.AddJoinedRoom(this.UserId, joinRoomMes.RoomId)
+			if err != nil {
+				//只影响查看, 搜索和操作房间中的消息
+				logger.For(this.Conn, this.UserId).Error("AddJoinedRoom fail", "room", joinRoomMes.RoomId, "err", err)
+			}
+		}
+		joinRoomResMes.Code = 200
+		joinRoomResMes.UsersId = roomUsers(joinRoomMes.RoomId)
+	}
+
+	tf := &utils.Transfer{
+		Conn: this.Conn,
+	}
+	return tf.WriteMes(message.JoinRoomResMesType, joinRoomResMes)
+}
+
+//处理查询聊天记录, 私聊只能查自己参与的会话
+func (this *RoomProcess) ServerProcessHistory(mes *message.Message) (err error) {
+
+	var historyMes message.HistoryMes
+	err = json.Unmarshal([]byte(mes.Data), &historyMes)
+	if err != nil {
+		logger.For(this.Conn, this.UserId).Warn("消息格式错误", "type", mes.Type, "err", err)
+		return
+	}
+
+	historyResMes := message.HistoryResMes{
+		RoomId:   historyMes.RoomId,
+		ToUserId: historyMes.ToUserId,
+		Last:     true,
+	}
+	tf := &utils.Transfer{
+		Conn: this.Conn,
+	}
+	if this.UserId == 0 {
+		historyResMes.Code = 403
+		historyResMes.Error = "请先登录"
+		return tf.WriteMes(message.HistoryResMesType, historyResMes)
+	}
+	//和搜索一样, 只能查看进入过的房间的聊天记录, 大厅所有人都在
+	if historyMes.ToUserId == 0 && historyMes.RoomId != "" {
+		joined, err := model.MyUserDao.HasJoinedRoom(this.UserId, historyMes.RoomId)
+		if err != nil {
+			logger.For(this.Conn, this.UserId).Error("HasJoinedRoom fail", "room", historyMes.RoomId, "err", err)
+			historyResMes.Code = 505
+			historyResMes.Error = "服务器内部错误..."
+			return tf.WriteMes(message.HistoryResMesType, historyResMes)
+		}
+		if !joined {
+			historyResMes.Code = 401
+			historyResMes.Error = "你没有进入过这个房间"
+			return tf.WriteMes(message.HistoryResMesType, historyResMes)
+		}
+	}
+
+	count := historyMes.Count
+	if count <= 0 {
+		count = message.HistoryDefaultCount
+	} else if count > message.HistoryMaxCount {
+		count = message.HistoryMaxCount
+	}
+	//用当前用户作为发送方算出会话的key, 保证查不到别人的私聊
+	key := model.HistoryKey(&message.SmsMes{
+		User:     message.User{UserId: this.UserId},
+		ToUserId: historyMes.ToUserId,
+		RoomId:   historyMes.RoomId,
+	})
+	messages, err := model.MyMessageDao.GetHistory(key, count)
+	if err != nil {
+		logger.For(this.Conn, this.UserId).Error("GetHistory fail", "err", err)
+		historyResMes.Code = 505
+		historyResMes.Error = "服务器内部错误..."
+		return tf.WriteMes(message.HistoryResMesType, historyResMes)
+	}
+
+	//按大小分批发送
+	historyResMes.Code = 200
+	historyResMes.Last = false
+	size := 0
+	for _, smsMes := range messages {
+		data, err := json.Marshal(smsMes)
+		if err != nil {
+			continue
+		}
+		if size > 0 && size+len(data) > historyBatchSize {
+			err = tf.WriteMes(message.HistoryResMesType, historyResMes)
+			if err != nil {
+				return err
+			}
+			historyResMes.Messages = nil
+			size = 0
+		}
+		historyResMes.Messages = append(historyResMes.Messages, *smsMes)
+		size += len(data)
+	}
+	historyResMes.Last = true
+	return tf.WriteMes(message.HistoryResMesType, historyResMes)
+}
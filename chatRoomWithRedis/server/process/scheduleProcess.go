@@ -0,0 +1,226 @@
+package process2
+
+import (
+	"encoding/json"
+	"log/slog"
+	"net"
+	"strings"
+	"time"
+
+	"go_code/chatroom/common/message"
+	"go_code/chatroom/server/logger"
+	"go_code/chatroom/server/model"
+	"go_code/chatroom/server/utils"
+)
+
+//定时发送的消息和提醒
+//任务保存在redis的延时队列中, 服务器重启后不会丢失
+//每个服务器都有一个协程轮询到期的任务, 一个任务只会被一个服务器取到
+//定时消息到时间后和机器人的消息一样保存并转发, 提醒发给创建它的用户, 不在线时等他上线后推送
+
+//多久检查一次到期的任务
+const ScheduleInterval = 500 * time.Millisecond
+
+type ScheduleProcess struct {
+	Conn net.Conn
+	//当前连接登录的用户
+	UserId int
+}
+
+func (this *ScheduleProcess) ServerProcessSchedule(mes *message.Message) (err error) {
+
+	var scheduleMes message.ScheduleMes
+	err = json.Unmarshal([]byte(mes.Data), &scheduleMes)
+	if err != nil {
+		logger.For(this.Conn, this.UserId).Warn("消息格式错误", "type", mes.Type, "err", err)
+		return
+	}
+
+	var resMes message.ScheduleResMes
+	if _, loginErr := getLoginProcess(this.Conn, this.UserId); loginErr != nil {
+		resMes.Code = 403
+		resMes.Error = "请先登录"
+	} else if scheduleMes.CancelId != 0 {
+		resMes.Id = scheduleMes.CancelId
+		resMes.Cancelled = true
+		this.cancel(scheduleMes.CancelId, &resMes)
+	} else if errText := checkSchedule(&scheduleMes, time.Now()); errText != "" {
+		resMes.Code = 400
+		resMes.Error = errText
+	} else {
+		this.add(&scheduleMes, &resMes)
+	}
+
+	tf := &utils.Transfer{
+		Conn: this.Conn,
+	}
+	return tf.WriteMes(message.ScheduleResMesType, resMes)
+}
+
+//检查定时任务的参数, 不合法时返回错误的说明
+func checkSchedule(scheduleMes *message.ScheduleMes, now time.Time) string {
+	switch {
+		case scheduleMes.Kind != message.ScheduleSend && scheduleMes.Kind != message.ScheduleRemind:
+			return "不支持的定时任务"
+		case strings.TrimSpace(scheduleMes.Content) == "":
+			return "内容不能为空"
+		case scheduleMes.SendAt <= now.Unix():
+			return "时间必须在现在之后"
+		case scheduleMes.SendAt > now.Unix()+message.ScheduleMaxAhead:
+			return "最多只能提前30天"
+		case scheduleMes.Kind == message.ScheduleSend && scheduleMes.ToUserId == 0 && !validRoomId(scheduleMes.RoomId):
+			return "房间名不合法"
+	}
+	return ""
+}
+
+func (this *ScheduleProcess) add(scheduleMes *message.ScheduleMes, resMes *message.ScheduleResMes) {
+
+	task := &model.ScheduledTask{
+		UserId: this.UserId,
+		ScheduleMes: *scheduleMes,
+	}
+	//提醒只发给自己
+	if task.Kind == message.ScheduleRemind {
+		task.ToUserId = 0
+		task.RoomId = ""
+	} else if task.ToUserId != 0 {
+		task.RoomId = ""
+		_, err := model.MyUserDao.GetUserById(task.ToUserId)
+		if err != nil {
+			resMes.Code = 500
+			resMes.Error = err.Error()
+			return
+		}
+	}
+
+	err := model.MyScheduleDao.Add(task)
+	if err == model.ERROR_SCHEDULE_LIMIT {
+		resMes.Code = 400
+		resMes.Error = err.Error()
+	} else if err != nil {
+		logger.For(this.Conn, this.UserId).Error("保存定时任务错误", "err", err)
+		resMes.Code = 505
+		resMes.Error = "服务器内部错误..."
+	} else {
+		logger.For(this.Conn, this.UserId).Debug("添加定时任务", "id", task.Id, "kind", task.Kind, "sendAt", task.SendAt)
+		resMes.Code = 200
+		resMes.Id = task.Id
+		resMes.SendAt = task.SendAt
+	}
+}
+
+//给用户添加一个提醒, 和客户端的 /remind 一样保存在延时队列中, 给插件使用
+//参数不合法或者超过数量限制时Code不是200, Error 是错误的说明
+func ScheduleReminder(userId int, content string, sendAt time.Time) (resMes message.ScheduleResMes) {
+
+	scheduleMes := message.ScheduleMes{
+		Kind: message.ScheduleRemind,
+		Content: content,
+		SendAt: sendAt.Unix(),
+	}
+	if errText := checkSchedule(&scheduleMes, time.Now()); errText != "" {
+		resMes.Code = 400
+		resMes.Error = errText
+		return
+	}
+	scheduleProcess := &ScheduleProcess{
+		UserId: userId,
+	}
+	scheduleProcess.add(&scheduleMes, &resMes)
+	return
+}
+
+func (this *ScheduleProcess) cancel(id int, resMes *message.ScheduleResMes) {
+
+	err := model.MyScheduleDao.Cancel(this.UserId, id)
+	if err == model.ERROR_SCHEDULE_NOTEXISTS {
+		resMes.Code = 404
+		resMes.Error = err.Error()
+	} else if err != nil {
+		logger.For(this.Conn, this.UserId).Error("取消定时任务错误", "id", id, "err", err)
+		resMes.Code = 505
+		resMes.Error = "服务器内部错误..."
+	} else {
+		resMes.Code = 200
+	}
+}
+
+//用户上线后，推送他不在线时到时间的提醒
+func (this *ScheduleProcess) SendOfflineReminders() {
+
+	reminders, err := model.MyScheduleDao.PopOfflineReminders(this.UserId)
+	if err != nil {
+		logger.For(this.Conn, this.UserId).Error("PopOfflineReminders fail", "err", err)
+		return
+	}
+	tf := &utils.Transfer{
+		Conn: this.Conn,
+	}
+	for _, reminder := range reminders {
+		err = tf.WriteMes(message.ReminderMesType, reminder)
+		if err != nil {
+			//没发出去，重新放回离线队列
+			model.MyScheduleDao.AddOfflineReminder(this.UserId, reminder)
+		}
+	}
+}
+
+//启动一个协程，处理到期的定时任务, 返回的stop停止处理
+func StartScheduler(interval time.Duration) (stop func()) {
+	return model.MyScheduleDao.Poll(interval, deliverTask)
+}
+
+//返回错误时延时队列稍后重试, 重试也不会成功的情况只记录日志
+func deliverTask(task *model.ScheduledTask) (err error) {
+
+	if task.Kind == message.ScheduleRemind {
+		return deliverReminder(task)
+	}
+	//到时间时被禁言了就不发送
+	muted, _, err := model.MyModerationDao.MutedFor(task.UserId)
+	if err != nil {
+		return
+	}
+	if muted {
+		slog.Info("用户已被禁言, 不发送定时消息", "user", task.UserId, "id", task.Id)
+		return
+	}
+	smsMes := &message.SmsMes{
+		Content: task.Content,
+		ToUserId: task.ToUserId,
+		RoomId: task.RoomId,
+	}
+	smsMes.UserId = task.UserId
+	err = SendBotMes(smsMes)
+	if err == model.ERROR_USER_NOTEXISTS {
+		slog.Error("私聊的对象不存在, 不发送定时消息", "user", task.UserId, "id", task.Id, "to", task.ToUserId)
+		return nil
+	}
+	if err != nil {
+		return
+	}
+	slog.Debug("已发送定时消息", "user", task.UserId, "id", task.Id, "mes", smsMes.MesId)
+	return
+}
+
+func deliverReminder(task *model.ScheduledTask) (err error) {
+
+	reminder := &message.ReminderMes{
+		Id: task.Id,
+		Content: task.Content,
+		SendAt: task.SendAt,
+	}
+	up, err := userMgr.GetOnlineUserById(task.UserId)
+	if err == nil {
+		tf := &utils.Transfer{
+			Conn: up.Conn,
+		}
+		err = tf.WriteMes(message.ReminderMesType, reminder)
+	}
+	if err != nil {
+		//不在线或者没有发出去, 等他上线后推送
+		return model.MyScheduleDao.AddOfflineReminder(task.UserId, reminder)
+	}
+	return
+}
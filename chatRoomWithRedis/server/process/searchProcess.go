@@ -0,0 +1,105 @@
+package process2
+
+import (
+	"encoding/json"
+
+	"go_code/chatroom/common/message"
+	"go_code/chatroom/server/logger"
+	"go_code/chatroom/server/model"
+	"go_code/chatroom/server/utils"
+)
+
+//处理搜索聊天记录
+//能搜到的消息: 大厅和自己进入过的房间中的群聊, 自己发出或收到的私聊, 已删除的消息不返回
+func (this *RoomProcess) ServerProcessSearch(mes *message.Message) (err error) {
+
+	var searchMes message.SearchMes
+	err = json.Unmarshal([]byte(mes.Data), &searchMes)
+	if err != nil {
+		logger.For(this.Conn, this.UserId).Warn("消息格式错误", "type", mes.Type, "err", err)
+		return
+	}
+
+	var searchResMes message.SearchResMes
+	tf := &utils.Transfer{
+		Conn: this.Conn,
+	}
+	if this.UserId == 0 {
+		searchResMes.Code = 403
+		searchResMes.Error = "请先登录"
+		return tf.WriteMes(message.SearchResMesType, searchResMes)
+	}
+	terms := model.QueryTerms(searchMes.Query)
+	if len(terms) == 0 {
+		searchResMes.Code = 400
+		searchResMes.Error = "请输入要搜索的内容"
+		return tf.WriteMes(message.SearchResMesType, searchResMes)
+	}
+	count := searchMes.Count
+	if count <= 0 {
+		count = message.SearchDefaultCount
+	} else if count > message.SearchMaxCount {
+		count = message.SearchMaxCount
+	}
+
+	rooms, err := model.MyUserDao.GetJoinedRooms(this.UserId)
+	if err != nil {
+		logger.For(this.Conn, this.UserId).Error("GetJoinedRooms fail", "err", err)
+		searchResMes.Code = 505
+		searchResMes.Error = "服务器内部错误..."
+		return tf.WriteMes(message.SearchResMesType, searchResMes)
+	}
+	//大厅所有人都在
+	rooms[""] = true
+	if searchMes.InRoom && searchMes.ToUserId == 0 && !rooms[searchMes.RoomId] {
+		searchResMes.Code = 401
+		searchResMes.Error = "你没有进入过这个房间"
+		return tf.WriteMes(message.SearchResMesType, searchResMes)
+	}
+
+	//指定了会话时只在这个会话中找, 私聊用当前用户算出会话的key, 保证查不到别人的私聊
+	conversationKey := ""
+	if searchMes.InRoom || searchMes.ToUserId != 0 {
+		conversationKey = model.HistoryKey(&message.SmsMes{
+			User:     message.User{UserId: this.UserId},
+			ToUserId: searchMes.ToUserId,
+			RoomId:   searchMes.RoomId,
+		})
+	}
+	messages, nextBeforeId, err := model.MyMessageDao.Search(terms, conversationKey, searchMes.BeforeId, count,
+		func(smsMes *message.SmsMes) bool {
+			if smsMes.Deleted {
+				return false
+			}
+			if smsMes.ToUserId != 0 {
+				if smsMes.UserId != this.UserId && smsMes.ToUserId != this.UserId {
+					return false
+				}
+			} else if !rooms[smsMes.RoomId] {
+				return false
+			}
+			if searchMes.FromUserId != 0 && smsMes.UserId != searchMes.FromUserId {
+				return false
+			}
+			if searchMes.Since != 0 && smsMes.SendTime < searchMes.Since {
+				return false
+			}
+			if searchMes.Until != 0 && smsMes.SendTime > searchMes.Until {
+				return false
+			}
+			return true
+		})
+	if err != nil {
+		logger.For(this.Conn, this.UserId).Error("搜索聊天记录错误", "err", err)
+		searchResMes.Code = 505
+		searchResMes.Error = "服务器内部错误..."
+		return tf.WriteMes(message.SearchResMesType, searchResMes)
+	}
+
+	searchResMes.Code = 200
+	searchResMes.NextBeforeId = nextBeforeId
+	for _, smsMes := range messages {
+		searchResMes.Messages = append(searchResMes.Messages, *smsMes)
+	}
+	return tf.WriteMes(message.SearchResMesType, searchResMes)
+}
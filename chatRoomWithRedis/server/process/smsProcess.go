@@ -3,49 +3,277 @@ import (
 	"fmt"
 	"net"
 	"go_code/chatroom/common/message"
+	"go_code/chatroom/server/model"
 	"go_code/chatroom/server/utils"
+	"go_code/chatroom/server/logger"
+	"go_code/chatroom/server/metrics"
+	"go_code/chatroom/server/events"
+	"time"
 	
 	"encoding/json"
 )
 
 type SmsProcess struct {
-	//..[暂时不需字段]
+	Conn net.Conn
+	//当前连接登录的用户
+	UserId int
 }
+
+//处理客户端发来的SmsMes
+//先分配消息id并保存，回复发送方SmsResMes，再转发给群聊的所有人或者私聊的对象
+func (this *SmsProcess) ServerProcessSms(mes *message.Message) (err error) {
+
+	var smsMes message.SmsMes
+	err = json.Unmarshal([]byte(mes.Data), &smsMes)
+	if err != nil {
+		logger.For(this.Conn, this.UserId).Warn("消息格式错误", "type", mes.Type, "err", err)
+		return
+	}
+
+	var smsResMes message.SmsResMes
+	smsResMes.LocalId = smsMes.LocalId
+	//发送方以当前连接登录的用户为准，不相信客户端填的UserId
+	smsMes.UserId = this.UserId
+	//群聊发到发送方当前所在的房间，私聊不属于任何房间
+	smsMes.RoomId = ""
+	if up, err := getLoginProcess(this.Conn, this.UserId); err == nil && smsMes.ToUserId == 0 {
+		smsMes.RoomId = up.GetRoom()
+	}
+
+	if this.UserId == 0 {
+		smsResMes.Code = 403
+		smsResMes.Error = "请先登录"
+	} else if muted, seconds, _ := model.MyModerationDao.MutedFor(this.UserId); muted {
+		smsResMes.Code = 407
+		if seconds > 0 {
+			smsResMes.Error = fmt.Sprintf("%s, 还剩%d秒解除", model.ERROR_USER_MUTED.Error(), seconds)
+		} else {
+			smsResMes.Error = model.ERROR_USER_MUTED.Error()
+		}
+	} else if smsMes.E2E != nil && smsMes.ToUserId == 0 {
+		smsResMes.Code = 400
+		smsResMes.Error = "加密消息只能私聊"
+	} else {
+		//私聊时，对方必须是已注册的用户
+		if smsMes.ToUserId != 0 {
+			_, err = model.MyUserDao.GetUserById(smsMes.ToUserId)
+		}
+		if err == nil {
+			err = model.MyMessageDao.AddMessage(&smsMes)
+		}
+		if err == model.ERROR_USER_NOTEXISTS {
+			smsResMes.Code = 500
+			smsResMes.Error = err.Error()
+		} else if err != nil {
+			logger.For(this.Conn, this.UserId).Error("保存消息错误", "err", err)
+			smsResMes.Code = 505
+			smsResMes.Error = "服务器内部错误..."
+		} else {
+			smsResMes.Code = 200
+			smsResMes.MesId = smsMes.MesId
+			smsResMes.SendTime = smsMes.SendTime
+		}
+	}
+
+	//先回复发送方，保证发送方在收到送达回执之前已经知道了mesId
+	tf := &utils.Transfer{
+		Conn : this.Conn,
+	}
+	err = tf.WriteMes(message.SmsResMesType, smsResMes)
+	if err != nil || smsResMes.Code != 200 {
+		return
+	}
+	events.MessageEvent(events.TypeMessage, this.UserId, &smsMes)
+
+	if smsMes.ToUserId != 0 {
+		this.SendPrivateMes(&smsMes)
+	} else {
+		this.SendGroupMes(&smsMes)
+		this.SendMentions(&smsMes)
+	}
+	return
+}
+
+//插件的机器人发消息和到时间的定时消息, 没有客户端连接, 保存后和普通消息一样转发
+//smsMes.UserId 是机器人或者定时消息发送者的id, 群聊时RoomId是要发到的房间
+func SendBotMes(smsMes *message.SmsMes) (err error) {
+
+	if smsMes.ToUserId != 0 {
+		_, err = model.MyUserDao.GetUserById(smsMes.ToUserId)
+		if err != nil {
+			return
+		}
+	}
+	err = model.MyMessageDao.AddMessage(smsMes)
+	if err != nil {
+		return
+	}
+	events.MessageEvent(events.TypeMessage, smsMes.UserId, smsMes)
+	smsProcess := &SmsProcess{
+		UserId : smsMes.UserId,
+	}
+	if smsMes.ToUserId != 0 {
+		smsProcess.SendPrivateMes(smsMes)
+	} else {
+		smsProcess.SendGroupMes(smsMes)
+		smsProcess.SendMentions(smsMes)
+	}
+	return
+}
+
 //写方法转发消息
-func (this *SmsProcess) SendGroupMes(mes *message.Message) {
+func (this *SmsProcess) SendGroupMes(smsMes *message.SmsMes) {
 
 	//遍历服务器端的onlineUsers map[int]*UserProcess, 
 	//将消息转发取出.
-	//取出mes的内容 SmsMes
-	var smsMes message.SmsMes
-	err := json.Unmarshal([]byte(mes.Data), &smsMes)
+	data, err := this.packSms(smsMes)
 	if err != nil {
-		fmt.Println("json.Unmarshal err=", err)
 		return
 	}
+	defer metrics.FanoutDuration.Since(time.Now(), "group")
+
+	for id, up := range userMgr.GetAllOnlineUser() {
+		//这里，还需要过滤到自己,即不要再发给自己
+		//只发给同一个房间的用户
+		if id == smsMes.UserId || up.GetRoom() != smsMes.RoomId {
+			continue
+		}
+		err = this.SendMesToEachOnlineUser(data, up.Conn)
+		if err != nil {
+			logger.For(this.Conn, this.UserId).Warn("转发消息失败", "mes", smsMes.MesId, "to", id, "err", err)
+		}
+	}
+}
 
-	data, err := json.Marshal(mes) 
+//私聊消息，对方不在线时放入离线队列，等对方上线后推送
+func (this *SmsProcess) SendPrivateMes(smsMes *message.SmsMes) {
+
+	data, err := this.packSms(smsMes)
 	if err != nil {
-		fmt.Println("json.Marshal err=", err)
 		return
 	}
+	defer metrics.FanoutDuration.Since(time.Now(), "private")
+	up, err := userMgr.GetOnlineUserById(smsMes.ToUserId)
+	if err == nil {
+		err = this.SendMesToEachOnlineUser(data, up.Conn)
+	}
+	if err != nil {
+		err = model.MyMessageDao.AddOffline(smsMes.ToUserId, smsMes.MesId)
+		if err != nil {
+			logger.For(this.Conn, this.UserId).Error("AddOffline fail", "mes", smsMes.MesId, "err", err)
+		}
+	}
+}
 
-	for id, up := range userMgr.onlineUsers {
-		//这里，还需要过滤到自己,即不要再发给自己
-		if id == smsMes.UserId {
+//用户上线后，推送他不在线时收到的私聊消息
+func (this *SmsProcess) SendOfflineMes() {
+
+	messages, err := model.MyMessageDao.PopOffline(this.UserId)
+	if err != nil {
+		logger.For(this.Conn, this.UserId).Error("PopOffline fail", "err", err)
+		return
+	}
+	for _, smsMes := range messages {
+		data, err := this.packSms(smsMes)
+		if err != nil {
 			continue
 		}
-		this.SendMesToEachOnlineUser(data, up.Conn)
+		err = this.SendMesToEachOnlineUser(data, this.Conn)
+		if err != nil {
+			//没发出去，重新放回离线队列
+			model.MyMessageDao.AddOffline(this.UserId, smsMes.MesId)
+		}
 	}
 }
-func (this *SmsProcess) SendMesToEachOnlineUser(data []byte , conn net.Conn) {
+
+//把SmsMes包装成Message并序列化
+func (this *SmsProcess) packSms(smsMes *message.SmsMes) (data []byte, err error) {
+
+	smsData, err := json.Marshal(smsMes)
+	if err != nil {
+		logger.For(this.Conn, this.UserId).Error("json.Marshal fail", "err", err)
+		return
+	}
+	mes := message.Message{
+		Type : message.SmsMesType,
+		Data : string(smsData),
+	}
+	data, err = json.Marshal(mes) 
+	if err != nil {
+		logger.For(this.Conn, this.UserId).Error("json.Marshal fail", "err", err)
+		return
+	}
+	return
+}
+
+func (this *SmsProcess) SendMesToEachOnlineUser(data []byte , conn net.Conn) (err error) {
 
 	//创建一个Transfer 实例，发送data
 	tf := &utils.Transfer{
 		Conn : conn, //
 	}
-	err := tf.WritePkg(data)
+	err = tf.WritePkg(data)
 	if err != nil {
-		fmt.Println("转发消息失败 err=", err)
+		logger.For(conn, 0).Debug("转发消息失败", "err", err)
 	}
-}
\ No newline at end of file
+	return
+}
+
+//处理接收方的送达回执和已读回执，更新消息状态后转发给发送方
+func (this *SmsProcess) ServerProcessReceipt(mes *message.Message) (err error) {
+
+	//DeliveredMes 和 ReadMes 的结构是一样的
+	var mesIds []int
+	var status int
+	switch mes.Type {
+		case message.DeliveredMesType :
+			var deliveredMes message.DeliveredMes
+			err = json.Unmarshal([]byte(mes.Data), &deliveredMes)
+			mesIds = deliveredMes.MesIds
+			status = message.MesDelivered
+		case message.ReadMesType :
+			var readMes message.ReadMes
+			err = json.Unmarshal([]byte(mes.Data), &readMes)
+			mesIds = readMes.MesIds
+			status = message.MesRead
+	}
+	if err != nil {
+		logger.For(this.Conn, this.UserId).Warn("消息格式错误", "type", mes.Type, "err", err)
+		return
+	}
+
+	//按发送方分组，每个发送方只收到自己消息的回执
+	senders := make(map[int][]int)
+	for _, mesId := range mesIds {
+		smsMes, err := model.MyMessageDao.GetMessageById(mesId)
+		//只有私聊的接收方才能回执
+		if err != nil || smsMes.ToUserId != this.UserId || this.UserId == 0 {
+			continue
+		}
+		_, changed, err := model.MyMessageDao.UpdateStatus(mesId, status)
+		if err != nil || !changed {
+			continue
+		}
+		senders[smsMes.UserId] = append(senders[smsMes.UserId], mesId)
+	}
+
+	for senderId, ids := range senders {
+		up, err := userMgr.GetOnlineUserById(senderId)
+		if err != nil {
+			//发送方不在线，他下次查看消息时能看到最新的状态
+			continue
+		}
+		tf := &utils.Transfer{
+			Conn : up.Conn,
+		}
+		if status == message.MesDelivered {
+			err = tf.WriteMes(mes.Type, message.DeliveredMes{MesIds: ids, UserId: this.UserId})
+		} else {
+			err = tf.WriteMes(mes.Type, message.ReadMes{MesIds: ids, UserId: this.UserId})
+		}
+		if err != nil {
+			logger.For(this.Conn, this.UserId).Warn("转发回执失败", "to", senderId, "err", err)
+		}
+	}
+	return nil
+}
@@ -1,6 +1,7 @@
 package process2
 import (
 	"fmt"
+	"sync"
 )
 //因为UserMgr 实例在服务器端有且只有一个
 //因为在很多的地方，都会使用到，因此，我们
@@ -10,6 +11,8 @@ var (
 )
 type UserMgr struct {
 	onlineUsers map[int]*UserProcess
+	//每个连接都有自己的协程，onlineUsers会被并发读写，需要加锁
+	lock sync.RWMutex
 }
 //完成对userMgr初始化工作
 func init() {
@@ -19,20 +22,45 @@ func init() {
 }
 //完成对onlineUsers添加
 func (this *UserMgr) AddOnlineUser(up *UserProcess) {
+	this.lock.Lock()
+	defer this.lock.Unlock()
 	this.onlineUsers[up.UserId] = up
 }
 //删除
 func (this *UserMgr) DelOnlineUser(userId int) {
+	this.lock.Lock()
+	defer this.lock.Unlock()
 	delete(this.onlineUsers, userId)
 }
-//返回当前所有在线的用户
+//只有当userId对应的还是这个连接时才删除
+//避免同一个用户在别的地方重新登录后，被旧连接的断开误删
+func (this *UserMgr) DelOnlineUserByProcess(up *UserProcess) (ok bool) {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	cur, ok := this.onlineUsers[up.UserId]
+	if ok && cur.Conn == up.Conn {
+		delete(this.onlineUsers, up.UserId)
+		return
+	}
+	ok = false
+	return
+}
+//返回当前所有在线的用户, 返回的是一个拷贝，调用方可以放心遍历
 func (this *UserMgr) GetAllOnlineUser() map[int]*UserProcess {
-	return this.onlineUsers
+	this.lock.RLock()
+	defer this.lock.RUnlock()
+	users := make(map[int]*UserProcess, len(this.onlineUsers))
+	for id, up := range this.onlineUsers {
+		users[id] = up
+	}
+	return users
 }
 //根据id返回对应的值
 func (this *UserMgr) GetOnlineUserById(userId int) (up *UserProcess, err error) {
 
 	//如何从map取出一个值，带检测方式
+	this.lock.RLock()
+	defer this.lock.RUnlock()
 	up, ok := this.onlineUsers[userId]
 	if !ok { //说明，你要查找的这个用户，当前不在线。
 		err = fmt.Errorf("用户%d 不存在", userId)
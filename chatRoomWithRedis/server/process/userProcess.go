@@ -1,10 +1,13 @@
 package process2
 import (
-	"fmt"
 	"net"
+	"sync"
+	"time"
 	"go_code/chatroom/common/message"
 	"go_code/chatroom/server/utils"
 	"go_code/chatroom/server/model"
+	"go_code/chatroom/server/logger"
+	"go_code/chatroom/server/events"
 	"encoding/json"
 )
 
@@ -13,37 +16,61 @@ type UserProcess struct {
 	Conn net.Conn
 	//增加一个字段，表示该Conn是哪个用户
 	UserId int
+	//用户的状态和自定义的状态说明
+	Status int
+	StatusText string
+	//最后一次操作的时间，用来自动设置离开
+	LastActive time.Time
+	//是否是服务器自动设置的离开，用户有操作后自动恢复在线
+	AutoAway bool
+	//当前所在的房间, 空表示大厅
+	Room string
+	//提醒设置, 转发群聊消息时用来匹配关键词
+	Notify message.NotifySettings
+	//状态会被该连接的协程和检查离开的协程同时修改
+	lock sync.Mutex
 }
 
 //这里我们编写通知所有在线的用户的方法
 //userId 要通知其它的在线用户，我上线
 func (this *UserProcess) NotifyOthersOnlineUser(userId int) {
+	this.NotifyOthersStatus(message.NotifyUserStatusMes{
+		UserId : userId,
+		Status : message.UserOnline,
+	})
+}
+
+//通知其它的在线用户，我的状态变了
+func (this *UserProcess) NotifyOthersStatus(notifyUserStatusMes message.NotifyUserStatusMes) {
 
 	//遍历 onlineUsers, 然后一个一个的发送 NotifyUserStatusMes
-	for id, up := range userMgr.onlineUsers {
+	for id, up := range userMgr.GetAllOnlineUser() {
 		//过滤到自己
-		if id == userId {
+		if id == notifyUserStatusMes.UserId {
 			continue
 		}
 		//开始通知【单独的写一个方法】
-		up.NotifyMeOnline(userId)
+		up.NotifyMeStatus(notifyUserStatusMes)
 	}
 }
 
 func (this *UserProcess) NotifyMeOnline(userId int) {
+	this.NotifyMeStatus(message.NotifyUserStatusMes{
+		UserId : userId,
+		Status : message.UserOnline,
+	})
+}
+
+func (this *UserProcess) NotifyMeStatus(notifyUserStatusMes message.NotifyUserStatusMes) {
 
 	//组装我们的NotifyUserStatusMes
 	var mes message.Message
 	mes.Type = message.NotifyUserStatusMesType
 
-	var notifyUserStatusMes message.NotifyUserStatusMes
-	notifyUserStatusMes.UserId = userId
-	notifyUserStatusMes.Status = message.UserOnline
-
 	//将notifyUserStatusMes序列化
 	data, err := json.Marshal(notifyUserStatusMes)
 	if err != nil {
-		fmt.Println("json.Marshal err=", err)
+		logger.For(this.Conn, this.UserId).Error("json.Marshal fail", "err", err)
 		return 
 	}
 	//将序列化后的notifyUserStatusMes赋值给 mes.Data
@@ -52,7 +79,7 @@ func (this *UserProcess) NotifyMeOnline(userId int) {
 	//对mes再次序列化，准备发送.
 	data, err = json.Marshal(mes)
 	if err != nil {
-		fmt.Println("json.Marshal err=", err)
+		logger.For(this.Conn, this.UserId).Error("json.Marshal fail", "err", err)
 		return 
 	}
 
@@ -63,7 +90,7 @@ func (this *UserProcess) NotifyMeOnline(userId int) {
 
 	err = tf.WritePkg(data)
 	if err != nil {
-		fmt.Println("NotifyMeOnline err=", err)
+		logger.For(this.Conn, this.UserId).Debug("通知用户状态失败", "err", err)
 		return
 	}
 }
@@ -75,7 +102,7 @@ func (this *UserProcess) ServerProcessRegister(mes *message.Message) (err error)
 	var registerMes message.RegisterMes
 	err = json.Unmarshal([]byte(mes.Data), &registerMes) 
 	if err != nil {
-		fmt.Println("json.Unmarshal fail err=", err)
+		logger.For(this.Conn, this.UserId).Warn("消息格式错误", "type", mes.Type, "err", err)
 		return 
 	}
 
@@ -86,6 +113,9 @@ func (this *UserProcess) ServerProcessRegister(mes *message.Message) (err error)
 
 	//我们需要到redis数据库去完成注册.
 	//1.使用model.MyUserDao 到redis去验证
+	//注册的用户都是普通用户，不能自己指定角色
+	registerMes.User.Role = message.RoleUser
+	registerMes.User.Bot = false
 	err = model.MyUserDao.Register(&registerMes.User)
 
 	if err != nil {
@@ -102,7 +132,7 @@ func (this *UserProcess) ServerProcessRegister(mes *message.Message) (err error)
 
 	data, err := json.Marshal(registerResMes)
 	if err != nil {
-		fmt.Println("json.Marshal fail", err)
+		logger.For(this.Conn, this.UserId).Error("json.Marshal fail", "err", err)
 		return 
 	}
 
@@ -113,7 +143,7 @@ func (this *UserProcess) ServerProcessRegister(mes *message.Message) (err error)
 	//5. 对resMes 进行序列化，准备发送
 	data, err = json.Marshal(resMes)
 	if err != nil {
-		fmt.Println("json.Marshal fail", err)
+		logger.For(this.Conn, this.UserId).Error("json.Marshal fail", "err", err)
 		return 
 	}
 	//6. 发送data, 我们将其封装到writePkg函数
@@ -133,7 +163,7 @@ func (this *UserProcess) ServerProcessLogin(mes *message.Message) (err error) {
 	var loginMes message.LoginMes
 	err = json.Unmarshal([]byte(mes.Data), &loginMes) 
 	if err != nil {
-		fmt.Println("json.Unmarshal fail err=", err)
+		logger.For(this.Conn, this.UserId).Warn("消息格式错误", "type", mes.Type, "err", err)
 		return 
 	}
 	//1先声明一个 resMes
@@ -145,6 +175,9 @@ func (this *UserProcess) ServerProcessLogin(mes *message.Message) (err error) {
 	//我们需要到redis数据库去完成验证.
 	//1.使用model.MyUserDao 到redis去验证
 	user, err := model.MyUserDao.Login(loginMes.UserId, loginMes.UserPwd)
+	if err == nil {
+		err = this.checkBanned(loginMes.UserId)
+	}
 	
 	if err != nil {
 
@@ -154,6 +187,9 @@ func (this *UserProcess) ServerProcessLogin(mes *message.Message) (err error) {
 		} else if err == model.ERROR_USER_PWD  {
 			loginResMes.Code = 403
 			loginResMes.Error = err.Error()
+		} else if err == model.ERROR_USER_BANNED {
+			loginResMes.Code = 406
+			loginResMes.Error = err.Error()
 		} else {
 			loginResMes.Code = 505
 			loginResMes.Error = "服务器内部错误..."
@@ -161,18 +197,36 @@ func (this *UserProcess) ServerProcessLogin(mes *message.Message) (err error) {
 
 	} else {
 		loginResMes.Code = 200
+		loginResMes.Role = user.Role
 		//这里，因为用户登录成功，我们就把该登录成功的用放入到userMgr中
 		//将登录成功的用户的userId 赋给 this
 		this.UserId = loginMes.UserId
+		this.Status = message.UserOnline
+		this.LastActive = time.Now()
+		this.Notify, err = model.MyUserDao.GetNotifySettings(this.UserId)
+		if err != nil {
+			//读不到设置时不影响登录, 只是收不到关键词提醒
+			logger.For(this.Conn, this.UserId).Error("读取提醒设置错误", "err", err)
+		}
 		userMgr.AddOnlineUser(this)
+		events.Login(this.UserId)
 		//通知其它的在线用户， 我上线了
 		this.NotifyOthersOnlineUser(loginMes.UserId)
 		//将当前在线用户的id 放入到loginResMes.UsersId
-		//遍历 userMgr.onlineUsers
-		for id, _ := range userMgr.onlineUsers {
+		//遍历 userMgr.onlineUsers, 隐身的用户不告诉别人
+		for id, up := range userMgr.GetAllOnlineUser() {
+			status, statusText := up.VisibleStatus()
+			if status == message.UserOffline && id != this.UserId {
+				continue
+			}
 			loginResMes.UsersId = append(loginResMes.UsersId, id)
+			loginResMes.Users = append(loginResMes.Users, message.User{
+				UserId : id,
+				UserStatus : status,
+				StatusText : statusText,
+			})
 		}
-		fmt.Println(user, "登录成功")
+		logger.For(this.Conn, user.UserId).Info("登录成功", "role", user.Role)
 	}
 	// //如果用户id= 100， 密码=123456, 认为合法，否则不合法
 	
@@ -189,7 +243,7 @@ func (this *UserProcess) ServerProcessLogin(mes *message.Message) (err error) {
 	//3将 loginResMes 序列化
 	data, err := json.Marshal(loginResMes)
 	if err != nil {
-		fmt.Println("json.Marshal fail", err)
+		logger.For(this.Conn, this.UserId).Error("json.Marshal fail", "err", err)
 		return 
 	}
 
@@ -199,7 +253,7 @@ func (this *UserProcess) ServerProcessLogin(mes *message.Message) (err error) {
 	//5. 对resMes 进行序列化，准备发送
 	data, err = json.Marshal(resMes)
 	if err != nil {
-		fmt.Println("json.Marshal fail", err)
+		logger.For(this.Conn, this.UserId).Error("json.Marshal fail", "err", err)
 		return 
 	}
 	//6. 发送data, 我们将其封装到writePkg函数
@@ -211,3 +265,34 @@ func (this *UserProcess) ServerProcessLogin(mes *message.Message) (err error) {
 	return 
 }
 
+
+
+//检查用户id和当前连接的ip是否被封禁
+func (this *UserProcess) checkBanned(userId int) (err error) {
+	banned, err := model.MyModerationDao.IsUserBanned(userId)
+	if err == nil && !banned {
+		banned, err = model.MyModerationDao.IsIpBanned(ConnIp(this.Conn))
+	}
+	if err == nil && banned {
+		err = model.ERROR_USER_BANNED
+	}
+	return
+}
+
+//客户端断开连接后，把该用户从onlineUsers中删除
+func (this *UserProcess) ServerProcessLogout() {
+	if this.UserId == 0 {
+		//还没有登录成功
+		return
+	}
+	if !userMgr.DelOnlineUserByProcess(this) {
+		return
+	}
+	clearTyping(this.UserId)
+	events.Logout(this.UserId)
+	//通知其它的在线用户，我下线了
+	this.NotifyOthersStatus(message.NotifyUserStatusMes{
+		UserId : this.UserId,
+		Status : message.UserOffline,
+	})
+}
\ No newline at end of file
@@ -0,0 +1,287 @@
+package main
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"go_code/chatroom/common/message"
+	"go_code/chatroom/server/model"
+)
+
+//每次从redis取多少条消息
+const scanBatch = 500
+
+//一种导出格式, 依次写入每条消息, 最后调用 Close
+type writer interface {
+	Write(smsMes *message.SmsMes) error
+	Close() error
+}
+
+func runExport(args []string) (err error) {
+	flags := flag.NewFlagSet("export", flag.ExitOnError)
+	address := flags.String("redis", "127.0.0.1:6379", "redis的地址")
+	group := flags.Bool("group", false, "导出大厅的消息")
+	room := flags.String("room", "", "导出这个房间的消息")
+	private := flags.String("private", "", "导出两个用户的私聊, 例如 100,200")
+	from := flags.String("from", "", "开始时间(包含), 为空表示从最早的消息开始")
+	to := flags.String("to", "", "结束时间(不包含), 只有日期时包含当天, 为空表示到最新的消息")
+	format := flags.String("format", "jsonl", "导出格式: jsonl, csv, md")
+	output := flags.String("o", "", "导出到这个文件, 为空时输出到标准输出")
+	flags.Parse(args)
+
+	key, name, err := conversation(*group, *room, *private)
+	if err != nil {
+		return
+	}
+	fromTime, err := parseTime(*from, false)
+	if err != nil {
+		return
+	}
+	toTime, err := parseTime(*to, true)
+	if err != nil {
+		return
+	}
+
+	var out io.Writer = os.Stdout
+	if *output != "" {
+		file, err := os.Create(*output)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		out = file
+	}
+
+	initDao(*address)
+	count, err := export(out, &exportOptions{
+		key:    key,
+		name:   name,
+		from:   fromTime,
+		to:     toTime,
+		format: *format,
+	})
+	if err == nil {
+		fmt.Fprintf(os.Stderr, "从%s导出了%d条消息\n", name, count)
+	}
+	return
+}
+
+//要导出的会话, 时间范围和格式
+type exportOptions struct {
+	key  string
+	name string
+	//from 包含, to 不包含, 零值表示不限制
+	from   time.Time
+	to     time.Time
+	format string
+}
+
+//把会话中时间范围内的消息写到out, 返回导出的条数
+func export(out io.Writer, opts *exportOptions) (count int, err error) {
+	buf := bufio.NewWriter(out)
+
+	var w writer
+	switch opts.format {
+		case "jsonl":
+			w = &jsonlWriter{enc: json.NewEncoder(buf)}
+		case "csv":
+			w = newCsvWriter(buf)
+		case "md":
+			w = newMarkdownWriter(buf, opts.name, opts.from, opts.to)
+		default:
+			return 0, fmt.Errorf("不支持的格式: %s", opts.format)
+	}
+
+	afterId := 0
+	for {
+		messages, nextAfterId, err := model.MyMessageDao.ScanHistory(opts.key, afterId, scanBatch)
+		if err != nil {
+			return count, err
+		}
+		for _, smsMes := range messages {
+			if !opts.from.IsZero() && smsMes.SendTime < opts.from.Unix() {
+				continue
+			}
+			if !opts.to.IsZero() && smsMes.SendTime >= opts.to.Unix() {
+				continue
+			}
+			//导出的文件不应该包含密码
+			smsMes.UserPwd = ""
+			err = w.Write(smsMes)
+			if err != nil {
+				return count, err
+			}
+			count++
+		}
+		if nextAfterId == 0 {
+			break
+		}
+		afterId = nextAfterId
+	}
+	err = w.Close()
+	if err == nil {
+		err = buf.Flush()
+	}
+	return
+}
+
+//用户的名字, 查不到时用消息中的名字
+var userNames = make(map[int]string)
+
+func userName(userId int, fallback string) string {
+	name, ok := userNames[userId]
+	if !ok {
+		user, err := model.MyUserDao.GetUserById(userId)
+		if err == nil {
+			name = user.UserName
+		}
+		userNames[userId] = name
+	}
+	if name == "" {
+		name = fallback
+	}
+	if name == "" {
+		name = fmt.Sprintf("用户%d", userId)
+	}
+	return name
+}
+
+func formatTime(unix int64) string {
+	return time.Unix(unix, 0).Format("2006-01-02 15:04:05")
+}
+
+//消息的文字内容, 删除和加密的消息没有可以显示的内容
+func displayContent(smsMes *message.SmsMes) string {
+	if smsMes.Deleted {
+		return "[消息已删除]"
+	}
+	if smsMes.E2E != nil {
+		return "[加密消息]"
+	}
+	return smsMes.Content
+}
+
+//JSON Lines, 每行一条完整的消息, 可以再导入
+type jsonlWriter struct {
+	enc *json.Encoder
+}
+
+func (this *jsonlWriter) Write(smsMes *message.SmsMes) error {
+	return this.enc.Encode(smsMes)
+}
+
+func (this *jsonlWriter) Close() error {
+	return nil
+}
+
+type csvWriter struct {
+	w      *csv.Writer
+	header bool
+}
+
+func newCsvWriter(out io.Writer) *csvWriter {
+	return &csvWriter{w: csv.NewWriter(out)}
+}
+
+func (this *csvWriter) Write(smsMes *message.SmsMes) error {
+	if !this.header {
+		this.header = true
+		this.w.Write([]string{"mesId", "sendTime", "userId", "userName", "toUserId", "roomId",
+			"content", "editTime", "deleted", "encrypted"})
+	}
+	editTime := ""
+	if smsMes.EditTime != 0 {
+		editTime = formatTime(smsMes.EditTime)
+	}
+	return this.w.Write([]string{
+		strconv.Itoa(smsMes.MesId),
+		formatTime(smsMes.SendTime),
+		strconv.Itoa(smsMes.UserId),
+		userName(smsMes.UserId, smsMes.UserName),
+		strconv.Itoa(smsMes.ToUserId),
+		smsMes.RoomId,
+		displayContent(smsMes),
+		editTime,
+		strconv.FormatBool(smsMes.Deleted),
+		strconv.FormatBool(smsMes.E2E != nil),
+	})
+}
+
+func (this *csvWriter) Close() error {
+	this.w.Flush()
+	return this.w.Error()
+}
+
+//方便阅读的 Markdown, 按天分组
+type markdownWriter struct {
+	out io.Writer
+	day string
+	err error
+}
+
+func newMarkdownWriter(out io.Writer, name string, from time.Time, to time.Time) *markdownWriter {
+	w := &markdownWriter{out: out}
+	w.printf("# 聊天记录: %s\n\n", name)
+	if !from.IsZero() || !to.IsZero() {
+		start, end := "最早", "最新"
+		if !from.IsZero() {
+			start = from.Format("2006-01-02 15:04:05")
+		}
+		if !to.IsZero() {
+			end = to.Format("2006-01-02 15:04:05")
+		}
+		w.printf("时间范围: %s 到 %s\n\n", start, end)
+	}
+	return w
+}
+
+//记下第一个错误, 之后不再写
+func (this *markdownWriter) printf(format string, args ...interface{}) {
+	if this.err != nil {
+		return
+	}
+	_, this.err = fmt.Fprintf(this.out, format, args...)
+}
+
+func (this *markdownWriter) Write(smsMes *message.SmsMes) error {
+	t := time.Unix(smsMes.SendTime, 0)
+	if day := t.Format("2006-01-02"); day != this.day {
+		if this.day != "" {
+			this.printf("\n")
+		}
+		this.day = day
+		this.printf("## %s\n\n", day)
+	}
+	var note string
+	if smsMes.EditTime != 0 && !smsMes.Deleted {
+		note = " (已修改)"
+	}
+	//多行的消息, 后面的行缩进到同一条消息中
+	content := strings.ReplaceAll(displayContent(smsMes), "\n", "\n  ")
+	this.printf("- **%s** %s%s: %s\n", userName(smsMes.UserId, smsMes.UserName), t.Format("15:04:05"), note, content)
+	if len(smsMes.Reactions) > 0 {
+		var reactions []string
+		for emoji, users := range smsMes.Reactions {
+			reactions = append(reactions, fmt.Sprintf("%s %d", emoji, len(users)))
+		}
+		sort.Strings(reactions)
+		this.printf("  - 回应: %s\n", strings.Join(reactions, ", "))
+	}
+	return this.err
+}
+
+func (this *markdownWriter) Close() error {
+	if this.day == "" {
+		this.printf("没有消息\n")
+	}
+	return this.err
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"go_code/chatroom/common/message"
+	"go_code/chatroom/server/model"
+)
+
+//导入文件中一行最多的字节数, 和服务器的包大小限制差不多
+const maxLineSize = 16 * 1024 * 1024
+
+func runImport(args []string) (err error) {
+	flags := flag.NewFlagSet("import", flag.ExitOnError)
+	address := flags.String("redis", "127.0.0.1:6379", "redis的地址")
+	input := flags.String("i", "", "导入这个 JSON Lines 文件, 为空时从标准输入读取")
+	flags.Parse(args)
+
+	var in io.Reader = os.Stdin
+	if *input != "" {
+		file, err := os.Open(*input)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		in = file
+	}
+
+	initDao(*address)
+	result, err := importMessages(in, os.Stderr)
+	if err != nil {
+		return
+	}
+	fmt.Fprintf(os.Stderr, "导入了%d条消息, 跳过了%d条已经存在的消息, %d条消息id冲突, %d条失败\n",
+		result.added, result.skipped, result.conflicts, result.failed)
+	if result.conflicts > 0 || result.failed > 0 {
+		err = fmt.Errorf("%d条消息id冲突, %d条消息导入失败", result.conflicts, result.failed)
+	}
+	return
+}
+
+//导入的结果
+type importResult struct {
+	added int
+	//同一条消息已经存在
+	skipped int
+	//消息id已经被另一条消息使用, 没有导入
+	conflicts int
+	//格式错误或者redis出错
+	failed int
+}
+
+//逐行导入 JSON Lines, 每一行的错误写到errOut, 读取失败时返回错误
+func importMessages(in io.Reader, errOut io.Writer) (result importResult, err error) {
+	scanner := bufio.NewScanner(in)
+	scanner.Buffer(make([]byte, 64*1024), maxLineSize)
+	for line := 1; scanner.Scan(); line++ {
+		if strings.TrimSpace(scanner.Text()) == "" {
+			continue
+		}
+		var smsMes message.SmsMes
+		err = json.Unmarshal(scanner.Bytes(), &smsMes)
+		if err == nil {
+			var ok bool
+			ok, err = model.MyMessageDao.ImportMessage(&smsMes)
+			if ok {
+				result.added++
+			} else if err == nil {
+				result.skipped++
+			}
+		}
+		//格式错误和冲突的行跳过, 最后返回错误
+		if err == model.ERROR_MES_CONFLICT {
+			fmt.Fprintf(errOut, "第%d行的消息id %d 已经被另一条消息使用\n", line, smsMes.MesId)
+			result.conflicts++
+		} else if err != nil {
+			fmt.Fprintf(errOut, "第%d行导入失败: %v\n", line, err)
+			result.failed++
+		}
+	}
+	err = scanner.Err()
+	return
+}
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/garyburd/redigo/redis"
+	"go_code/chatroom/common/message"
+	"go_code/chatroom/server/model"
+)
+
+//聊天记录的导出和导入工具, 直接读写服务器保存聊天记录的redis
+//导出一个会话在某段时间内的消息, 格式为 JSON Lines, CSV 或者 Markdown
+//导入 JSON Lines 格式的导出文件, 用于在不同的环境之间迁移, 保留mesId和时间
+//已经存在的消息跳过, mesId 在目标环境中已经被另一条消息使用时报告冲突, 不覆盖
+//例子:
+//	go run go_code/chatroom/transcript export -group -from 2024-01-01 -to 2024-01-31 -format md -o lobby.md
+//	go run go_code/chatroom/transcript export -room golang -format csv
+//	go run go_code/chatroom/transcript export -private 100,200 -o 100-200.jsonl
+//	go run go_code/chatroom/transcript import -redis 10.0.0.2:6379 -i 100-200.jsonl
+
+const usage = `用法:
+	transcript export [-redis addr] (-group | -room 房间 | -private 用户id,用户id) [-from 时间] [-to 时间] [-format jsonl|csv|md] [-o 文件]
+	transcript import [-redis addr] [-i 文件]
+`
+
+//支持的时间格式, 使用本地时区
+var timeLayouts = []string{
+	time.RFC3339,
+	"2006-01-02 15:04:05",
+	"2006-01-02 15:04",
+	"2006-01-02",
+}
+
+func main() {
+	if len(os.Args) < 2 {
+		fmt.Print(usage)
+		os.Exit(2)
+	}
+	var err error
+	switch os.Args[1] {
+		case "export":
+			err = runExport(os.Args[2:])
+		case "import":
+			err = runImport(os.Args[2:])
+		default:
+			fmt.Print(usage)
+			os.Exit(2)
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
+
+//连接redis, 初始化用到的Dao
+func initDao(address string) {
+	pool := &redis.Pool{
+		MaxIdle:     2,
+		IdleTimeout: time.Minute,
+		Dial: func() (redis.Conn, error) {
+			return redis.Dial("tcp", address)
+		},
+	}
+	model.MyUserDao = model.NewUserDao(pool)
+	model.MyMessageDao = model.NewMessageDao(pool)
+}
+
+//要导出的会话, 返回会话的key和名字
+//-group, -room, -private 必须且只能指定一个
+func conversation(group bool, room string, private string) (key string, name string, err error) {
+	n := 0
+	for _, set := range []bool{group, room != "", private != ""} {
+		if set {
+			n++
+		}
+	}
+	if n != 1 {
+		err = fmt.Errorf("-group, -room, -private 必须且只能指定一个")
+		return
+	}
+	switch {
+		case group:
+			return model.HistoryKey(&message.SmsMes{}), "大厅", nil
+		case room != "":
+			return model.HistoryKey(&message.SmsMes{RoomId: room}), "房间 " + room, nil
+	}
+	ids := strings.Split(private, ",")
+	if len(ids) != 2 {
+		err = fmt.Errorf("-private 需要两个用户id, 用逗号分隔")
+		return
+	}
+	a, err := strconv.Atoi(strings.TrimSpace(ids[0]))
+	if err != nil {
+		return
+	}
+	b, err := strconv.Atoi(strings.TrimSpace(ids[1]))
+	if err != nil {
+		return
+	}
+	if a == b || a <= 0 || b <= 0 {
+		err = fmt.Errorf("-private 的两个用户id不合法: %s", private)
+		return
+	}
+	smsMes := &message.SmsMes{ToUserId: b}
+	smsMes.UserId = a
+	return model.HistoryKey(smsMes), fmt.Sprintf("用户%d 和 用户%d 的私聊", a, b), nil
+}
+
+//解析时间, 为空时返回零值
+//dateEnd 为true并且只有日期时, 返回第二天的0点, 这样 -to 2024-01-31 包含31号当天
+func parseTime(value string, dateEnd bool) (t time.Time, err error) {
+	if value == "" {
+		return
+	}
+	for _, layout := range timeLayouts {
+		t, err = time.ParseInLocation(layout, value, time.Local)
+		if err != nil {
+			continue
+		}
+		if dateEnd && len(value) == len("2006-01-02") {
+			t = t.AddDate(0, 0, 1)
+		}
+		return
+	}
+	err = fmt.Errorf("时间格式不正确: %s, 例如 2024-01-02 或 2024-01-02 15:04:05", value)
+	return
+}
@@ -0,0 +1,255 @@
+package main
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"go_code/chatroom/common/message"
+	"go_code/chatroom/server/memredis"
+	"go_code/chatroom/server/model"
+)
+
+//用 memredis 初始化用到的Dao, 每个用例一份新的数据
+func setup(t *testing.T) {
+	t.Helper()
+	pool := memredis.NewStore().NewPool()
+	t.Cleanup(func() { pool.Close() })
+	model.MyUserDao = model.NewUserDao(pool)
+	model.MyMessageDao = model.NewMessageDao(pool)
+}
+
+func localTime(t *testing.T, value string) time.Time {
+	t.Helper()
+	tm, err := time.ParseInLocation("2006-01-02 15:04:05", value, time.Local)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return tm
+}
+
+func newMes(mesId int, userId int, sendTime time.Time, content string) *message.SmsMes {
+	smsMes := &message.SmsMes{
+		MesId:    mesId,
+		Content:  content,
+		SendTime: sendTime.Unix(),
+	}
+	smsMes.UserId = userId
+	return smsMes
+}
+
+func importMes(t *testing.T, smsMes *message.SmsMes) {
+	t.Helper()
+	added, err := model.MyMessageDao.ImportMessage(smsMes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !added {
+		t.Fatalf("消息%d 没有导入", smsMes.MesId)
+	}
+}
+
+//只导出指定会话中时间范围内的消息, 三种格式的内容一样
+func TestExport(t *testing.T) {
+	setup(t)
+	secret := newMes(2, 7001, localTime(t, "2024-01-02 10:00:00"), "第二天早上")
+	secret.UserPwd = "secret"
+	for _, smsMes := range []*message.SmsMes{
+		newMes(1, 7001, localTime(t, "2024-01-01 10:00:00"), "第一天"),
+		secret,
+		newMes(3, 7002, localTime(t, "2024-01-02 23:59:59"), "第二天晚上"),
+		newMes(4, 7002, localTime(t, "2024-01-03 00:00:00"), "第三天"),
+	} {
+		importMes(t, smsMes)
+	}
+	room := newMes(5, 7001, localTime(t, "2024-01-02 12:00:00"), "房间里的消息")
+	room.RoomId = "golang"
+	importMes(t, room)
+
+	key, name, err := conversation(true, "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	//-to 只有日期时包含当天
+	from, err := parseTime("2024-01-02", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	to, err := parseTime("2024-01-02", true)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	exportAs := func(format string) string {
+		t.Helper()
+		var out bytes.Buffer
+		count, err := export(&out, &exportOptions{key: key, name: name, from: from, to: to, format: format})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if count != 2 {
+			t.Fatalf("%s 导出了%d 条消息, 期望2条", format, count)
+		}
+		if strings.Contains(out.String(), "secret") {
+			t.Fatalf("%s 导出的内容包含密码", format)
+		}
+		return out.String()
+	}
+
+	var ids []int
+	for _, line := range strings.Split(strings.TrimSpace(exportAs("jsonl")), "\n") {
+		var smsMes message.SmsMes
+		if err := json.Unmarshal([]byte(line), &smsMes); err != nil {
+			t.Fatal(err)
+		}
+		ids = append(ids, smsMes.MesId)
+	}
+	if ids[0] != 2 || ids[1] != 3 {
+		t.Fatalf("jsonl 导出的消息是%v, 期望[2 3]", ids)
+	}
+
+	records, err := csv.NewReader(strings.NewReader(exportAs("csv"))).ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 3 || records[0][0] != "mesId" || records[1][0] != "2" || records[2][0] != "3" {
+		t.Fatalf("csv 导出的内容不对: %v", records)
+	}
+	if records[1][1] != "2024-01-02 10:00:00" || records[2][6] != "第二天晚上" {
+		t.Fatalf("csv 的时间或者内容不对: %v", records)
+	}
+
+	md := exportAs("md")
+	for _, want := range []string{"# 聊天记录: 大厅", "时间范围: 2024-01-02 00:00:00 到 2024-01-03 00:00:00", "## 2024-01-02", "10:00:00: 第二天早上", "23:59:59: 第二天晚上"} {
+		if !strings.Contains(md, want) {
+			t.Fatalf("markdown 中没有%q:\n%s", want, md)
+		}
+	}
+	for _, unwanted := range []string{"第一天", "第三天", "房间里的消息"} {
+		if strings.Contains(md, unwanted) {
+			t.Fatalf("markdown 中不应该有%q:\n%s", unwanted, md)
+		}
+	}
+
+	if _, err := export(&bytes.Buffer{}, &exportOptions{key: key, format: "xml"}); err == nil {
+		t.Fatalf("不支持的格式没有返回错误")
+	}
+}
+
+func jsonLines(t *testing.T, messages ...*message.SmsMes) string {
+	t.Helper()
+	var lines []string
+	for _, smsMes := range messages {
+		data, err := json.Marshal(smsMes)
+		if err != nil {
+			t.Fatal(err)
+		}
+		lines = append(lines, string(data))
+	}
+	return strings.Join(lines, "\n") + "\n"
+}
+
+func importLines(t *testing.T, input string) importResult {
+	t.Helper()
+	var errOut bytes.Buffer
+	result, err := importMessages(strings.NewReader(input), &errOut)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return result
+}
+
+//导入保留mesId和时间, 同一条消息跳过, mesId 被另一条消息占用时报告冲突, 不覆盖
+func TestImport(t *testing.T) {
+	setup(t)
+	sendTime := localTime(t, "2024-01-02 10:00:00")
+	private := newMes(100, 7101, sendTime, "私聊")
+	private.ToUserId = 7102
+	room := newMes(101, 7101, sendTime.Add(time.Second), "房间")
+	room.RoomId = "golang"
+	lobby := newMes(102, 7102, sendTime.Add(2*time.Second), "大厅")
+	input := jsonLines(t, private, room, lobby) + "\n{格式错误\n"
+
+	result := importLines(t, input)
+	if result != (importResult{added: 3, failed: 1}) {
+		t.Fatalf("第一次导入的结果是%+v", result)
+	}
+	for _, want := range []*message.SmsMes{private, room, lobby} {
+		messages, _, err := model.MyMessageDao.ScanHistory(model.HistoryKey(want), 0, 10)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(messages) != 1 || messages[0].MesId != want.MesId || messages[0].SendTime != want.SendTime || messages[0].Content != want.Content {
+			t.Fatalf("导入以后会话中的消息是%+v, 期望%+v", messages, want)
+		}
+	}
+	//之后服务器分配的mesId不会和导入的消息重复, 导入更小的id时seq不会变小
+	importMes(t, newMes(50, 7101, sendTime, "更早的消息"))
+	added := newMes(0, 7101, time.Time{}, "新消息")
+	if err := model.MyMessageDao.AddMessage(added); err != nil {
+		t.Fatal(err)
+	}
+	if added.MesId != 103 {
+		t.Fatalf("导入以后分配的mesId是%d, 期望103", added.MesId)
+	}
+
+	//再导入一次全部跳过, 修改过的同一条消息也跳过
+	edited := *room
+	edited.Content = "修改以后的内容"
+	edited.EditTime = sendTime.Add(time.Minute).Unix()
+	result = importLines(t, input+jsonLines(t, &edited))
+	if result != (importResult{skipped: 4, failed: 1}) {
+		t.Fatalf("重复导入的结果是%+v", result)
+	}
+
+	//另一个环境中的消息用了同样的id
+	conflicts := []*message.SmsMes{
+		newMes(100, 7103, sendTime, "私聊"),
+		newMes(101, 7101, sendTime.Add(time.Hour), "房间"),
+		newMes(102, 7102, sendTime.Add(2*time.Second), "别的内容"),
+		newMes(103, 7101, sendTime, "新消息"),
+	}
+	conflicts[0].ToUserId = 7102
+	conflicts[1].RoomId = "golang"
+	result = importLines(t, jsonLines(t, conflicts...))
+	if result != (importResult{conflicts: 4}) {
+		t.Fatalf("id冲突的导入结果是%+v", result)
+	}
+	for _, want := range []*message.SmsMes{private, room, lobby, added} {
+		smsMes, err := model.MyMessageDao.GetMessageById(want.MesId)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if smsMes.UserId != want.UserId || smsMes.SendTime != want.SendTime || smsMes.Content != want.Content {
+			t.Fatalf("消息%d 被冲突的消息覆盖了: %+v", want.MesId, smsMes)
+		}
+	}
+}
+
+//多个导入同时调整 messages:seq, seq 是其中最大的id
+func TestImportSeq(t *testing.T) {
+	setup(t)
+	done := make(chan error)
+	for i := 1; i <= 20; i++ {
+		go func(mesId int) {
+			_, err := model.MyMessageDao.ImportMessage(newMes(mesId, 7201, time.Now(), strconv.Itoa(mesId)))
+			done <- err
+		}(i * 10)
+	}
+	for i := 0; i < 20; i++ {
+		if err := <-done; err != nil {
+			t.Fatal(err)
+		}
+	}
+	smsMes := newMes(0, 7201, time.Time{}, "新消息")
+	if err := model.MyMessageDao.AddMessage(smsMes); err != nil {
+		t.Fatal(err)
+	}
+	if smsMes.MesId != 201 {
+		t.Fatalf("导入以后分配的mesId是%d, 期望201", smsMes.MesId)
+	}
+}
@@ -0,0 +1,331 @@
+package delayqueue
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strconv"
+	"time"
+
+	"github.com/go-redis/redis"
+)
+
+/*
+  基于redis的延时队列, 设计见 goredis/redisDelayQueue.go
+
+每个topic使用下面几个key, key中的 {topic} 是hash tag, 在redis集群中这些key在同一个slot, lua脚本才能同时操作它们
+  前缀:{topic}:delayed   zset  score 为到期时间(unix毫秒), member 为消息id
+  前缀:{topic}:inflight  zset  已经被取走正在处理的消息, score 为可见性超时的时间
+  前缀:{topic}:payload   hash  消息id -> 消息的内容
+  前缀:{topic}:attempts  hash  消息id -> 已经投递的次数
+  前缀:{topic}:errors    hash  消息id -> 最后一次处理失败的原因
+  前缀:{topic}:dead      zset  死信, score 为进入死信的时间
+
+取消息用一个lua脚本一次取一批, 取到的消息从 delayed 移到 inflight, 多个进程同时取也不会重复
+处理成功后 Ack 删除消息, 失败后 Nack 按退避时间放回 delayed
+worker 崩溃时消息留在 inflight 中, 超过可见性超时以后下一次取消息时重新投递
+投递次数超过 MaxRetries+1 的消息进入死信, 可以用 DeadLetters 查看, 用 Redrive 重新投递
+死信的内容一直留在 payload 中, 设置 DeadLetterTTL 以后 worker 空闲时删除过期的死信, 也可以自己调用 PurgeDeadLetters
+
+到期时间和超时时间用的是客户端的时钟, 多台机器的时钟偏差应该远小于 VisibilityTimeout
+消息至少投递一次, 处理消息的逻辑需要是幂等的
+*/
+
+// Ack 和 Nack 时消息已经不属于这一次投递了, 比如处理时间超过了可见性超时, 消息已经重新投递
+var ErrLeaseLost = errors.New("delayqueue: message lease lost")
+
+// MaxRetries 为0时使用默认值, 不重试要设置成 NoRetries
+const NoRetries = -1
+
+type Options struct {
+	// key的前缀, 默认是 delayqueue
+	Prefix string
+	// 消息被取走以后多久没有 Ack 就重新投递, 默认30秒, 也是 handler 的超时时间
+	VisibilityTimeout time.Duration
+	// 处理失败以后最多重试几次, 0 表示使用默认的3次, 不重试时设置成 NoRetries 或者其它负数
+	MaxRetries int
+	// 第attempts次处理失败以后, 过多久重试, 默认从1秒开始每次翻倍, 最多1分钟
+	Backoff func(attempts int) time.Duration
+	// 死信保留多久, 超过以后 worker 空闲时连同内容一起删除, 默认0表示一直保留
+	DeadLetterTTL time.Duration
+	// worker 一次最多取多少条消息, 默认100
+	BatchSize int
+	// worker 没有取到消息时, 过多久再取, 默认1秒
+	PollInterval time.Duration
+	// 默认是 slog.Default()
+	Logger *slog.Logger
+	// 到期时间和可见性超时用的时钟, 默认是 time.Now, 测试时可以换成假的时钟
+	Now func() time.Time
+}
+
+// 一条消息
+type Message struct {
+	ID      string
+	Topic   string
+	Payload []byte
+	// 第几次投递, 从1开始
+	Attempts int
+	// 最后一次处理失败的原因, 只有死信才有
+	Error string
+	// 进入死信的时间, 只有死信才有
+	DeadAt time.Time
+}
+
+// 各个集合中消息的数量
+type Stats struct {
+	// 还没有被取走的消息, 包括已经到期的
+	Delayed int64
+	// 已经到期等待被取走的消息
+	Ready    int64
+	InFlight int64
+	Dead     int64
+}
+
+type Queue struct {
+	rdb      redis.Cmdable
+	opts     Options
+	handlers []*worker
+}
+
+func defaultBackoff(attempts int) time.Duration {
+	d := time.Second
+	for i := 1; i < attempts && d < time.Minute; i++ {
+		d *= 2
+	}
+	if d > time.Minute {
+		d = time.Minute
+	}
+	return d
+}
+
+// rdb 可以是 *redis.Client, *redis.ClusterClient 等, opts 为nil时全部使用默认值
+func New(rdb redis.Cmdable, opts *Options) *Queue {
+	queue := &Queue{
+		rdb: rdb,
+	}
+	if opts != nil {
+		queue.opts = *opts
+	}
+	if queue.opts.Prefix == "" {
+		queue.opts.Prefix = "delayqueue"
+	}
+	if queue.opts.VisibilityTimeout <= 0 {
+		queue.opts.VisibilityTimeout = 30 * time.Second
+	}
+	if queue.opts.MaxRetries == 0 {
+		queue.opts.MaxRetries = 3
+	} else if queue.opts.MaxRetries < 0 {
+		queue.opts.MaxRetries = 0
+	}
+	if queue.opts.Backoff == nil {
+		queue.opts.Backoff = defaultBackoff
+	}
+	if queue.opts.BatchSize <= 0 {
+		queue.opts.BatchSize = 100
+	}
+	if queue.opts.PollInterval <= 0 {
+		queue.opts.PollInterval = time.Second
+	}
+	if queue.opts.Logger == nil {
+		queue.opts.Logger = slog.Default()
+	}
+	if queue.opts.Now == nil {
+		queue.opts.Now = time.Now
+	}
+	return queue
+}
+
+// 一个topic的所有key
+type topicKeys struct {
+	delayed, inflight, payload, attempts, errors, dead string
+}
+
+func (this *Queue) keys(topic string) topicKeys {
+	prefix := fmt.Sprintf("%s:{%s}:", this.opts.Prefix, topic)
+	return topicKeys{
+		delayed:  prefix + "delayed",
+		inflight: prefix + "inflight",
+		payload:  prefix + "payload",
+		attempts: prefix + "attempts",
+		errors:   prefix + "errors",
+		dead:     prefix + "dead",
+	}
+}
+
+// 一条消息最多投递几次
+func (this *Queue) maxAttempts() int {
+	return this.opts.MaxRetries + 1
+}
+
+func newID() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// 添加一条消息, delay 之后可以被取走, 返回消息的id
+func (this *Queue) Push(ctx context.Context, topic string, payload []byte, delay time.Duration) (id string, err error) {
+	id, err = newID()
+	if err != nil {
+		return
+	}
+	keys := this.keys(topic)
+	at := this.opts.Now().Add(delay).UnixMilli()
+	// 先保存内容再加入 delayed, 在一个事务中执行
+	_, err = this.rdb.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.HSet(ctx, keys.payload, id, payload)
+		pipe.ZAdd(ctx, keys.delayed, &redis.Z{Score: float64(at), Member: id})
+		return nil
+	})
+	if err != nil {
+		return "", err
+	}
+	return
+}
+
+// 取走最多count条已经到期的消息, 按到期时间从早到晚
+// 同时把超过可见性超时的消息放回 delayed, 投递次数用完的放进死信
+// 取走的消息需要在 VisibilityTimeout 之内 Ack 或者 Nack, 否则会重新投递
+func (this *Queue) Claim(ctx context.Context, topic string, count int) (msgs []*Message, err error) {
+	keys := this.keys(topic)
+	now := this.opts.Now()
+	res, err := claimScript.Run(ctx, this.rdb,
+		[]string{keys.delayed, keys.inflight, keys.payload, keys.attempts, keys.errors, keys.dead},
+		now.UnixMilli(), count, now.Add(this.opts.VisibilityTimeout).UnixMilli(), this.maxAttempts()).Slice()
+	if err != nil {
+		return
+	}
+	// 返回 id1, payload1, attempts1, id2, ...
+	for i := 0; i+2 < len(res); i += 3 {
+		id, _ := res[i].(string)
+		payload, _ := res[i+1].(string)
+		attempts, _ := res[i+2].(int64)
+		msgs = append(msgs, &Message{
+			ID:       id,
+			Topic:    topic,
+			Payload:  []byte(payload),
+			Attempts: int(attempts),
+		})
+	}
+	return
+}
+
+// 处理成功, 删除这条消息
+func (this *Queue) Ack(ctx context.Context, msg *Message) error {
+	keys := this.keys(msg.Topic)
+	n, err := ackScript.Run(ctx, this.rdb,
+		[]string{keys.inflight, keys.payload, keys.attempts, keys.errors},
+		msg.ID, msg.Attempts).Int()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return ErrLeaseLost
+	}
+	return nil
+}
+
+// 处理失败, retryDelay 之后重新投递, 投递次数用完时放进死信, dead 为true
+func (this *Queue) Nack(ctx context.Context, msg *Message, retryDelay time.Duration, reason string) (dead bool, err error) {
+	keys := this.keys(msg.Topic)
+	now := this.opts.Now()
+	n, err := nackScript.Run(ctx, this.rdb,
+		[]string{keys.delayed, keys.inflight, keys.attempts, keys.errors, keys.dead},
+		msg.ID, msg.Attempts, now.Add(retryDelay).UnixMilli(), now.UnixMilli(), this.maxAttempts(), reason).Int()
+	if err != nil {
+		return
+	}
+	if n == 0 {
+		return false, ErrLeaseLost
+	}
+	return n == 2, nil
+}
+
+func (this *Queue) Stats(ctx context.Context, topic string) (stats Stats, err error) {
+	keys := this.keys(topic)
+	var delayed, ready, inflight, dead *redis.IntCmd
+	_, err = this.rdb.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		delayed = pipe.ZCard(ctx, keys.delayed)
+		ready = pipe.ZCount(ctx, keys.delayed, "-inf", strconv.FormatInt(this.opts.Now().UnixMilli(), 10))
+		inflight = pipe.ZCard(ctx, keys.inflight)
+		dead = pipe.ZCard(ctx, keys.dead)
+		return nil
+	})
+	if err != nil {
+		return
+	}
+	return Stats{
+		Delayed:  delayed.Val(),
+		Ready:    ready.Val(),
+		InFlight: inflight.Val(),
+		Dead:     dead.Val(),
+	}, nil
+}
+
+// 查看最早进入死信的count条消息
+func (this *Queue) DeadLetters(ctx context.Context, topic string, count int) (msgs []*Message, err error) {
+	keys := this.keys(topic)
+	zs, err := this.rdb.ZRangeWithScores(ctx, keys.dead, 0, int64(count)-1).Result()
+	if err != nil || len(zs) == 0 {
+		return
+	}
+	ids := make([]string, len(zs))
+	for i, z := range zs {
+		ids[i], _ = z.Member.(string)
+	}
+	var payloads, attempts, reasons *redis.SliceCmd
+	_, err = this.rdb.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		payloads = pipe.HMGet(ctx, keys.payload, ids...)
+		attempts = pipe.HMGet(ctx, keys.attempts, ids...)
+		reasons = pipe.HMGet(ctx, keys.errors, ids...)
+		return nil
+	})
+	if err != nil {
+		return
+	}
+	for i, id := range ids {
+		msg := &Message{
+			ID:     id,
+			Topic:  topic,
+			DeadAt: time.UnixMilli(int64(zs[i].Score)),
+		}
+		if s, ok := payloads.Val()[i].(string); ok {
+			msg.Payload = []byte(s)
+		}
+		if s, ok := attempts.Val()[i].(string); ok {
+			msg.Attempts, _ = strconv.Atoi(s)
+		}
+		msg.Error, _ = reasons.Val()[i].(string)
+		msgs = append(msgs, msg)
+	}
+	return
+}
+
+// 删除 before 之前进入死信的消息和它们的内容, 返回删除的条数
+func (this *Queue) PurgeDeadLetters(ctx context.Context, topic string, before time.Time) (n int, err error) {
+	keys := this.keys(topic)
+	for {
+		// 一次最多删除 BatchSize 条, 死信很多时不会长时间阻塞redis
+		deleted, err := purgeScript.Run(ctx, this.rdb,
+			[]string{keys.dead, keys.payload, keys.attempts, keys.errors},
+			before.UnixMilli(), this.opts.BatchSize).Int()
+		n += deleted
+		if err != nil || deleted < this.opts.BatchSize {
+			return n, err
+		}
+	}
+}
+
+// 把一条死信重新放回队列, 马上投递, 投递次数从0开始计算, 不是死信时ok为false
+func (this *Queue) Redrive(ctx context.Context, topic string, id string) (ok bool, err error) {
+	keys := this.keys(topic)
+	n, err := redriveScript.Run(ctx, this.rdb,
+		[]string{keys.dead, keys.delayed, keys.attempts, keys.errors},
+		id, this.opts.Now().UnixMilli()).Int()
+	return n == 1, err
+}
@@ -0,0 +1,596 @@
+package delayqueue
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/go-redis/redis"
+)
+
+// 测试用的时钟, 只有调用 advance 才会前进
+type fakeClock struct {
+	lock   sync.Mutex
+	now    time.Time
+	server *miniredis.Miniredis
+}
+
+func (this *fakeClock) Now() time.Time {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	return this.now
+}
+
+// 时钟和 miniredis 一起前进, 到期时间和可见性超时用时钟, redis中key的过期时间用 FastForward
+func (this *fakeClock) advance(d time.Duration) {
+	this.lock.Lock()
+	this.now = this.now.Add(d)
+	this.lock.Unlock()
+	this.server.FastForward(d)
+}
+
+// 启动 miniredis, 返回连接它的客户端和从整毫秒开始的时钟
+func setup(t *testing.T) (rdb *redis.Client, clock *fakeClock) {
+	t.Helper()
+	server := miniredis.RunT(t)
+	rdb = redis.NewClient(&redis.Options{Addr: server.Addr()})
+	t.Cleanup(func() { rdb.Close() })
+	clock = &fakeClock{
+		now:    time.UnixMilli(time.Now().UnixMilli()),
+		server: server,
+	}
+	return
+}
+
+// 不输出测试中故意制造的失败
+var discardLogger = slog.New(slog.NewTextHandler(io.Discard, nil))
+
+// 在后台运行queue, 返回的stop停止并等待Run返回
+func runQueue(t *testing.T, queue *Queue) (stop func()) {
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		queue.Run(ctx)
+	}()
+	var once sync.Once
+	stop = func() {
+		once.Do(func() {
+			cancel()
+			<-done
+		})
+	}
+	t.Cleanup(stop)
+	return
+}
+
+// 在timeout之内等到cond为true
+func waitFor(t *testing.T, what string, timeout time.Duration, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(timeout)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("等待%s超时(%v)", what, timeout)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func expectStats(t *testing.T, what string, queue *Queue, topic string, want Stats) {
+	t.Helper()
+	got, err := queue.Stats(context.Background(), topic)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != want {
+		t.Fatalf("%s: stats=%+v, 期望%+v", what, got, want)
+	}
+}
+
+func claim(t *testing.T, queue *Queue, topic string, count int) []*Message {
+	t.Helper()
+	msgs, err := queue.Claim(context.Background(), topic, count)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return msgs
+}
+
+func payloads(msgs []*Message) string {
+	var list []string
+	for _, msg := range msgs {
+		list = append(list, string(msg.Payload))
+	}
+	return strings.Join(list, ",")
+}
+
+// 统计客户端发出的命令数
+type countHook struct {
+	count int32
+}
+
+func (this *countHook) BeforeProcess(ctx context.Context, cmd redis.Cmder) (context.Context, error) {
+	atomic.AddInt32(&this.count, 1)
+	return ctx, nil
+}
+
+func (this *countHook) AfterProcess(ctx context.Context, cmd redis.Cmder) error {
+	return nil
+}
+
+func (this *countHook) BeforeProcessPipeline(ctx context.Context, cmds []redis.Cmder) (context.Context, error) {
+	atomic.AddInt32(&this.count, int32(len(cmds)))
+	return ctx, nil
+}
+
+func (this *countHook) AfterProcessPipeline(ctx context.Context, cmds []redis.Cmder) error {
+	return nil
+}
+
+// 消息按到期时间的顺序取出, 不会提前取出, Ack 以后删除
+func TestClaimOrder(t *testing.T) {
+	rdb, clock := setup(t)
+	ctx := context.Background()
+	queue := New(rdb, &Options{Now: clock.Now})
+	for _, item := range []struct {
+		payload string
+		delay   time.Duration
+	}{{"c", 400 * time.Millisecond}, {"a", 0}, {"b", 200 * time.Millisecond}, {"later", time.Hour}} {
+		if _, err := queue.Push(ctx, "order", []byte(item.payload), item.delay); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var claimed []*Message
+	for _, want := range []string{"a", "b", "c", ""} {
+		msgs := claim(t, queue, "order", 10)
+		if got := payloads(msgs); got != want {
+			t.Fatalf("时钟在%v 时取到了[%s], 期望[%s]", clock.Now(), got, want)
+		}
+		claimed = append(claimed, msgs...)
+		clock.advance(200 * time.Millisecond)
+	}
+	expectStats(t, "取走3条以后", queue, "order", Stats{Delayed: 1, InFlight: 3})
+	for _, msg := range claimed {
+		if msg.Attempts != 1 {
+			t.Fatalf("第一次取到的消息 Attempts=%d", msg.Attempts)
+		}
+		if err := queue.Ack(ctx, msg); err != nil {
+			t.Fatal(err)
+		}
+	}
+	expectStats(t, "Ack 以后", queue, "order", Stats{Delayed: 1})
+	if err := queue.Ack(ctx, claimed[0]); err != ErrLeaseLost {
+		t.Fatalf("重复 Ack 返回%v, 期望ErrLeaseLost", err)
+	}
+}
+
+// 一次lua脚本取一批消息, 按到期时间排序
+func TestClaimBatch(t *testing.T) {
+	rdb, clock := setup(t)
+	ctx := context.Background()
+	queue := New(rdb, &Options{Now: clock.Now})
+	for i := 0; i < 250; i++ {
+		// 到期时间各不相同, 取出的顺序是确定的
+		delay := time.Duration(i-1000) * time.Millisecond
+		if _, err := queue.Push(ctx, "batch", []byte(fmt.Sprint(i)), delay); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// 第一次运行脚本时 evalsha 失败后再 eval
+	counter := &countHook{}
+	rdb.AddHook(counter)
+	msgs := claim(t, queue, "batch", 100)
+	if n := atomic.LoadInt32(&counter.count); n > 2 {
+		t.Fatalf("取一批消息用了%d 条命令", n)
+	}
+	if len(msgs) != 100 || string(msgs[0].Payload) != "0" || string(msgs[99].Payload) != "99" {
+		t.Fatalf("取到了%d 条消息, 应该是按顺序的100条", len(msgs))
+	}
+	expectStats(t, "取走一批以后", queue, "batch", Stats{Delayed: 150, Ready: 150, InFlight: 100})
+}
+
+// 两个进程同时处理一个topic, 每条消息只处理一次, 同时处理的消息不超过并发数
+func TestConcurrentWorkers(t *testing.T) {
+	const count = 300
+	const concurrency = 4
+	rdb, _ := setup(t)
+	ctx := context.Background()
+
+	var lock sync.Mutex
+	handled := make(map[string]int)
+	var total int32
+	var queues []*Queue
+	var maxRunning []*int32
+	for i := 0; i < 2; i++ {
+		// 每个进程用自己的连接
+		rdb := redis.NewClient(&redis.Options{Addr: rdb.Options().Addr})
+		defer rdb.Close()
+		queue := New(rdb, &Options{BatchSize: 8, PollInterval: 10 * time.Millisecond, Logger: discardLogger})
+		var running, max int32
+		queue.Handle("concurrency", concurrency, func(ctx context.Context, msg *Message) error {
+			n := atomic.AddInt32(&running, 1)
+			defer atomic.AddInt32(&running, -1)
+			for {
+				old := atomic.LoadInt32(&max)
+				if n <= old || atomic.CompareAndSwapInt32(&max, old, n) {
+					break
+				}
+			}
+			time.Sleep(time.Millisecond)
+			lock.Lock()
+			handled[string(msg.Payload)]++
+			lock.Unlock()
+			atomic.AddInt32(&total, 1)
+			return nil
+		})
+		queues = append(queues, queue)
+		maxRunning = append(maxRunning, &max)
+	}
+	for i := 0; i < count; i++ {
+		if _, err := queues[0].Push(ctx, "concurrency", []byte(fmt.Sprint(i)), 0); err != nil {
+			t.Fatal(err)
+		}
+	}
+	var stops []func()
+	for _, queue := range queues {
+		stops = append(stops, runQueue(t, queue))
+	}
+	waitFor(t, "所有消息处理完", 5*time.Second, func() bool {
+		return atomic.LoadInt32(&total) >= count
+	})
+	for _, stop := range stops {
+		stop()
+	}
+
+	if len(handled) != count {
+		t.Fatalf("处理了%d 条不同的消息, 应该是%d 条", len(handled), count)
+	}
+	for payload, n := range handled {
+		if n != 1 {
+			t.Fatalf("消息%s 被处理了%d 次", payload, n)
+		}
+	}
+	for i, max := range maxRunning {
+		if *max > concurrency {
+			t.Fatalf("进程%d 同时处理了%d 条消息, 并发数是%d", i, *max, concurrency)
+		}
+	}
+	expectStats(t, "处理完以后", queues[0], "concurrency", Stats{})
+}
+
+// worker 取走消息以后崩溃, 超过可见性超时以后重新投递, 崩溃的 worker 不能再 Ack
+// 投递次数用完以后进入死信
+func TestVisibilityTimeout(t *testing.T) {
+	rdb, clock := setup(t)
+	ctx := context.Background()
+	const timeout = 30 * time.Second
+	queue := New(rdb, &Options{VisibilityTimeout: timeout, MaxRetries: 1, Now: clock.Now})
+	if _, err := queue.Push(ctx, "crash", []byte("job"), 0); err != nil {
+		t.Fatal(err)
+	}
+
+	crashed := claim(t, queue, "crash", 10)
+	if len(crashed) != 1 || crashed[0].Attempts != 1 {
+		t.Fatalf("第一次取到的消息不对: %v", crashed)
+	}
+	clock.advance(timeout - time.Millisecond)
+	if msgs := claim(t, queue, "crash", 10); len(msgs) != 0 {
+		t.Fatalf("可见性超时之前又取到了%d 条消息", len(msgs))
+	}
+	clock.advance(time.Millisecond)
+	msgs := claim(t, queue, "crash", 10)
+	if len(msgs) != 1 || msgs[0].ID != crashed[0].ID || msgs[0].Attempts != 2 {
+		t.Fatalf("超时以后没有重新投递: %v", msgs)
+	}
+	if err := queue.Ack(ctx, crashed[0]); err != ErrLeaseLost {
+		t.Fatalf("崩溃的worker Ack 返回%v, 期望ErrLeaseLost", err)
+	}
+	if _, err := queue.Nack(ctx, crashed[0], 0, "late"); err != ErrLeaseLost {
+		t.Fatalf("崩溃的worker Nack 返回%v, 期望ErrLeaseLost", err)
+	}
+
+	// 第二次也崩溃, 投递次数用完进入死信
+	clock.advance(timeout)
+	if msgs := claim(t, queue, "crash", 10); len(msgs) != 0 {
+		t.Fatalf("投递次数用完以后又取到了%d 条消息", len(msgs))
+	}
+	dead, err := queue.DeadLetters(ctx, "crash", 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(dead) != 1 || dead[0].Error != "visibility timeout" || dead[0].Attempts != 2 || !dead[0].DeadAt.Equal(clock.Now()) {
+		t.Fatalf("死信不对: %+v", dead)
+	}
+	expectStats(t, "进入死信以后", queue, "crash", Stats{Dead: 1})
+}
+
+// 失败和panic按退避时间重试, 重试次数用完进入死信, 死信可以重新投递
+func TestRetryAndDeadLetters(t *testing.T) {
+	rdb, clock := setup(t)
+	ctx := context.Background()
+	const backoff = 10 * time.Second
+	queue := New(rdb, &Options{
+		MaxRetries:   2,
+		Backoff:      func(attempts int) time.Duration { return backoff },
+		PollInterval: 5 * time.Millisecond,
+		Logger:       discardLogger,
+		Now:          clock.Now,
+	})
+
+	var fixed int32
+	var lock sync.Mutex
+	attempts := make(map[string][]int)
+	done := make(map[string]bool)
+	total := func() (n int) {
+		lock.Lock()
+		defer lock.Unlock()
+		for _, list := range attempts {
+			n += len(list)
+		}
+		return
+	}
+	queue.Handle("retry", 2, func(ctx context.Context, msg *Message) error {
+		payload := string(msg.Payload)
+		lock.Lock()
+		attempts[payload] = append(attempts[payload], msg.Attempts)
+		lock.Unlock()
+		switch {
+		case payload == "panic":
+			panic("boom")
+		case payload == "bad" && atomic.LoadInt32(&fixed) == 0:
+			return errors.New("bad payload")
+		case payload == "flaky" && msg.Attempts < 2:
+			return errors.New("try again")
+		}
+		lock.Lock()
+		done[payload] = true
+		lock.Unlock()
+		return nil
+	})
+	for _, payload := range []string{"bad", "panic", "flaky"} {
+		if _, err := queue.Push(ctx, "retry", []byte(payload), 0); err != nil {
+			t.Fatal(err)
+		}
+	}
+	runQueue(t, queue)
+
+	// 每次失败以后要等时钟走过退避时间才重试
+	for i, round := range []struct {
+		total int
+		stats Stats
+	}{
+		{3, Stats{Delayed: 3}},
+		{6, Stats{Delayed: 2}},
+		{8, Stats{Dead: 2}},
+	} {
+		if i > 0 {
+			clock.advance(backoff)
+		}
+		waitFor(t, fmt.Sprintf("第%d 次投递", i+1), time.Second, func() bool {
+			stats, _ := queue.Stats(ctx, "retry")
+			return total() == round.total && stats == round.stats
+		})
+	}
+
+	lock.Lock()
+	summary := fmt.Sprint(attempts["bad"], attempts["panic"], attempts["flaky"], done["flaky"])
+	lock.Unlock()
+	if summary != "[1 2 3] [1 2 3] [1 2] true" {
+		t.Fatalf("投递的次数不对: %s", summary)
+	}
+	dead, err := queue.DeadLetters(ctx, "retry", 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(dead) != 2 {
+		t.Fatalf("有%d 条死信, 应该是2条", len(dead))
+	}
+	var badId string
+	for _, msg := range dead {
+		switch string(msg.Payload) {
+		case "bad":
+			badId = msg.ID
+			if msg.Error != "bad payload" {
+				t.Fatalf("死信的错误是%q", msg.Error)
+			}
+		case "panic":
+			if !strings.Contains(msg.Error, "boom") {
+				t.Fatalf("panic的死信的错误是%q", msg.Error)
+			}
+		}
+		if msg.Attempts != 3 || !msg.DeadAt.Equal(clock.Now()) {
+			t.Fatalf("死信的内容不对: %+v", msg)
+		}
+	}
+
+	// 重新投递的死信马上处理, 投递次数从1开始
+	atomic.StoreInt32(&fixed, 1)
+	ok, err := queue.Redrive(ctx, "retry", badId)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !ok {
+		t.Fatalf("重新投递死信%s 失败", badId)
+	}
+	if ok, _ = queue.Redrive(ctx, "retry", badId); ok {
+		t.Fatalf("不是死信的消息也能重新投递")
+	}
+	waitFor(t, "重新投递的死信处理完", time.Second, func() bool {
+		lock.Lock()
+		defer lock.Unlock()
+		return done["bad"]
+	})
+	lock.Lock()
+	redriven := attempts["bad"][len(attempts["bad"])-1]
+	lock.Unlock()
+	if redriven != 1 {
+		t.Fatalf("重新投递的死信 Attempts=%d, 期望1", redriven)
+	}
+	waitFor(t, "重新投递的死信 Ack", time.Second, func() bool {
+		stats, _ := queue.Stats(ctx, "retry")
+		return stats == Stats{Dead: 1}
+	})
+}
+
+// handler 超过可见性超时以后ctx被取消, 下一次投递处理成功
+func TestHandlerTimeout(t *testing.T) {
+	rdb, clock := setup(t)
+	ctx := context.Background()
+	queue := New(rdb, &Options{
+		VisibilityTimeout: 50 * time.Millisecond,
+		Backoff:           func(attempts int) time.Duration { return 0 },
+		PollInterval:      5 * time.Millisecond,
+		Logger:            discardLogger,
+		Now:               clock.Now,
+	})
+	var lock sync.Mutex
+	var handled []int
+	queue.Handle("slow", 1, func(ctx context.Context, msg *Message) error {
+		lock.Lock()
+		handled = append(handled, msg.Attempts)
+		lock.Unlock()
+		if msg.Attempts == 1 {
+			<-ctx.Done()
+			return ctx.Err()
+		}
+		return nil
+	})
+	if _, err := queue.Push(ctx, "slow", []byte("slow"), 0); err != nil {
+		t.Fatal(err)
+	}
+	runQueue(t, queue)
+	waitFor(t, "超时的消息重新处理", time.Second, func() bool {
+		stats, _ := queue.Stats(ctx, "slow")
+		lock.Lock()
+		defer lock.Unlock()
+		return len(handled) == 2 && stats == Stats{}
+	})
+	if fmt.Sprint(handled) != "[1 2]" {
+		t.Fatalf("投递的次数不对: %v", handled)
+	}
+}
+
+// MaxRetries 为0时使用默认的3次, NoRetries 第一次失败就进入死信
+func TestMaxRetries(t *testing.T) {
+	rdb, clock := setup(t)
+	ctx := context.Background()
+	for _, tt := range []struct {
+		maxRetries int
+		attempts   int
+	}{
+		{0, 4},
+		{NoRetries, 1},
+		{2, 3},
+	} {
+		topic := fmt.Sprint("retries", tt.maxRetries)
+		queue := New(rdb, &Options{MaxRetries: tt.maxRetries, Now: clock.Now})
+		if _, err := queue.Push(ctx, topic, []byte("job"), 0); err != nil {
+			t.Fatal(err)
+		}
+		for attempts := 1; ; attempts++ {
+			msgs := claim(t, queue, topic, 10)
+			if len(msgs) != 1 {
+				t.Fatalf("MaxRetries=%d 第%d 次投递取到了%d 条消息", tt.maxRetries, attempts, len(msgs))
+			}
+			dead, err := queue.Nack(ctx, msgs[0], 0, "failed")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if dead {
+				if attempts != tt.attempts {
+					t.Fatalf("MaxRetries=%d 投递了%d 次进入死信, 期望%d 次", tt.maxRetries, attempts, tt.attempts)
+				}
+				break
+			}
+		}
+	}
+}
+
+// 过期的死信连同内容一起删除, 没有过期的保留
+func TestPurgeDeadLetters(t *testing.T) {
+	rdb, clock := setup(t)
+	ctx := context.Background()
+	queue := New(rdb, &Options{MaxRetries: NoRetries, BatchSize: 2, Now: clock.Now})
+	kill := func(payload string) {
+		t.Helper()
+		if _, err := queue.Push(ctx, "purge", []byte(payload), 0); err != nil {
+			t.Fatal(err)
+		}
+		msgs := claim(t, queue, "purge", 1)
+		if dead, err := queue.Nack(ctx, msgs[0], 0, "failed"); err != nil || !dead {
+			t.Fatalf("Nack 返回%v %v, 期望进入死信", dead, err)
+		}
+	}
+	for _, payload := range []string{"old1", "old2", "old3"} {
+		kill(payload)
+	}
+	before := clock.Now()
+	clock.advance(time.Hour)
+	kill("new")
+
+	// 一次脚本最多删除 BatchSize 条, 3条要分两次
+	n, err := queue.PurgeDeadLetters(ctx, "purge", before)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 3 {
+		t.Fatalf("删除了%d 条死信, 期望3条", n)
+	}
+	dead, err := queue.DeadLetters(ctx, "purge", 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if payloads(dead) != "new" {
+		t.Fatalf("剩下的死信是[%s], 期望[new]", payloads(dead))
+	}
+	keys := queue.keys("purge")
+	for _, key := range []string{keys.payload, keys.attempts, keys.errors} {
+		if n, _ := rdb.HLen(ctx, key).Result(); n != 1 {
+			t.Fatalf("%s 中还有%d 条, 只应该剩下没有过期的死信", key, n)
+		}
+	}
+}
+
+// 设置了 DeadLetterTTL 时 worker 空闲时删除过期的死信
+func TestDeadLetterTTL(t *testing.T) {
+	rdb, clock := setup(t)
+	ctx := context.Background()
+	queue := New(rdb, &Options{
+		MaxRetries:    NoRetries,
+		DeadLetterTTL: time.Hour,
+		PollInterval:  5 * time.Millisecond,
+		Logger:        discardLogger,
+		Now:           clock.Now,
+	})
+	queue.Handle("ttl", 1, func(ctx context.Context, msg *Message) error {
+		return errors.New("failed")
+	})
+	if _, err := queue.Push(ctx, "ttl", []byte("job"), 0); err != nil {
+		t.Fatal(err)
+	}
+	runQueue(t, queue)
+	waitFor(t, "消息进入死信", time.Second, func() bool {
+		stats, _ := queue.Stats(ctx, "ttl")
+		return stats == Stats{Dead: 1}
+	})
+	// 没有过期时保留
+	time.Sleep(20 * time.Millisecond)
+	expectStats(t, "死信没有过期", queue, "ttl", Stats{Dead: 1})
+
+	clock.advance(time.Hour)
+	waitFor(t, "过期的死信被删除", time.Second, func() bool {
+		stats, _ := queue.Stats(ctx, "ttl")
+		n, _ := rdb.HLen(ctx, queue.keys("ttl").payload).Result()
+		return stats == Stats{} && n == 0
+	})
+}
@@ -0,0 +1,91 @@
+package delayqueue
+
+import "github.com/go-redis/redis"
+
+// 取消息, 先处理超时的 inflight, 再从 delayed 中取到期的消息
+// KEYS: delayed inflight payload attempts errors dead
+// ARGV: 现在的时间, 最多取几条, 可见性超时的时间, 最多投递几次
+var claimScript = redis.NewScript(`
+local now = tonumber(ARGV[1])
+local maxAttempts = tonumber(ARGV[4])
+local expired = redis.call('ZRANGEBYSCORE', KEYS[2], '-inf', now, 'LIMIT', 0, ARGV[2])
+for _, id in ipairs(expired) do
+	redis.call('ZREM', KEYS[2], id)
+	local attempts = tonumber(redis.call('HGET', KEYS[4], id) or '0')
+	if attempts >= maxAttempts then
+		redis.call('HSET', KEYS[5], id, 'visibility timeout')
+		redis.call('ZADD', KEYS[6], now, id)
+	else
+		redis.call('ZADD', KEYS[1], now, id)
+	end
+end
+local res = {}
+local ids = redis.call('ZRANGEBYSCORE', KEYS[1], '-inf', now, 'LIMIT', 0, ARGV[2])
+for _, id in ipairs(ids) do
+	redis.call('ZREM', KEYS[1], id)
+	local payload = redis.call('HGET', KEYS[3], id)
+	if payload then
+		local attempts = redis.call('HINCRBY', KEYS[4], id, 1)
+		redis.call('ZADD', KEYS[2], ARGV[3], id)
+		table.insert(res, id)
+		table.insert(res, payload)
+		table.insert(res, attempts)
+	end
+end
+return res
+`)
+
+// 投递次数就是这一次投递的租约, 和 attempts 中的不一致说明消息已经重新投递了
+// KEYS: inflight payload attempts errors
+// ARGV: id 投递次数
+var ackScript = redis.NewScript(`
+if redis.call('HGET', KEYS[3], ARGV[1]) ~= ARGV[2] or redis.call('ZREM', KEYS[1], ARGV[1]) == 0 then
+	return 0
+end
+redis.call('HDEL', KEYS[2], ARGV[1])
+redis.call('HDEL', KEYS[3], ARGV[1])
+redis.call('HDEL', KEYS[4], ARGV[1])
+return 1
+`)
+
+// 返回0 租约已经失效, 1 放回 delayed, 2 放进死信
+// KEYS: delayed inflight attempts errors dead
+// ARGV: id 投递次数 重试的时间 现在的时间 最多投递几次 失败的原因
+var nackScript = redis.NewScript(`
+if redis.call('HGET', KEYS[3], ARGV[1]) ~= ARGV[2] or redis.call('ZREM', KEYS[2], ARGV[1]) == 0 then
+	return 0
+end
+redis.call('HSET', KEYS[4], ARGV[1], ARGV[6])
+if tonumber(ARGV[2]) >= tonumber(ARGV[5]) then
+	redis.call('ZADD', KEYS[5], ARGV[4], ARGV[1])
+	return 2
+end
+redis.call('ZADD', KEYS[1], ARGV[3], ARGV[1])
+return 1
+`)
+
+// KEYS: dead delayed attempts errors
+// ARGV: id 现在的时间
+var redriveScript = redis.NewScript(`
+if redis.call('ZREM', KEYS[1], ARGV[1]) == 0 then
+	return 0
+end
+redis.call('HDEL', KEYS[3], ARGV[1])
+redis.call('HDEL', KEYS[4], ARGV[1])
+redis.call('ZADD', KEYS[2], ARGV[2], ARGV[1])
+return 1
+`)
+
+// 返回删除的条数
+// KEYS: dead payload attempts errors
+// ARGV: 删除这个时间之前的死信 最多删除几条
+var purgeScript = redis.NewScript(`
+local ids = redis.call('ZRANGEBYSCORE', KEYS[1], '-inf', ARGV[1], 'LIMIT', 0, ARGV[2])
+for _, id in ipairs(ids) do
+	redis.call('ZREM', KEYS[1], id)
+	redis.call('HDEL', KEYS[2], id)
+	redis.call('HDEL', KEYS[3], id)
+	redis.call('HDEL', KEYS[4], id)
+end
+return #ids
+`)
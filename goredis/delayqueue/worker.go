@@ -0,0 +1,157 @@
+package delayqueue
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// 处理一条消息, 返回nil表示成功, 返回错误或者panic都会按 Backoff 重试
+// ctx 在可见性超时的时候取消, 超时以后消息会重新投递给别的 worker
+type Handler func(ctx context.Context, msg *Message) error
+
+// 一个topic的 worker
+type worker struct {
+	queue       *Queue
+	topic       string
+	handler     Handler
+	concurrency int
+}
+
+// 注册topic的处理函数, 最多同时处理concurrency条消息, 需要在 Run 之前调用
+func (this *Queue) Handle(topic string, concurrency int, handler Handler) {
+	if concurrency <= 0 {
+		concurrency = 1
+	}
+	this.handlers = append(this.handlers, &worker{
+		queue:       this,
+		topic:       topic,
+		handler:     handler,
+		concurrency: concurrency,
+	})
+}
+
+// 开始处理注册过的topic, 直到ctx取消
+// ctx 取消以后不再取新的消息, 等已经取到的消息处理完再返回
+func (this *Queue) Run(ctx context.Context) error {
+	if len(this.handlers) == 0 {
+		return errors.New("delayqueue: no handler registered")
+	}
+	var wg sync.WaitGroup
+	for _, w := range this.handlers {
+		wg.Add(1)
+		go func(w *worker) {
+			defer wg.Done()
+			w.run(ctx)
+		}(w)
+	}
+	wg.Wait()
+	return nil
+}
+
+// 有空闲的并发时才取消息, 一次取的条数不超过空闲的并发数和 BatchSize
+// 取满了马上继续取, 没取满说明暂时没有到期的消息, 等 PollInterval 再取
+func (this *worker) run(ctx context.Context) {
+	opts := &this.queue.opts
+	slots := make(chan struct{}, this.concurrency)
+	var wg sync.WaitGroup
+	defer wg.Wait()
+	for {
+		select {
+		case slots <- struct{}{}:
+		case <-ctx.Done():
+			return
+		}
+		n := 1
+	fill:
+		for n < opts.BatchSize {
+			select {
+			case slots <- struct{}{}:
+				n++
+			default:
+				break fill
+			}
+		}
+
+		msgs, err := this.queue.Claim(ctx, this.topic, n)
+		if err != nil && ctx.Err() == nil {
+			opts.Logger.Error("delayqueue: claim failed", "topic", this.topic, "err", err)
+		}
+		for i := len(msgs); i < n; i++ {
+			<-slots
+		}
+		for _, msg := range msgs {
+			wg.Add(1)
+			go func(msg *Message) {
+				defer func() {
+					<-slots
+					wg.Done()
+				}()
+				this.process(msg)
+			}(msg)
+		}
+		if err == nil && len(msgs) == n {
+			continue
+		}
+		this.purge(ctx)
+		timer := time.NewTimer(opts.PollInterval)
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		}
+	}
+}
+
+// 设置了 DeadLetterTTL 时删除过期的死信, 在没有到期的消息时调用
+func (this *worker) purge(ctx context.Context) {
+	opts := &this.queue.opts
+	if opts.DeadLetterTTL <= 0 {
+		return
+	}
+	n, err := this.queue.PurgeDeadLetters(ctx, this.topic, opts.Now().Add(-opts.DeadLetterTTL))
+	if err != nil && ctx.Err() == nil {
+		opts.Logger.Error("delayqueue: purge dead letters failed", "topic", this.topic, "err", err)
+	} else if n > 0 {
+		opts.Logger.Info("delayqueue: purged dead letters", "topic", this.topic, "count", n)
+	}
+}
+
+// 处理一条消息, 根据结果 Ack 或者 Nack
+// Run 的ctx取消时正在处理的消息不会被打断, 所以这里不用它
+func (this *worker) process(msg *Message) {
+	opts := &this.queue.opts
+	ctx, cancel := context.WithTimeout(context.Background(), opts.VisibilityTimeout)
+	err := this.call(ctx, msg)
+	cancel()
+
+	log := opts.Logger.With("topic", msg.Topic, "id", msg.ID, "attempts", msg.Attempts)
+	if err == nil {
+		err = this.queue.Ack(context.Background(), msg)
+		if err != nil {
+			log.Warn("delayqueue: ack failed", "err", err)
+		}
+		return
+	}
+	dead, nackErr := this.queue.Nack(context.Background(), msg, opts.Backoff(msg.Attempts), err.Error())
+	if nackErr != nil {
+		log.Warn("delayqueue: nack failed", "err", nackErr, "handlerErr", err)
+	} else if dead {
+		log.Error("delayqueue: message moved to dead letters", "err", err)
+	} else {
+		log.Info("delayqueue: message will be retried", "err", err)
+	}
+}
+
+// handler panic 时当作处理失败
+func (this *worker) call(ctx context.Context, msg *Message) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("panic: %v", r)
+		}
+	}()
+	return this.handler(ctx, msg)
+}
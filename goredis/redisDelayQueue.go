@@ -3,6 +3,8 @@ package main
 /*
   redis延时队列
 
+下面是最简单的做法, 完整的实现见 delayqueue 包: 用lua脚本批量取消息, 支持可见性超时, 重试和死信
+
 redis的zset是一种能自动排序的数据结构，我们可以用这个特性来实现简单的延时队列。
 
 利用zadd将数据添加到zset中，每个数据的score值设置为数据的延时时间+当前时间戳，后台goroutine不断zrange轮询zset，取出score值小于当前时间戳的数据，然后再对数据进一步处理，这样就实现了简单延时队列的功能。
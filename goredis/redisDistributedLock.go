@@ -2,4 +2,10 @@ package main
 
 /*
    redis分布式锁
+
+   实现见 redislock 包:
+   用 SET NX PX 加锁, value 是随机的token, 释放和续期时用lua脚本比较token, 不会释放别人的锁
+   持有者活着的时候自动续期, 崩溃以后锁在租约到期后释放
+   每次加锁成功返回一个递增的 fencing token, 写共享资源时带上它, 拒绝旧的持有者的写入
+   有多个独立的redis节点时用 Redlock, 在多数节点上加锁成功才算成功, 少数节点不可用时仍然可以加锁
 */
@@ -0,0 +1,150 @@
+package redisclient
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/go-redis/redis"
+)
+
+/*
+  对 go-redis 的简单封装, 原来是 goredis/RedisClient.go 中的函数
+
+每个方法对应一个redis命令, 第一个参数都是ctx, 多个key或者值用可变参数
+结果和错误都返回给调用方, 不打印也不退出
+key, 字段或者成员不存在时返回 ErrNotFound, 和redis出错区分开
+*/
+
+// key, 字段或者成员不存在, errors.Is(err, redis.Nil) 也成立
+var ErrNotFound = fmt.Errorf("redisclient: not found: %w", redis.Nil)
+
+// 参数的个数不对, 比如 MSet 的key和值不是成对的
+var ErrArgs = errors.New("redisclient: wrong number of arguments")
+
+type Client struct {
+	rdb redis.Cmdable
+	// Connect 创建的连接, Close 时关闭
+	closer io.Closer
+}
+
+// rdb 可以是 *redis.Client, *redis.ClusterClient 等, 由调用方关闭
+func New(rdb redis.Cmdable) *Client {
+	return &Client{
+		rdb: rdb,
+	}
+}
+
+// 连接redis服务端, ping 成功后返回, 用完需要 Close
+func Connect(ctx context.Context, opts *redis.Options) (client *Client, err error) {
+	rdb := redis.NewClient(opts)
+	err = rdb.Ping(ctx).Err()
+	if err != nil {
+		rdb.Close()
+		return
+	}
+	return &Client{
+		rdb:    rdb,
+		closer: rdb,
+	}, nil
+}
+
+func (this *Client) Close() error {
+	if this.closer == nil {
+		return nil
+	}
+	return this.closer.Close()
+}
+
+// redis.Nil 转换成 ErrNotFound
+func notFound(err error) error {
+	if err == redis.Nil {
+		return ErrNotFound
+	}
+	return err
+}
+
+func toArgs(values []string) []interface{} {
+	args := make([]interface{}, len(values))
+	for i, v := range values {
+		args[i] = v
+	}
+	return args
+}
+
+// MGet 和 HMGet 的结果, 不存在的key不放进map
+func toMap(keys []string, values []interface{}) map[string]string {
+	m := make(map[string]string, len(keys))
+	for i, v := range values {
+		if s, ok := v.(string); ok && i < len(keys) {
+			m[keys[i]] = s
+		}
+	}
+	return m
+}
+
+// redis命令：ping
+func (this *Client) Ping(ctx context.Context) error {
+	return this.rdb.Ping(ctx).Err()
+}
+
+// string类型数据操作
+// redis命令：set key val 或者 setex key time val
+// 有效期为0表示不设置有效期，非0表示经过该时间后键值对失效
+func (this *Client) Set(ctx context.Context, key string, val string, expire time.Duration) error {
+	return this.rdb.Set(ctx, key, val, expire).Err()
+}
+
+// redis命令：get key
+func (this *Client) Get(ctx context.Context, key string) (string, error) {
+	val, err := this.rdb.Get(ctx, key).Result()
+	return val, notFound(err)
+}
+
+// redis命令：mset key1 val1 key2 val2 ...
+func (this *Client) MSet(ctx context.Context, pairs ...string) error {
+	if len(pairs) == 0 || len(pairs)%2 != 0 {
+		return ErrArgs
+	}
+	return this.rdb.MSet(ctx, toArgs(pairs)...).Err()
+}
+
+// redis命令：mget key1 key2 ...
+// 返回存在的key和它的值
+func (this *Client) MGet(ctx context.Context, keys ...string) (map[string]string, error) {
+	vals, err := this.rdb.MGet(ctx, keys...).Result()
+	if err != nil {
+		return nil, err
+	}
+	return toMap(keys, vals), nil
+}
+
+// redis命令：del key1 key2 ...
+// 返回删除了几个key
+func (this *Client) Del(ctx context.Context, keys ...string) (int64, error) {
+	return this.rdb.Del(ctx, keys...).Result()
+}
+
+// redis命令：exists key1 key2 ...
+// 返回存在几个key
+func (this *Client) Exists(ctx context.Context, keys ...string) (int64, error) {
+	return this.rdb.Exists(ctx, keys...).Result()
+}
+
+// redis命令：getrange key start end
+func (this *Client) GetRange(ctx context.Context, key string, start, end int64) (string, error) {
+	return this.rdb.GetRange(ctx, key, start, end).Result()
+}
+
+// redis命令：strlen key
+func (this *Client) StrLen(ctx context.Context, key string) (int64, error) {
+	return this.rdb.StrLen(ctx, key).Result()
+}
+
+// redis命令：append key val
+// 将val插入key对应值的末尾，并返回新串长度
+func (this *Client) Append(ctx context.Context, key string, val string) (int64, error) {
+	return this.rdb.Append(ctx, key, val).Result()
+}
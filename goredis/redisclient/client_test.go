@@ -0,0 +1,294 @@
+package redisclient
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/go-redis/redis"
+)
+
+// 启动 miniredis 并连接, 测试结束时关闭
+func setup(t *testing.T) (*miniredis.Miniredis, *Client) {
+	t.Helper()
+	server := miniredis.RunT(t)
+	client, err := Connect(context.Background(), &redis.Options{Addr: server.Addr()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { client.Close() })
+	return server, client
+}
+
+// 命令的结果, err 不为nil或者got和want不一样时失败
+func check(t *testing.T, what string, got interface{}, err error, want interface{}) {
+	t.Helper()
+	if err != nil {
+		t.Fatalf("%s: %v", what, err)
+	}
+	if fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Fatalf("%s: 结果是%v, 期望%v", what, got, want)
+	}
+}
+
+// 集合的结果没有顺序, 排序以后再比较
+func sorted(vals []string) []string {
+	sort.Strings(vals)
+	return vals
+}
+
+// 不存在时返回 ErrNotFound, 原来判断 redis.Nil 的调用方也不用改
+func expectNotFound(t *testing.T, what string, err error) {
+	t.Helper()
+	if !errors.Is(err, ErrNotFound) || !errors.Is(err, redis.Nil) {
+		t.Fatalf("%s: 返回%v, 期望ErrNotFound", what, err)
+	}
+}
+
+// key, 字段或者成员不存在的各种情况
+func TestNotFound(t *testing.T) {
+	server, client := setup(t)
+	ctx := context.Background()
+	if _, err := client.RPush(ctx, "list", "a"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := client.HSet(ctx, "hash", "k", "v"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := client.ZAdd(ctx, "zset", Z{Member: "a", Score: 1}); err != nil {
+		t.Fatal(err)
+	}
+	if err := client.Set(ctx, "expired", "v", time.Second); err != nil {
+		t.Fatal(err)
+	}
+	server.FastForward(time.Second)
+
+	tests := []struct {
+		name string
+		fn   func() error
+	}{
+		{"get不存在的key", func() error { _, err := client.Get(ctx, "none"); return err }},
+		{"get过期的key", func() error { _, err := client.Get(ctx, "expired"); return err }},
+		{"hget不存在的字段", func() error { _, err := client.HGet(ctx, "hash", "none"); return err }},
+		{"hget不存在的key", func() error { _, err := client.HGet(ctx, "none", "k"); return err }},
+		{"lindex超出范围", func() error { _, err := client.LIndex(ctx, "list", 10); return err }},
+		{"lpop空的列表", func() error { _, err := client.LPop(ctx, "none"); return err }},
+		{"rpop空的列表", func() error { _, err := client.RPop(ctx, "none"); return err }},
+		{"spop空的集合", func() error { _, err := client.SPop(ctx, "none"); return err }},
+		{"srandmember空的集合", func() error { _, err := client.SRandMember(ctx, "none"); return err }},
+		{"zrank不存在的成员", func() error { _, err := client.ZRank(ctx, "zset", "none"); return err }},
+		{"zscore不存在的成员", func() error { _, err := client.ZScore(ctx, "zset", "none"); return err }},
+	}
+	for _, tt := range tests {
+		expectNotFound(t, tt.name, tt.fn())
+	}
+}
+
+// string, 可变参数
+func TestString(t *testing.T) {
+	_, client := setup(t)
+	ctx := context.Background()
+
+	if err := client.Set(ctx, "k1", "v1", 0); err != nil {
+		t.Fatal(err)
+	}
+	val, err := client.Get(ctx, "k1")
+	check(t, "get", val, err, "v1")
+	if err = client.MSet(ctx, "k2", "v2", "k3"); err != ErrArgs {
+		t.Fatalf("mset 参数不成对时返回%v", err)
+	}
+	if err = client.MSet(ctx, "k2", "v2", "k3", "v3"); err != nil {
+		t.Fatal(err)
+	}
+	vals, err := client.MGet(ctx, "k1", "none", "k3")
+	check(t, "mget", vals, err, map[string]string{"k1": "v1", "k3": "v3"})
+	n, err := client.Append(ctx, "k1", "-more")
+	check(t, "append", n, err, 7)
+	val, err = client.GetRange(ctx, "k1", 3, -1)
+	check(t, "getrange", val, err, "more")
+	n, err = client.StrLen(ctx, "k2")
+	check(t, "strlen", n, err, 2)
+	n, err = client.Exists(ctx, "k1", "k2", "none")
+	check(t, "exists", n, err, 2)
+	n, err = client.Del(ctx, "k2", "k3", "none")
+	check(t, "del", n, err, 2)
+}
+
+func TestHash(t *testing.T) {
+	_, client := setup(t)
+	ctx := context.Background()
+
+	if _, err := client.HSet(ctx, "hs", "k1"); err != ErrArgs {
+		t.Fatalf("hset 参数不成对时返回%v", err)
+	}
+	n, err := client.HSet(ctx, "hs", "k1", "v1", "k2", "v2", "k3", "v3")
+	check(t, "hset", n, err, 3)
+	n, err = client.HSet(ctx, "hs", "k1", "new")
+	check(t, "hset已经存在的字段", n, err, 0)
+	val, err := client.HGet(ctx, "hs", "k1")
+	check(t, "hget", val, err, "new")
+	vals, err := client.HMGet(ctx, "hs", "k2", "none", "k3")
+	check(t, "hmget", vals, err, map[string]string{"k2": "v2", "k3": "v3"})
+	// 删除的是字段, 不是整个hash
+	n, err = client.HDel(ctx, "hs", "k3", "none")
+	check(t, "hdel", n, err, 1)
+	exists, err := client.HExists(ctx, "hs", "k3")
+	check(t, "hexists", exists, err, false)
+	all, err := client.HGetAll(ctx, "hs")
+	check(t, "hgetall", all, err, map[string]string{"k1": "new", "k2": "v2"})
+	n, err = client.HLen(ctx, "hs")
+	check(t, "hlen", n, err, 2)
+	keys, err := client.HKeys(ctx, "hs")
+	check(t, "hkeys", sorted(keys), err, []string{"k1", "k2"})
+	hvals, err := client.HVals(ctx, "hs")
+	check(t, "hvals", sorted(hvals), err, []string{"new", "v2"})
+}
+
+func TestList(t *testing.T) {
+	_, client := setup(t)
+	ctx := context.Background()
+
+	n, err := client.RPush(ctx, "list", "a", "b", "c", "b")
+	check(t, "rpush", n, err, 4)
+	n, err = client.LPush(ctx, "list", "z")
+	check(t, "lpush", n, err, 5)
+	n, err = client.LRem(ctx, "list", 0, "b")
+	check(t, "lrem", n, err, 2)
+	if err = client.LSet(ctx, "list", 1, "A"); err != nil {
+		t.Fatal(err)
+	}
+	items, err := client.LRange(ctx, "list", 0, -1)
+	check(t, "lrange", items, err, []string{"z", "A", "c"})
+	val, err := client.LIndex(ctx, "list", -1)
+	check(t, "lindex", val, err, "c")
+	if err = client.LTrim(ctx, "list", 0, 1); err != nil {
+		t.Fatal(err)
+	}
+	val, err = client.RPop(ctx, "list")
+	check(t, "rpop", val, err, "A")
+	val, err = client.LPop(ctx, "list")
+	check(t, "lpop", val, err, "z")
+	_, err = client.LPop(ctx, "list")
+	expectNotFound(t, "lpop空的列表", err)
+	n, err = client.LLen(ctx, "list")
+	check(t, "llen", n, err, 0)
+}
+
+func TestSet(t *testing.T) {
+	_, client := setup(t)
+	ctx := context.Background()
+
+	n, err := client.SAdd(ctx, "s1", "a", "b", "c", "a")
+	check(t, "sadd", n, err, 3)
+	if _, err = client.SAdd(ctx, "s2", "b", "c", "d"); err != nil {
+		t.Fatal(err)
+	}
+	members, err := client.SUnion(ctx, "s1", "s2")
+	check(t, "sunion", sorted(members), err, []string{"a", "b", "c", "d"})
+	members, err = client.SInter(ctx, "s1", "s2")
+	check(t, "sinter", sorted(members), err, []string{"b", "c"})
+	members, err = client.SDiff(ctx, "s1", "s2")
+	check(t, "sdiff", members, err, []string{"a"})
+	n, err = client.SUnionStore(ctx, "s3", "s1", "s2")
+	check(t, "sunionstore", n, err, 4)
+	n, err = client.SInterStore(ctx, "s3", "s1", "s2")
+	check(t, "sinterstore", n, err, 2)
+	n, err = client.SDiffStore(ctx, "s3", "s2", "s1")
+	check(t, "sdiffstore", n, err, 1)
+	moved, err := client.SMove(ctx, "s1", "s3", "a")
+	check(t, "smove", moved, err, true)
+	isMember, err := client.SIsMember(ctx, "s3", "a")
+	check(t, "sismember", isMember, err, true)
+	n, err = client.SRem(ctx, "s1", "b", "none")
+	check(t, "srem", n, err, 1)
+	members, err = client.SMembers(ctx, "s1")
+	check(t, "smembers", members, err, []string{"c"})
+	val, err := client.SRandMember(ctx, "s1")
+	check(t, "srandmember", val, err, "c")
+	members, err = client.SRandMemberN(ctx, "s2", 2)
+	check(t, "srandmember count", len(members), err, 2)
+	val, err = client.SPop(ctx, "s1")
+	check(t, "spop", val, err, "c")
+	_, err = client.SPop(ctx, "s1")
+	expectNotFound(t, "spop空的集合", err)
+	n, err = client.SCard(ctx, "s2")
+	check(t, "scard", n, err, 3)
+}
+
+func TestZSet(t *testing.T) {
+	_, client := setup(t)
+	ctx := context.Background()
+
+	n, err := client.ZAdd(ctx, "zs",
+		Z{Member: "Golang", Score: 90},
+		Z{Member: "Java", Score: 98},
+		Z{Member: "Python", Score: 95})
+	check(t, "zadd", n, err, 3)
+	members, err := client.ZRange(ctx, "zs", 0, -1)
+	check(t, "zrange", members, err, []string{"Golang", "Python", "Java"})
+	withScores, err := client.ZRevRangeWithScores(ctx, "zs", 0, 0)
+	check(t, "zrevrange withscores", withScores, err, []Z{{Member: "Java", Score: 98}})
+	members, err = client.ZRevRange(ctx, "zs", 0, 1)
+	check(t, "zrevrange", members, err, []string{"Java", "Python"})
+	withScores, err = client.ZRangeWithScores(ctx, "zs", 0, 0)
+	check(t, "zrange withscores", withScores, err, []Z{{Member: "Golang", Score: 90}})
+	members, err = client.ZRangeByScore(ctx, "zs", "(90", "+inf")
+	check(t, "zrangebyscore", members, err, []string{"Python", "Java"})
+	withScores, err = client.ZRangeByScoreWithScores(ctx, "zs", "95", "96")
+	check(t, "zrangebyscore withscores", withScores, err, []Z{{Member: "Python", Score: 95}})
+	n, err = client.ZCount(ctx, "zs", "-inf", "95")
+	check(t, "zcount", n, err, 2)
+	n, err = client.ZRank(ctx, "zs", "Java")
+	check(t, "zrank", n, err, 2)
+	score, err := client.ZScore(ctx, "zs", "Python")
+	check(t, "zscore", score, err, 95)
+	n, err = client.ZRem(ctx, "zs", "Golang", "Rust")
+	check(t, "zrem", n, err, 1)
+	n, err = client.ZCard(ctx, "zs")
+	check(t, "zcard", n, err, 2)
+}
+
+// ctx 取消时不发送命令, redis出错和连接失败时返回错误, 不会退出
+func TestErrors(t *testing.T) {
+	server, client := setup(t)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := client.Set(ctx, "key", "v", 0); !errors.Is(err, context.Canceled) {
+		t.Fatalf("ctx取消以后set返回%v", err)
+	}
+	ctx = context.Background()
+	_, err := client.Get(ctx, "key")
+	expectNotFound(t, "ctx取消时set不应该执行", err)
+
+	server.SetError("ERR injected")
+	_, err = client.Get(ctx, "key")
+	server.SetError("")
+	if err == nil || errors.Is(err, ErrNotFound) || errors.Is(err, redis.Nil) {
+		t.Fatalf("redis出错时get返回%v", err)
+	}
+
+	// 端口已经没有人监听了
+	closed := miniredis.RunT(t)
+	addr := closed.Addr()
+	closed.Close()
+	dialCtx, cancel := context.WithTimeout(ctx, time.Second)
+	defer cancel()
+	if _, err = Connect(dialCtx, &redis.Options{Addr: addr, MaxRetries: -1}); err == nil {
+		t.Fatalf("连接不存在的服务器成功了")
+	}
+
+	// New 的连接由调用方关闭, Close 什么都不做
+	rdb := redis.NewClient(&redis.Options{Addr: server.Addr()})
+	defer rdb.Close()
+	if err = New(rdb).Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err = rdb.Ping(ctx).Err(); err != nil {
+		t.Fatalf("Close 关闭了调用方的连接: %v", err)
+	}
+}
@@ -0,0 +1,60 @@
+package redisclient
+
+import "context"
+
+// hash类型数据操作
+// redis命令：hset hashTable key1 val1 key2 val2 ...
+// 返回新增加的字段数, 已经存在的字段只更新值
+func (this *Client) HSet(ctx context.Context, hashTable string, pairs ...string) (int64, error) {
+	if len(pairs) == 0 || len(pairs)%2 != 0 {
+		return 0, ErrArgs
+	}
+	return this.rdb.HSet(ctx, hashTable, toArgs(pairs)...).Result()
+}
+
+// redis命令：hget hashTable key
+func (this *Client) HGet(ctx context.Context, hashTable string, key string) (string, error) {
+	val, err := this.rdb.HGet(ctx, hashTable, key).Result()
+	return val, notFound(err)
+}
+
+// redis命令：hmget hashTable key1 key2 ...
+// 返回存在的字段和它的值
+func (this *Client) HMGet(ctx context.Context, hashTable string, keys ...string) (map[string]string, error) {
+	vals, err := this.rdb.HMGet(ctx, hashTable, keys...).Result()
+	if err != nil {
+		return nil, err
+	}
+	return toMap(keys, vals), nil
+}
+
+// redis命令：hdel hashTable key1 key2 ...
+// 返回删除了几个字段, 不存在的字段不算
+func (this *Client) HDel(ctx context.Context, hashTable string, keys ...string) (int64, error) {
+	return this.rdb.HDel(ctx, hashTable, keys...).Result()
+}
+
+// redis命令：hgetall hashTable
+func (this *Client) HGetAll(ctx context.Context, hashTable string) (map[string]string, error) {
+	return this.rdb.HGetAll(ctx, hashTable).Result()
+}
+
+// redis命令：hexists hashTable key
+func (this *Client) HExists(ctx context.Context, hashTable string, key string) (bool, error) {
+	return this.rdb.HExists(ctx, hashTable, key).Result()
+}
+
+// redis命令：hlen hashTable
+func (this *Client) HLen(ctx context.Context, hashTable string) (int64, error) {
+	return this.rdb.HLen(ctx, hashTable).Result()
+}
+
+// redis命令：hkeys hashTable
+func (this *Client) HKeys(ctx context.Context, hashTable string) ([]string, error) {
+	return this.rdb.HKeys(ctx, hashTable).Result()
+}
+
+// redis命令：hvals hashTable
+func (this *Client) HVals(ctx context.Context, hashTable string) ([]string, error) {
+	return this.rdb.HVals(ctx, hashTable).Result()
+}
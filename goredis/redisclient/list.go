@@ -0,0 +1,63 @@
+package redisclient
+
+import "context"
+
+// list类型数据操作
+// redis命令：lpush mylist val1 val2 ...
+// 返回列表的总长度（即有多少个元素在列表中）
+func (this *Client) LPush(ctx context.Context, mylist string, vals ...string) (int64, error) {
+	return this.rdb.LPush(ctx, mylist, toArgs(vals)...).Result()
+}
+
+// redis命令：rpush mylist val1 val2 ...
+// 返回列表的总长度（即有多少个元素在列表中）
+func (this *Client) RPush(ctx context.Context, mylist string, vals ...string) (int64, error) {
+	return this.rdb.RPush(ctx, mylist, toArgs(vals)...).Result()
+}
+
+// redis命令：lpop mylist
+// 返回被删除的值, 列表为空时返回 ErrNotFound
+func (this *Client) LPop(ctx context.Context, mylist string) (string, error) {
+	val, err := this.rdb.LPop(ctx, mylist).Result()
+	return val, notFound(err)
+}
+
+// redis命令：rpop mylist
+// 返回被删除的值, 列表为空时返回 ErrNotFound
+func (this *Client) RPop(ctx context.Context, mylist string) (string, error) {
+	val, err := this.rdb.RPop(ctx, mylist).Result()
+	return val, notFound(err)
+}
+
+// redis命令：lrem mylist count val
+// 返回成功删除的val的数量
+func (this *Client) LRem(ctx context.Context, mylist string, count int64, val string) (int64, error) {
+	return this.rdb.LRem(ctx, mylist, count, val).Result()
+}
+
+// redis命令：ltrim mylist start end
+func (this *Client) LTrim(ctx context.Context, mylist string, start, end int64) error {
+	return this.rdb.LTrim(ctx, mylist, start, end).Err()
+}
+
+// redis命令：lset mylist index val
+func (this *Client) LSet(ctx context.Context, mylist string, index int64, val string) error {
+	return this.rdb.LSet(ctx, mylist, index, val).Err()
+}
+
+// redis命令：lindex mylist index
+// 通过索引查找字符串, 索引超出范围时返回 ErrNotFound
+func (this *Client) LIndex(ctx context.Context, mylist string, index int64) (string, error) {
+	val, err := this.rdb.LIndex(ctx, mylist, index).Result()
+	return val, notFound(err)
+}
+
+// redis命令：lrange mylist start end
+func (this *Client) LRange(ctx context.Context, mylist string, start, end int64) ([]string, error) {
+	return this.rdb.LRange(ctx, mylist, start, end).Result()
+}
+
+// redis命令：llen mylist
+func (this *Client) LLen(ctx context.Context, mylist string) (int64, error) {
+	return this.rdb.LLen(ctx, mylist).Result()
+}
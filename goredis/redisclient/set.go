@@ -0,0 +1,90 @@
+package redisclient
+
+import "context"
+
+// 无序集合set类型数据操作
+// redis命令：sadd myset val1 val2 ...
+// 返回新加入集合的值的个数
+func (this *Client) SAdd(ctx context.Context, myset string, vals ...string) (int64, error) {
+	return this.rdb.SAdd(ctx, myset, toArgs(vals)...).Result()
+}
+
+// redis命令：srem myset val1 val2 ...
+// 返回从集合中删除的值的个数
+func (this *Client) SRem(ctx context.Context, myset string, vals ...string) (int64, error) {
+	return this.rdb.SRem(ctx, myset, toArgs(vals)...).Result()
+}
+
+// redis命令：spop myset
+// 随机删除一个值并返回, 集合为空时返回 ErrNotFound
+func (this *Client) SPop(ctx context.Context, myset string) (string, error) {
+	val, err := this.rdb.SPop(ctx, myset).Result()
+	return val, notFound(err)
+}
+
+// redis命令：smembers myset
+func (this *Client) SMembers(ctx context.Context, myset string) ([]string, error) {
+	return this.rdb.SMembers(ctx, myset).Result()
+}
+
+// redis命令：scard myset
+func (this *Client) SCard(ctx context.Context, myset string) (int64, error) {
+	return this.rdb.SCard(ctx, myset).Result()
+}
+
+// redis命令：sismember myset val
+// 判断值是否为集合中的成员
+func (this *Client) SIsMember(ctx context.Context, myset string, val string) (bool, error) {
+	return this.rdb.SIsMember(ctx, myset, val).Result()
+}
+
+// redis命令：srandmember myset
+// 随机返回一个值, 集合为空时返回 ErrNotFound
+func (this *Client) SRandMember(ctx context.Context, myset string) (string, error) {
+	val, err := this.rdb.SRandMember(ctx, myset).Result()
+	return val, notFound(err)
+}
+
+// redis命令：srandmember myset count
+func (this *Client) SRandMemberN(ctx context.Context, myset string, count int64) ([]string, error) {
+	return this.rdb.SRandMemberN(ctx, myset, count).Result()
+}
+
+// redis命令：smove myset myset2 val
+// val不在myset中时返回false
+func (this *Client) SMove(ctx context.Context, myset, myset2 string, val string) (bool, error) {
+	return this.rdb.SMove(ctx, myset, myset2, val).Result()
+}
+
+// redis命令：sunion myset myset2 ...
+func (this *Client) SUnion(ctx context.Context, mysets ...string) ([]string, error) {
+	return this.rdb.SUnion(ctx, mysets...).Result()
+}
+
+// redis命令：sunionstore desset myset myset2 ...
+// 返回新集合的长度
+func (this *Client) SUnionStore(ctx context.Context, desset string, mysets ...string) (int64, error) {
+	return this.rdb.SUnionStore(ctx, desset, mysets...).Result()
+}
+
+// redis命令：sinter myset myset2 ...
+func (this *Client) SInter(ctx context.Context, mysets ...string) ([]string, error) {
+	return this.rdb.SInter(ctx, mysets...).Result()
+}
+
+// redis命令：sinterstore desset myset myset2 ...
+// 返回新集合的长度
+func (this *Client) SInterStore(ctx context.Context, desset string, mysets ...string) (int64, error) {
+	return this.rdb.SInterStore(ctx, desset, mysets...).Result()
+}
+
+// redis命令：sdiff myset myset2 ...
+func (this *Client) SDiff(ctx context.Context, mysets ...string) ([]string, error) {
+	return this.rdb.SDiff(ctx, mysets...).Result()
+}
+
+// redis命令：sdiffstore desset myset myset2 ...
+// 返回新集合的长度
+func (this *Client) SDiffStore(ctx context.Context, desset string, mysets ...string) (int64, error) {
+	return this.rdb.SDiffStore(ctx, desset, mysets...).Result()
+}
@@ -0,0 +1,96 @@
+package redisclient
+
+import (
+	"context"
+
+	"github.com/go-redis/redis"
+)
+
+// 有序集合中的一个成员和它的score
+type Z struct {
+	Member string
+	Score  float64
+}
+
+func fromZ(zs []redis.Z) []Z {
+	members := make([]Z, len(zs))
+	for i, z := range zs {
+		members[i].Member, _ = z.Member.(string)
+		members[i].Score = z.Score
+	}
+	return members
+}
+
+// 有序集合zset类型数据操作
+// redis命令：zadd myzset score1 val1 score2 val2 ...
+// 返回新加入的成员数, 已经存在的成员只更新score
+func (this *Client) ZAdd(ctx context.Context, myzset string, members ...Z) (int64, error) {
+	zs := make([]*redis.Z, len(members))
+	for i, m := range members {
+		zs[i] = &redis.Z{Score: m.Score, Member: m.Member}
+	}
+	return this.rdb.ZAdd(ctx, myzset, zs...).Result()
+}
+
+// redis命令：zrem myzset val1 val2 ...
+func (this *Client) ZRem(ctx context.Context, myzset string, vals ...string) (int64, error) {
+	return this.rdb.ZRem(ctx, myzset, toArgs(vals)...).Result()
+}
+
+// redis命令：zrange myzset start end
+func (this *Client) ZRange(ctx context.Context, myzset string, start, end int64) ([]string, error) {
+	return this.rdb.ZRange(ctx, myzset, start, end).Result()
+}
+
+// redis命令：zrange myzset start end withscores
+func (this *Client) ZRangeWithScores(ctx context.Context, myzset string, start, end int64) ([]Z, error) {
+	zs, err := this.rdb.ZRangeWithScores(ctx, myzset, start, end).Result()
+	return fromZ(zs), err
+}
+
+// redis命令：zrevrange myzset start end
+func (this *Client) ZRevRange(ctx context.Context, myzset string, start, end int64) ([]string, error) {
+	return this.rdb.ZRevRange(ctx, myzset, start, end).Result()
+}
+
+// redis命令：zrevrange myzset start end withscores
+func (this *Client) ZRevRangeWithScores(ctx context.Context, myzset string, start, end int64) ([]Z, error) {
+	zs, err := this.rdb.ZRevRangeWithScores(ctx, myzset, start, end).Result()
+	return fromZ(zs), err
+}
+
+// redis命令：zrangebyscore myzset min max
+// min和max可以是 -inf, +inf 或者 (1 这样不包含边界的写法
+func (this *Client) ZRangeByScore(ctx context.Context, myzset string, min, max string) ([]string, error) {
+	return this.rdb.ZRangeByScore(ctx, myzset, &redis.ZRangeBy{Min: min, Max: max}).Result()
+}
+
+// redis命令：zrangebyscore myzset min max withscores
+func (this *Client) ZRangeByScoreWithScores(ctx context.Context, myzset string, min, max string) ([]Z, error) {
+	zs, err := this.rdb.ZRangeByScoreWithScores(ctx, myzset, &redis.ZRangeBy{Min: min, Max: max}).Result()
+	return fromZ(zs), err
+}
+
+// redis命令：zcard myzset
+func (this *Client) ZCard(ctx context.Context, myzset string) (int64, error) {
+	return this.rdb.ZCard(ctx, myzset).Result()
+}
+
+// redis命令：zcount myzset minscore maxscore
+func (this *Client) ZCount(ctx context.Context, myzset string, minscore, maxscore string) (int64, error) {
+	return this.rdb.ZCount(ctx, myzset, minscore, maxscore).Result()
+}
+
+// redis命令：zrank myzset val
+// 成员不存在时返回 ErrNotFound
+func (this *Client) ZRank(ctx context.Context, myzset string, val string) (int64, error) {
+	rank, err := this.rdb.ZRank(ctx, myzset, val).Result()
+	return rank, notFound(err)
+}
+
+// redis命令：zscore myzset val
+// 成员不存在时返回 ErrNotFound
+func (this *Client) ZScore(ctx context.Context, myzset string, val string) (float64, error) {
+	score, err := this.rdb.ZScore(ctx, myzset, val).Result()
+	return score, notFound(err)
+}
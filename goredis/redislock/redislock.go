@@ -0,0 +1,320 @@
+package redislock
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log/slog"
+	mathrand "math/rand"
+	"sync"
+	"time"
+
+	"github.com/go-redis/redis"
+)
+
+/*
+  redis分布式锁, 设计见 goredis/redisDistributedLock.go
+
+每个锁使用两个key, {名字} 是hash tag, 在redis集群中两个key在同一个slot
+  前缀:{名字}        string 持有者的随机token, 过期时间就是租约
+  前缀:{名字}:fence  string 每次加锁成功加1, 就是 fencing token
+
+加锁用 SET NX PX, 成功以后在同一个lua脚本中 INCR fence
+释放和续期都要先比较token, 不会释放或者续期别人的锁
+持有者活着的时候每 TTL/3 续期一次, 崩溃以后不再续期, 锁在 TTL 之后过期
+
+锁过期以后旧的持有者可能还以为自己持有锁, 所以写共享资源时要带上 Fence,
+资源那边拒绝比见过的最大 fence 小的写入
+*/
+
+var (
+	// 锁已经被别人持有
+	ErrNotObtained = errors.New("redislock: not obtained")
+	// 锁已经过期或者被别人持有, 不能释放或者续期
+	ErrNotHeld = errors.New("redislock: lock not held")
+	// Redlock 至少要有一个节点
+	ErrNoNodes = errors.New("redislock: no nodes")
+)
+
+type Options struct {
+	// key的前缀, 默认是 lock
+	Prefix string
+	// 为true时不自动续期, 需要自己调用 Refresh
+	ManualRenew bool
+	// 阻塞加锁时重试的间隔, 从 MinRetryDelay 开始每次翻倍, 最多 MaxRetryDelay, 默认10毫秒和500毫秒
+	MinRetryDelay time.Duration
+	MaxRetryDelay time.Duration
+	// 只用于 Redlock, 每个节点的超时时间, 应该远小于 TTL, 默认50毫秒
+	NodeTimeout time.Duration
+	// 只用于 Redlock, 各个节点的时钟走得快慢不同, 锁的有效期要减去 TTL*ClockDriftFactor, 默认0.01
+	ClockDriftFactor float64
+	// 默认是 slog.Default()
+	Logger *slog.Logger
+}
+
+type Client struct {
+	rdb  redis.Cmdable
+	opts Options
+}
+
+// rdb 可以是 *redis.Client, *redis.ClusterClient 等, opts 为nil时全部使用默认值
+func New(rdb redis.Cmdable, opts *Options) *Client {
+	client := &Client{
+		rdb: rdb,
+	}
+	if opts != nil {
+		client.opts = *opts
+	}
+	client.opts.setDefaults()
+	return client
+}
+
+func (this *Options) setDefaults() {
+	if this.Prefix == "" {
+		this.Prefix = "lock"
+	}
+	if this.MinRetryDelay <= 0 {
+		this.MinRetryDelay = 10 * time.Millisecond
+	}
+	if this.MaxRetryDelay < this.MinRetryDelay {
+		this.MaxRetryDelay = 500 * time.Millisecond
+		if this.MaxRetryDelay < this.MinRetryDelay {
+			this.MaxRetryDelay = this.MinRetryDelay
+		}
+	}
+	if this.NodeTimeout <= 0 {
+		this.NodeTimeout = 50 * time.Millisecond
+	}
+	if this.ClockDriftFactor <= 0 {
+		this.ClockDriftFactor = 0.01
+	}
+	if this.Logger == nil {
+		this.Logger = slog.Default()
+	}
+}
+
+// 第attempt次重试前等待的时间, 在 [d/2, d] 之间随机, 避免多个客户端同时重试
+func (this *Options) retryDelay(attempt int) time.Duration {
+	d := this.MinRetryDelay
+	for i := 1; i < attempt && d < this.MaxRetryDelay; i++ {
+		d *= 2
+	}
+	if d > this.MaxRetryDelay {
+		d = this.MaxRetryDelay
+	}
+	return d/2 + time.Duration(mathrand.Int63n(int64(d/2)+1))
+}
+
+// 等待第attempt次重试, ctx 取消时返回错误
+func (this *Options) wait(ctx context.Context, attempt int) error {
+	timer := time.NewTimer(this.retryDelay(attempt))
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return fmt.Errorf("%w: %w", ErrNotObtained, ctx.Err())
+	}
+}
+
+func newToken() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// 锁的key, {名字} 是hash tag
+func (this *Options) key(name string) string {
+	return fmt.Sprintf("%s:{%s}", this.Prefix, name)
+}
+
+// 尝试加锁一次, 锁被别人持有时返回 ErrNotObtained
+func (this *Client) TryLock(ctx context.Context, name string, ttl time.Duration) (lock *Lock, err error) {
+	token, err := newToken()
+	if err != nil {
+		return
+	}
+	key := this.opts.key(name)
+	start := time.Now()
+	fence, err := obtainScript.Run(ctx, this.rdb, []string{key, key + ":fence"}, token, ttl.Milliseconds()).Int64()
+	if err != nil {
+		return
+	}
+	if fence == 0 {
+		return nil, ErrNotObtained
+	}
+	return newLock(this, &this.opts, key, token, fence, ttl, start), nil
+}
+
+// 加锁, 锁被别人持有时按退避时间重试, 直到加锁成功或者ctx取消
+func (this *Client) Lock(ctx context.Context, name string, ttl time.Duration) (lock *Lock, err error) {
+	return this.opts.retry(ctx, func() (*Lock, error) {
+		return this.TryLock(ctx, name, ttl)
+	})
+}
+
+// tryLock 返回 ErrNotObtained 时按退避时间重试
+func (this *Options) retry(ctx context.Context, tryLock func() (*Lock, error)) (lock *Lock, err error) {
+	for attempt := 1; ; attempt++ {
+		lock, err = tryLock()
+		if err != nil && ctx.Err() != nil {
+			// 等到一半 ctx 取消了, 节点返回的是 ctx 的错误, 和等待时取消一样处理
+			return nil, fmt.Errorf("%w: %w", ErrNotObtained, ctx.Err())
+		}
+		if err != ErrNotObtained {
+			return
+		}
+		if err = this.wait(ctx, attempt); err != nil {
+			return
+		}
+	}
+}
+
+// 锁的续期, 释放和查看租约, 单节点是 Client, 多节点是 Redlock
+type backend interface {
+	refresh(ctx context.Context, key string, token string, ttl time.Duration) error
+	release(ctx context.Context, key string, token string) error
+	pttl(ctx context.Context, key string, token string) (time.Duration, error)
+}
+
+func (this *Client) refresh(ctx context.Context, key string, token string, ttl time.Duration) error {
+	n, err := refreshScript.Run(ctx, this.rdb, []string{key}, token, ttl.Milliseconds()).Int()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return ErrNotHeld
+	}
+	return nil
+}
+
+func (this *Client) release(ctx context.Context, key string, token string) error {
+	n, err := releaseScript.Run(ctx, this.rdb, []string{key}, token).Int()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return ErrNotHeld
+	}
+	return nil
+}
+
+func (this *Client) pttl(ctx context.Context, key string, token string) (time.Duration, error) {
+	ms, err := pttlScript.Run(ctx, this.rdb, []string{key}, token).Int64()
+	if err != nil {
+		return 0, err
+	}
+	if ms < 0 {
+		return 0, ErrNotHeld
+	}
+	return time.Duration(ms) * time.Millisecond, nil
+}
+
+// 一次加锁成功的结果
+type Lock struct {
+	backend backend
+	opts    *Options
+	key     string
+	token   string
+	fence   int64
+	ttl     time.Duration
+
+	// 最后一次续期成功的时间, 只在续期的协程中使用
+	renewed  time.Time
+	stop     chan struct{}
+	stopOnce sync.Once
+	lost     chan struct{}
+	lostOnce sync.Once
+}
+
+// 加锁成功以后创建Lock, 没有设置 ManualRenew 时开始自动续期, start 是开始加锁的时间
+func newLock(backend backend, opts *Options, key string, token string, fence int64, ttl time.Duration, start time.Time) *Lock {
+	lock := &Lock{
+		backend: backend,
+		opts:    opts,
+		key:     key,
+		token:   token,
+		fence:   fence,
+		ttl:     ttl,
+		renewed: start,
+		stop:    make(chan struct{}),
+		lost:    make(chan struct{}),
+	}
+	if !opts.ManualRenew {
+		go lock.renew()
+	}
+	return lock
+}
+
+// 这次加锁的随机token
+func (this *Lock) Token() string {
+	return this.token
+}
+
+// fencing token, 同一个锁每次加锁成功都比上一次大, Redlock 没有 fencing token, 总是0
+func (this *Lock) Fence() int64 {
+	return this.fence
+}
+
+// 自动续期发现锁已经不属于自己时关闭, 手动续期时只有 Release 以后关闭
+func (this *Lock) Lost() <-chan struct{} {
+	return this.lost
+}
+
+func (this *Lock) markLost() {
+	this.lostOnce.Do(func() {
+		close(this.lost)
+	})
+}
+
+// 把租约延长为从现在开始的ttl, 锁已经不属于自己时返回 ErrNotHeld
+func (this *Lock) Refresh(ctx context.Context, ttl time.Duration) error {
+	return this.backend.refresh(ctx, this.key, this.token, ttl)
+}
+
+// 租约还剩多久, 锁已经不属于自己时返回 ErrNotHeld
+func (this *Lock) TTL(ctx context.Context) (time.Duration, error) {
+	return this.backend.pttl(ctx, this.key, this.token)
+}
+
+// 释放锁并停止续期, 锁已经过期或者被别人持有时返回 ErrNotHeld
+func (this *Lock) Release(ctx context.Context) error {
+	this.stopOnce.Do(func() {
+		close(this.stop)
+	})
+	defer this.markLost()
+	return this.backend.release(ctx, this.key, this.token)
+}
+
+// 每 TTL/3 续期一次, 续期失败时继续重试
+// 锁已经不属于自己, 或者从最后一次续期成功开始已经超过了 TTL 时, 认为锁已经丢了
+func (this *Lock) renew() {
+	ticker := time.NewTicker(this.ttl / 3)
+	defer ticker.Stop()
+	log := this.opts.Logger.With("key", this.key, "fence", this.fence)
+	for {
+		select {
+		case <-this.stop:
+			return
+		case <-ticker.C:
+		}
+		start := time.Now()
+		ctx, cancel := context.WithTimeout(context.Background(), this.ttl/3)
+		err := this.Refresh(ctx, this.ttl)
+		cancel()
+		if err == nil {
+			this.renewed = start
+			continue
+		}
+		if err == ErrNotHeld || time.Since(this.renewed) >= this.ttl {
+			log.Warn("redislock: lock lost", "err", err)
+			this.markLost()
+			return
+		}
+		log.Warn("redislock: refresh failed", "err", err)
+	}
+}
@@ -0,0 +1,257 @@
+package redislock
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/go-redis/redis"
+)
+
+// 连接 miniredis 的客户端, 测试结束时关闭
+func newRdb(t *testing.T, server *miniredis.Miniredis) *redis.Client {
+	t.Helper()
+	rdb := redis.NewClient(&redis.Options{Addr: server.Addr()})
+	t.Cleanup(func() { rdb.Close() })
+	return rdb
+}
+
+func tryLock(t *testing.T, client *Client, name string, ttl time.Duration) *Lock {
+	t.Helper()
+	lock, err := client.TryLock(context.Background(), name, ttl)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return lock
+}
+
+// 同一个锁每次加锁成功的 fencing token 都比上一次大, 过期以后重新加锁也一样
+func TestFenceMonotonic(t *testing.T) {
+	server := miniredis.RunT(t)
+	client := New(newRdb(t, server), &Options{ManualRenew: true})
+	ctx := context.Background()
+
+	var last int64
+	for i := 0; i < 5; i++ {
+		lock := tryLock(t, client, "fence", time.Second)
+		if lock.Fence() <= last {
+			t.Fatalf("第%d 次加锁 fence=%d, 不大于上一次的%d", i+1, lock.Fence(), last)
+		}
+		last = lock.Fence()
+		if i%2 == 0 {
+			if err := lock.Release(ctx); err != nil {
+				t.Fatal(err)
+			}
+		} else {
+			// 不释放, 等它过期
+			server.FastForward(time.Second)
+		}
+	}
+	// 别的锁的 fence 从1开始
+	if lock := tryLock(t, client, "other", time.Second); lock.Fence() != 1 {
+		t.Fatalf("新的锁 fence=%d, 期望1", lock.Fence())
+	}
+}
+
+// 多个协程用不同的连接竞争同一个锁, 同一时间只有一个持有者, fence 递增
+func TestLockContention(t *testing.T) {
+	const workers = 8
+	const rounds = 5
+	server := miniredis.RunT(t)
+
+	var inside int32
+	var lastFence int64
+	var count int32
+	var wg sync.WaitGroup
+	errs := make(chan error, workers*rounds)
+	for i := 0; i < workers; i++ {
+		client := New(newRdb(t, server), nil)
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < rounds; j++ {
+				ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+				lock, err := client.Lock(ctx, "contention", 2*time.Second)
+				cancel()
+				if err != nil {
+					errs <- err
+					return
+				}
+				if !atomic.CompareAndSwapInt32(&inside, 0, 1) {
+					errs <- errors.New("两个协程同时持有锁")
+				}
+				// 锁保护的数据也用原子操作, 连接之间的先后关系race检测看不到
+				if last := atomic.SwapInt64(&lastFence, lock.Fence()); lock.Fence() <= last {
+					errs <- errors.New("fence 没有递增")
+				}
+				atomic.AddInt32(&count, 1)
+				time.Sleep(time.Millisecond)
+				atomic.StoreInt32(&inside, 0)
+				if err = lock.Release(context.Background()); err != nil {
+					errs <- err
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Fatal(err)
+	}
+	if count != workers*rounds {
+		t.Fatalf("加锁成功%d 次, 应该是%d 次", count, workers*rounds)
+	}
+}
+
+// 锁过期以后被别人持有, 旧的持有者释放和续期都只比较token, 不会动别人的锁
+func TestReleaseOtherOwner(t *testing.T) {
+	server := miniredis.RunT(t)
+	client := New(newRdb(t, server), &Options{ManualRenew: true})
+	ctx := context.Background()
+
+	a := tryLock(t, client, "owner", time.Second)
+	if _, err := client.TryLock(ctx, "owner", time.Second); err != ErrNotObtained {
+		t.Fatalf("锁被持有时加锁返回%v, 期望ErrNotObtained", err)
+	}
+	ttl, err := a.TTL(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ttl <= 0 || ttl > time.Second {
+		t.Fatalf("租约还剩%v", ttl)
+	}
+
+	server.FastForward(time.Second)
+	b := tryLock(t, client, "owner", time.Second)
+	if err := a.Release(ctx); err != ErrNotHeld {
+		t.Fatalf("过期的锁释放返回%v, 期望ErrNotHeld", err)
+	}
+	if err := a.Refresh(ctx, time.Second); err != ErrNotHeld {
+		t.Fatalf("过期的锁续期返回%v, 期望ErrNotHeld", err)
+	}
+	if _, err := a.TTL(ctx); err != ErrNotHeld {
+		t.Fatalf("过期的锁查看租约返回%v, 期望ErrNotHeld", err)
+	}
+	if got, _ := server.Get(client.opts.key("owner")); got != b.Token() {
+		t.Fatalf("旧的持有者改掉了别人的锁, 现在的token=%q", got)
+	}
+	select {
+	case <-a.Lost():
+	default:
+		t.Fatalf("Release 以后 Lost 没有关闭")
+	}
+
+	if err := b.Release(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if server.Exists(client.opts.key("owner")) {
+		t.Fatalf("释放以后锁还在")
+	}
+}
+
+// 阻塞加锁在ctx取消时返回, 锁释放以后可以加锁
+func TestLockWait(t *testing.T) {
+	server := miniredis.RunT(t)
+	client := New(newRdb(t, server), &Options{ManualRenew: true})
+	ctx := context.Background()
+	a := tryLock(t, client, "wait", time.Second)
+
+	waitCtx, cancel := context.WithTimeout(ctx, 100*time.Millisecond)
+	defer cancel()
+	_, err := client.Lock(waitCtx, "wait", time.Second)
+	if !errors.Is(err, ErrNotObtained) || !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("等待超时返回%v", err)
+	}
+
+	done := make(chan error, 1)
+	go func() {
+		lock, err := client.Lock(ctx, "wait", time.Second)
+		if err == nil {
+			err = lock.Release(ctx)
+		}
+		done <- err
+	}()
+	time.Sleep(20 * time.Millisecond)
+	if err := a.Release(ctx); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatalf("锁释放以后等待的协程没有加锁成功")
+	}
+}
+
+// 持有者活着时自动续期, 崩溃以后不再续期, 租约到期后别人可以加锁
+func TestAutoRenew(t *testing.T) {
+	const ttl = 300 * time.Millisecond
+	server := miniredis.RunT(t)
+	ctx := context.Background()
+	holderRdb := newRdb(t, server)
+	holder := tryLock(t, New(holderRdb, nil), "renew", ttl)
+	other := New(newRdb(t, server), &Options{ManualRenew: true})
+
+	// miniredis 的时间只有 FastForward 时才会走, 每次走的时间小于 TTL, 中间有续期就不会过期
+	for i := 0; i < 4; i++ {
+		time.Sleep(ttl / 2)
+		server.FastForward(ttl * 2 / 3)
+		if _, err := other.TryLock(ctx, "renew", ttl); err != ErrNotObtained {
+			t.Fatalf("持有者活着时加锁返回%v, 期望ErrNotObtained", err)
+		}
+	}
+	select {
+	case <-holder.Lost():
+		t.Fatalf("续期成功时 Lost 不应该关闭")
+	default:
+	}
+
+	// 断开持有者的连接, 相当于进程崩溃
+	holderRdb.Close()
+	server.FastForward(ttl)
+	lock, err := other.TryLock(ctx, "renew", ttl)
+	if err != nil {
+		t.Fatalf("持有者崩溃以后加锁失败: %v", err)
+	}
+	if lock.Fence() <= holder.Fence() {
+		t.Fatalf("新的fence=%d 不大于崩溃的持有者的%d", lock.Fence(), holder.Fence())
+	}
+	select {
+	case <-holder.Lost():
+	case <-time.After(2 * ttl):
+		t.Fatalf("崩溃的持有者没有发现锁已经丢了")
+	}
+}
+
+// 锁的key被别人删掉以后, 下一次续期发现锁已经不属于自己, 关闭 Lost
+func TestLostAfterExternalDelete(t *testing.T) {
+	const ttl = 150 * time.Millisecond
+	server := miniredis.RunT(t)
+	client := New(newRdb(t, server), nil)
+	lock := tryLock(t, client, "deleted", ttl)
+
+	// 续期几次以后还持有锁
+	time.Sleep(ttl)
+	select {
+	case <-lock.Lost():
+		t.Fatalf("续期成功时 Lost 不应该关闭")
+	default:
+	}
+
+	server.Del(client.opts.key("deleted"))
+	select {
+	case <-lock.Lost():
+	case <-time.After(2 * ttl):
+		t.Fatalf("锁被删除以后 Lost 没有关闭")
+	}
+	if err := lock.Refresh(context.Background(), ttl); err != ErrNotHeld {
+		t.Fatalf("锁被删除以后续期返回%v, 期望ErrNotHeld", err)
+	}
+}
@@ -0,0 +1,182 @@
+package redislock
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/go-redis/redis"
+)
+
+/*
+  Redlock, 在N个独立的redis节点上加同一个锁, 在多数节点上加锁成功才算成功
+
+加锁时记下开始的时间, 同时向所有节点 SET NX PX, 每个节点最多等 NodeTimeout
+成功的节点不少于 N/2+1, 并且 TTL 减去加锁用掉的时间和时钟漂移以后还有剩余, 才算加锁成功
+失败时在所有节点上释放, 包括没有回复的节点, 它们可能已经加锁成功只是回复丢了
+续期也要在多数节点上成功, 释放时在所有节点上释放
+
+少数节点不可用时仍然可以加锁, 各个节点的计数器互相独立, 所以 Redlock 没有 fencing token
+*/
+
+type Redlock struct {
+	nodes  []*Client
+	opts   Options
+	quorum int
+}
+
+// 每个节点是一个独立的redis, 不是同一个集群中的节点, 没有节点时返回 ErrNoNodes
+func NewRedlock(nodes []redis.Cmdable, opts *Options) (*Redlock, error) {
+	if len(nodes) == 0 {
+		return nil, ErrNoNodes
+	}
+	redlock := &Redlock{
+		quorum: len(nodes)/2 + 1,
+	}
+	if opts != nil {
+		redlock.opts = *opts
+	}
+	redlock.opts.setDefaults()
+	for _, rdb := range nodes {
+		redlock.nodes = append(redlock.nodes, &Client{
+			rdb:  rdb,
+			opts: redlock.opts,
+		})
+	}
+	return redlock, nil
+}
+
+// 从start开始加锁或者续期, 锁还能保证有效多久
+func (this *Redlock) validity(start time.Time, ttl time.Duration) time.Duration {
+	drift := time.Duration(float64(ttl)*this.opts.ClockDriftFactor) + 2*time.Millisecond
+	return ttl - time.Since(start) - drift
+}
+
+// 同时在所有节点上执行fn, 每个节点最多等 NodeTimeout, 返回每个节点的错误
+func (this *Redlock) each(ctx context.Context, fn func(ctx context.Context, i int, node *Client) error) []error {
+	errs := make([]error, len(this.nodes))
+	var wg sync.WaitGroup
+	for i, node := range this.nodes {
+		wg.Add(1)
+		go func(i int, node *Client) {
+			defer wg.Done()
+			ctx, cancel := context.WithTimeout(ctx, this.opts.NodeTimeout)
+			defer cancel()
+			errs[i] = fn(ctx, i, node)
+		}(i, node)
+	}
+	wg.Wait()
+	return errs
+}
+
+// 成功的节点数, 和是因为什么失败的
+func countErrs(errs []error) (ok int, notHeld int, other []error) {
+	for _, err := range errs {
+		switch {
+		case err == nil:
+			ok++
+		case err == ErrNotObtained || err == ErrNotHeld:
+			notHeld++
+		default:
+			other = append(other, err)
+		}
+	}
+	return
+}
+
+// 尝试加锁一次, 没有在多数节点上加锁成功时返回 ErrNotObtained
+// 可用的节点不够多数时返回各个节点的错误
+func (this *Redlock) TryLock(ctx context.Context, name string, ttl time.Duration) (lock *Lock, err error) {
+	token, err := newToken()
+	if err != nil {
+		return
+	}
+	key := this.opts.key(name)
+	start := time.Now()
+	errs := this.each(ctx, func(ctx context.Context, i int, node *Client) error {
+		ok, err := node.rdb.SetNX(ctx, key, token, ttl).Result()
+		if err == nil && !ok {
+			err = ErrNotObtained
+		}
+		return err
+	})
+	ok, notHeld, other := countErrs(errs)
+	if ok >= this.quorum && this.validity(start, ttl) > 0 {
+		return newLock(this, &this.opts, key, token, 0, ttl, start), nil
+	}
+
+	// 调用方的ctx可能已经取消了, 释放时不用它
+	this.release(context.Background(), key, token)
+	if ok+notHeld < this.quorum {
+		return nil, errors.Join(other...)
+	}
+	return nil, ErrNotObtained
+}
+
+// 加锁, 锁被别人持有时按退避时间重试, 直到加锁成功或者ctx取消
+func (this *Redlock) Lock(ctx context.Context, name string, ttl time.Duration) (lock *Lock, err error) {
+	return this.opts.retry(ctx, func() (*Lock, error) {
+		return this.TryLock(ctx, name, ttl)
+	})
+}
+
+// 在多数节点上续期成功, 并且续期用掉的时间没有超过ttl才算成功
+func (this *Redlock) refresh(ctx context.Context, key string, token string, ttl time.Duration) error {
+	start := time.Now()
+	errs := this.each(ctx, func(ctx context.Context, i int, node *Client) error {
+		return node.refresh(ctx, key, token, ttl)
+	})
+	ok, notHeld, other := countErrs(errs)
+	switch {
+	case ok >= this.quorum && this.validity(start, ttl) > 0:
+		return nil
+	case notHeld > len(this.nodes)-this.quorum:
+		// 多数节点上已经不是自己的锁了, 再续期也没有用
+		return ErrNotHeld
+	case len(other) > 0:
+		return errors.Join(other...)
+	}
+	return ErrNotHeld
+}
+
+// 在所有节点上释放, 任何一个节点释放成功都算成功
+func (this *Redlock) release(ctx context.Context, key string, token string) error {
+	errs := this.each(ctx, func(ctx context.Context, i int, node *Client) error {
+		return node.release(ctx, key, token)
+	})
+	ok, _, other := countErrs(errs)
+	switch {
+	case ok > 0:
+		return nil
+	case len(other) > 0:
+		return errors.Join(other...)
+	}
+	return ErrNotHeld
+}
+
+// 多数节点上都还剩的租约
+func (this *Redlock) pttl(ctx context.Context, key string, token string) (time.Duration, error) {
+	ttls := make([]time.Duration, len(this.nodes))
+	errs := this.each(ctx, func(ctx context.Context, i int, node *Client) (err error) {
+		ttls[i], err = node.pttl(ctx, key, token)
+		return
+	})
+	var held []time.Duration
+	for i, err := range errs {
+		if err == nil {
+			held = append(held, ttls[i])
+		}
+	}
+	if len(held) < this.quorum {
+		if _, _, other := countErrs(errs); len(other) > len(this.nodes)-this.quorum {
+			return 0, errors.Join(other...)
+		}
+		return 0, ErrNotHeld
+	}
+	sort.Slice(held, func(i, j int) bool {
+		return held[i] > held[j]
+	})
+	return held[this.quorum-1], nil
+}
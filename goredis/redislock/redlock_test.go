@@ -0,0 +1,173 @@
+package redislock
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/go-redis/redis"
+)
+
+// 启动n个独立的节点, 返回节点和连接它们的客户端
+// 节点不可用时不重试, 否则用例要等很久
+func redlockNodes(t *testing.T, n int) (servers []*miniredis.Miniredis, nodes []redis.Cmdable) {
+	t.Helper()
+	for i := 0; i < n; i++ {
+		server := miniredis.RunT(t)
+		rdb := redis.NewClient(&redis.Options{
+			Addr:       server.Addr(),
+			MaxRetries: -1,
+		})
+		t.Cleanup(func() { rdb.Close() })
+		servers = append(servers, server)
+		nodes = append(nodes, rdb)
+	}
+	return
+}
+
+func newRedlock(t *testing.T, nodes []redis.Cmdable, opts *Options) *Redlock {
+	t.Helper()
+	redlock, err := NewRedlock(nodes, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return redlock
+}
+
+// 锁的key在哪些节点上存在, 值是什么
+func keyOnServers(servers []*miniredis.Miniredis, key string) (values []string) {
+	for _, server := range servers {
+		value, _ := server.Get(key)
+		values = append(values, value)
+	}
+	return
+}
+
+// 没有节点时多数是1, 不能让加锁返回 (nil, nil)
+func TestRedlockNoNodes(t *testing.T) {
+	if _, err := NewRedlock(nil, nil); err != ErrNoNodes {
+		t.Fatalf("没有节点时返回%v, 期望ErrNoNodes", err)
+	}
+}
+
+// 3个节点中的一个不可用时仍然可以加锁, 续期和释放
+func TestRedlockQuorum(t *testing.T) {
+	const ttl = 300 * time.Millisecond
+	servers, nodes := redlockNodes(t, 3)
+	ctx := context.Background()
+	opts := &Options{NodeTimeout: 200 * time.Millisecond}
+	holder := newRedlock(t, nodes, opts)
+	other := newRedlock(t, nodes, opts)
+	key := holder.opts.key("redlock")
+
+	servers[2].Close()
+	lock, err := holder.TryLock(ctx, "redlock", ttl)
+	if err != nil {
+		t.Fatalf("一个节点不可用时加锁失败: %v", err)
+	}
+	if values := keyOnServers(servers[:2], key); values[0] != lock.Token() || values[1] != lock.Token() {
+		t.Fatalf("可用的节点上锁的值是%v", values)
+	}
+	if _, err = other.TryLock(ctx, "redlock", ttl); err != ErrNotObtained {
+		t.Fatalf("锁被持有时加锁返回%v, 期望ErrNotObtained", err)
+	}
+	waitCtx, cancel := context.WithTimeout(ctx, 100*time.Millisecond)
+	defer cancel()
+	if _, err = other.Lock(waitCtx, "redlock", ttl); !errors.Is(err, ErrNotObtained) {
+		t.Fatalf("等待超时返回%v", err)
+	}
+	left, err := lock.TTL(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if left <= 0 || left > ttl {
+		t.Fatalf("租约还剩%v", left)
+	}
+
+	// 在多数节点上自动续期
+	for i := 0; i < 3; i++ {
+		time.Sleep(ttl / 2)
+		for _, server := range servers[:2] {
+			server.FastForward(ttl * 2 / 3)
+		}
+		if _, err = other.TryLock(ctx, "redlock", ttl); err != ErrNotObtained {
+			t.Fatalf("续期以后加锁返回%v, 期望ErrNotObtained", err)
+		}
+	}
+	select {
+	case <-lock.Lost():
+		t.Fatalf("一个节点不可用时续期失败")
+	default:
+	}
+
+	if err = lock.Release(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if values := keyOnServers(servers[:2], key); values[0] != "" || values[1] != "" {
+		t.Fatalf("释放以后节点上还有锁: %v", values)
+	}
+	lock, err = other.TryLock(ctx, "redlock", ttl)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = lock.Release(ctx); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// 可用的节点不够多数, 或者只在少数节点上加锁成功时加锁失败
+// 失败以后在所有节点上释放, 不影响别人的锁
+func TestRedlockNoQuorum(t *testing.T) {
+	const ttl = time.Second
+	servers, nodes := redlockNodes(t, 3)
+	ctx := context.Background()
+	redlock := newRedlock(t, nodes, &Options{NodeTimeout: 200 * time.Millisecond})
+	key := redlock.opts.key("noquorum")
+
+	servers[1].SetError("LOADING redis is loading the dataset in memory")
+	servers[2].Close()
+	lock, err := redlock.TryLock(ctx, "noquorum", ttl)
+	if err == nil || lock != nil || errors.Is(err, ErrNotObtained) {
+		t.Fatalf("两个节点不可用时加锁返回%v, 期望节点的错误", err)
+	}
+	if servers[0].Exists(key) {
+		t.Fatalf("加锁失败以后可用的节点上还有锁")
+	}
+
+	// 另一个客户端只在节点1上持有锁
+	servers[1].SetError("")
+	servers[1].Set(key, "other")
+	if _, err = redlock.TryLock(ctx, "noquorum", ttl); err != ErrNotObtained {
+		t.Fatalf("只在少数节点上加锁成功时返回%v, 期望ErrNotObtained", err)
+	}
+	if values := keyOnServers(servers[:2], key); values[0] != "" || values[1] != "other" {
+		t.Fatalf("加锁失败以后节点上锁的值是%v", values)
+	}
+}
+
+// 扣掉时钟漂移以后没有有效期时, 在所有节点上加锁成功也算失败
+func TestRedlockDrift(t *testing.T) {
+	const ttl = time.Second
+	servers, nodes := redlockNodes(t, 3)
+	ctx := context.Background()
+	drifting := newRedlock(t, nodes, &Options{NodeTimeout: 200 * time.Millisecond, ClockDriftFactor: 1})
+	key := drifting.opts.key("drift")
+
+	if _, err := drifting.TryLock(ctx, "drift", ttl); err != ErrNotObtained {
+		t.Fatalf("没有有效期时加锁返回%v, 期望ErrNotObtained", err)
+	}
+	if values := keyOnServers(servers, key); values[0] != "" || values[1] != "" || values[2] != "" {
+		t.Fatalf("没有有效期时节点上还有锁: %v", values)
+	}
+
+	// 同样的节点, 正常的时钟漂移可以加锁
+	lock, err := newRedlock(t, nodes, nil).TryLock(ctx, "drift", ttl)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if lock.Fence() != 0 {
+		t.Fatalf("Redlock 没有 fencing token, fence=%d", lock.Fence())
+	}
+}
@@ -0,0 +1,41 @@
+package redislock
+
+import "github.com/go-redis/redis"
+
+// 加锁成功返回新的 fencing token, 锁被别人持有时返回0
+// KEYS: 锁 fence
+// ARGV: token 租约(毫秒)
+var obtainScript = redis.NewScript(`
+if redis.call('SET', KEYS[1], ARGV[1], 'NX', 'PX', ARGV[2]) then
+	return redis.call('INCR', KEYS[2])
+end
+return 0
+`)
+
+// KEYS: 锁
+// ARGV: token
+var releaseScript = redis.NewScript(`
+if redis.call('GET', KEYS[1]) == ARGV[1] then
+	return redis.call('DEL', KEYS[1])
+end
+return 0
+`)
+
+// KEYS: 锁
+// ARGV: token 租约(毫秒)
+var refreshScript = redis.NewScript(`
+if redis.call('GET', KEYS[1]) == ARGV[1] then
+	return redis.call('PEXPIRE', KEYS[1], ARGV[2])
+end
+return 0
+`)
+
+// 锁不属于自己时返回-3
+// KEYS: 锁
+// ARGV: token
+var pttlScript = redis.NewScript(`
+if redis.call('GET', KEYS[1]) == ARGV[1] then
+	return redis.call('PTTL', KEYS[1])
+end
+return -3
+`)